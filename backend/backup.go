@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	backupPrefix     = "ccds-"
+	backupSuffix     = ".sqlite3"
+	backupTimeFormat = "20060102T150405Z"
+)
+
+// backupName returns the file name of a backup taken at the time. Names sort
+// in the order that the backups were taken.
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeFormat) + backupSuffix
+}
+
+// RunBackups backs up the database to BackupDir every BackupInterval until the
+// context is cancelled, keeping the latest BackupKeep backups. The interval is
+// counted from the latest backup so that restarts do not delay or add backups.
+func (s *Server) RunBackups(ctx context.Context) {
+	if s.Config.BackupDir == "" {
+		return
+	}
+	for {
+		wait, err := s.nextBackupWait()
+		if err != nil {
+			slog.Error("error finding latest backup", "err", err)
+			wait = s.Config.BackupInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		path, err := s.scheduledBackup(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			slog.Error("error backing up database", "err", err)
+			s.Metrics.backupFailures.Inc()
+			// Retry after an interval instead of right away as the latest backup
+			// is still too old.
+			timer := time.NewTimer(s.Config.BackupInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		slog.Info("backed up database", "path", path)
+	}
+}
+
+// nextBackupWait returns how long to wait until the next scheduled backup is
+// due.
+func (s *Server) nextBackupWait() (time.Duration, error) {
+	backups, err := listBackups(s.Config.BackupDir)
+	if err != nil || len(backups) == 0 {
+		return 0, err
+	}
+	latest := backups[len(backups)-1]
+	taken, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(latest, backupPrefix), backupSuffix))
+	if err != nil {
+		return 0, fmt.Errorf("invalid backup name %q: %w", latest, err)
+	}
+	return max(time.Until(taken.Add(s.Config.BackupInterval)), 0), nil
+}
+
+// scheduledBackup backs up the database to BackupDir and removes the oldest
+// backups beyond BackupKeep.
+func (s *Server) scheduledBackup(ctx context.Context) (string, error) {
+	now := time.Now()
+	path := filepath.Join(s.Config.BackupDir, backupName(now))
+	// The backup is written under a temporary name first so that a backup that
+	// fails halfway is never mistaken for a complete one.
+	tmpPath := filepath.Join(s.Config.BackupDir, "."+backupName(now)+".tmp")
+	if err := backupDatabase(ctx, s.DB, tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("error moving backup into place: %w", err)
+	}
+	s.Metrics.backupLastSuccess.Set(float64(now.Unix()))
+	backups, err := listBackups(s.Config.BackupDir)
+	if err != nil {
+		return path, err
+	}
+	for len(backups) > s.Config.BackupKeep {
+		if err := os.Remove(filepath.Join(s.Config.BackupDir, backups[0])); err != nil {
+			return path, fmt.Errorf("error removing old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return path, nil
+}
+
+// listBackups returns the names of the backups in the directory, oldest first.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups: %w", err)
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// DownloadBackup streams a consistent snapshot of the database.
+func (s *Server) DownloadBackup(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	ctx := req.Context()
+	// The snapshot is taken to a file first so that the database is not
+	// locked while it is sent to a slow client.
+	f, err := os.CreateTemp(s.Config.BackupDir, ".download-*"+backupSuffix)
+	if err != nil {
+		slog.Error("error creating backup file", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	if err := backupDatabase(ctx, s.DB, f.Name()); err != nil {
+		slog.Error("error backing up database", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		slog.Error("error reading backup file", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	name := backupName(time.Now())
+	if err := recordAudit(ctx, s.Queries, sessionUser(req), auditBackup, name, "download", nil, nil); err != nil {
+		slog.Error("error recording backup download", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Content-Length", fmt.Sprint(stat.Size()))
+	if _, err := io.Copy(w, f); err != nil {
+		slog.Error("error writing backup response", "err", err)
+	}
+}
+
+// backupDatabase writes a consistent snapshot of the database to the file at
+// path with SQLite's online backup API, which lets the server keep serving
+// while it runs.
+func backupDatabase(ctx context.Context, src *sql.DB, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("error opening backup file: %w", err)
+	}
+	defer dest.Close()
+	return copyDatabase(ctx, dest, src)
+}
+
+// copyDatabase replaces the contents of dest with those of src.
+func copyDatabase(ctx context.Context, dest *sql.DB, src *sql.DB) error {
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to destination database: %w", err)
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to source database: %w", err)
+	}
+	defer srcConn.Close()
+	return destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			destSQLite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("destination database is %T, not SQLite", destDriverConn)
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("source database is %T, not SQLite", srcDriverConn)
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("error starting backup: %w", err)
+			}
+			// Copying every page in one step keeps the snapshot consistent
+			// without restarting when the source is written to.
+			if _, err := backup.Step(-1); err != nil {
+				_ = backup.Finish()
+				return fmt.Errorf("error copying database: %w", err)
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("error finishing backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// runRestore replaces the database with the backup at path. The current
+// database is backed up to BackupDir first if it is set. Backups that have
+// migrations this binary does not know about are refused, while migrations
+// that the backup is missing are applied after restoring.
+func runRestore(ctx context.Context, cfg *ServerConfig, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: restore <backup file>")
+	}
+	path := args[0]
+	if err := validateBackup(path); err != nil {
+		return err
+	}
+	backupDB, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer backupDB.Close()
+	sqlDB, dbmateDB, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	dbmateDB.Log = out
+	if cfg.BackupDir != "" {
+		current := filepath.Join(cfg.BackupDir, "pre-restore-"+backupName(time.Now()))
+		if err := backupDatabase(ctx, sqlDB, current); err != nil {
+			return fmt.Errorf("error backing up current database: %w", err)
+		}
+		fmt.Fprintf(out, "Backed up current database to %s\n", current)
+	}
+	if err := copyDatabase(ctx, sqlDB, backupDB); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Restored database from %s\n", path)
+	return dbmateDB.Migrate()
+}
+
+// validateBackup checks that the file is an intact database with migrations
+// that are all embedded in this binary.
+func validateBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	connStr := "file:" + path + "?mode=ro"
+	backupDB, err := sql.Open("sqlite3", connStr)
+	if err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer backupDB.Close()
+	var check string
+	if err := backupDB.QueryRow("PRAGMA quick_check").Scan(&check); err != nil {
+		return fmt.Errorf("error checking backup: %w", err)
+	}
+	if check != "ok" {
+		return fmt.Errorf("backup is corrupted: %s", check)
+	}
+	rows, err := backupDB.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("backup has no migrations, it may not be a backup of the shop: %w", err)
+	}
+	defer rows.Close()
+	var applied []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return fmt.Errorf("error reading backup migrations: %w", err)
+		}
+		applied = append(applied, version)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading backup migrations: %w", err)
+	}
+	migrations := dbmate.New(&url.URL{Scheme: "sqlite3", Opaque: connStr})
+	migrations.FS = migrationFS
+	known, err := migrations.FindMigrations()
+	if err != nil {
+		return fmt.Errorf("error listing migrations: %w", err)
+	}
+	for _, version := range applied {
+		if !slices.ContainsFunc(known, func(m dbmate.Migration) bool { return m.Version == version }) {
+			return fmt.Errorf("backup has migration %s that this version does not know about, restore it with a newer version", version)
+		}
+	}
+	return nil
+}
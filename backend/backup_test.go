@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// countAdmins returns the number of admins in the SQLite database at path.
+func countAdmins(t *testing.T, path string) int64 {
+	t.Helper()
+	backupDB, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatalf("error opening backup: %v", err)
+	}
+	defer backupDB.Close()
+	count, err := db.New(backupDB).CountAdminUsers(context.Background())
+	if err != nil {
+		t.Fatalf("error counting admins in backup: %v", err)
+	}
+	return count
+}
+
+func TestScheduledBackup(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Config.BackupDir = t.TempDir()
+	ts.Config.BackupInterval = time.Hour
+	ts.Config.BackupKeep = 2
+	if wait, err := ts.nextBackupWait(); err != nil || wait != 0 {
+		t.Errorf("got wait %v (err: %v) without backups, want 0", wait, err)
+	}
+	for _, name := range []string{"ccds-20250101T000000Z.sqlite3", "ccds-20250102T000000Z.sqlite3", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(ts.Config.BackupDir, name), nil, 0o600); err != nil {
+			t.Fatalf("error writing old backup: %v", err)
+		}
+	}
+	path, err := ts.scheduledBackup(context.Background())
+	if err != nil {
+		t.Fatalf("error backing up: %v", err)
+	}
+	if count := countAdmins(t, path); count != 1 {
+		t.Errorf("got %d admins in backup, want 1", count)
+	}
+	backups, err := listBackups(ts.Config.BackupDir)
+	if err != nil {
+		t.Fatalf("error listing backups: %v", err)
+	}
+	if want := []string{"ccds-20250102T000000Z.sqlite3", filepath.Base(path)}; !slices.Equal(backups, want) {
+		t.Errorf("got backups %q, want %q", backups, want)
+	}
+	if _, err := os.Stat(filepath.Join(ts.Config.BackupDir, "notes.txt")); err != nil {
+		t.Errorf("got error %v, want other files kept", err)
+	}
+	if wait, err := ts.nextBackupWait(); err != nil || wait <= 59*time.Minute || wait > time.Hour {
+		t.Errorf("got wait %v (err: %v) after backing up, want an hour", wait, err)
+	}
+}
+
+func TestDownloadBackup(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+	rec := ts.request("GET", "/api/v0/backup", nil, true)
+	expectResponse(t, rec, http.StatusOK, "")
+	if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="ccds-`) {
+		t.Errorf("got content disposition %q, want a backup attachment", got)
+	}
+	if !bytes.HasPrefix(rec.Body.Bytes(), []byte("SQLite format 3\x00")) {
+		t.Fatalf("got body starting with %q, want a SQLite database", rec.Body.Bytes()[:min(16, rec.Body.Len())])
+	}
+	path := filepath.Join(t.TempDir(), "backup.sqlite3")
+	if err := os.WriteFile(path, rec.Body.Bytes(), 0o600); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+	if count := countAdmins(t, path); count != 2 {
+		t.Errorf("got %d admins in backup, want 2", count)
+	}
+	if entries := ts.auditLog("entity_type=backup"); len(entries) != 1 || entries[0].Action != "download" || entries[0].Actor != testAdminEmail {
+		t.Errorf("got audit log %+v, want the download", entries)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &ServerConfig{
+		Sqlite3ConnStr:   "file:" + filepath.Join(dir, "db.sqlite3"),
+		Mode:             ModeDev,
+		MockPaymentState: MockSessionPaid,
+		BackupDir:        filepath.Join(dir, "backups"),
+		SkipSchemaDump:   true,
+	}
+	if err := os.Mkdir(cfg.BackupDir, 0o700); err != nil {
+		t.Fatalf("error creating backup directory: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	addAdmin := func(email string) {
+		if err := server.Queries.CreateAdminUser(context.Background(), db.CreateAdminUserParams{Email: email, Role: string(RoleOwner)}); err != nil {
+			t.Fatalf("error creating admin user: %v", err)
+		}
+	}
+	addAdmin("first@e.ntu.edu.sg")
+	backupPath := filepath.Join(dir, "backup.sqlite3")
+	if err := backupDatabase(context.Background(), server.DB, backupPath); err != nil {
+		t.Fatalf("error backing up: %v", err)
+	}
+	addAdmin("second@e.ntu.edu.sg")
+	if err := server.Close(); err != nil {
+		t.Fatalf("error closing server: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(context.Background(), cfg, []string{"restore", backupPath}, &out); err != nil {
+		t.Fatalf("error restoring: %v", err)
+	}
+	if count := countAdmins(t, filepath.Join(dir, "db.sqlite3")); count != 1 {
+		t.Errorf("got %d admins after restoring, want 1", count)
+	}
+	// The database before restoring is kept in case the wrong backup was
+	// restored.
+	entries, err := os.ReadDir(cfg.BackupDir)
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "pre-restore-ccds-") {
+		t.Fatalf("got backup directory %v (err: %v), want the database before restoring", entries, err)
+	}
+	if count := countAdmins(t, filepath.Join(cfg.BackupDir, entries[0].Name())); count != 2 {
+		t.Errorf("got %d admins before restoring, want 2", count)
+	}
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	dir := t.TempDir()
+	newerPath := filepath.Join(dir, "newer.sqlite3")
+	newerDB, err := sql.Open("sqlite3", "file:"+newerPath)
+	if err != nil {
+		t.Fatalf("error opening backup: %v", err)
+	}
+	if _, err := newerDB.Exec("CREATE TABLE schema_migrations (version VARCHAR(128) PRIMARY KEY); INSERT INTO schema_migrations VALUES ('20250505031917'), ('99990101000000')"); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+	_ = newerDB.Close()
+	garbagePath := filepath.Join(dir, "garbage.sqlite3")
+	if err := os.WriteFile(garbagePath, []byte("not a database at all, just some text that is long enough"), 0o600); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+	emptyPath := filepath.Join(dir, "empty.sqlite3")
+	if err := os.WriteFile(emptyPath, nil, 0o600); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"newer", newerPath, "migration 99990101000000 that this version does not know about"},
+		{"not a database", garbagePath, "error checking backup"},
+		{"not the shop", emptyPath, "backup has no migrations"},
+		{"missing", filepath.Join(dir, "missing.sqlite3"), "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ServerConfig{
+				Sqlite3ConnStr: "file:" + filepath.Join(t.TempDir(), "db.sqlite3"),
+				SkipSchemaDump: true,
+			}
+			var out bytes.Buffer
+			if err := runCommand(context.Background(), cfg, []string{"restore", tt.path}, &out); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+const cliUsage = `Commands, which are run instead of the server:
+  admin list                           List admins and their roles
+  admin add <email> <role>             Add an admin or change their role
+  admin remove <email>                 Remove an admin
+  migrate up|down|status               Apply, roll back one or list migrations
+  sale list [-archived]                List sale periods
+  sale create [-end time] [-closed-message message] <name> <start time>
+                                       Create a sale period
+  order show <id>                      Show an order
+  order collect <id>                   Mark an order as collected
+  order cancel [-reason reason] <id>   Cancel an order, refunding it if paid
+  restore <backup file>                Replace the database with a backup
+
+Roles are owner, merch_manager, collector and viewer. Times are in RFC 3339
+format, e.g. 2025-01-31T09:00:00+08:00.
+`
+
+// cli runs commands directly against the database, for fixing things over SSH
+// when the admin interface is unavailable.
+type cli struct {
+	s   *Server
+	out io.Writer
+	// actor is recorded in the audit log as making the changes.
+	actor string
+}
+
+var cliCommands = map[string]map[string]func(c *cli, ctx context.Context, args []string) error{
+	"admin": {
+		"list":   (*cli).adminList,
+		"add":    (*cli).adminAdd,
+		"remove": (*cli).adminRemove,
+	},
+	"sale": {
+		"list":   (*cli).saleList,
+		"create": (*cli).saleCreate,
+	},
+	"order": {
+		"show":    (*cli).orderShow,
+		"collect": (*cli).orderCollect,
+		"cancel":  (*cli).orderCancel,
+	},
+}
+
+// runCommand runs the command in args. Commands other than migrate and restore
+// apply pending migrations first, as starting the server does.
+func runCommand(ctx context.Context, cfg *ServerConfig, args []string, out io.Writer) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(cfg, args[1:], out)
+	case "restore":
+		return runRestore(ctx, cfg, args[1:], out)
+	}
+	group, ok := cliCommands[args[0]]
+	if !ok || len(args) < 2 || group[args[1]] == nil {
+		return fmt.Errorf("unknown command %q, see -help", strings.Join(args, " "))
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		return fmt.Errorf("error constructing server: %w", err)
+	}
+	defer server.Close()
+	c := &cli{
+		s:     server,
+		out:   out,
+		actor: cliActor(),
+	}
+	return group[args[1]](c, ctx, args[2:])
+}
+
+// cliActor returns who is recorded as making changes from the command line.
+func cliActor() string {
+	u, err := user.Current()
+	if err != nil {
+		return "cli"
+	}
+	return "cli:" + u.Username
+}
+
+// runMigrate applies or rolls back migrations, or lists them.
+func runMigrate(cfg *ServerConfig, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+	sqlDB, dbmateDB, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	dbmateDB.Log = out
+	switch args[0] {
+	case "up":
+		return dbmateDB.Migrate()
+	case "down":
+		return dbmateDB.Rollback()
+	case "status":
+		_, err := dbmateDB.Status(false)
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// parseArgs parses the flags of a command, which must be followed by exactly
+// the named arguments.
+func parseArgs(fs *flag.FlagSet, args []string, names ...string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != len(names) {
+		usage := fs.Name()
+		fs.VisitAll(func(f *flag.Flag) {
+			usage += fmt.Sprintf(" [-%s %s]", f.Name, f.Usage)
+		})
+		for _, name := range names {
+			usage += " <" + name + ">"
+		}
+		return nil, errors.New("usage: " + usage)
+	}
+	return fs.Args(), nil
+}
+
+func (c *cli) adminList(ctx context.Context, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("admin list", flag.ContinueOnError), args); err != nil {
+		return err
+	}
+	users, err := c.s.Queries.ListAdminUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching users: %w", err)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EMAIL\tROLE")
+	for _, u := range users {
+		fmt.Fprintf(w, "%s\t%s\n", u.Email, u.Role)
+	}
+	return w.Flush()
+}
+
+func (c *cli) adminAdd(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("admin add", flag.ContinueOnError), args, "email", "role")
+	if err != nil {
+		return err
+	}
+	email, role := args[0], Role(args[1])
+	if _, err := parseAddress(email); err != nil {
+		return fmt.Errorf("invalid email %q: %w", email, err)
+	}
+	if !role.Valid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	err = c.s.changeAdminUser(ctx, c.actor, email, func(ctx context.Context, queries *db.Queries) error {
+		return queries.CreateAdminUser(ctx, db.CreateAdminUserParams{
+			Email: email,
+			Role:  string(role),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s is now %s\n", email, role)
+	return nil
+}
+
+func (c *cli) adminRemove(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("admin remove", flag.ContinueOnError), args, "email")
+	if err != nil {
+		return err
+	}
+	email := args[0]
+	err = c.s.changeAdminUser(ctx, c.actor, email, func(ctx context.Context, queries *db.Queries) error {
+		if _, err := queries.AuthAdminUser(ctx, email); errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s is not an admin", email)
+		}
+		return queries.DeleteAdminUser(ctx, email)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s is no longer an admin\n", email)
+	return nil
+}
+
+func (c *cli) saleList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sale list", flag.ContinueOnError)
+	archived := fs.Bool("archived", false, "include archived sale periods")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	periods, err := c.s.Queries.ListSalePeriods(ctx, *archived)
+	if err != nil {
+		return fmt.Errorf("error fetching sale periods: %w", err)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTART\tEND\tARCHIVED")
+	for _, p := range periods {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", p.ID, p.AdminName, formatCLITime(p.StartTime), formatCLINullTime(p.EndTime), formatCLINullTime(p.DeleteTime))
+	}
+	return w.Flush()
+}
+
+func (c *cli) saleCreate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("sale create", flag.ContinueOnError)
+	end := fs.String("end", "", "end time")
+	closedMessage := fs.String("closed-message", "", "message shown after the sale ends")
+	args, err := parseArgs(fs, args, "name", "start time")
+	if err != nil {
+		return err
+	}
+	salePeriod := SalePeriod{
+		Name:          args[0],
+		ClosedMessage: *closedMessage,
+	}
+	if salePeriod.StartTime, err = time.Parse(time.RFC3339, args[1]); err != nil {
+		return fmt.Errorf("invalid start time: %w", err)
+	}
+	if *end != "" {
+		endTime, err := time.Parse(time.RFC3339, *end)
+		if err != nil {
+			return fmt.Errorf("invalid end time: %w", err)
+		}
+		salePeriod.EndTime = &endTime
+	}
+	endTime, err := normalizeSalePeriodTimes(&salePeriod)
+	if err != nil {
+		return err
+	}
+	tx, err := c.s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error creating transaction for sale period: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := c.s.Queries.WithTx(tx)
+	id, err := queries.CreateSalePeriod(ctx, db.CreateSalePeriodParams{
+		AdminName:     salePeriod.Name,
+		StartTime:     salePeriod.StartTime,
+		EndTime:       endTime,
+		ClosedMessage: salePeriod.ClosedMessage,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating sale period: %w", err)
+	}
+	after, err := queries.SalePeriodByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error fetching created sale period: %w", err)
+	}
+	if err := recordAudit(ctx, queries, c.actor, auditSalePeriod, id, "create", nil, after); err != nil {
+		return fmt.Errorf("error recording sale period creation: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error commiting sale period: %w", err)
+	}
+	fmt.Fprintf(c.out, "Created sale period %d\n", id)
+	return nil
+}
+
+func (c *cli) orderShow(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("order show", flag.ContinueOnError), args, "id")
+	if err != nil {
+		return err
+	}
+	order, err := c.s.Queries.OrderByID(ctx, args[0])
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("order %s does not exist", args[0])
+	case err != nil:
+		return fmt.Errorf("error looking up order: %w", err)
+	}
+	items, err := c.s.Queries.ListOrderItems(ctx, order.OrderID)
+	if err != nil {
+		return fmt.Errorf("error looking up order items: %w", err)
+	}
+	refunds, err := c.s.Queries.ListOrderRefunds(ctx, order.OrderID)
+	if err != nil {
+		return fmt.Errorf("error looking up order refunds: %w", err)
+	}
+	coupon := "-"
+	if order.CouponID.Valid {
+		dbCoupon, err := c.s.Queries.CouponByID(ctx, order.CouponID.Int64)
+		if err != nil {
+			return fmt.Errorf("error looking up coupon: %w", err)
+		}
+		coupon = dbCoupon.CouponCode
+	}
+	status := "unpaid"
+	switch {
+	case order.Cancelled:
+		status = "cancelled"
+	case order.CollectionTime.Valid:
+		status = "collected"
+	case order.PaymentTime.Valid:
+		status = "paid"
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"Order", order.OrderID},
+		{"Status", status},
+		{"Name", order.Name},
+		{"Email", order.Email},
+		{"Matric Number", order.MatricNumber},
+		{"Sale Period", strconv.FormatInt(order.SalePeriod, 10)},
+		{"Payment Reference", order.PaymentReference.String},
+		{"Payment Time", formatCLINullTime(order.PaymentTime)},
+		{"Collection Time", formatCLINullTime(order.CollectionTime)},
+		{"Coupon", coupon},
+	} {
+		fmt.Fprintf(w, "%s:\t%s\n", field[0], field[1])
+	}
+	fmt.Fprintln(w, "\nITEM\tVARIANT\tAMOUNT\tUNIT PRICE\tREFUNDED")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\n", item.ProductName, item.Variant, item.Amount, formatPrice(item.UnitPrice), item.RefundedAmount)
+	}
+	if len(refunds) > 0 {
+		fmt.Fprintln(w, "\nREFUND TIME\tAMOUNT\tADMIN\tREASON")
+		for _, refund := range refunds {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatCLITime(refund.RefundTime), formatPrice(refund.Amount), refund.AdminEmail, refund.Reason)
+		}
+	}
+	return w.Flush()
+}
+
+func (c *cli) orderCollect(ctx context.Context, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("order collect", flag.ContinueOnError), args, "id")
+	if err != nil {
+		return err
+	}
+	collected, err := c.s.collectOrder(ctx, args[0], c.actor)
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		return fmt.Errorf("order %s does not exist", args[0])
+	case err != nil:
+		return err
+	}
+	if !collected {
+		fmt.Fprintf(c.out, "Order %s was already collected\n", args[0])
+		return nil
+	}
+	fmt.Fprintf(c.out, "Order %s is now collected\n", args[0])
+	return nil
+}
+
+func (c *cli) orderCancel(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("order cancel", flag.ContinueOnError)
+	reason := fs.String("reason", "", "reason told to the customer")
+	args, err := parseArgs(fs, args, "id")
+	if err != nil {
+		return err
+	}
+	err = c.s.cancelOrder(ctx, args[0], *reason, c.actor)
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		return fmt.Errorf("order %s does not exist or is already cancelled", args[0])
+	case err != nil:
+		return err
+	}
+	fmt.Fprintf(c.out, "Order %s is now cancelled\n", args[0])
+	return nil
+}
+
+// formatCLITime formats the time in the store's time zone.
+func formatCLITime(t time.Time) string {
+	return t.In(storeLocation).Format(time.RFC3339)
+}
+
+// formatCLINullTime formats the time, or "-" if it is not set.
+func formatCLINullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return formatCLITime(t.Time)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCLI runs the command against the test server as "cli:test" and returns
+// its output.
+func (ts *testServer) runCLI(args ...string) (string, error) {
+	ts.t.Helper()
+	var out bytes.Buffer
+	c := &cli{
+		s:     ts.Server,
+		out:   &out,
+		actor: "cli:test",
+	}
+	err := cliCommands[args[0]][args[1]](c, context.Background(), args[2:])
+	return out.String(), err
+}
+
+// collapseSpace replaces runs of whitespace with a single space so that output
+// can be checked without depending on column widths.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func TestCLIAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	if _, err := ts.runCLI("admin", "add", "helper@e.ntu.edu.sg", "collector"); err != nil {
+		t.Fatalf("error adding admin: %v", err)
+	}
+	out, err := ts.runCLI("admin", "list")
+	if err != nil {
+		t.Fatalf("error listing admins: %v", err)
+	}
+	for _, want := range []string{testAdminEmail + " owner", "helper@e.ntu.edu.sg collector"} {
+		if !strings.Contains(collapseSpace(out), want) {
+			t.Errorf("got admins\n%s\nwant them to contain %q", out, want)
+		}
+	}
+	if _, err := ts.runCLI("admin", "remove", "helper@e.ntu.edu.sg"); err != nil {
+		t.Fatalf("error removing admin: %v", err)
+	}
+	if out, _ := ts.runCLI("admin", "list"); strings.Contains(out, "helper@e.ntu.edu.sg") {
+		t.Errorf("got admins\n%s\nwant helper removed", out)
+	}
+	entries := ts.auditLog("entity_type=admin_user&actor=cli:test")
+	if len(entries) != 2 || entries[0].Action != "delete" || entries[1].Action != "create" {
+		t.Errorf("got audit log %+v, want the addition and removal by the CLI", entries)
+	}
+}
+
+func TestCLIAdminErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"invalid role", []string{"admin", "add", "helper@e.ntu.edu.sg", "boss"}, `invalid role "boss"`},
+		{"invalid email", []string{"admin", "add", "helper", "viewer"}, "invalid email"},
+		{"missing role", []string{"admin", "add", "helper@e.ntu.edu.sg"}, "usage: admin add <email> <role>"},
+		{"remove last owner", []string{"admin", "remove", testAdminEmail}, "at least one owner is required"},
+		{"demote last owner", []string{"admin", "add", testAdminEmail, "viewer"}, "at least one owner is required"},
+		{"remove non-admin", []string{"admin", "remove", "helper@e.ntu.edu.sg"}, "is not an admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if _, err := ts.runCLI(tt.args...); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLISale(t *testing.T) {
+	ts := newTestServer(t)
+	out, err := ts.runCLI("sale", "create", "-end", "2025-02-28T18:00:00+08:00", "-closed-message", "See you next time", "Freshmen Sale", "2025-02-01T09:00:00+08:00")
+	if err != nil {
+		t.Fatalf("error creating sale period: %v", err)
+	}
+	if out != "Created sale period 2\n" {
+		t.Errorf("got output %q, want sale period 2 created", out)
+	}
+	period, err := ts.Queries.SalePeriodByID(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("error fetching sale period: %v", err)
+	}
+	if period.AdminName != "Freshmen Sale" || period.ClosedMessage != "See you next time" || period.StartTime.Hour() != 1 || !period.EndTime.Valid {
+		t.Errorf("got sale period %+v, want the one created in UTC", period)
+	}
+	out, err = ts.runCLI("sale", "list")
+	if err != nil {
+		t.Fatalf("error listing sale periods: %v", err)
+	}
+	if !strings.Contains(collapseSpace(out), "2 Freshmen Sale 2025-02-01T09:00:00+08:00 2025-02-28T18:00:00+08:00 -") {
+		t.Errorf("got sale periods\n%s\nwant the created sale period", out)
+	}
+	if entries := ts.auditLog("entity_type=sale_period&actor=cli:test"); len(entries) != 1 || entries[0].Action != "create" {
+		t.Errorf("got audit log %+v, want the creation by the CLI", entries)
+	}
+
+	if _, err := ts.runCLI("sale", "create", "-end", "2025-01-01T00:00:00Z", "Backwards", "2025-02-01T00:00:00Z"); err == nil || !strings.Contains(err.Error(), "End time must be after start time") {
+		t.Errorf("got error %v creating sale ending before it starts", err)
+	}
+	if _, err := ts.runCLI("sale", "create", "Tomorrow", "tomorrow"); err == nil || !strings.Contains(err.Error(), "invalid start time") {
+		t.Errorf("got error %v creating sale with invalid start time", err)
+	}
+}
+
+func TestCLIOrder(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "S", 1)))
+
+	out, err := ts.runCLI("order", "show", orderID)
+	if err != nil {
+		t.Fatalf("error showing order: %v", err)
+	}
+	for _, want := range []string{"Status: paid", "Name: Tan Ah Kow", "Shirt S 1 15.00 0"} {
+		if !strings.Contains(collapseSpace(out), want) {
+			t.Errorf("got order\n%s\nwant it to contain %q", out, want)
+		}
+	}
+
+	if out, err := ts.runCLI("order", "collect", orderID); err != nil || !strings.Contains(out, "is now collected") {
+		t.Fatalf("got output %q (err: %v) collecting order", out, err)
+	}
+	if out, err := ts.runCLI("order", "collect", orderID); err != nil || !strings.Contains(out, "was already collected") {
+		t.Errorf("got output %q (err: %v) collecting order again", out, err)
+	}
+	if out, err := ts.runCLI("order", "cancel", "-reason", "Stock damaged", orderID); err != nil || !strings.Contains(out, "is now cancelled") {
+		t.Fatalf("got output %q (err: %v) cancelling order", out, err)
+	}
+	order := ts.lookupOrder(orderID)
+	if !order.Cancelled || order.CollectionTime == nil || len(order.Refunds) != 1 || order.Refunds[0].AdminEmail != "cli:test" || order.Refunds[0].Reason != "Stock damaged" {
+		t.Errorf("got order %+v, want it collected, then cancelled and refunded by the CLI", order)
+	}
+	if _, err := ts.runCLI("order", "cancel", orderID); err == nil || !strings.Contains(err.Error(), "already cancelled") {
+		t.Errorf("got error %v cancelling order again", err)
+	}
+	if _, err := ts.runCLI("order", "show", "NOPE"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("got error %v showing unknown order", err)
+	}
+}
+
+func TestCLIMigrate(t *testing.T) {
+	cfg := &ServerConfig{
+		Mode:           ModeDev,
+		Sqlite3ConnStr: "file:" + filepath.Join(t.TempDir(), "db.sqlite3"),
+		SkipSchemaDump: true,
+	}
+	var out bytes.Buffer
+	if err := runCommand(context.Background(), cfg, []string{"migrate", "status"}, &out); err != nil {
+		t.Fatalf("error getting migration status: %v", err)
+	}
+	if !strings.Contains(out.String(), "Applied: 0") {
+		t.Errorf("got status\n%s\nwant no migrations applied", out.String())
+	}
+	out.Reset()
+	if err := runCommand(context.Background(), cfg, []string{"migrate", "up"}, &out); err != nil {
+		t.Fatalf("error migrating: %v", err)
+	}
+	if err := runCommand(context.Background(), cfg, []string{"migrate", "down"}, &out); err != nil {
+		t.Fatalf("error rolling back: %v", err)
+	}
+	out.Reset()
+	if err := runCommand(context.Background(), cfg, []string{"migrate", "status"}, &out); err != nil {
+		t.Fatalf("error getting migration status: %v", err)
+	}
+	if !strings.Contains(out.String(), "Pending: 1") {
+		t.Errorf("got status\n%s\nwant one migration rolled back", out.String())
+	}
+
+	// Other commands migrate the database first.
+	if err := runCommand(context.Background(), cfg, []string{"admin", "list"}, &out); err != nil {
+		t.Fatalf("error listing admins: %v", err)
+	}
+	if err := runCommand(context.Background(), cfg, []string{"admin", "promote"}, &out); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("got error %v running unknown command", err)
+	}
+}
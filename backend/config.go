@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix is the prefix of environment variables that configure the
+// server. The rest of the name is the flag name in upper case with dashes
+// replaced by underscores, e.g. CCDS_SMTP_HOST for -smtp-host.
+const envPrefix = "CCDS_"
+
+// secretFlags are redacted when printing the config.
+var secretFlags = []string{"client-secret", "stripe-secret", "stripe-webhook", "smtp-password", "session-secret"}
+
+// Mode is whether the server is run for local development or in production.
+type Mode string
+
+const (
+	// ModeDev offers a local login form for admins when Google authentication
+	// is not configured, and makes the first admin to log in the owner.
+	ModeDev Mode = "dev"
+	// ModeProduction requires Google authentication or magic link login, and
+	// only creates the first owner from BootstrapAdmins.
+	ModeProduction Mode = "production"
+)
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m), nil
+}
+
+func (m *Mode) UnmarshalText(b []byte) error {
+	switch mode := Mode(b); mode {
+	case ModeDev, ModeProduction:
+		*m = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q", b)
+	}
+}
+
+// emailList is a comma-separated list of emails.
+type emailList []string
+
+func (l *emailList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *emailList) Set(s string) error {
+	*l = nil
+	for _, email := range strings.Split(s, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			*l = append(*l, email)
+		}
+	}
+	return nil
+}
+
+func (l *emailList) Get() any {
+	return []string(*l)
+}
+
+type ServerConfig struct {
+	// Mode defaults to production when the config is not loaded with
+	// LoadConfig.
+	Mode           Mode
+	ListenAddr     string
+	Sqlite3ConnStr string
+	// StaticPath is the directory of the built frontend. It is served from
+	// StaticDir unless ForwardURL is set.
+	StaticPath string
+	StaticDir  *http.Dir
+	// ForwardURL is where requests for the frontend are forwarded to, such as
+	// the development server of the frontend.
+	ForwardURL string
+	Forwarder  *httputil.ReverseProxy
+	ImageDir   string
+
+	// ReadTimeout, WriteTimeout and IdleTimeout are passed to http.Server.
+	// Zero means no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is how long in-flight requests are given to finish
+	// when shutting down. Zero means waiting for as long as they need.
+	ShutdownTimeout time.Duration
+
+	GoogleClientID     string
+	GoogleClientSecret string
+	// MagicLinkLogin allows admins to log in with one-time links sent to
+	// their email.
+	MagicLinkLogin bool
+	// BootstrapAdmins are the emails that become owners when they log in
+	// while there are no admins.
+	BootstrapAdmins []string
+	// SessionSecret is the key that admin sessions are signed with. If it is
+	// empty, the SESSION_SECRET environment variable read by gothic is used.
+	SessionSecret       string
+	StripeSecretKey     string
+	StripeWebhookSecret string
+	FrontendURL         string
+	// MockPaymentState is the outcome of checkout sessions when Stripe is
+	// not configured.
+	MockPaymentState MockSessionState
+	// ReadyCheckPayment makes /readyz check the payment provider credentials.
+	ReadyCheckPayment bool
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// EmailFrom is the sender of emails, e.g. "Name <email@example.com>".
+	EmailFrom string
+	// EmailDir is where emails are written to if SMTP is not configured.
+	EmailDir string
+	// BackupDir is where the database is backed up to every BackupInterval.
+	// Scheduled backups are disabled if it is empty.
+	BackupDir      string
+	BackupInterval time.Duration
+	// BackupKeep is the number of scheduled backups to keep.
+	BackupKeep int
+	// SkipSchemaDump stops db/schema.sql from being rewritten after
+	// migrating.
+	SkipSchemaDump bool
+
+	// Command is the subcommand to run instead of the server, which is made of
+	// the arguments after the flags.
+	Command []string
+
+	flags *flag.FlagSet
+}
+
+// LoadConfig loads the config from, in increasing order of precedence, the
+// defaults, the TOML config file given by -config or CCDS_CONFIG, CCDS_*
+// environment variables and command line flags. Keys in the config file are
+// flag names with dashes replaced by underscores.
+//
+// printConfig is true if the config should be printed instead of starting the
+// server.
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (cfg *ServerConfig, printConfig bool, err error) {
+	cfg = &ServerConfig{}
+	fs := cfg.flagSet()
+	configPath := fs.String("config", "", "TOML file to read config from, overridden by environment variables and flags")
+	fs.BoolVar(&printConfig, "print-config", false, "Print the effective config with secrets redacted and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	cfg.Command = fs.Args()
+	// Flags take precedence, so they are applied again after the config file
+	// and environment variables.
+	setFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+	if *configPath == "" {
+		*configPath, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, false, err
+		}
+	}
+	var envErr error
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		value, ok := lookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			envErr = errors.Join(envErr, fmt.Errorf("invalid value for %s: %w", name, err))
+		}
+	})
+	if envErr != nil {
+		return nil, false, envErr
+	}
+	for name, value := range setFlags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, false, err
+		}
+	}
+	if cfg.SessionSecret == "" {
+		cfg.SessionSecret, _ = lookupEnv("SESSION_SECRET")
+	}
+	return cfg, printConfig, nil
+}
+
+// flagSet returns a flag set with a flag for every setting that writes to the
+// config. The flags are kept so that the config file, environment variables
+// and printing can go through the same names.
+func (cfg *ServerConfig) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("ccds-shop", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ccds-shop [flags] [command]\n\n%s\nFlags:\n", cliUsage)
+		fs.PrintDefaults()
+	}
+	fs.TextVar(&cfg.Mode, "mode", ModeProduction, "Mode to run in (dev or production)")
+	fs.StringVar(&cfg.Sqlite3ConnStr, "db", "file:db.sqlite3", "Connection string for the SQLite3 database")
+	fs.StringVar(&cfg.ListenAddr, "listen", ":8080", "Address and port to listen to")
+	fs.StringVar(&cfg.StaticPath, "static", "static", "Directory to static folder to serve")
+	fs.StringVar(&cfg.ForwardURL, "forward", "", "URL to forward to, overrides -static flag")
+	fs.StringVar(&cfg.FrontendURL, "frontend", "http://localhost:8080", "URL of the frontend")
+	fs.StringVar(&cfg.GoogleClientID, "client-id", "", "Google Client ID")
+	fs.StringVar(&cfg.GoogleClientSecret, "client-secret", "", "Google Client Secret")
+	fs.BoolVar(&cfg.MagicLinkLogin, "magic-link-login", false, "Allow admins to log in with one-time links sent to their email")
+	fs.Var((*emailList)(&cfg.BootstrapAdmins), "bootstrap-admins", "Comma-separated emails that become owners when logging in while there are no admins")
+	fs.StringVar(&cfg.SessionSecret, "session-secret", "", "Secret used to sign admin sessions, defaults to $SESSION_SECRET")
+	fs.StringVar(&cfg.StripeSecretKey, "stripe-secret", "", "Stripe Secret Key")
+	fs.StringVar(&cfg.StripeWebhookSecret, "stripe-webhook", "", "Stripe Webhook Secret")
+	fs.StringVar(&cfg.ImageDir, "image-dir", "", "Image directory")
+	fs.StringVar(&cfg.SMTPHost, "smtp-host", "", "SMTP server to send emails with, emails are only logged if not set")
+	fs.IntVar(&cfg.SMTPPort, "smtp-port", 587, "SMTP server port")
+	fs.StringVar(&cfg.SMTPUsername, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.SMTPPassword, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.EmailFrom, "email-from", "SCDS Merch Store <noreply@localhost>", "Sender of emails")
+	fs.StringVar(&cfg.EmailDir, "email-dir", "", "Directory to write emails to if SMTP is not configured")
+	fs.StringVar(&cfg.BackupDir, "backup-dir", "", "Directory to back up the database to, scheduled backups are disabled if not set")
+	fs.DurationVar(&cfg.BackupInterval, "backup-interval", 24*time.Hour, "Time between scheduled backups")
+	fs.IntVar(&cfg.BackupKeep, "backup-keep", 14, "Number of scheduled backups to keep")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 15*time.Second, "Maximum time to read a request including its body")
+	fs.DurationVar(&cfg.WriteTimeout, "write-timeout", time.Minute, "Maximum time to write a response")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "Maximum time to keep idle connections open")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for requests to finish when shutting down")
+	fs.BoolVar(&cfg.ReadyCheckPayment, "ready-check-payment", false, "Check the Stripe secret key in /readyz, which calls Stripe on every probe")
+	fs.TextVar(&cfg.MockPaymentState, "mock-payment", MockSessionPaid, "State of checkout sessions if Stripe is not configured (paid, unpaid, expired or failed)")
+	// Only the settings above are part of the config. Flags that are added
+	// afterwards, such as -config, are not.
+	cfg.flags = flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.VisitAll(func(f *flag.Flag) {
+		cfg.flags.Var(f.Value, f.Name, f.Usage)
+	})
+	return fs
+}
+
+func (cfg *ServerConfig) loadFile(path string) error {
+	var values map[string]any
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	for key, value := range values {
+		f := cfg.flags.Lookup(strings.ReplaceAll(key, "_", "-"))
+		if f == nil {
+			return fmt.Errorf("unknown key %q in config file", key)
+		}
+		var s string
+		switch value := value.(type) {
+		case string, bool, int64, float64:
+			s = fmt.Sprint(value)
+		case []any:
+			// Lists are only used for emails, which cannot contain commas.
+			items := make([]string, 0, len(value))
+			for _, v := range value {
+				items = append(items, fmt.Sprint(v))
+			}
+			s = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("invalid value for %s in config file: %v", key, value)
+		}
+		if err := f.Value.Set(s); err != nil {
+			return fmt.Errorf("invalid value for %s in config file: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Print writes the config in the format of the config file. Secrets are
+// redacted.
+func (cfg *ServerConfig) Print(w io.Writer) error {
+	values := make(map[string]any)
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		key := strings.ReplaceAll(f.Name, "-", "_")
+		switch value := f.Value.(flag.Getter).Get().(type) {
+		case time.Duration:
+			values[key] = value.String()
+		case encoding.TextMarshaler:
+			values[key] = f.Value.String()
+		default:
+			values[key] = value
+		}
+		if slices.Contains(secretFlags, f.Name) && f.Value.String() != "" {
+			values[key] = "REDACTED"
+		}
+	})
+	return toml.NewEncoder(w).Encode(values)
+}
+
+// Validate reports every problem with the config.
+func (cfg *ServerConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(cfg.ListenAddr != "", "listen address is required")
+	check(cfg.Sqlite3ConnStr != "", "database connection string is required")
+	if u, err := url.Parse(cfg.FrontendURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("frontend URL %q must be an absolute http or https URL", cfg.FrontendURL))
+	}
+	if cfg.ForwardURL != "" {
+		u, err := url.Parse(cfg.ForwardURL)
+		check(err == nil && u.Host != "", "forward URL %q must be an absolute URL", cfg.ForwardURL)
+	}
+	check((cfg.GoogleClientID == "") == (cfg.GoogleClientSecret == ""), "Google client ID and secret must be set together")
+	check(cfg.IsDev() || cfg.authenticationOK() || cfg.MagicLinkLogin, "Google client ID and secret or magic link login are required in production mode")
+	check(cfg.IsDev() || !cfg.MagicLinkLogin || cfg.SMTPHost != "", "SMTP is required for magic link login in production mode")
+	check(!cfg.MagicLinkLogin || cfg.SessionSecret != "", "session secret is required for magic link login")
+	for _, email := range cfg.BootstrapAdmins {
+		_, err := mail.ParseAddress(email)
+		check(err == nil, "invalid bootstrap admin email %q", email)
+	}
+	check(!cfg.authenticationOK() || cfg.SessionSecret != "", "session secret is required when Google authentication is configured")
+	check(cfg.IsDev() || cfg.StripeSecretKey != "", "Stripe secret key is required in production mode")
+	check(cfg.StripeSecretKey == "" || cfg.StripeWebhookSecret != "", "Stripe webhook secret is required when the Stripe secret key is set")
+	if cfg.SMTPHost != "" {
+		check(cfg.SMTPPort > 0 && cfg.SMTPPort < 65536, "SMTP port %d is out of range", cfg.SMTPPort)
+		if _, err := parseAddress(cfg.EmailFrom); err != nil {
+			errs = append(errs, fmt.Errorf("invalid email sender: %w", err))
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"read":     cfg.ReadTimeout,
+		"write":    cfg.WriteTimeout,
+		"idle":     cfg.IdleTimeout,
+		"shutdown": cfg.ShutdownTimeout,
+	} {
+		check(d >= 0, "%s timeout must not be negative", name)
+	}
+	if cfg.BackupDir != "" {
+		check(cfg.BackupInterval >= time.Minute, "backup interval must be at least a minute")
+		check(cfg.BackupKeep >= 1, "at least one backup must be kept")
+	}
+	return errors.Join(errs...)
+}
+
+// Prepare creates the directories in the config and sets up serving of the
+// frontend. It should be called after Validate.
+func (cfg *ServerConfig) Prepare() error {
+	if cfg.ForwardURL != "" {
+		parsedURL, err := url.Parse(cfg.ForwardURL)
+		if err != nil {
+			return fmt.Errorf("error parsing URL to forward to: %w", err)
+		}
+		cfg.Forwarder = httputil.NewSingleHostReverseProxy(parsedURL)
+	} else {
+		static := http.Dir(cfg.StaticPath)
+		cfg.StaticDir = &static
+	}
+	if cfg.ImageDir != "" {
+		if err := os.MkdirAll(cfg.ImageDir, 0o755); err != nil {
+			return fmt.Errorf("error creating image directory: %w", err)
+		}
+	} else {
+		slog.Warn("image directory not configured")
+	}
+	if cfg.EmailDir != "" {
+		if err := os.MkdirAll(cfg.EmailDir, 0o755); err != nil {
+			return fmt.Errorf("error creating email directory: %w", err)
+		}
+	}
+	if cfg.BackupDir != "" {
+		// Backups hold the personal details of every buyer.
+		if err := os.MkdirAll(cfg.BackupDir, 0o700); err != nil {
+			return fmt.Errorf("error creating backup directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// IsDev returns whether the server is run in development mode.
+func (cfg *ServerConfig) IsDev() bool {
+	return cfg.Mode == ModeDev
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	return path
+}
+
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+listen = ":1000"
+smtp_host = "file.smtp"
+smtp_port = 25
+email_from = "File <file@example.com>"
+read_timeout = "5s"
+mock_payment = "unpaid"
+`)
+	env := testEnv(map[string]string{
+		"CCDS_SMTP_HOST":  "env.smtp",
+		"CCDS_SMTP_PORT":  "465",
+		"CCDS_EMAIL_FROM": "",
+	})
+	cfg, printConfig, err := LoadConfig([]string{"-config", path, "-smtp-port=2525"}, env)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if printConfig {
+		t.Errorf("got print config, want false")
+	}
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"default", cfg.FrontendURL, "http://localhost:8080"},
+		{"file", cfg.ListenAddr, ":1000"},
+		{"file duration", cfg.ReadTimeout, 5 * time.Second},
+		{"file mock payment", cfg.MockPaymentState, MockSessionUnpaid},
+		{"env over file", cfg.SMTPHost, "env.smtp"},
+		{"empty env over file", cfg.EmailFrom, ""},
+		{"flag over env", cfg.SMTPPort, 2525},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigBootstrapAdmins(t *testing.T) {
+	path := writeConfigFile(t, `bootstrap_admins = ["a@e.ntu.edu.sg", "b@e.ntu.edu.sg"]`)
+	cfg, _, err := LoadConfig([]string{"-config", path}, testEnv(nil))
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if !slices.Equal(cfg.BootstrapAdmins, []string{"a@e.ntu.edu.sg", "b@e.ntu.edu.sg"}) {
+		t.Errorf("got bootstrap admins %q from file", cfg.BootstrapAdmins)
+	}
+	cfg, _, err = LoadConfig([]string{"-config", path}, testEnv(map[string]string{"CCDS_BOOTSTRAP_ADMINS": "c@e.ntu.edu.sg, d@e.ntu.edu.sg"}))
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if !slices.Equal(cfg.BootstrapAdmins, []string{"c@e.ntu.edu.sg", "d@e.ntu.edu.sg"}) {
+		t.Errorf("got bootstrap admins %q from env", cfg.BootstrapAdmins)
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	path := writeConfigFile(t, `frontend = "https://merch.example.com"`)
+	cfg, _, err := LoadConfig(nil, testEnv(map[string]string{"CCDS_CONFIG": path}))
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if cfg.FrontendURL != "https://merch.example.com" {
+		t.Errorf("got frontend URL %q, want it from the config file", cfg.FrontendURL)
+	}
+}
+
+func TestLoadConfigSessionSecret(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"legacy", nil, map[string]string{"SESSION_SECRET": "legacy"}, "legacy"},
+		{"env", nil, map[string]string{"SESSION_SECRET": "legacy", "CCDS_SESSION_SECRET": "env"}, "env"},
+		{"flag", []string{"-session-secret=flag"}, map[string]string{"SESSION_SECRET": "legacy"}, "flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, err := LoadConfig(tt.args, testEnv(tt.env))
+			if err != nil {
+				t.Fatalf("error loading config: %v", err)
+			}
+			if cfg.SessionSecret != tt.want {
+				t.Errorf("got session secret %q, want %q", cfg.SessionSecret, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{"unknown key", `colour = "red"`, nil, `unknown key "colour"`},
+		{"invalid file value", `smtp_port = "many"`, nil, "invalid value for smtp_port"},
+		{"table value", "[smtp_host]\nhost = \"x\"", nil, "invalid value for smtp_host"},
+		{"invalid env value", "", map[string]string{"CCDS_READ_TIMEOUT": "soon"}, "invalid value for CCDS_READ_TIMEOUT"},
+		{"invalid mock payment", "", map[string]string{"CCDS_MOCK_PAYMENT": "maybe"}, `unknown mock session state "maybe"`},
+		{"invalid mode", `mode = "staging"`, nil, `unknown mode "staging"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file)
+			_, _, err := LoadConfig([]string{"-config", path}, testEnv(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *ServerConfig)
+		want   string
+	}{
+		{"valid", func(cfg *ServerConfig) {}, ""},
+		{"production without auth", func(cfg *ServerConfig) { cfg.Mode = ModeProduction }, "required in production mode"},
+		{"production", func(cfg *ServerConfig) {
+			cfg.Mode = ModeProduction
+			cfg.GoogleClientID = "id"
+			cfg.GoogleClientSecret = "secret"
+			cfg.SessionSecret = "session"
+			cfg.StripeSecretKey = "sk_test"
+			cfg.StripeWebhookSecret = "whsec_test"
+		}, ""},
+		{"production with magic links", func(cfg *ServerConfig) {
+			cfg.Mode = ModeProduction
+			cfg.MagicLinkLogin = true
+			cfg.SMTPHost = "smtp.example.com"
+			cfg.SessionSecret = "session"
+			cfg.StripeSecretKey = "sk_test"
+			cfg.StripeWebhookSecret = "whsec_test"
+		}, ""},
+		{"production without stripe", func(cfg *ServerConfig) {
+			cfg.Mode = ModeProduction
+			cfg.GoogleClientID = "id"
+			cfg.GoogleClientSecret = "secret"
+			cfg.SessionSecret = "session"
+		}, "Stripe secret key is required in production mode"},
+		{"magic links without smtp", func(cfg *ServerConfig) {
+			cfg.Mode = ModeProduction
+			cfg.MagicLinkLogin = true
+			cfg.SessionSecret = "session"
+		}, "SMTP is required"},
+		{"magic links without session secret", func(cfg *ServerConfig) { cfg.MagicLinkLogin = true }, "session secret is required"},
+		{"invalid bootstrap admin", func(cfg *ServerConfig) { cfg.BootstrapAdmins = []string{"owner"} }, "invalid bootstrap admin"},
+		{"auth configured", func(cfg *ServerConfig) {
+			cfg.GoogleClientID = "id"
+			cfg.GoogleClientSecret = "secret"
+			cfg.SessionSecret = "session"
+		}, ""},
+		{"relative frontend", func(cfg *ServerConfig) { cfg.FrontendURL = "/shop" }, "frontend URL"},
+		{"invalid forward", func(cfg *ServerConfig) { cfg.ForwardURL = "localhost" }, "forward URL"},
+		{"half auth", func(cfg *ServerConfig) { cfg.GoogleClientID = "id" }, "must be set together"},
+		{"no session secret", func(cfg *ServerConfig) {
+			cfg.GoogleClientID = "id"
+			cfg.GoogleClientSecret = "secret"
+		}, "session secret is required"},
+		{"stripe without webhook", func(cfg *ServerConfig) { cfg.StripeSecretKey = "sk_test" }, "webhook secret is required"},
+		{"smtp port", func(cfg *ServerConfig) {
+			cfg.SMTPHost = "smtp.example.com"
+			cfg.SMTPPort = 0
+		}, "SMTP port 0"},
+		{"email sender", func(cfg *ServerConfig) {
+			cfg.SMTPHost = "smtp.example.com"
+			cfg.EmailFrom = "not an address"
+		}, "invalid email sender"},
+		{"negative timeout", func(cfg *ServerConfig) { cfg.IdleTimeout = -time.Second }, "idle timeout"},
+		{"backups", func(cfg *ServerConfig) { cfg.BackupDir = "backups" }, ""},
+		{"backup interval", func(cfg *ServerConfig) {
+			cfg.BackupDir = "backups"
+			cfg.BackupInterval = time.Second
+		}, "backup interval"},
+		{"backup keep", func(cfg *ServerConfig) {
+			cfg.BackupDir = "backups"
+			cfg.BackupKeep = 0
+		}, "at least one backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _, err := LoadConfig(nil, testEnv(nil))
+			if err != nil {
+				t.Fatalf("error loading config: %v", err)
+			}
+			cfg.Mode = ModeDev
+			tt.modify(cfg)
+			err = cfg.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("got error %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	cfg, printConfig, err := LoadConfig([]string{"-print-config", "-stripe-secret=sk_live_secret", "-smtp-host=smtp.example.com", "-bootstrap-admins=a@e.ntu.edu.sg"}, testEnv(nil))
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if !printConfig {
+		t.Errorf("got print config false, want true")
+	}
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("error printing config: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{`stripe_secret = "REDACTED"`, `smtp_host = "smtp.example.com"`, `smtp_password = ""`, `read_timeout = "15s"`, `mock_payment = "paid"`, `mode = "production"`, `bootstrap_admins = ["a@e.ntu.edu.sg"]`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got config\n%s\nwant it to contain %s", out, want)
+		}
+	}
+	if strings.Contains(out, "sk_live_secret") {
+		t.Errorf("got config\n%s\nwant secrets redacted", out)
+	}
+
+	// The printed config can be loaded back.
+	path := writeConfigFile(t, out)
+	loaded, _, err := LoadConfig([]string{"-config", path}, testEnv(nil))
+	if err != nil {
+		t.Fatalf("error loading printed config: %v", err)
+	}
+	if loaded.SMTPHost != "smtp.example.com" || loaded.ReadTimeout != 15*time.Second {
+		t.Errorf("got config %+v, want it to match the printed config", loaded)
+	}
+}
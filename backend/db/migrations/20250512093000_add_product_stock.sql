@@ -0,0 +1,14 @@
+-- migrate:up
+CREATE TABLE product_stock (
+	product_id INTEGER NOT NULL REFERENCES products(product_id),
+	-- Selected options joined the same way as order_items.variant.
+	variant    TEXT    NOT NULL,
+	-- Units that can still be checked out.
+	available  INTEGER NOT NULL,
+	-- Units held by orders that are pending payment.
+	reserved   INTEGER NOT NULL,
+	UNIQUE (product_id, variant)
+);
+
+-- migrate:down
+DROP TABLE product_stock;
@@ -0,0 +1,59 @@
+-- migrate:up
+-- order_items is rebuilt so that each line has an ID that can be refunded
+-- individually.
+CREATE TABLE order_items_new (
+	id              INTEGER PRIMARY KEY,
+	order_id        TEXT    NOT NULL REFERENCES orders(order_id),
+	product_id      TEXT    NOT NULL REFERENCES products(product_id),
+	product_name    TEXT    NOT NULL,
+	unit_price      INTEGER NOT NULL,
+	amount          INTEGER NOT NULL,
+	image_url       TEXT    NOT NULL,
+	-- JSON of the selected variants.
+	variant         TEXT    NOT NULL,
+	-- Units of this line that have been refunded.
+	refunded_amount INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO order_items_new (
+	order_id, product_id, product_name, unit_price, amount, image_url, variant
+) SELECT
+	order_id, product_id, product_name, unit_price, amount, image_url, variant
+FROM order_items;
+
+DROP TABLE order_items;
+ALTER TABLE order_items_new RENAME TO order_items;
+
+CREATE TABLE order_refunds (
+	id                INTEGER  PRIMARY KEY,
+	order_id          TEXT     NOT NULL REFERENCES orders(order_id),
+	-- Amount refunded in cents.
+	amount            INTEGER  NOT NULL,
+	reason            TEXT     NOT NULL,
+	refund_time       DATETIME NOT NULL,
+	-- Email of the admin that issued the refund.
+	admin_email       TEXT     NOT NULL,
+	-- ID of the refund on the payment provider.
+	payment_reference TEXT     NOT NULL
+);
+
+-- migrate:down
+DROP TABLE order_refunds;
+
+CREATE TABLE order_items_old (
+	order_id     TEXT    NOT NULL REFERENCES orders(order_id),
+	product_id   TEXT    NOT NULL REFERENCES products(product_id),
+	product_name TEXT    NOT NULL,
+	unit_price   INTEGER NOT NULL,
+	amount       INTEGER NOT NULL,
+	image_url    TEXT    NOT NULL,
+	-- JSON of the selected variants.
+	variant      TEXT    NOT NULL
+);
+
+INSERT INTO order_items_old SELECT
+	order_id, product_id, product_name, unit_price, amount, image_url, variant
+FROM order_items;
+
+DROP TABLE order_items;
+ALTER TABLE order_items_old RENAME TO order_items;
@@ -0,0 +1,23 @@
+-- migrate:up
+CREATE TABLE email_outbox (
+	id                INTEGER  PRIMARY KEY,
+	-- Order that the email is about.
+	order_id          TEXT     NOT NULL,
+	-- Template used, e.g. order_confirmation.
+	kind              TEXT     NOT NULL,
+	recipient         TEXT     NOT NULL,
+	subject           TEXT     NOT NULL,
+	text_body         TEXT     NOT NULL,
+	html_body         TEXT     NOT NULL,
+	create_time       DATETIME NOT NULL,
+	next_attempt_time DATETIME NOT NULL,
+	attempts          INTEGER  NOT NULL DEFAULT 0,
+	last_error        TEXT     NOT NULL DEFAULT '',
+	-- NULL until the email has been handed to the mail server.
+	sent_time         DATETIME
+);
+CREATE INDEX email_outbox_pending ON email_outbox (next_attempt_time) WHERE sent_time IS NULL;
+
+-- migrate:down
+DROP INDEX email_outbox_pending;
+DROP TABLE email_outbox;
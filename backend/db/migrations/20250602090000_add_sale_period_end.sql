@@ -0,0 +1,9 @@
+-- migrate:up
+-- The store is closed from end_time until the next sale period starts.
+ALTER TABLE sale_periods ADD COLUMN end_time DATETIME;
+-- Shown to users while the store is closed after the sale period ends.
+ALTER TABLE sale_periods ADD COLUMN closed_message TEXT NOT NULL DEFAULT '';
+
+-- migrate:down
+ALTER TABLE sale_periods DROP COLUMN closed_message;
+ALTER TABLE sale_periods DROP COLUMN end_time;
@@ -0,0 +1,6 @@
+-- migrate:up
+-- Existing admins keep full access.
+ALTER TABLE admin_users ADD COLUMN role TEXT NOT NULL DEFAULT 'owner';
+
+-- migrate:down
+ALTER TABLE admin_users DROP COLUMN role;
@@ -0,0 +1,25 @@
+-- migrate:up
+CREATE TABLE audit_log (
+	id          INTEGER  PRIMARY KEY,
+	-- Email of the admin that made the change.
+	actor_email TEXT     NOT NULL,
+	action_time DATETIME NOT NULL,
+	-- Table-like name of the entity changed, e.g. coupon.
+	entity_type TEXT     NOT NULL,
+	entity_id   TEXT     NOT NULL,
+	-- What was done, e.g. create, update or cancel.
+	action      TEXT     NOT NULL,
+	-- JSON object of the fields that changed. before_json is NULL if the
+	-- entity was created and after_json is NULL if it was deleted.
+	before_json TEXT,
+	after_json  TEXT
+);
+CREATE INDEX audit_log_entity ON audit_log (entity_type, entity_id);
+CREATE INDEX audit_log_actor ON audit_log (actor_email);
+CREATE INDEX audit_log_action_time ON audit_log (action_time);
+
+-- migrate:down
+DROP INDEX audit_log_action_time;
+DROP INDEX audit_log_actor;
+DROP INDEX audit_log_entity;
+DROP TABLE audit_log;
@@ -0,0 +1,18 @@
+-- migrate:up
+CREATE TABLE api_tokens (
+	id             INTEGER  PRIMARY KEY,
+	name           TEXT     NOT NULL,
+	-- SHA-256 of the token in hex. The token itself is only shown when created.
+	token_hash     TEXT     NOT NULL UNIQUE,
+	-- Admin that created the token. Requests made with the token act as them.
+	admin_email    TEXT     NOT NULL,
+	-- Comma separated permissions that the token is limited to.
+	permissions    TEXT     NOT NULL,
+	create_time    DATETIME NOT NULL,
+	expire_time    DATETIME NOT NULL,
+	last_used_time DATETIME,
+	revoke_time    DATETIME
+);
+
+-- migrate:down
+DROP TABLE api_tokens;
@@ -0,0 +1,15 @@
+-- migrate:up
+CREATE TABLE admin_login_links (
+	id          INTEGER  PRIMARY KEY,
+	-- SHA-256 of the token in hex. The token itself is only sent in the email.
+	token_hash  TEXT     NOT NULL UNIQUE,
+	email       TEXT     NOT NULL,
+	create_time DATETIME NOT NULL,
+	expire_time DATETIME NOT NULL,
+	used_time   DATETIME
+);
+
+CREATE INDEX admin_login_links_email ON admin_login_links (email, create_time);
+
+-- migrate:down
+DROP TABLE admin_login_links;
@@ -0,0 +1,14 @@
+-- migrate:up
+-- JSON describing the discount, see couponDiscount.
+ALTER TABLE coupons ADD COLUMN discount TEXT NOT NULL DEFAULT '{}';
+UPDATE coupons SET discount = json_object('type', 'percentage', 'amount', discount_percentage);
+ALTER TABLE coupons DROP COLUMN discount_percentage;
+
+-- migrate:down
+ALTER TABLE coupons ADD COLUMN discount_percentage INTEGER NOT NULL DEFAULT 0;
+UPDATE coupons SET discount_percentage = discount ->> '$.amount' WHERE discount ->> '$.type' = 'percentage';
+-- Other discounts cannot be represented, so they are disabled instead of
+-- silently giving a different discount.
+UPDATE coupons SET enabled = FALSE
+	WHERE discount ->> '$.type' != 'percentage' OR json_array_length(discount, '$.products') > 0;
+ALTER TABLE coupons DROP COLUMN discount;
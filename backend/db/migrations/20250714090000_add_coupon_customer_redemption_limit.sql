@@ -0,0 +1,9 @@
+-- migrate:up
+-- redemption_limit is now enforced by the server by counting the orders that
+-- use the coupon and have not been cancelled.
+ALTER TABLE coupons ADD COLUMN customer_redemption_limit INTEGER;
+CREATE INDEX orders_coupon_id ON orders (coupon_id);
+
+-- migrate:down
+DROP INDEX orders_coupon_id;
+ALTER TABLE coupons DROP COLUMN customer_redemption_limit;
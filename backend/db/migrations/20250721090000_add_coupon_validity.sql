@@ -0,0 +1,7 @@
+-- migrate:up
+ALTER TABLE coupons ADD COLUMN valid_from DATETIME;
+ALTER TABLE coupons ADD COLUMN valid_until DATETIME;
+
+-- migrate:down
+ALTER TABLE coupons DROP COLUMN valid_until;
+ALTER TABLE coupons DROP COLUMN valid_from;
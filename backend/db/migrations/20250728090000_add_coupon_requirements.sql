@@ -0,0 +1,8 @@
+-- migrate:up
+-- Requirements that do not have their own column, stored as a JSON array.
+ALTER TABLE coupons ADD COLUMN requirements TEXT NOT NULL DEFAULT '[]';
+
+-- migrate:down
+-- Coupons would lose their requirements, so they are disabled instead.
+UPDATE coupons SET enabled = FALSE WHERE requirements != '[]';
+ALTER TABLE coupons DROP COLUMN requirements;
@@ -0,0 +1,19 @@
+-- migrate:up
+CREATE TABLE coupon_batches (
+	id          INTEGER PRIMARY KEY,
+	name        TEXT NOT NULL,
+	stripe_id   TEXT NOT NULL,
+	sale_period INTEGER NOT NULL REFERENCES sale_periods(id),
+	create_time DATETIME NOT NULL,
+	revoke_time DATETIME
+);
+-- Coupons generated in a batch are single-use codes that are managed together.
+ALTER TABLE coupons ADD COLUMN batch_id INTEGER;
+CREATE INDEX coupons_batch_id ON coupons (batch_id);
+
+-- migrate:down
+DROP INDEX coupons_batch_id;
+-- Batch coupons are kept for their orders but can no longer be revoked together.
+UPDATE coupons SET enabled = FALSE WHERE batch_id IS NOT NULL;
+ALTER TABLE coupons DROP COLUMN batch_id;
+DROP TABLE coupon_batches;
@@ -9,21 +9,83 @@ import (
 	"time"
 )
 
+type AdminLoginLink struct {
+	ID         int64
+	TokenHash  string
+	Email      string
+	CreateTime time.Time
+	ExpireTime time.Time
+	UsedTime   sql.NullTime
+}
+
 type AdminUser struct {
 	Email string
+	Role  string
+}
+
+type ApiToken struct {
+	ID           int64
+	Name         string
+	TokenHash    string
+	AdminEmail   string
+	Permissions  string
+	CreateTime   time.Time
+	ExpireTime   time.Time
+	LastUsedTime sql.NullTime
+	RevokeTime   sql.NullTime
+}
+
+type AuditLog struct {
+	ID         int64
+	ActorEmail string
+	ActionTime time.Time
+	EntityType string
+	EntityID   string
+	Action     string
+	BeforeJson sql.NullString
+	AfterJson  sql.NullString
 }
 
 type Coupon struct {
-	CouponID            int64
-	CouponCode          string
-	StripeID            string
-	MinPurchaseQuantity sql.NullInt64
-	EmailMatch          sql.NullString
-	DiscountPercentage  int64
-	Enabled             bool
-	Public              bool
-	RedemptionLimit     sql.NullInt64
-	SalePeriod          int64
+	CouponID                int64
+	CouponCode              string
+	StripeID                string
+	MinPurchaseQuantity     sql.NullInt64
+	EmailMatch              sql.NullString
+	Enabled                 bool
+	Public                  bool
+	RedemptionLimit         sql.NullInt64
+	SalePeriod              int64
+	Discount                string
+	CustomerRedemptionLimit sql.NullInt64
+	ValidFrom               sql.NullTime
+	ValidUntil              sql.NullTime
+	Requirements            string
+	BatchID                 sql.NullInt64
+}
+
+type CouponBatch struct {
+	ID         int64
+	Name       string
+	StripeID   string
+	SalePeriod int64
+	CreateTime time.Time
+	RevokeTime sql.NullTime
+}
+
+type EmailOutbox struct {
+	ID              int64
+	OrderID         string
+	Kind            string
+	Recipient       string
+	Subject         string
+	TextBody        string
+	HtmlBody        string
+	CreateTime      time.Time
+	NextAttemptTime time.Time
+	Attempts        int64
+	LastError       string
+	SentTime        sql.NullTime
 }
 
 type Order struct {
@@ -41,13 +103,25 @@ type Order struct {
 }
 
 type OrderItem struct {
-	OrderID     string
-	ProductID   string
-	ProductName string
-	UnitPrice   int64
-	Amount      int64
-	ImageUrl    string
-	Variant     string
+	ID             int64
+	OrderID        string
+	ProductID      string
+	ProductName    string
+	UnitPrice      int64
+	Amount         int64
+	ImageUrl       string
+	Variant        string
+	RefundedAmount int64
+}
+
+type OrderRefund struct {
+	ID               int64
+	OrderID          string
+	Amount           int64
+	Reason           string
+	RefundTime       time.Time
+	AdminEmail       string
+	PaymentReference string
 }
 
 type Product struct {
@@ -61,11 +135,20 @@ type Product struct {
 	SalePeriod       int64
 }
 
+type ProductStock struct {
+	ProductID int64
+	Variant   string
+	Available int64
+	Reserved  int64
+}
+
 type SalePeriod struct {
-	ID         int64
-	AdminName  string
-	StartTime  time.Time
-	DeleteTime sql.NullTime
+	ID            int64
+	AdminName     string
+	StartTime     time.Time
+	DeleteTime    sql.NullTime
+	EndTime       sql.NullTime
+	ClosedMessage string
 }
 
 type StoreClosure struct {
@@ -11,6 +11,65 @@ import (
 	"time"
 )
 
+const apiTokenByHash = `-- name: ApiTokenByHash :one
+SELECT
+	id, name, token_hash, admin_email, permissions, create_time, expire_time, last_used_time, revoke_time
+FROM
+	api_tokens
+WHERE
+	token_hash = ?1
+	AND revoke_time IS NULL
+	AND expire_time > ?2
+`
+
+type ApiTokenByHashParams struct {
+	TokenHash string
+	Now       time.Time
+}
+
+func (q *Queries) ApiTokenByHash(ctx context.Context, arg ApiTokenByHashParams) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, apiTokenByHash, arg.TokenHash, arg.Now)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.TokenHash,
+		&i.AdminEmail,
+		&i.Permissions,
+		&i.CreateTime,
+		&i.ExpireTime,
+		&i.LastUsedTime,
+		&i.RevokeTime,
+	)
+	return i, err
+}
+
+const apiTokenByID = `-- name: ApiTokenByID :one
+SELECT
+	id, name, token_hash, admin_email, permissions, create_time, expire_time, last_used_time, revoke_time
+FROM
+	api_tokens
+WHERE
+	id = ?
+`
+
+func (q *Queries) ApiTokenByID(ctx context.Context, id int64) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, apiTokenByID, id)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.TokenHash,
+		&i.AdminEmail,
+		&i.Permissions,
+		&i.CreateTime,
+		&i.ExpireTime,
+		&i.LastUsedTime,
+		&i.RevokeTime,
+	)
+	return i, err
+}
+
 const associateOrder = `-- name: AssociateOrder :exec
 UPDATE
 	orders
@@ -32,37 +91,25 @@ func (q *Queries) AssociateOrder(ctx context.Context, arg AssociateOrderParams)
 
 const authAdminUser = `-- name: AuthAdminUser :one
 SELECT
-	email
+	email, role
 FROM
 	admin_users
 WHERE
 	email = ?
 `
 
-func (q *Queries) AuthAdminUser(ctx context.Context, email string) (string, error) {
+func (q *Queries) AuthAdminUser(ctx context.Context, email string) (AdminUser, error) {
 	row := q.db.QueryRowContext(ctx, authAdminUser, email)
-	err := row.Scan(&email)
-	return email, err
+	var i AdminUser
+	err := row.Scan(&i.Email, &i.Role)
+	return i, err
 }
 
 const completeCheckout = `-- name: CompleteCheckout :one
 UPDATE
 	orders
 SET
-	payment_time = COALESCE(payment_time, ?),
-	coupon_id = (
-		SELECT coupon_id FROM (
-			SELECT
-				coupon_id
-			FROM
-				coupons
-			WHERE
-				stripe_id = ?
-			UNION ALL
-			SELECT NULL
-		) x
-		LIMIT 1
-	)
+	payment_time = COALESCE(payment_time, ?)
 WHERE
 	orders.payment_reference = ?
 RETURNING order_id
@@ -70,17 +117,37 @@ RETURNING order_id
 
 type CompleteCheckoutParams struct {
 	PaymentTime      sql.NullTime
-	CouponStripeID   string
 	PaymentReference sql.NullString
 }
 
 func (q *Queries) CompleteCheckout(ctx context.Context, arg CompleteCheckoutParams) (string, error) {
-	row := q.db.QueryRowContext(ctx, completeCheckout, arg.PaymentTime, arg.CouponStripeID, arg.PaymentReference)
+	row := q.db.QueryRowContext(ctx, completeCheckout, arg.PaymentTime, arg.PaymentReference)
 	var order_id string
 	err := row.Scan(&order_id)
 	return order_id, err
 }
 
+const confirmStock = `-- name: ConfirmStock :exec
+UPDATE
+	product_stock
+SET
+	reserved = reserved - ?
+WHERE
+	product_id = ?
+	AND variant = ?
+`
+
+type ConfirmStockParams struct {
+	Amount    int64
+	ProductID int64
+	Variant   string
+}
+
+func (q *Queries) ConfirmStock(ctx context.Context, arg ConfirmStockParams) error {
+	_, err := q.db.ExecContext(ctx, confirmStock, arg.Amount, arg.ProductID, arg.Variant)
+	return err
+}
+
 const countAdminUsers = `-- name: CountAdminUsers :one
 SELECT
 	COUNT(*)
@@ -95,9 +162,142 @@ func (q *Queries) CountAdminUsers(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const countAdminUsersWithRole = `-- name: CountAdminUsersWithRole :one
+SELECT
+	COUNT(*)
+FROM
+	admin_users
+WHERE
+	role = ?
+`
+
+func (q *Queries) CountAdminUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAdminUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCouponRedemptions = `-- name: CountCouponRedemptions :one
+SELECT
+	COUNT(*) AS total,
+	CAST(COALESCE(SUM(matric_number = ?1 COLLATE NOCASE OR email = ?2 COLLATE NOCASE), 0) AS INTEGER) AS customer
+FROM
+	orders
+WHERE
+	coupon_id = ?3
+	AND cancelled = FALSE
+`
+
+type CountCouponRedemptionsParams struct {
+	MatricNumber string
+	Email        string
+	CouponID     sql.NullInt64
+}
+
+type CountCouponRedemptionsRow struct {
+	Total    int64
+	Customer int64
+}
+
+func (q *Queries) CountCouponRedemptions(ctx context.Context, arg CountCouponRedemptionsParams) (CountCouponRedemptionsRow, error) {
+	row := q.db.QueryRowContext(ctx, countCouponRedemptions, arg.MatricNumber, arg.Email, arg.CouponID)
+	var i CountCouponRedemptionsRow
+	err := row.Scan(&i.Total, &i.Customer)
+	return i, err
+}
+
+const countPaidOrders = `-- name: CountPaidOrders :one
+SELECT
+	COUNT(*)
+FROM
+	orders
+WHERE
+	payment_time IS NOT NULL
+	AND cancelled = FALSE
+	AND sale_period = ?
+`
+
+func (q *Queries) CountPaidOrders(ctx context.Context, salePeriod int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPaidOrders, salePeriod)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRecentAdminLoginLinks = `-- name: CountRecentAdminLoginLinks :one
+SELECT
+	COUNT(*)
+FROM
+	admin_login_links
+WHERE
+	email = ?
+	AND create_time > ?
+`
+
+type CountRecentAdminLoginLinksParams struct {
+	Email string
+	Since time.Time
+}
+
+func (q *Queries) CountRecentAdminLoginLinks(ctx context.Context, arg CountRecentAdminLoginLinksParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRecentAdminLoginLinks, arg.Email, arg.Since)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUncollectedOrders = `-- name: CountUncollectedOrders :one
+SELECT
+	COUNT(*)
+FROM
+	orders
+WHERE
+	payment_time IS NOT NULL
+	AND collection_time IS NULL
+	AND cancelled = FALSE
+	AND sale_period = ?
+`
+
+func (q *Queries) CountUncollectedOrders(ctx context.Context, salePeriod int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUncollectedOrders, salePeriod)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const couponBatchByID = `-- name: CouponBatchByID :one
+SELECT
+	id, name, stripe_id, sale_period, create_time, revoke_time
+FROM
+	coupon_batches
+WHERE
+	id = ?
+	AND sale_period = ?
+`
+
+type CouponBatchByIDParams struct {
+	ID         int64
+	SalePeriod int64
+}
+
+func (q *Queries) CouponBatchByID(ctx context.Context, arg CouponBatchByIDParams) (CouponBatch, error) {
+	row := q.db.QueryRowContext(ctx, couponBatchByID, arg.ID, arg.SalePeriod)
+	var i CouponBatch
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.StripeID,
+		&i.SalePeriod,
+		&i.CreateTime,
+		&i.RevokeTime,
+	)
+	return i, err
+}
+
 const couponByID = `-- name: CouponByID :one
 SELECT
-	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, discount_percentage, enabled, public, redemption_limit, sale_period
+	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, enabled, public, redemption_limit, sale_period, discount, customer_redemption_limit, valid_from, valid_until, requirements, batch_id
 FROM
 	coupons
 WHERE
@@ -113,33 +313,41 @@ func (q *Queries) CouponByID(ctx context.Context, couponID int64) (Coupon, error
 		&i.StripeID,
 		&i.MinPurchaseQuantity,
 		&i.EmailMatch,
-		&i.DiscountPercentage,
 		&i.Enabled,
 		&i.Public,
 		&i.RedemptionLimit,
 		&i.SalePeriod,
+		&i.Discount,
+		&i.CustomerRedemptionLimit,
+		&i.ValidFrom,
+		&i.ValidUntil,
+		&i.Requirements,
+		&i.BatchID,
 	)
 	return i, err
 }
 
 const couponEnabledByCode = `-- name: CouponEnabledByCode :one
 SELECT
-	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, discount_percentage, enabled, public, redemption_limit, sale_period
+	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, enabled, public, redemption_limit, sale_period, discount, customer_redemption_limit, valid_from, valid_until, requirements, batch_id
 FROM
 	coupons
 WHERE
-	coupon_code = ? COLLATE NOCASE
+	coupon_code = ?1 COLLATE NOCASE
 	AND enabled = TRUE
-	AND sale_period = ?
+	AND sale_period = ?2
+	AND (valid_from IS NULL OR valid_from <= ?3)
+	AND (valid_until IS NULL OR valid_until > ?3)
 `
 
 type CouponEnabledByCodeParams struct {
-	CouponCode string
-	SalePeriod int64
+	CouponCode  string
+	SalePeriod  int64
+	CurrentTime time.Time
 }
 
 func (q *Queries) CouponEnabledByCode(ctx context.Context, arg CouponEnabledByCodeParams) (Coupon, error) {
-	row := q.db.QueryRowContext(ctx, couponEnabledByCode, arg.CouponCode, arg.SalePeriod)
+	row := q.db.QueryRowContext(ctx, couponEnabledByCode, arg.CouponCode, arg.SalePeriod, arg.CurrentTime)
 	var i Coupon
 	err := row.Scan(
 		&i.CouponID,
@@ -147,45 +355,149 @@ func (q *Queries) CouponEnabledByCode(ctx context.Context, arg CouponEnabledByCo
 		&i.StripeID,
 		&i.MinPurchaseQuantity,
 		&i.EmailMatch,
-		&i.DiscountPercentage,
 		&i.Enabled,
 		&i.Public,
 		&i.RedemptionLimit,
 		&i.SalePeriod,
+		&i.Discount,
+		&i.CustomerRedemptionLimit,
+		&i.ValidFrom,
+		&i.ValidUntil,
+		&i.Requirements,
+		&i.BatchID,
 	)
 	return i, err
 }
 
+const createAdminLoginLink = `-- name: CreateAdminLoginLink :exec
+INSERT INTO admin_login_links (
+	token_hash, email, create_time, expire_time
+) VALUES (
+	?, ?, ?, ?
+)
+`
+
+type CreateAdminLoginLinkParams struct {
+	TokenHash  string
+	Email      string
+	CreateTime time.Time
+	ExpireTime time.Time
+}
+
+func (q *Queries) CreateAdminLoginLink(ctx context.Context, arg CreateAdminLoginLinkParams) error {
+	_, err := q.db.ExecContext(ctx, createAdminLoginLink,
+		arg.TokenHash,
+		arg.Email,
+		arg.CreateTime,
+		arg.ExpireTime,
+	)
+	return err
+}
+
 const createAdminUser = `-- name: CreateAdminUser :exec
 INSERT INTO admin_users (
-	email
+	email, role
 ) VALUES (
-	?
-) ON CONFLICT DO NOTHING
+	?, ?
+) ON CONFLICT (email) DO UPDATE SET
+	role = excluded.role
+`
+
+type CreateAdminUserParams struct {
+	Email string
+	Role  string
+}
+
+func (q *Queries) CreateAdminUser(ctx context.Context, arg CreateAdminUserParams) error {
+	_, err := q.db.ExecContext(ctx, createAdminUser, arg.Email, arg.Role)
+	return err
+}
+
+const createApiToken = `-- name: CreateApiToken :one
+INSERT INTO api_tokens (
+	name, token_hash, admin_email, permissions, create_time, expire_time
+) VALUES (
+	?, ?, ?, ?, ?, ?
+) RETURNING id
+`
+
+type CreateApiTokenParams struct {
+	Name        string
+	TokenHash   string
+	AdminEmail  string
+	Permissions string
+	CreateTime  time.Time
+	ExpireTime  time.Time
+}
+
+func (q *Queries) CreateApiToken(ctx context.Context, arg CreateApiTokenParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createApiToken,
+		arg.Name,
+		arg.TokenHash,
+		arg.AdminEmail,
+		arg.Permissions,
+		arg.CreateTime,
+		arg.ExpireTime,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createAuditLog = `-- name: CreateAuditLog :exec
+INSERT INTO audit_log (
+	actor_email, action_time, entity_type, entity_id, action, before_json, after_json
+) VALUES (
+	?, ?, ?, ?, ?, ?, ?
+)
 `
 
-func (q *Queries) CreateAdminUser(ctx context.Context, email string) error {
-	_, err := q.db.ExecContext(ctx, createAdminUser, email)
+type CreateAuditLogParams struct {
+	ActorEmail string
+	ActionTime time.Time
+	EntityType string
+	EntityID   string
+	Action     string
+	BeforeJson sql.NullString
+	AfterJson  sql.NullString
+}
+
+func (q *Queries) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) error {
+	_, err := q.db.ExecContext(ctx, createAuditLog,
+		arg.ActorEmail,
+		arg.ActionTime,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Action,
+		arg.BeforeJson,
+		arg.AfterJson,
+	)
 	return err
 }
 
 const createCoupon = `-- name: CreateCoupon :one
 INSERT INTO coupons (
-	stripe_id, coupon_code, min_purchase_quantity, email_match, discount_percentage, enabled, public, sale_period
+	stripe_id, coupon_code, min_purchase_quantity, email_match, requirements, discount, enabled, public, redemption_limit, customer_redemption_limit, valid_from, valid_until, sale_period, batch_id
 ) VALUES (
-	?, ?, ?, ?, ?, ?, ?, ?
+	?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 ) RETURNING coupon_id
 `
 
 type CreateCouponParams struct {
-	StripeID            string
-	CouponCode          string
-	MinPurchaseQuantity sql.NullInt64
-	EmailMatch          sql.NullString
-	DiscountPercentage  int64
-	Enabled             bool
-	Public              bool
-	SalePeriod          int64
+	StripeID                string
+	CouponCode              string
+	MinPurchaseQuantity     sql.NullInt64
+	EmailMatch              sql.NullString
+	Requirements            string
+	Discount                string
+	Enabled                 bool
+	Public                  bool
+	RedemptionLimit         sql.NullInt64
+	CustomerRedemptionLimit sql.NullInt64
+	ValidFrom               sql.NullTime
+	ValidUntil              sql.NullTime
+	SalePeriod              int64
+	BatchID                 sql.NullInt64
 }
 
 func (q *Queries) CreateCoupon(ctx context.Context, arg CreateCouponParams) (int64, error) {
@@ -194,16 +506,49 @@ func (q *Queries) CreateCoupon(ctx context.Context, arg CreateCouponParams) (int
 		arg.CouponCode,
 		arg.MinPurchaseQuantity,
 		arg.EmailMatch,
-		arg.DiscountPercentage,
+		arg.Requirements,
+		arg.Discount,
 		arg.Enabled,
 		arg.Public,
+		arg.RedemptionLimit,
+		arg.CustomerRedemptionLimit,
+		arg.ValidFrom,
+		arg.ValidUntil,
 		arg.SalePeriod,
+		arg.BatchID,
 	)
 	var coupon_id int64
 	err := row.Scan(&coupon_id)
 	return coupon_id, err
 }
 
+const createCouponBatch = `-- name: CreateCouponBatch :one
+INSERT INTO coupon_batches (
+	name, stripe_id, sale_period, create_time
+) VALUES (
+	?, ?, ?, ?
+) RETURNING id
+`
+
+type CreateCouponBatchParams struct {
+	Name       string
+	StripeID   string
+	SalePeriod int64
+	CreateTime time.Time
+}
+
+func (q *Queries) CreateCouponBatch(ctx context.Context, arg CreateCouponBatchParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createCouponBatch,
+		arg.Name,
+		arg.StripeID,
+		arg.SalePeriod,
+		arg.CreateTime,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
 const createOrder = `-- name: CreateOrder :exec
 INSERT INTO orders (
 	order_id, name, matric_number, email, payment_reference, payment_time, collection_time, cancelled, coupon_id, sale_period
@@ -264,6 +609,68 @@ func (q *Queries) CreateOrderItem(ctx context.Context, arg CreateOrderItemParams
 	return err
 }
 
+const createOrderRefund = `-- name: CreateOrderRefund :exec
+INSERT INTO order_refunds (
+	order_id, amount, reason, refund_time, admin_email, payment_reference
+) VALUES (
+	?, ?, ?, ?, ?, ?
+)
+`
+
+type CreateOrderRefundParams struct {
+	OrderID          string
+	Amount           int64
+	Reason           string
+	RefundTime       time.Time
+	AdminEmail       string
+	PaymentReference string
+}
+
+func (q *Queries) CreateOrderRefund(ctx context.Context, arg CreateOrderRefundParams) error {
+	_, err := q.db.ExecContext(ctx, createOrderRefund,
+		arg.OrderID,
+		arg.Amount,
+		arg.Reason,
+		arg.RefundTime,
+		arg.AdminEmail,
+		arg.PaymentReference,
+	)
+	return err
+}
+
+const createOutboxEmail = `-- name: CreateOutboxEmail :exec
+INSERT INTO email_outbox (
+	order_id, kind, recipient, subject, text_body, html_body, create_time, next_attempt_time
+) VALUES (
+	?, ?, ?, ?, ?, ?, ?, ?
+)
+`
+
+type CreateOutboxEmailParams struct {
+	OrderID         string
+	Kind            string
+	Recipient       string
+	Subject         string
+	TextBody        string
+	HtmlBody        string
+	CreateTime      time.Time
+	NextAttemptTime time.Time
+}
+
+func (q *Queries) CreateOutboxEmail(ctx context.Context, arg CreateOutboxEmailParams) error {
+	_, err := q.db.ExecContext(ctx, createOutboxEmail,
+		arg.OrderID,
+		arg.Kind,
+		arg.Recipient,
+		arg.Subject,
+		arg.TextBody,
+		arg.HtmlBody,
+		arg.CreateTime,
+		arg.NextAttemptTime,
+	)
+	return err
+}
+
 const createProduct = `-- name: CreateProduct :one
 INSERT INTO products (
 	name, base_price, default_image_url, variants, variant_image_urls, enabled, sale_period
@@ -299,20 +706,27 @@ func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (i
 
 const createSalePeriod = `-- name: CreateSalePeriod :one
 INSERT INTO sale_periods (
-	admin_name, start_time, delete_time
+	admin_name, start_time, end_time, closed_message, delete_time
 ) VALUES (
-	?, ?, NULL
+	?, ?, ?, ?, NULL
 ) RETURNING
 	id
 `
 
 type CreateSalePeriodParams struct {
-	AdminName string
-	StartTime time.Time
+	AdminName     string
+	StartTime     time.Time
+	EndTime       sql.NullTime
+	ClosedMessage string
 }
 
 func (q *Queries) CreateSalePeriod(ctx context.Context, arg CreateSalePeriodParams) (int64, error) {
-	row := q.db.QueryRowContext(ctx, createSalePeriod, arg.AdminName, arg.StartTime)
+	row := q.db.QueryRowContext(ctx, createSalePeriod,
+		arg.AdminName,
+		arg.StartTime,
+		arg.EndTime,
+		arg.ClosedMessage,
+	)
 	var id int64
 	err := row.Scan(&id)
 	return id, err
@@ -345,90 +759,538 @@ func (q *Queries) CreateStoreClosure(ctx context.Context, arg CreateStoreClosure
 	return id, err
 }
 
-const currentSalePeriod = `-- name: CurrentSalePeriod :one
+const currentSalePeriod = `-- name: CurrentSalePeriod :one
+SELECT
+	id, admin_name, start_time, delete_time, end_time, closed_message
+FROM
+	sale_periods
+WHERE
+	start_time <= ?1
+	AND delete_time IS NULL
+ORDER BY
+	start_time DESC
+LIMIT
+	1
+`
+
+func (q *Queries) CurrentSalePeriod(ctx context.Context, currentTime time.Time) (SalePeriod, error) {
+	row := q.db.QueryRowContext(ctx, currentSalePeriod, currentTime)
+	var i SalePeriod
+	err := row.Scan(
+		&i.ID,
+		&i.AdminName,
+		&i.StartTime,
+		&i.DeleteTime,
+		&i.EndTime,
+		&i.ClosedMessage,
+	)
+	return i, err
+}
+
+const deleteAdminUser = `-- name: DeleteAdminUser :exec
+DELETE FROM
+	admin_users
+WHERE
+	email = ?
+`
+
+func (q *Queries) DeleteAdminUser(ctx context.Context, email string) error {
+	_, err := q.db.ExecContext(ctx, deleteAdminUser, email)
+	return err
+}
+
+const deleteProductStock = `-- name: DeleteProductStock :exec
+DELETE FROM
+	product_stock
+WHERE
+	product_id = ?
+	AND variant = ?
+`
+
+type DeleteProductStockParams struct {
+	ProductID int64
+	Variant   string
+}
+
+func (q *Queries) DeleteProductStock(ctx context.Context, arg DeleteProductStockParams) error {
+	_, err := q.db.ExecContext(ctx, deleteProductStock, arg.ProductID, arg.Variant)
+	return err
+}
+
+const deleteSalePeriod = `-- name: DeleteSalePeriod :execrows
+UPDATE
+	sale_periods
+SET
+	delete_time = ?
+WHERE
+	id = ?
+	AND delete_time IS NULL
+`
+
+type DeleteSalePeriodParams struct {
+	DeleteTime sql.NullTime
+	ID         int64
+}
+
+func (q *Queries) DeleteSalePeriod(ctx context.Context, arg DeleteSalePeriodParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteSalePeriod, arg.DeleteTime, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteStoreClosure = `-- name: DeleteStoreClosure :exec
+UPDATE
+	store_closures
+SET
+	deleted = TRUE
+WHERE
+	id = ?
+`
+
+func (q *Queries) DeleteStoreClosure(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteStoreClosure, id)
+	return err
+}
+
+const disableCouponBatch = `-- name: DisableCouponBatch :exec
+UPDATE
+	coupons
+SET
+	enabled = FALSE
+WHERE
+	batch_id = ?
+`
+
+func (q *Queries) DisableCouponBatch(ctx context.Context, batchID sql.NullInt64) error {
+	_, err := q.db.ExecContext(ctx, disableCouponBatch, batchID)
+	return err
+}
+
+const expireCheckout = `-- name: ExpireCheckout :one
+UPDATE
+	orders
+SET
+	cancelled = TRUE
+WHERE
+	payment_reference = ?
+RETURNING order_id
+`
+
+func (q *Queries) ExpireCheckout(ctx context.Context, paymentReference sql.NullString) (string, error) {
+	row := q.db.QueryRowContext(ctx, expireCheckout, paymentReference)
+	var order_id string
+	err := row.Scan(&order_id)
+	return order_id, err
+}
+
+const exportOrderItems = `-- name: ExportOrderItems :many
+SELECT
+	order_items.id, orders.order_id, orders.name, orders.matric_number, orders.email,
+	orders.payment_time, orders.collection_time, orders.cancelled, coupons.coupon_code,
+	order_items.product_name, order_items.variant, order_items.unit_price,
+	order_items.amount, order_items.refunded_amount
+FROM
+	order_items
+	JOIN orders ON orders.order_id = order_items.order_id
+	LEFT JOIN coupons ON orders.coupon_id = coupons.coupon_id
+WHERE
+	orders.sale_period = ?1
+	AND order_items.id > ?2
+ORDER BY
+	order_items.id
+LIMIT
+	?3
+`
+
+type ExportOrderItemsParams struct {
+	SalePeriod int64
+	AfterID    int64
+	MaxCount   int64
+}
+
+type ExportOrderItemsRow struct {
+	ID             int64
+	OrderID        string
+	Name           string
+	MatricNumber   string
+	Email          string
+	PaymentTime    sql.NullTime
+	CollectionTime sql.NullTime
+	Cancelled      bool
+	CouponCode     sql.NullString
+	ProductName    string
+	Variant        string
+	UnitPrice      int64
+	Amount         int64
+	RefundedAmount int64
+}
+
+func (q *Queries) ExportOrderItems(ctx context.Context, arg ExportOrderItemsParams) ([]ExportOrderItemsRow, error) {
+	rows, err := q.db.QueryContext(ctx, exportOrderItems, arg.SalePeriod, arg.AfterID, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ExportOrderItemsRow
+	for rows.Next() {
+		var i ExportOrderItemsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Name,
+			&i.MatricNumber,
+			&i.Email,
+			&i.PaymentTime,
+			&i.CollectionTime,
+			&i.Cancelled,
+			&i.CouponCode,
+			&i.ProductName,
+			&i.Variant,
+			&i.UnitPrice,
+			&i.Amount,
+			&i.RefundedAmount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAdminUsers = `-- name: ListAdminUsers :many
+SELECT
+	email, role
+FROM
+	admin_users
+`
+
+func (q *Queries) ListAdminUsers(ctx context.Context) ([]AdminUser, error) {
+	rows, err := q.db.QueryContext(ctx, listAdminUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AdminUser
+	for rows.Next() {
+		var i AdminUser
+		if err := rows.Scan(&i.Email, &i.Role); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listApiTokens = `-- name: ListApiTokens :many
+SELECT
+	id, name, token_hash, admin_email, permissions, create_time, expire_time, last_used_time, revoke_time
+FROM
+	api_tokens
+WHERE
+	CAST(?1 AS TEXT) = ''
+	OR admin_email = ?1
+ORDER BY
+	id
+`
+
+func (q *Queries) ListApiTokens(ctx context.Context, adminEmail string) ([]ApiToken, error) {
+	rows, err := q.db.QueryContext(ctx, listApiTokens, adminEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiToken
+	for rows.Next() {
+		var i ApiToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.TokenHash,
+			&i.AdminEmail,
+			&i.Permissions,
+			&i.CreateTime,
+			&i.ExpireTime,
+			&i.LastUsedTime,
+			&i.RevokeTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditLog = `-- name: ListAuditLog :many
+SELECT
+	id, actor_email, action_time, entity_type, entity_id, action, before_json, after_json
+FROM
+	audit_log
+WHERE
+	(CAST(?1 AS TEXT) = '' OR entity_type = ?1)
+	AND (CAST(?2 AS TEXT) = '' OR entity_id = ?2)
+	AND (CAST(?3 AS TEXT) = '' OR actor_email = ?3)
+	AND action_time >= ?4
+	AND action_time < ?5
+	AND id < ?6
+ORDER BY
+	id DESC
+LIMIT
+	?7
+`
+
+type ListAuditLogParams struct {
+	EntityType string
+	EntityID   string
+	ActorEmail string
+	FromTime   time.Time
+	UntilTime  time.Time
+	BeforeID   int64
+	MaxCount   int64
+}
+
+func (q *Queries) ListAuditLog(ctx context.Context, arg ListAuditLogParams) ([]AuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditLog,
+		arg.EntityType,
+		arg.EntityID,
+		arg.ActorEmail,
+		arg.FromTime,
+		arg.UntilTime,
+		arg.BeforeID,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditLog
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.ActorEmail,
+			&i.ActionTime,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Action,
+			&i.BeforeJson,
+			&i.AfterJson,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCouponBatchCodes = `-- name: ListCouponBatchCodes :many
 SELECT
-	id
+	coupons.coupon_code,
+	coupons.enabled,
+	COUNT(orders.payment_time) AS redemptions
 FROM
-	sale_periods
+	coupons
+	LEFT JOIN orders ON orders.coupon_id = coupons.coupon_id AND orders.cancelled = FALSE
 WHERE
-	start_time <= date('now')
-	AND delete_time IS NULL
+	coupons.batch_id = ?
+GROUP BY
+	coupons.coupon_id
 ORDER BY
-	start_time DESC
-LIMIT
-	1
+	coupons.coupon_id
 `
 
-func (q *Queries) CurrentSalePeriod(ctx context.Context) (int64, error) {
-	row := q.db.QueryRowContext(ctx, currentSalePeriod)
-	var id int64
-	err := row.Scan(&id)
-	return id, err
+type ListCouponBatchCodesRow struct {
+	CouponCode  string
+	Enabled     bool
+	Redemptions int64
 }
 
-const deleteAdminUser = `-- name: DeleteAdminUser :exec
-DELETE FROM
-	admin_users
-WHERE
-	email = ?
-`
-
-func (q *Queries) DeleteAdminUser(ctx context.Context, email string) error {
-	_, err := q.db.ExecContext(ctx, deleteAdminUser, email)
-	return err
+func (q *Queries) ListCouponBatchCodes(ctx context.Context, batchID sql.NullInt64) ([]ListCouponBatchCodesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listCouponBatchCodes, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListCouponBatchCodesRow
+	for rows.Next() {
+		var i ListCouponBatchCodesRow
+		if err := rows.Scan(&i.CouponCode, &i.Enabled, &i.Redemptions); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const deleteStoreClosure = `-- name: DeleteStoreClosure :exec
-UPDATE
-	store_closures
-SET
-	deleted = TRUE
+const listCouponBatches = `-- name: ListCouponBatches :many
+SELECT
+	coupon_batches.id, coupon_batches.name, coupon_batches.stripe_id, coupon_batches.sale_period, coupon_batches.create_time, coupon_batches.revoke_time,
+	(
+		SELECT
+			COUNT(*)
+		FROM
+			coupons
+		WHERE
+			coupons.batch_id = coupon_batches.id
+	) AS coupon_count,
+	(
+		SELECT
+			COUNT(*)
+		FROM
+			orders
+			JOIN coupons ON orders.coupon_id = coupons.coupon_id
+		WHERE
+			coupons.batch_id = coupon_batches.id
+			AND orders.cancelled = FALSE
+			AND orders.payment_time IS NOT NULL
+	) AS redemptions
+FROM
+	coupon_batches
 WHERE
-	id = ?
+	sale_period = ?
+ORDER BY
+	id
 `
 
-func (q *Queries) DeleteStoreClosure(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, deleteStoreClosure, id)
-	return err
+type ListCouponBatchesRow struct {
+	ID          int64
+	Name        string
+	StripeID    string
+	SalePeriod  int64
+	CreateTime  time.Time
+	RevokeTime  sql.NullTime
+	CouponCount int64
+	Redemptions int64
 }
 
-const expireCheckout = `-- name: ExpireCheckout :one
-UPDATE
-	orders
-SET
-	cancelled = TRUE
+func (q *Queries) ListCouponBatches(ctx context.Context, salePeriod int64) ([]ListCouponBatchesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listCouponBatches, salePeriod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListCouponBatchesRow
+	for rows.Next() {
+		var i ListCouponBatchesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.StripeID,
+			&i.SalePeriod,
+			&i.CreateTime,
+			&i.RevokeTime,
+			&i.CouponCount,
+			&i.Redemptions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCouponCodes = `-- name: ListCouponCodes :many
+SELECT
+	coupon_code
+FROM
+	coupons
 WHERE
-	payment_reference = ?
-RETURNING order_id
+	sale_period = ?
 `
 
-func (q *Queries) ExpireCheckout(ctx context.Context, paymentReference sql.NullString) (string, error) {
-	row := q.db.QueryRowContext(ctx, expireCheckout, paymentReference)
-	var order_id string
-	err := row.Scan(&order_id)
-	return order_id, err
+func (q *Queries) ListCouponCodes(ctx context.Context, salePeriod int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listCouponCodes, salePeriod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var coupon_code string
+		if err := rows.Scan(&coupon_code); err != nil {
+			return nil, err
+		}
+		items = append(items, coupon_code)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const listAdminUsers = `-- name: ListAdminUsers :many
+const listCouponRedemptions = `-- name: ListCouponRedemptions :many
 SELECT
-	email
+	coupons.coupon_id,
+	COUNT(orders.payment_time) AS paid,
+	COUNT(orders.id) - COUNT(orders.payment_time) AS pending
 FROM
-	admin_users
+	coupons
+	JOIN orders ON orders.coupon_id = coupons.coupon_id AND orders.cancelled = FALSE
+WHERE
+	coupons.sale_period = ?
+GROUP BY
+	coupons.coupon_id
 `
 
-func (q *Queries) ListAdminUsers(ctx context.Context) ([]string, error) {
-	rows, err := q.db.QueryContext(ctx, listAdminUsers)
+type ListCouponRedemptionsRow struct {
+	CouponID int64
+	Paid     int64
+	Pending  int64
+}
+
+func (q *Queries) ListCouponRedemptions(ctx context.Context, salePeriod int64) ([]ListCouponRedemptionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listCouponRedemptions, salePeriod)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []string
+	var items []ListCouponRedemptionsRow
 	for rows.Next() {
-		var email string
-		if err := rows.Scan(&email); err != nil {
+		var i ListCouponRedemptionsRow
+		if err := rows.Scan(&i.CouponID, &i.Paid, &i.Pending); err != nil {
 			return nil, err
 		}
-		items = append(items, email)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -441,11 +1303,12 @@ func (q *Queries) ListAdminUsers(ctx context.Context) ([]string, error) {
 
 const listCoupons = `-- name: ListCoupons :many
 SELECT
-	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, discount_percentage, enabled, public, redemption_limit, sale_period
+	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, enabled, public, redemption_limit, sale_period, discount, customer_redemption_limit, valid_from, valid_until, requirements, batch_id
 FROM
 	coupons
 WHERE
 	sale_period = ?
+	AND batch_id IS NULL
 `
 
 func (q *Queries) ListCoupons(ctx context.Context, salePeriod int64) ([]Coupon, error) {
@@ -463,11 +1326,16 @@ func (q *Queries) ListCoupons(ctx context.Context, salePeriod int64) ([]Coupon,
 			&i.StripeID,
 			&i.MinPurchaseQuantity,
 			&i.EmailMatch,
-			&i.DiscountPercentage,
 			&i.Enabled,
 			&i.Public,
 			&i.RedemptionLimit,
 			&i.SalePeriod,
+			&i.Discount,
+			&i.CustomerRedemptionLimit,
+			&i.ValidFrom,
+			&i.ValidUntil,
+			&i.Requirements,
+			&i.BatchID,
 		); err != nil {
 			return nil, err
 		}
@@ -484,7 +1352,7 @@ func (q *Queries) ListCoupons(ctx context.Context, salePeriod int64) ([]Coupon,
 
 const listOrderItems = `-- name: ListOrderItems :many
 SELECT
-	order_id, product_id, product_name, unit_price, amount, image_url, variant
+	id, order_id, product_id, product_name, unit_price, amount, image_url, variant, refunded_amount
 FROM
 	order_items
 WHERE
@@ -501,6 +1369,7 @@ func (q *Queries) ListOrderItems(ctx context.Context, orderID string) ([]OrderIt
 	for rows.Next() {
 		var i OrderItem
 		if err := rows.Scan(
+			&i.ID,
 			&i.OrderID,
 			&i.ProductID,
 			&i.ProductName,
@@ -508,6 +1377,93 @@ func (q *Queries) ListOrderItems(ctx context.Context, orderID string) ([]OrderIt
 			&i.Amount,
 			&i.ImageUrl,
 			&i.Variant,
+			&i.RefundedAmount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrderRefunds = `-- name: ListOrderRefunds :many
+SELECT
+	id, order_id, amount, reason, refund_time, admin_email, payment_reference
+FROM
+	order_refunds
+WHERE
+	order_id = ?
+ORDER BY
+	refund_time
+`
+
+func (q *Queries) ListOrderRefunds(ctx context.Context, orderID string) ([]OrderRefund, error) {
+	rows, err := q.db.QueryContext(ctx, listOrderRefunds, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OrderRefund
+	for rows.Next() {
+		var i OrderRefund
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Amount,
+			&i.Reason,
+			&i.RefundTime,
+			&i.AdminEmail,
+			&i.PaymentReference,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProductStock = `-- name: ListProductStock :many
+SELECT
+	product_id, variant, available, reserved
+FROM
+	product_stock
+WHERE
+	product_id IN (
+		SELECT
+			product_id
+		FROM
+			products
+		WHERE
+			sale_period = ?
+	)
+`
+
+func (q *Queries) ListProductStock(ctx context.Context, salePeriod int64) ([]ProductStock, error) {
+	rows, err := q.db.QueryContext(ctx, listProductStock, salePeriod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ProductStock
+	for rows.Next() {
+		var i ProductStock
+		if err := rows.Scan(
+			&i.ProductID,
+			&i.Variant,
+			&i.Available,
+			&i.Reserved,
 		); err != nil {
 			return nil, err
 		}
@@ -574,17 +1530,36 @@ func (q *Queries) ListProducts(ctx context.Context, arg ListProductsParams) ([]P
 
 const listPublicCoupons = `-- name: ListPublicCoupons :many
 SELECT
-	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, discount_percentage, enabled, public, redemption_limit, sale_period
+	coupon_id, coupon_code, stripe_id, min_purchase_quantity, email_match, enabled, public, redemption_limit, sale_period, discount, customer_redemption_limit, valid_from, valid_until, requirements, batch_id
 FROM
 	coupons
 WHERE
 	enabled = TRUE
 	AND public = TRUE
-	AND sale_period = ?
+	AND sale_period = ?1
+	AND (valid_from IS NULL OR valid_from <= ?2)
+	AND (valid_until IS NULL OR valid_until > ?2)
+	AND (
+		redemption_limit IS NULL
+		OR redemption_limit > (
+			SELECT
+				COUNT(*)
+			FROM
+				orders
+			WHERE
+				orders.coupon_id = coupons.coupon_id
+				AND orders.cancelled = FALSE
+		)
+	)
 `
 
-func (q *Queries) ListPublicCoupons(ctx context.Context, salePeriod int64) ([]Coupon, error) {
-	rows, err := q.db.QueryContext(ctx, listPublicCoupons, salePeriod)
+type ListPublicCouponsParams struct {
+	SalePeriod  int64
+	CurrentTime time.Time
+}
+
+func (q *Queries) ListPublicCoupons(ctx context.Context, arg ListPublicCouponsParams) ([]Coupon, error) {
+	rows, err := q.db.QueryContext(ctx, listPublicCoupons, arg.SalePeriod, arg.CurrentTime)
 	if err != nil {
 		return nil, err
 	}
@@ -598,11 +1573,16 @@ func (q *Queries) ListPublicCoupons(ctx context.Context, salePeriod int64) ([]Co
 			&i.StripeID,
 			&i.MinPurchaseQuantity,
 			&i.EmailMatch,
-			&i.DiscountPercentage,
 			&i.Enabled,
 			&i.Public,
 			&i.RedemptionLimit,
 			&i.SalePeriod,
+			&i.Discount,
+			&i.CustomerRedemptionLimit,
+			&i.ValidFrom,
+			&i.ValidUntil,
+			&i.Requirements,
+			&i.BatchID,
 		); err != nil {
 			return nil, err
 		}
@@ -619,13 +1599,15 @@ func (q *Queries) ListPublicCoupons(ctx context.Context, salePeriod int64) ([]Co
 
 const listSalePeriods = `-- name: ListSalePeriods :many
 SELECT
-	id, admin_name, start_time, delete_time
+	id, admin_name, start_time, delete_time, end_time, closed_message
 FROM
 	sale_periods
+WHERE
+	(delete_time IS NOT NULL) = CAST(?1 AS BOOLEAN)
 `
 
-func (q *Queries) ListSalePeriods(ctx context.Context) ([]SalePeriod, error) {
-	rows, err := q.db.QueryContext(ctx, listSalePeriods)
+func (q *Queries) ListSalePeriods(ctx context.Context, archived bool) ([]SalePeriod, error) {
+	rows, err := q.db.QueryContext(ctx, listSalePeriods, archived)
 	if err != nil {
 		return nil, err
 	}
@@ -638,6 +1620,8 @@ func (q *Queries) ListSalePeriods(ctx context.Context) ([]SalePeriod, error) {
 			&i.AdminName,
 			&i.StartTime,
 			&i.DeleteTime,
+			&i.EndTime,
+			&i.ClosedMessage,
 		); err != nil {
 			return nil, err
 		}
@@ -787,6 +1771,7 @@ WHERE
 			order_items.order_id = orders.order_id
 			AND order_items.product_name = ?1 COLLATE NOCASE
 			AND order_items.variant = ?2 COLLATE NOCASE
+			AND order_items.refunded_amount < order_items.amount
 	)
 	AND orders.payment_time IS NOT NULL
 	AND orders.cancelled = FALSE
@@ -848,6 +1833,125 @@ func (q *Queries) LookupOrderFromItem(ctx context.Context, arg LookupOrderFromIt
 	return items, nil
 }
 
+const markOutboxEmailFailed = `-- name: MarkOutboxEmailFailed :exec
+UPDATE
+	email_outbox
+SET
+	attempts = attempts + 1,
+	last_error = ?,
+	next_attempt_time = ?
+WHERE
+	id = ?
+`
+
+type MarkOutboxEmailFailedParams struct {
+	LastError       string
+	NextAttemptTime time.Time
+	ID              int64
+}
+
+func (q *Queries) MarkOutboxEmailFailed(ctx context.Context, arg MarkOutboxEmailFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markOutboxEmailFailed, arg.LastError, arg.NextAttemptTime, arg.ID)
+	return err
+}
+
+const markOutboxEmailSent = `-- name: MarkOutboxEmailSent :exec
+UPDATE
+	email_outbox
+SET
+	sent_time = ?,
+	attempts = attempts + 1
+WHERE
+	id = ?
+`
+
+type MarkOutboxEmailSentParams struct {
+	SentTime sql.NullTime
+	ID       int64
+}
+
+func (q *Queries) MarkOutboxEmailSent(ctx context.Context, arg MarkOutboxEmailSentParams) error {
+	_, err := q.db.ExecContext(ctx, markOutboxEmailSent, arg.SentTime, arg.ID)
+	return err
+}
+
+const nextSalePeriodStart = `-- name: NextSalePeriodStart :one
+SELECT
+	start_time
+FROM
+	sale_periods
+WHERE
+	start_time > ?1
+	AND delete_time IS NULL
+ORDER BY
+	start_time
+LIMIT
+	1
+`
+
+func (q *Queries) NextSalePeriodStart(ctx context.Context, currentTime time.Time) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, nextSalePeriodStart, currentTime)
+	var start_time time.Time
+	err := row.Scan(&start_time)
+	return start_time, err
+}
+
+const orderByID = `-- name: OrderByID :one
+SELECT
+	id, order_id, name, matric_number, email, payment_reference, payment_time, collection_time, cancelled, coupon_id, sale_period
+FROM
+	orders
+WHERE
+	order_id = ?
+`
+
+func (q *Queries) OrderByID(ctx context.Context, orderID string) (Order, error) {
+	row := q.db.QueryRowContext(ctx, orderByID, orderID)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Name,
+		&i.MatricNumber,
+		&i.Email,
+		&i.PaymentReference,
+		&i.PaymentTime,
+		&i.CollectionTime,
+		&i.Cancelled,
+		&i.CouponID,
+		&i.SalePeriod,
+	)
+	return i, err
+}
+
+const orderByPaymentReference = `-- name: OrderByPaymentReference :one
+SELECT
+	id, order_id, name, matric_number, email, payment_reference, payment_time, collection_time, cancelled, coupon_id, sale_period
+FROM
+	orders
+WHERE
+	payment_reference = ?
+`
+
+func (q *Queries) OrderByPaymentReference(ctx context.Context, paymentReference sql.NullString) (Order, error) {
+	row := q.db.QueryRowContext(ctx, orderByPaymentReference, paymentReference)
+	var i Order
+	err := row.Scan(
+		&i.ID,
+		&i.OrderID,
+		&i.Name,
+		&i.MatricNumber,
+		&i.Email,
+		&i.PaymentReference,
+		&i.PaymentTime,
+		&i.CollectionTime,
+		&i.Cancelled,
+		&i.CouponID,
+		&i.SalePeriod,
+	)
+	return i, err
+}
+
 const orderNumberStats = `-- name: OrderNumberStats :many
 SELECT
 	CAST((orders.collection_time IS NULL) AS BOOLEAN) AS uncollected,
@@ -893,7 +1997,7 @@ func (q *Queries) OrderNumberStats(ctx context.Context, salePeriod int64) ([]Ord
 const orderSummary = `-- name: OrderSummary :many
 SELECT
 	order_items.product_id, order_items.product_name, order_items.variant,
-	SUM(order_items.amount)
+	SUM(order_items.amount - order_items.refunded_amount)
 FROM
 	orders
 	JOIN order_items ON orders.order_id = order_items.order_id
@@ -949,6 +2053,291 @@ func (q *Queries) OrderSummary(ctx context.Context, arg OrderSummaryParams) ([]O
 	return items, nil
 }
 
+const pendingOutboxEmails = `-- name: PendingOutboxEmails :many
+SELECT
+	id, order_id, kind, recipient, subject, text_body, html_body, create_time, next_attempt_time, attempts, last_error, sent_time
+FROM
+	email_outbox
+WHERE
+	sent_time IS NULL
+	AND next_attempt_time <= ?1
+	AND attempts < ?2
+ORDER BY
+	id
+LIMIT
+	?3
+`
+
+type PendingOutboxEmailsParams struct {
+	Now         time.Time
+	MaxAttempts int64
+	MaxCount    int64
+}
+
+func (q *Queries) PendingOutboxEmails(ctx context.Context, arg PendingOutboxEmailsParams) ([]EmailOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, pendingOutboxEmails, arg.Now, arg.MaxAttempts, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []EmailOutbox
+	for rows.Next() {
+		var i EmailOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrderID,
+			&i.Kind,
+			&i.Recipient,
+			&i.Subject,
+			&i.TextBody,
+			&i.HtmlBody,
+			&i.CreateTime,
+			&i.NextAttemptTime,
+			&i.Attempts,
+			&i.LastError,
+			&i.SentTime,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const productByID = `-- name: ProductByID :one
+SELECT
+	product_id, name, base_price, default_image_url, variants, variant_image_urls, enabled, sale_period
+FROM
+	products
+WHERE
+	product_id = ?
+`
+
+func (q *Queries) ProductByID(ctx context.Context, productID int64) (Product, error) {
+	row := q.db.QueryRowContext(ctx, productByID, productID)
+	var i Product
+	err := row.Scan(
+		&i.ProductID,
+		&i.Name,
+		&i.BasePrice,
+		&i.DefaultImageUrl,
+		&i.Variants,
+		&i.VariantImageUrls,
+		&i.Enabled,
+		&i.SalePeriod,
+	)
+	return i, err
+}
+
+const productStockByVariant = `-- name: ProductStockByVariant :one
+SELECT
+	product_id, variant, available, reserved
+FROM
+	product_stock
+WHERE
+	product_id = ?
+	AND variant = ?
+`
+
+type ProductStockByVariantParams struct {
+	ProductID int64
+	Variant   string
+}
+
+func (q *Queries) ProductStockByVariant(ctx context.Context, arg ProductStockByVariantParams) (ProductStock, error) {
+	row := q.db.QueryRowContext(ctx, productStockByVariant, arg.ProductID, arg.Variant)
+	var i ProductStock
+	err := row.Scan(
+		&i.ProductID,
+		&i.Variant,
+		&i.Available,
+		&i.Reserved,
+	)
+	return i, err
+}
+
+const refundAllOrderItems = `-- name: RefundAllOrderItems :exec
+UPDATE
+	order_items
+SET
+	refunded_amount = amount
+WHERE
+	order_id = ?
+`
+
+func (q *Queries) RefundAllOrderItems(ctx context.Context, orderID string) error {
+	_, err := q.db.ExecContext(ctx, refundAllOrderItems, orderID)
+	return err
+}
+
+const refundOrderItem = `-- name: RefundOrderItem :execrows
+UPDATE
+	order_items
+SET
+	refunded_amount = refunded_amount + ?1
+WHERE
+	id = ?2
+	AND order_id = ?3
+	AND refunded_amount + ?1 <= amount
+`
+
+type RefundOrderItemParams struct {
+	Amount  int64
+	ID      int64
+	OrderID string
+}
+
+func (q *Queries) RefundOrderItem(ctx context.Context, arg RefundOrderItemParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, refundOrderItem, arg.Amount, arg.ID, arg.OrderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const releaseStock = `-- name: ReleaseStock :exec
+UPDATE
+	product_stock
+SET
+	available = available + ?,
+	reserved = reserved - ?
+WHERE
+	product_id = ?
+	AND variant = ?
+`
+
+type ReleaseStockParams struct {
+	Amount         int64
+	ReservedAmount int64
+	ProductID      int64
+	Variant        string
+}
+
+func (q *Queries) ReleaseStock(ctx context.Context, arg ReleaseStockParams) error {
+	_, err := q.db.ExecContext(ctx, releaseStock,
+		arg.Amount,
+		arg.ReservedAmount,
+		arg.ProductID,
+		arg.Variant,
+	)
+	return err
+}
+
+const reserveStock = `-- name: ReserveStock :execrows
+UPDATE
+	product_stock
+SET
+	available = available - ?1,
+	reserved = reserved + ?1
+WHERE
+	product_id = ?2
+	AND variant = ?3
+	AND available >= ?1
+`
+
+type ReserveStockParams struct {
+	Amount    int64
+	ProductID int64
+	Variant   string
+}
+
+func (q *Queries) ReserveStock(ctx context.Context, arg ReserveStockParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, reserveStock, arg.Amount, arg.ProductID, arg.Variant)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreSalePeriod = `-- name: RestoreSalePeriod :execrows
+UPDATE
+	sale_periods
+SET
+	delete_time = NULL
+WHERE
+	id = ?
+	AND delete_time IS NOT NULL
+`
+
+func (q *Queries) RestoreSalePeriod(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreSalePeriod, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeApiToken = `-- name: RevokeApiToken :execrows
+UPDATE
+	api_tokens
+SET
+	revoke_time = ?
+WHERE
+	id = ?
+	AND revoke_time IS NULL
+`
+
+type RevokeApiTokenParams struct {
+	RevokeTime sql.NullTime
+	ID         int64
+}
+
+func (q *Queries) RevokeApiToken(ctx context.Context, arg RevokeApiTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeApiToken, arg.RevokeTime, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeCouponBatch = `-- name: RevokeCouponBatch :exec
+UPDATE
+	coupon_batches
+SET
+	revoke_time = ?
+WHERE
+	id = ?
+`
+
+type RevokeCouponBatchParams struct {
+	RevokeTime sql.NullTime
+	ID         int64
+}
+
+func (q *Queries) RevokeCouponBatch(ctx context.Context, arg RevokeCouponBatchParams) error {
+	_, err := q.db.ExecContext(ctx, revokeCouponBatch, arg.RevokeTime, arg.ID)
+	return err
+}
+
+const salePeriodByID = `-- name: SalePeriodByID :one
+SELECT
+	id, admin_name, start_time, delete_time, end_time, closed_message
+FROM
+	sale_periods
+WHERE
+	id = ?
+`
+
+func (q *Queries) SalePeriodByID(ctx context.Context, id int64) (SalePeriod, error) {
+	row := q.db.QueryRowContext(ctx, salePeriodByID, id)
+	var i SalePeriod
+	err := row.Scan(
+		&i.ID,
+		&i.AdminName,
+		&i.StartTime,
+		&i.DeleteTime,
+		&i.EndTime,
+		&i.ClosedMessage,
+	)
+	return i, err
+}
+
 const setCouponEnabled = `-- name: SetCouponEnabled :exec
 UPDATE
 	coupons
@@ -987,6 +2376,49 @@ func (q *Queries) SetProductEnabled(ctx context.Context, arg SetProductEnabledPa
 	return err
 }
 
+const setProductStock = `-- name: SetProductStock :exec
+INSERT INTO product_stock (
+	product_id, variant, available, reserved
+) VALUES (
+	?, ?, ?, 0
+) ON CONFLICT (product_id, variant) DO UPDATE SET
+	available = excluded.available
+`
+
+type SetProductStockParams struct {
+	ProductID int64
+	Variant   string
+	Available int64
+}
+
+func (q *Queries) SetProductStock(ctx context.Context, arg SetProductStockParams) error {
+	_, err := q.db.ExecContext(ctx, setProductStock, arg.ProductID, arg.Variant, arg.Available)
+	return err
+}
+
+const storeClosureByID = `-- name: StoreClosureByID :one
+SELECT
+	id, start_time, end_time, user_message, allow_order_check, deleted
+FROM
+	store_closures
+WHERE
+	id = ?
+`
+
+func (q *Queries) StoreClosureByID(ctx context.Context, id int64) (StoreClosure, error) {
+	row := q.db.QueryRowContext(ctx, storeClosureByID, id)
+	var i StoreClosure
+	err := row.Scan(
+		&i.ID,
+		&i.StartTime,
+		&i.EndTime,
+		&i.UserMessage,
+		&i.AllowOrderCheck,
+		&i.Deleted,
+	)
+	return i, err
+}
+
 const storeClosureCurrent = `-- name: StoreClosureCurrent :one
 SELECT
 	id, start_time, end_time, user_message, allow_order_check, deleted
@@ -1013,6 +2445,30 @@ func (q *Queries) StoreClosureCurrent(ctx context.Context, currentTime time.Time
 	return i, err
 }
 
+const touchApiToken = `-- name: TouchApiToken :exec
+UPDATE
+	api_tokens
+SET
+	last_used_time = ?1
+WHERE
+	id = ?2
+	AND (
+		last_used_time IS NULL
+		OR last_used_time < ?3
+	)
+`
+
+type TouchApiTokenParams struct {
+	Now       sql.NullTime
+	ID        int64
+	StaleTime sql.NullTime
+}
+
+func (q *Queries) TouchApiToken(ctx context.Context, arg TouchApiTokenParams) error {
+	_, err := q.db.ExecContext(ctx, touchApiToken, arg.Now, arg.ID, arg.StaleTime)
+	return err
+}
+
 const unfulfilledOrderIDs = `-- name: UnfulfilledOrderIDs :many
 SELECT
 	order_id
@@ -1064,7 +2520,7 @@ WHERE
 	order_id = ?
 	AND cancelled = FALSE
 RETURNING
-	payment_reference
+	payment_reference, payment_time
 `
 
 type UpdateCancelledParams struct {
@@ -1072,11 +2528,16 @@ type UpdateCancelledParams struct {
 	OrderID   string
 }
 
-func (q *Queries) UpdateCancelled(ctx context.Context, arg UpdateCancelledParams) (sql.NullString, error) {
+type UpdateCancelledRow struct {
+	PaymentReference sql.NullString
+	PaymentTime      sql.NullTime
+}
+
+func (q *Queries) UpdateCancelled(ctx context.Context, arg UpdateCancelledParams) (UpdateCancelledRow, error) {
 	row := q.db.QueryRowContext(ctx, updateCancelled, arg.Cancelled, arg.OrderID)
-	var payment_reference sql.NullString
-	err := row.Scan(&payment_reference)
-	return payment_reference, err
+	var i UpdateCancelledRow
+	err := row.Scan(&i.PaymentReference, &i.PaymentTime)
+	return i, err
 }
 
 const updateCollectionTime = `-- name: UpdateCollectionTime :exec
@@ -1098,7 +2559,7 @@ func (q *Queries) UpdateCollectionTime(ctx context.Context, arg UpdateCollection
 	return err
 }
 
-const updateCoupon = `-- name: UpdateCoupon :exec
+const updateCoupon = `-- name: UpdateCoupon :execrows
 UPDATE
 	coupons
 SET
@@ -1106,42 +2567,60 @@ SET
 	coupon_code = ?,
 	min_purchase_quantity = ?,
 	email_match = ?,
-	discount_percentage = ?,
+	requirements = ?,
+	discount = ?,
 	enabled = ?,
-	public = ?
+	public = ?,
+	redemption_limit = ?,
+	customer_redemption_limit = ?,
+	valid_from = ?,
+	valid_until = ?
 WHERE
 	coupon_id = ?
 	AND sale_period = ?
 `
 
 type UpdateCouponParams struct {
-	StripeID            string
-	CouponCode          string
-	MinPurchaseQuantity sql.NullInt64
-	EmailMatch          sql.NullString
-	DiscountPercentage  int64
-	Enabled             bool
-	Public              bool
-	CouponID            int64
-	SalePeriod          int64
-}
-
-func (q *Queries) UpdateCoupon(ctx context.Context, arg UpdateCouponParams) error {
-	_, err := q.db.ExecContext(ctx, updateCoupon,
+	StripeID                string
+	CouponCode              string
+	MinPurchaseQuantity     sql.NullInt64
+	EmailMatch              sql.NullString
+	Requirements            string
+	Discount                string
+	Enabled                 bool
+	Public                  bool
+	RedemptionLimit         sql.NullInt64
+	CustomerRedemptionLimit sql.NullInt64
+	ValidFrom               sql.NullTime
+	ValidUntil              sql.NullTime
+	CouponID                int64
+	SalePeriod              int64
+}
+
+func (q *Queries) UpdateCoupon(ctx context.Context, arg UpdateCouponParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateCoupon,
 		arg.StripeID,
 		arg.CouponCode,
 		arg.MinPurchaseQuantity,
 		arg.EmailMatch,
-		arg.DiscountPercentage,
+		arg.Requirements,
+		arg.Discount,
 		arg.Enabled,
 		arg.Public,
+		arg.RedemptionLimit,
+		arg.CustomerRedemptionLimit,
+		arg.ValidFrom,
+		arg.ValidUntil,
 		arg.CouponID,
 		arg.SalePeriod,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const updateProduct = `-- name: UpdateProduct :exec
+const updateProduct = `-- name: UpdateProduct :execrows
 UPDATE
 	products
 SET
@@ -1167,8 +2646,8 @@ type UpdateProductParams struct {
 	SalePeriod       int64
 }
 
-func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) error {
-	_, err := q.db.ExecContext(ctx, updateProduct,
+func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateProduct,
 		arg.Name,
 		arg.BasePrice,
 		arg.DefaultImageUrl,
@@ -1178,32 +2657,48 @@ func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) er
 		arg.ProductID,
 		arg.SalePeriod,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const updateSalePeriod = `-- name: UpdateSalePeriod :exec
+const updateSalePeriod = `-- name: UpdateSalePeriod :execrows
 UPDATE
 	sale_periods
 SET
 	admin_name = ?,
-	start_time = ?
+	start_time = ?,
+	end_time = ?,
+	closed_message = ?
 WHERE
 	id = ?
 	AND delete_time IS NULL
 `
 
 type UpdateSalePeriodParams struct {
-	AdminName string
-	StartTime time.Time
-	ID        int64
+	AdminName     string
+	StartTime     time.Time
+	EndTime       sql.NullTime
+	ClosedMessage string
+	ID            int64
 }
 
-func (q *Queries) UpdateSalePeriod(ctx context.Context, arg UpdateSalePeriodParams) error {
-	_, err := q.db.ExecContext(ctx, updateSalePeriod, arg.AdminName, arg.StartTime, arg.ID)
-	return err
+func (q *Queries) UpdateSalePeriod(ctx context.Context, arg UpdateSalePeriodParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateSalePeriod,
+		arg.AdminName,
+		arg.StartTime,
+		arg.EndTime,
+		arg.ClosedMessage,
+		arg.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const updateStoreClosure = `-- name: UpdateStoreClosure :exec
+const updateStoreClosure = `-- name: UpdateStoreClosure :execrows
 UPDATE
 	store_closures
 SET
@@ -1224,13 +2719,41 @@ type UpdateStoreClosureParams struct {
 	ID              int64
 }
 
-func (q *Queries) UpdateStoreClosure(ctx context.Context, arg UpdateStoreClosureParams) error {
-	_, err := q.db.ExecContext(ctx, updateStoreClosure,
+func (q *Queries) UpdateStoreClosure(ctx context.Context, arg UpdateStoreClosureParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateStoreClosure,
 		arg.StartTime,
 		arg.EndTime,
 		arg.UserMessage,
 		arg.AllowOrderCheck,
 		arg.ID,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useAdminLoginLink = `-- name: UseAdminLoginLink :one
+UPDATE
+	admin_login_links
+SET
+	used_time = ?1
+WHERE
+	token_hash = ?2
+	AND used_time IS NULL
+	AND expire_time > ?1
+RETURNING
+	email
+`
+
+type UseAdminLoginLinkParams struct {
+	Now       sql.NullTime
+	TokenHash string
+}
+
+func (q *Queries) UseAdminLoginLink(ctx context.Context, arg UseAdminLoginLinkParams) (string, error) {
+	row := q.db.QueryRowContext(ctx, useAdminLoginLink, arg.Now, arg.TokenHash)
+	var email string
+	err := row.Scan(&email)
+	return email, err
 }
@@ -1,6 +1,6 @@
 CREATE TABLE admin_users (
 	email TEXT UNIQUE NOT NULL
-);
+, role TEXT NOT NULL DEFAULT 'owner');
 CREATE TABLE products (
 	product_id         INTEGER PRIMARY KEY,
 	name               TEXT NOT NULL,
@@ -20,14 +20,13 @@ CREATE TABLE coupons (
 	stripe_id             TEXT NOT NULL,
 	min_purchase_quantity INTEGER,
 	email_match           TEXT,
-	discount_percentage   INTEGER NOT NULL,
 	enabled               BOOLEAN NOT NULL,
 	public                BOOLEAN NOT NULL,
 	redemption_limit      INTEGER -- Not actually tracked by this server but Stripe instead.
 , sale_period
 	INTEGER NOT NULL
 	REFERENCES sale_periods(id)
-	DEFAULT 1);
+	DEFAULT 1, discount TEXT NOT NULL DEFAULT '{}', customer_redemption_limit INTEGER, valid_from DATETIME, valid_until DATETIME, requirements TEXT NOT NULL DEFAULT '[]', batch_id INTEGER);
 CREATE TABLE orders (
 	id                INTEGER PRIMARY KEY,
 	order_id          TEXT UNIQUE NOT NULL,
@@ -43,16 +42,6 @@ CREATE TABLE orders (
 	INTEGER NOT NULL
 	REFERENCES sale_periods(id)
 	DEFAULT 1);
-CREATE TABLE order_items (
-	order_id     TEXT    NOT NULL REFERENCES orders(order_id),
-	product_id   TEXT    NOT NULL REFERENCES products(product_id),
-	product_name TEXT    NOT NULL,
-	unit_price   INTEGER NOT NULL,
-	amount       INTEGER NOT NULL,
-	image_url    TEXT    NOT NULL,
-	-- JSON of the selected variants.
-	variant      TEXT    NOT NULL
-);
 CREATE TABLE store_closures (
 	id                INTEGER PRIMARY KEY,
 	start_time        DATETIME NOT NULL,
@@ -67,8 +56,126 @@ CREATE TABLE sale_periods (
 	admin_name  TEXT NOT NULL,
 	start_time  DATETIME NOT NULL,
 	delete_time DATETIME
+, end_time DATETIME, closed_message TEXT NOT NULL DEFAULT '');
+CREATE TABLE product_stock (
+	product_id INTEGER NOT NULL REFERENCES products(product_id),
+	-- Selected options joined the same way as order_items.variant.
+	variant    TEXT    NOT NULL,
+	-- Units that can still be checked out.
+	available  INTEGER NOT NULL,
+	-- Units held by orders that are pending payment.
+	reserved   INTEGER NOT NULL,
+	UNIQUE (product_id, variant)
+);
+CREATE TABLE IF NOT EXISTS "order_items" (
+	id              INTEGER PRIMARY KEY,
+	order_id        TEXT    NOT NULL REFERENCES orders(order_id),
+	product_id      TEXT    NOT NULL REFERENCES products(product_id),
+	product_name    TEXT    NOT NULL,
+	unit_price      INTEGER NOT NULL,
+	amount          INTEGER NOT NULL,
+	image_url       TEXT    NOT NULL,
+	-- JSON of the selected variants.
+	variant         TEXT    NOT NULL,
+	-- Units of this line that have been refunded.
+	refunded_amount INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE order_refunds (
+	id                INTEGER  PRIMARY KEY,
+	order_id          TEXT     NOT NULL REFERENCES orders(order_id),
+	-- Amount refunded in cents.
+	amount            INTEGER  NOT NULL,
+	reason            TEXT     NOT NULL,
+	refund_time       DATETIME NOT NULL,
+	-- Email of the admin that issued the refund.
+	admin_email       TEXT     NOT NULL,
+	-- ID of the refund on the payment provider.
+	payment_reference TEXT     NOT NULL
+);
+CREATE TABLE email_outbox (
+	id                INTEGER  PRIMARY KEY,
+	-- Order that the email is about.
+	order_id          TEXT     NOT NULL,
+	-- Template used, e.g. order_confirmation.
+	kind              TEXT     NOT NULL,
+	recipient         TEXT     NOT NULL,
+	subject           TEXT     NOT NULL,
+	text_body         TEXT     NOT NULL,
+	html_body         TEXT     NOT NULL,
+	create_time       DATETIME NOT NULL,
+	next_attempt_time DATETIME NOT NULL,
+	attempts          INTEGER  NOT NULL DEFAULT 0,
+	last_error        TEXT     NOT NULL DEFAULT '',
+	-- NULL until the email has been handed to the mail server.
+	sent_time         DATETIME
+);
+CREATE INDEX email_outbox_pending ON email_outbox (next_attempt_time) WHERE sent_time IS NULL;
+CREATE TABLE audit_log (
+	id          INTEGER  PRIMARY KEY,
+	-- Email of the admin that made the change.
+	actor_email TEXT     NOT NULL,
+	action_time DATETIME NOT NULL,
+	-- Table-like name of the entity changed, e.g. coupon.
+	entity_type TEXT     NOT NULL,
+	entity_id   TEXT     NOT NULL,
+	-- What was done, e.g. create, update or cancel.
+	action      TEXT     NOT NULL,
+	-- JSON object of the fields that changed. before_json is NULL if the
+	-- entity was created and after_json is NULL if it was deleted.
+	before_json TEXT,
+	after_json  TEXT
+);
+CREATE INDEX audit_log_entity ON audit_log (entity_type, entity_id);
+CREATE INDEX audit_log_actor ON audit_log (actor_email);
+CREATE INDEX audit_log_action_time ON audit_log (action_time);
+CREATE TABLE api_tokens (
+	id             INTEGER  PRIMARY KEY,
+	name           TEXT     NOT NULL,
+	-- SHA-256 of the token in hex. The token itself is only shown when created.
+	token_hash     TEXT     NOT NULL UNIQUE,
+	-- Admin that created the token. Requests made with the token act as them.
+	admin_email    TEXT     NOT NULL,
+	-- Comma separated permissions that the token is limited to.
+	permissions    TEXT     NOT NULL,
+	create_time    DATETIME NOT NULL,
+	expire_time    DATETIME NOT NULL,
+	last_used_time DATETIME,
+	revoke_time    DATETIME
+);
+CREATE TABLE admin_login_links (
+	id          INTEGER  PRIMARY KEY,
+	-- SHA-256 of the token in hex. The token itself is only sent in the email.
+	token_hash  TEXT     NOT NULL UNIQUE,
+	email       TEXT     NOT NULL,
+	create_time DATETIME NOT NULL,
+	expire_time DATETIME NOT NULL,
+	used_time   DATETIME
+);
+CREATE INDEX admin_login_links_email ON admin_login_links (email, create_time);
+CREATE INDEX orders_coupon_id ON orders (coupon_id);
+CREATE TABLE coupon_batches (
+	id          INTEGER PRIMARY KEY,
+	name        TEXT NOT NULL,
+	stripe_id   TEXT NOT NULL,
+	sale_period INTEGER NOT NULL REFERENCES sale_periods(id),
+	create_time DATETIME NOT NULL,
+	revoke_time DATETIME
 );
+CREATE INDEX coupons_batch_id ON coupons (batch_id);
 -- Dbmate schema migrations
 INSERT INTO "schema_migrations" (version) VALUES
   ('20250505031917'),
-  ('20250505035817');
+  ('20250505035817'),
+  ('20250512093000'),
+  ('20250519090000'),
+  ('20250526090000'),
+  ('20250602090000'),
+  ('20250609090000'),
+  ('20250616090000'),
+  ('20250623090000'),
+  ('20250630090000'),
+  ('20250707090000'),
+  ('20250714090000'),
+  ('20250721090000'),
+  ('20250728090000'),
+  ('20250804090000');
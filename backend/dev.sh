@@ -37,6 +37,7 @@ fi
 
 export SESSION_SECRET
 go run . \
+	-mode=dev \
 	-forward=http://localhost:5173 \
 	"-client-id=$GOOGLE_CLIENT_ID" \
 	"-client-secret=$GOOGLE_CLIENT_SECRET" \
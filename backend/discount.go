@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// Types of discounts that coupons can give.
+const (
+	discountPercentage = "percentage"
+	discountFixed      = "fixed"
+	discountFreeItem   = "free_item"
+	discountBuyXGetY   = "buy_x_get_y"
+)
+
+// couponDiscount is the discount given by a coupon. It is stored as JSON in the
+// discount column of coupons.
+type couponDiscount struct {
+	Type string `json:"type"`
+	// Amount is the percentage taken off for percentage discounts, the amount
+	// taken off in cents for fixed discounts and the number of free units for
+	// free item discounts.
+	Amount int `json:"amount,omitempty"`
+	// Buy and Free are the number of units to buy and the number of units that
+	// are free for every such purchase in buy X get Y discounts.
+	Buy  int `json:"buy,omitempty"`
+	Free int `json:"free,omitempty"`
+	// Products are the IDs of the products that the discount applies to. Every
+	// product is discounted if it is empty.
+	Products []string `json:"products,omitempty"`
+}
+
+func parseCouponDiscount(s string) (couponDiscount, error) {
+	var discount couponDiscount
+	if err := json.Unmarshal([]byte(s), &discount); err != nil {
+		return couponDiscount{}, fmt.Errorf("error parsing coupon discount: %w", err)
+	}
+	return discount, nil
+}
+
+// validate checks that the discount can be given. It does not check that the
+// products exist.
+func (d couponDiscount) validate() error {
+	switch d.Type {
+	case discountPercentage:
+		if d.Amount <= 0 || d.Amount > 100 {
+			return errors.New("percentage must be between 1 and 100")
+		}
+	case discountFixed:
+		if d.Amount <= 0 {
+			return errors.New("amount must be positive")
+		}
+	case discountFreeItem:
+		if d.Amount <= 0 {
+			return errors.New("number of free items must be positive")
+		}
+	case discountBuyXGetY:
+		if d.Buy <= 0 || d.Free <= 0 {
+			return errors.New("number of items to buy and free items must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown discount type %q", d.Type)
+	}
+	// Free items are discounted fully by the payment provider, which would
+	// make the whole order free if it is not limited to the products.
+	if d.freesUnits() && len(d.Products) == 0 {
+		return errors.New("free items must be limited to products")
+	}
+	return nil
+}
+
+// freesUnits reports whether the discount makes some units free instead of
+// reducing the price of every unit.
+func (d couponDiscount) freesUnits() bool {
+	return d.Type == discountFreeItem || d.Type == discountBuyXGetY
+}
+
+func (d couponDiscount) appliesTo(productID string) bool {
+	return len(d.Products) == 0 || slices.Contains(d.Products, productID)
+}
+
+// itemDiscounts returns the discount in cents given to each of the items.
+func (d couponDiscount) itemDiscounts(items []db.OrderItem) []int64 {
+	discounts := make([]int64, len(items))
+	if d.freesUnits() {
+		for i, free := range d.freeUnits(items) {
+			discounts[i] = items[i].UnitPrice * free
+		}
+		return discounts
+	}
+	var subtotal int64
+	last := -1
+	for i, item := range items {
+		if d.appliesTo(item.ProductID) {
+			subtotal += item.UnitPrice * item.Amount
+			last = i
+		}
+	}
+	if subtotal == 0 {
+		return discounts
+	}
+	// Both are applied to the total of the items like Stripe does, with
+	// percentages rounded to the nearest cent.
+	total := min(int64(d.Amount), subtotal)
+	if d.Type == discountPercentage {
+		total = (subtotal*int64(d.Amount) + 50) / 100
+	}
+	// The total is split in proportion to the price of each item, with what is
+	// left over from rounding given to the last item so that they add up.
+	remaining := total
+	for i, item := range items {
+		if i == last {
+			discounts[i] = remaining
+			break
+		}
+		if d.appliesTo(item.ProductID) {
+			discounts[i] = total * item.UnitPrice * item.Amount / subtotal
+			remaining -= discounts[i]
+		}
+	}
+	return discounts
+}
+
+// freeUnits returns the number of units of each item that are free. The
+// cheapest units are the ones made free.
+func (d couponDiscount) freeUnits(items []db.OrderItem) []int64 {
+	free := make([]int64, len(items))
+	var eligible []int
+	var count int64
+	for i, item := range items {
+		if d.appliesTo(item.ProductID) {
+			eligible = append(eligible, i)
+			count += item.Amount
+		}
+	}
+	var remaining int64
+	switch d.Type {
+	case discountFreeItem:
+		remaining = min(int64(d.Amount), count)
+	case discountBuyXGetY:
+		remaining = count / int64(d.Buy+d.Free) * int64(d.Free)
+	}
+	slices.SortStableFunc(eligible, func(a, b int) int {
+		return cmp.Compare(items[a].UnitPrice, items[b].UnitPrice)
+	})
+	for _, i := range eligible {
+		free[i] = min(items[i].Amount, remaining)
+		remaining -= free[i]
+	}
+	return free
+}
+
+// paymentItems returns the items to send to the payment provider so that it
+// applies the discount to the same units.
+func (d couponDiscount) paymentItems(items []db.OrderItem) []PaymentItem {
+	paymentItems := make([]PaymentItem, 0, len(items))
+	if !d.freesUnits() {
+		for _, item := range items {
+			paymentItems = append(paymentItems, PaymentItem{
+				OrderItem:  item,
+				Discounted: len(d.Products) > 0 && d.appliesTo(item.ProductID),
+			})
+		}
+		return paymentItems
+	}
+	// Free units are split into their own line as the payment provider can
+	// only discount whole lines.
+	for i, free := range d.freeUnits(items) {
+		item := items[i]
+		if paid := item.Amount - free; paid > 0 {
+			item.Amount = paid
+			paymentItems = append(paymentItems, PaymentItem{OrderItem: item})
+		}
+		if free > 0 {
+			item.Amount = free
+			paymentItems = append(paymentItems, PaymentItem{OrderItem: item, Discounted: true})
+		}
+	}
+	return paymentItems
+}
+
+// paymentDiscount returns the discount as the payment provider applies it. The
+// products that the discount is limited to must be provided.
+func (d couponDiscount) paymentDiscount(products []Product) (PaymentDiscount, error) {
+	var discount PaymentDiscount
+	switch d.Type {
+	case discountPercentage:
+		discount.PercentOff = d.Amount
+	case discountFixed:
+		discount.AmountOff = int64(d.Amount)
+	case discountFreeItem, discountBuyXGetY:
+		discount.PercentOff = 100
+	}
+	for _, id := range d.Products {
+		idx := slices.IndexFunc(products, func(p Product) bool {
+			return p.ID == id
+		})
+		if idx < 0 {
+			return PaymentDiscount{}, fmt.Errorf("unknown product %q", id)
+		}
+		discount.Products = append(discount.Products, PaymentProduct{
+			ID:       id,
+			Name:     products[idx].Name,
+			ImageURL: products[idx].DefaultImageURL,
+		})
+	}
+	return discount, nil
+}
+
+// paidTotal returns the amount to pay for the items after the discounts.
+func paidTotal(items []db.OrderItem, discounts []int64) int64 {
+	var total int64
+	for i, item := range items {
+		total += item.UnitPrice*item.Amount - discounts[i]
+	}
+	return total
+}
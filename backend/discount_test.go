@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+var testDiscountItems = []db.OrderItem{
+	{ProductID: "1", ProductName: "Shirt", UnitPrice: 1700, Amount: 2, Variant: "M"},
+	{ProductID: "1", ProductName: "Shirt", UnitPrice: 1500, Amount: 1, Variant: "S"},
+	{ProductID: "2", ProductName: "Sticker", UnitPrice: 300, Amount: 3},
+}
+
+func TestItemDiscounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount couponDiscount
+		want     []int64
+	}{
+		{"percentage", couponDiscount{Type: discountPercentage, Amount: 10}, []int64{340, 150, 90}},
+		{"fixed rounding", couponDiscount{Type: discountFixed, Amount: 100}, []int64{58, 25, 17}},
+		{"percentage of product", couponDiscount{Type: discountPercentage, Amount: 15, Products: []string{"2"}}, []int64{0, 0, 135}},
+		{"fixed of product", couponDiscount{Type: discountFixed, Amount: 500, Products: []string{"1"}}, []int64{346, 154, 0}},
+		{"fixed above total", couponDiscount{Type: discountFixed, Amount: 10000}, []int64{3400, 1500, 900}},
+		{"fixed of missing product", couponDiscount{Type: discountFixed, Amount: 500, Products: []string{"3"}}, []int64{0, 0, 0}},
+		{"free items", couponDiscount{Type: discountFreeItem, Amount: 2, Products: []string{"1"}}, []int64{1700, 1500, 0}},
+		{"free items above amount", couponDiscount{Type: discountFreeItem, Amount: 5, Products: []string{"2"}}, []int64{0, 0, 900}},
+		{"buy x get y", couponDiscount{Type: discountBuyXGetY, Buy: 2, Free: 1, Products: []string{"1", "2"}}, []int64{0, 0, 600}},
+		{"buy x get y of product", couponDiscount{Type: discountBuyXGetY, Buy: 2, Free: 1, Products: []string{"1"}}, []int64{0, 1500, 0}},
+		{"buy x get y not enough", couponDiscount{Type: discountBuyXGetY, Buy: 3, Free: 1, Products: []string{"1"}}, []int64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.discount.validate(); err != nil {
+				t.Fatalf("got error %v validating discount", err)
+			}
+			if got := tt.discount.itemDiscounts(testDiscountItems); !slices.Equal(got, tt.want) {
+				t.Errorf("got discounts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		discount couponDiscount
+		// want is the amount of each payment item, with discounted items
+		// suffixed by an asterisk.
+		want string
+	}{
+		{"percentage", couponDiscount{Type: discountPercentage, Amount: 10}, "[2 1 3]"},
+		{"percentage of product", couponDiscount{Type: discountPercentage, Amount: 10, Products: []string{"2"}}, "[2 1 3*]"},
+		{"free items", couponDiscount{Type: discountFreeItem, Amount: 2, Products: []string{"1"}}, "[1 1* 1* 3]"},
+		{"buy x get y", couponDiscount{Type: discountBuyXGetY, Buy: 2, Free: 1, Products: []string{"1", "2"}}, "[2 1 1 2*]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, item := range tt.discount.paymentItems(testDiscountItems) {
+				amount := fmt.Sprint(item.Amount)
+				if item.Discounted {
+					amount += "*"
+				}
+				got = append(got, amount)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("got payment items %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDiscount(t *testing.T) {
+	tests := []struct {
+		discount couponDiscount
+		want     string
+	}{
+		{couponDiscount{Type: discountPercentage, Amount: 0}, "percentage must be between 1 and 100"},
+		{couponDiscount{Type: discountPercentage, Amount: 101}, "percentage must be between 1 and 100"},
+		{couponDiscount{Type: discountFixed, Amount: -5}, "amount must be positive"},
+		{couponDiscount{Type: discountFreeItem, Amount: 1}, "free items must be limited to products"},
+		{couponDiscount{Type: discountBuyXGetY, Buy: 2, Products: []string{"1"}}, "number of items to buy and free items must be positive"},
+		{couponDiscount{Type: "free_shipping"}, `unknown discount type "free_shipping"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if err := tt.discount.validate(); err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Mailer delivers emails to users.
+type Mailer interface {
+	// Send delivers the email. It returns an error if the email should be
+	// retried later.
+	Send(email Email) error
+}
+
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// message encodes the email as a multipart/alternative MIME message with both
+// the plaintext and HTML bodies.
+func (e Email) message(from string, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", e.Text},
+		{"text/html; charset=utf-8", e.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	toAddr, err := mail.ParseAddress(e.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", e.To, err)
+	}
+	var msg bytes.Buffer
+	header := []struct {
+		key   string
+		value string
+	}{
+		{"From", fromAddr.String()},
+		{"To", toAddr.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", e.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID(fromAddr.Address)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, v := range header {
+		fmt.Fprintf(&msg, "%s: %s\r\n", v.key, v.value)
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func messageID(from string) string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	domain := from[strings.LastIndexByte(from, '@')+1:]
+	return "<" + hex.EncodeToString(buf[:]) + "@" + domain + ">"
+}
+
+// parseAddress returns the bare email address of a "Name <email>" address.
+func parseAddress(address string) (string, error) {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address %q: %w", address, err)
+	}
+	return addr.Address, nil
+}
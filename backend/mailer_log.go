@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var _ Mailer = (*LogMailer)(nil)
+
+// LogMailer is a mailer for development that does not deliver any email. If a
+// directory is provided, every email is written to it as an .eml file that can
+// be opened by most email clients. Otherwise, the plaintext body is logged.
+type LogMailer struct {
+	dir  string
+	from string
+
+	mu   sync.Mutex
+	next int
+}
+
+func NewLogMailer(dir string, from string) *LogMailer {
+	return &LogMailer{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (m *LogMailer) Send(email Email) error {
+	if m.dir == "" {
+		slog.Info("email not sent, SMTP is not configured", "to", email.To, "subject", email.Subject, "body", email.Text)
+		return nil
+	}
+	msg, err := email.message(m.from, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.next++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().UTC().Format("20060102T150405"), m.next)
+	m.mu.Unlock()
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, msg, 0o644); err != nil {
+		return fmt.Errorf("error writing email: %w", err)
+	}
+	slog.Info("email written to file", "to", email.To, "subject", email.Subject, "path", path)
+	return nil
+}
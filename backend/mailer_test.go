@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailMessage(t *testing.T) {
+	email := Email{
+		To:      "Tan Ah Kow <tanahkow@e.ntu.edu.sg>",
+		Subject: "Order confirmed – thank you",
+		Text:    "Hi Tan Ah Kow,\n\nYour order is confirmed.\n",
+		HTML:    "<p>Hi Tan Ah Kow,</p><p>Your order is confirmed.</p>",
+	}
+	date := time.Date(2025, 5, 26, 9, 0, 0, 0, time.UTC)
+	raw, err := email.message("SCDS Merch Store <noreply@shop.test>", date)
+	if err != nil {
+		t.Fatalf("error encoding message: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("error decoding subject: %v", err)
+	}
+	if subject != email.Subject {
+		t.Errorf("got subject %q, want %q", subject, email.Subject)
+	}
+	if to, err := msg.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Address != "tanahkow@e.ntu.edu.sg" {
+		t.Errorf("got To %q, want the recipient", msg.Header.Get("To"))
+	}
+	if got, err := msg.Header.Date(); err != nil || !got.Equal(date) {
+		t.Errorf("got Date %q, want %s", msg.Header.Get("Date"), date)
+	}
+	if got := msg.Header.Get("Message-ID"); !strings.HasSuffix(got, "@shop.test>") {
+		t.Errorf("got Message-ID %q, want it to be in the sender's domain", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got Content-Type %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("error reading %s part: %v", want.contentType, err)
+		}
+		if got := part.Header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("got part Content-Type %q, want %q", got, want.contentType)
+		}
+		// NextPart decodes the quoted-printable transfer encoding. Line breaks
+		// are sent as CRLF.
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("error reading %s part: %v", want.contentType, err)
+		}
+		if strings.ReplaceAll(string(content), "\r\n", "\n") != want.content {
+			t.Errorf("got %s part %q, want %q", want.contentType, content, want.content)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("got extra part in message, want only text and HTML parts")
+	}
+}
+
+func TestEmailMessageInvalidRecipient(t *testing.T) {
+	_, err := Email{To: "not an address"}.message("noreply@shop.test", time.Now())
+	if err == nil {
+		t.Errorf("got no error encoding message with invalid recipient")
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := NewLogMailer(dir, "noreply@shop.test")
+	for range 2 {
+		if err := mailer.Send(Email{To: "tanahkow@e.ntu.edu.sg", Subject: "Hello", Text: "Hello", HTML: "<p>Hello</p>"}); err != nil {
+			t.Fatalf("error sending email: %v", err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d emails written, want 2", len(files))
+	}
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("error reading email: %v", err)
+	}
+	if _, err := mail.ReadMessage(strings.NewReader(string(content))); err != nil {
+		t.Errorf("error parsing written email: %v", err)
+	}
+}
@@ -1,55 +1,49 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
-	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	sqlite3ConnStr := flag.String("db", "file:db.sqlite3", "Connection string for the SQLite3 database")
-	listenAddr := flag.String("listen", ":8080", "Address and port to listen to")
-	staticDir := flag.String("static", "static", "Directory to static folder to serve")
-	forwardURL := flag.String("forward", "", "URL to forward to, overrides -static flag")
-	frontendURL := flag.String("frontend", "http://localhost:8080", "URL of the frontend")
-	googleClientID := flag.String("client-id", "", "Google Client ID")
-	googleClientSecret := flag.String("client-secret", "", "Google Client Secret")
-	stripeSecretKey := flag.String("stripe-secret", "", "Stripe Secret Key")
-	stripeWebhookSecret := flag.String("stripe-webhook", "", "Stripe Webhook Secret")
-	imageDir := flag.String("image-dir", "", "Image directory")
-	flag.Parse()
-
-	cfg := &ServerConfig{
-		ListenAddr:          *listenAddr,
-		Sqlite3ConnStr:      *sqlite3ConnStr,
-		FrontendURL:         *frontendURL,
-		GoogleClientID:      *googleClientID,
-		GoogleClientSecret:  *googleClientSecret,
-		StripeSecretKey:     *stripeSecretKey,
-		StripeWebhookSecret: *stripeWebhookSecret,
+	cfg, printConfig, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case err != nil:
+		slog.Error("error loading config", "err", err)
+		os.Exit(2)
 	}
-	if *forwardURL != "" {
-		parsedURL, err := url.Parse(*forwardURL)
-		if err != nil {
-			slog.Error("error parsing URL to forward to", "err", err)
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			slog.Error("error printing config", "err", err)
+			os.Exit(1)
 		}
-		cfg.Forwarder = httputil.NewSingleHostReverseProxy(parsedURL)
-	} else {
-		static := http.Dir(*staticDir)
-		cfg.StaticDir = &static
+		return
 	}
-	if *imageDir != "" {
-		if err := os.MkdirAll(*imageDir, 0o755); err != nil {
-			slog.Error("error creating image directory", "err", err)
+	if len(cfg.Command) > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runCommand(ctx, cfg, cfg.Command, os.Stdout); err != nil {
+			slog.Error("error running command", "err", err)
 			os.Exit(1)
 		}
-		cfg.ImageDir = *imageDir
-	} else {
-		slog.Warn("image directory not configured")
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		slog.Error("invalid config", "err", err)
+		os.Exit(2)
+	}
+	if err := cfg.Prepare(); err != nil {
+		slog.Error("error preparing config", "err", err)
+		os.Exit(1)
 	}
 
 	if err := run(cfg); err != nil {
@@ -58,25 +52,24 @@ func main() {
 	}
 }
 
-type ServerConfig struct {
-	ListenAddr     string
-	Sqlite3ConnStr string
-	StaticDir      *http.Dir
-	ImageDir       string
-	Forwarder      *httputil.ReverseProxy
-
-	GoogleClientID      string
-	GoogleClientSecret  string
-	StripeSecretKey     string
-	StripeWebhookSecret string
-	FrontendURL         string
-}
-
 func run(config *ServerConfig) error {
+	// Deploys send SIGTERM, which is handled by finishing the requests in
+	// flight before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	server, err := NewServer(config)
 	if err != nil {
 		return fmt.Errorf("error constructing server: %w", err)
 	}
-	slog.Info("server listening", "addr", config.ListenAddr)
-	return http.ListenAndServe(config.ListenAddr, server.HTTPMux())
+	defer func() {
+		if err := server.Close(); err != nil {
+			slog.Error("error closing server", "err", err)
+		}
+	}()
+	ln, err := net.Listen("tcp", config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("error listening: %w", err)
+	}
+	slog.Info("server listening", "addr", ln.Addr())
+	return server.Serve(ctx, ln)
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reasons that checkouts fail for.
+const (
+	checkoutFailureClosed          = "store_closed"
+	checkoutFailureInvalidRequest  = "invalid_request"
+	checkoutFailureInvalidCoupon   = "invalid_coupon"
+	checkoutFailureInvalidVariant  = "invalid_variant"
+	checkoutFailureOutOfStock      = "out_of_stock"
+	checkoutFailurePaymentProvider = "payment_provider"
+	checkoutFailureInternal        = "internal"
+)
+
+// Metrics are the Prometheus metrics of a server. Every server has its own
+// registry so that tests do not share counters.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	checkoutAttempts prometheus.Counter
+	checkoutSuccess  prometheus.Counter
+	checkoutFailures *prometheus.CounterVec
+	webhookEvents    *prometheus.CounterVec
+	orderIDRetries   prometheus.Counter
+
+	backupLastSuccess prometheus.Gauge
+	backupFailures    prometheus.Counter
+}
+
+func NewMetrics(queries *db.Queries) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ccds_http_requests_total",
+			Help: "Number of HTTP requests handled, by route and status code.",
+		}, []string{"route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ccds_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		checkoutAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ccds_checkout_attempts_total",
+			Help: "Number of checkouts attempted.",
+		}),
+		checkoutSuccess: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ccds_checkout_successes_total",
+			Help: "Number of checkouts that were sent to the payment provider.",
+		}),
+		checkoutFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ccds_checkout_failures_total",
+			Help: "Number of checkouts that failed, by reason.",
+		}, []string{"reason"}),
+		webhookEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ccds_webhook_events_total",
+			Help: "Number of payment webhook events received, by type.",
+		}, []string{"type"}),
+		orderIDRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ccds_order_id_retries_total",
+			Help: "Number of times an order had to be retried with a new order ID.",
+		}),
+		backupLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ccds_backup_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful scheduled backup.",
+		}),
+		backupFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ccds_backup_failures_total",
+			Help: "Number of scheduled backups that failed.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.checkoutAttempts,
+		m.checkoutSuccess,
+		m.checkoutFailures,
+		m.webhookEvents,
+		m.orderIDRetries,
+		m.backupLastSuccess,
+		m.backupFailures,
+		orderCollector{queries: queries},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument records the count and latency of requests served by the mux by
+// the pattern that they matched so that path values do not blow up the
+// number of series.
+func (m *Metrics) Instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, req)
+		// The mux sets the pattern on the request as it routes it.
+		route := req.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+		m.requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+var (
+	paidOrdersDesc = prometheus.NewDesc(
+		"ccds_orders_paid",
+		"Number of paid orders in the current sale period.",
+		[]string{"sale_period"}, nil,
+	)
+	uncollectedOrdersDesc = prometheus.NewDesc(
+		"ccds_orders_uncollected",
+		"Number of paid orders in the current sale period that have not been collected.",
+		[]string{"sale_period"}, nil,
+	)
+)
+
+// orderCollector counts the orders of the current sale period whenever the
+// metrics are scraped.
+type orderCollector struct {
+	queries *db.Queries
+}
+
+func (c orderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- paidOrdersDesc
+	ch <- uncollectedOrdersDesc
+}
+
+func (c orderCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	period, err := c.queries.CurrentSalePeriod(ctx, time.Now().UTC())
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return
+	case err != nil:
+		ch <- prometheus.NewInvalidMetric(paidOrdersDesc, err)
+		ch <- prometheus.NewInvalidMetric(uncollectedOrdersDesc, err)
+		return
+	}
+	periodID := strconv.FormatInt(period.ID, 10)
+	paid, err := c.queries.CountPaidOrders(ctx, period.ID)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(paidOrdersDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(paidOrdersDesc, prometheus.GaugeValue, float64(paid), periodID)
+	}
+	uncollected, err := c.queries.CountUncollectedOrders(ctx, period.ID)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(uncollectedOrdersDesc, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(uncollectedOrdersDesc, prometheus.GaugeValue, float64(uncollected), periodID)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// metrics scrapes the metrics of the server.
+func (ts *testServer) metrics() string {
+	ts.t.Helper()
+	rec := ts.request("GET", "/metrics", nil, true)
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("error scraping metrics: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func expectMetrics(t *testing.T, metrics string, want ...string) {
+	t.Helper()
+	lines := strings.Split(metrics, "\n")
+	for _, w := range want {
+		found := false
+		for _, line := range lines {
+			if line == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("metrics do not contain %q", w)
+		}
+	}
+}
+
+func TestCheckoutMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	ts.checkout(testCheckoutRequest(shirtItem("1", "S", 2)))
+
+	invalidCoupon := testCheckoutRequest(shirtItem("1", "M", 1))
+	invalidCoupon.Coupon = ptr("NOPE")
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", invalidCoupon, false), http.StatusBadRequest, "Invalid coupon code")
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", testCheckoutRequest(shirtItem("1", "XXXL", 1)), false), http.StatusBadRequest, "")
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", testCheckoutRequest(shirtItem("1", "S", 3)), false), http.StatusBadRequest, "")
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", testCheckoutRequest(), false), http.StatusBadRequest, "")
+
+	expectMetrics(t, ts.metrics(),
+		"ccds_checkout_attempts_total 6",
+		"ccds_checkout_successes_total 2",
+		`ccds_checkout_failures_total{reason="invalid_coupon"} 1`,
+		`ccds_checkout_failures_total{reason="invalid_request"} 1`,
+		`ccds_checkout_failures_total{reason="invalid_variant"} 1`,
+		`ccds_checkout_failures_total{reason="out_of_stock"} 1`,
+		`ccds_orders_paid{sale_period="1"} 1`,
+		`ccds_orders_uncollected{sale_period="1"} 1`,
+	)
+}
+
+func TestPaymentProviderFailureMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.payment.DefaultState = MockSessionFailed
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", testCheckoutRequest(shirtItem("1", "M", 1)), false), http.StatusInternalServerError, "")
+	expectMetrics(t, ts.metrics(), `ccds_checkout_failures_total{reason="payment_provider"} 1`)
+}
+
+func TestWebhookMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	for _, payload := range []string{
+		`{"type":"charge.succeeded"}`,
+		`{"type":"charge.succeeded"}`,
+		`not json`,
+	} {
+		ts.request("POST", "/api/v0/checkout/stripe", payload, false)
+	}
+	expectMetrics(t, ts.metrics(), `ccds_webhook_events_total{type="charge.succeeded"} 2`)
+}
+
+func TestRequestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	ts.request("GET", "/api/v0/orders/AB1234", nil, false)
+	ts.request("GET", "/api/v0/orders/CD5678", nil, false)
+	ts.request("GET", "/api/v0/sales/current/products", nil, false)
+	metrics := ts.metrics()
+	// Requests are grouped by the route rather than the path.
+	expectMetrics(t, metrics,
+		`ccds_http_requests_total{code="200",route="GET /api/v0/orders/{id}"} 2`,
+		`ccds_http_requests_total{code="200",route="GET /api/v0/sales/{sale_id}/products"} 1`,
+		`ccds_http_request_duration_seconds_count{route="GET /api/v0/orders/{id}"} 2`,
+	)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// PaymentProvider is the payment backend used to collect payment for orders.
+type PaymentProvider interface {
+	// CreateSession creates a checkout session that the user should be
+	// redirected to in order to pay for the order.
+	CreateSession(params PaymentSessionParams) (*PaymentSession, error)
+	// GetSession fetches the latest state of the checkout session.
+	GetSession(sessionID string) (*PaymentSession, error)
+	// ExpireSession expires the checkout session so that it can no longer be
+	// paid for.
+	ExpireSession(sessionID string) error
+	// Refund refunds the payment collected by the checkout session. If amount
+	// is nil, everything that has not been refunded yet is refunded.
+	Refund(sessionID string, amount *int64, reason string) (*PaymentRefund, error)
+	// UpsertCoupon attempts to update the given coupon but creates a new one if
+	// it is not possible to do so. It returns the ID of the coupon with the
+	// given attributes.
+	UpsertCoupon(couponID string, name string, discount PaymentDiscount) (string, error)
+	// CouponName returns the name of the coupon as shown to the user during
+	// payment.
+	CouponName(couponID string) (string, error)
+	// VerifyWebhook verifies the webhook request and parses the event.
+	VerifyWebhook(payload []byte, header http.Header) (*PaymentEvent, error)
+	// Ping checks that the payment provider can be reached with the
+	// configured credentials.
+	Ping() error
+}
+
+type PaymentSessionParams struct {
+	OrderID string
+	Email   string
+	Items   []PaymentItem
+	// CouponID is the ID of the coupon on the payment provider, if any.
+	CouponID *string
+	// CompleteURL is where the user is sent after paying. The session ID is
+	// passed in the session_id query parameter.
+	CompleteURL string
+	// CancelURL is where the user is sent if the checkout is abandoned.
+	CancelURL string
+}
+
+// PaymentItem is an item in a checkout session.
+type PaymentItem struct {
+	db.OrderItem
+	// Discounted is set for items that a coupon limited to products applies
+	// to. Coupons that are not limited apply to every item.
+	Discounted bool
+}
+
+// PaymentDiscount is the discount given by a coupon on the payment provider.
+// Only one of PercentOff and AmountOff is set.
+type PaymentDiscount struct {
+	PercentOff int
+	// AmountOff is the amount taken off in cents.
+	AmountOff int64
+	// Products limits the discount to discounted items of the products. Every
+	// item is discounted if it is empty.
+	Products []PaymentProduct
+}
+
+// PaymentProduct is how discounted items of a product are shown to the user
+// during payment.
+type PaymentProduct struct {
+	ID       string
+	Name     string
+	ImageURL string
+}
+
+type PaymentSessionStatus string
+
+const (
+	PaymentSessionOpen     PaymentSessionStatus = "open"
+	PaymentSessionComplete PaymentSessionStatus = "complete"
+	PaymentSessionExpired  PaymentSessionStatus = "expired"
+)
+
+type PaymentSession struct {
+	ID     string
+	URL    string
+	Status PaymentSessionStatus
+	Paid   bool
+	// CouponID is the ID of the coupon applied to the session, if any.
+	CouponID string
+	// AmountTotal is the amount to be paid in cents after discounts.
+	AmountTotal int64
+	ExpiresAt   time.Time
+}
+
+type PaymentRefund struct {
+	ID string
+	// Amount is the amount refunded in cents.
+	Amount int64
+}
+
+const (
+	PaymentEventSessionCompleted = "checkout.session.completed"
+	PaymentEventSessionExpired   = "checkout.session.expired"
+)
+
+type PaymentEvent struct {
+	Type string
+	// SessionID is the checkout session the event is about, if any.
+	SessionID string
+}
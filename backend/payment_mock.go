@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+var _ PaymentProvider = (*MockPayment)(nil)
+
+// MockSessionState is the outcome simulated by a mock checkout session.
+type MockSessionState string
+
+const (
+	// MockSessionPaid sessions are complete and paid.
+	MockSessionPaid MockSessionState = "paid"
+	// MockSessionUnpaid sessions are still open and waiting for payment.
+	MockSessionUnpaid MockSessionState = "unpaid"
+	// MockSessionExpired sessions have expired without being paid.
+	MockSessionExpired MockSessionState = "expired"
+	// MockSessionFailed sessions cannot be fetched, similar to when the
+	// payment provider is unavailable.
+	MockSessionFailed MockSessionState = "failed"
+)
+
+func ParseMockSessionState(s string) (MockSessionState, error) {
+	switch state := MockSessionState(s); state {
+	case MockSessionPaid, MockSessionUnpaid, MockSessionExpired, MockSessionFailed:
+		return state, nil
+	default:
+		return "", fmt.Errorf("unknown mock session state %q", s)
+	}
+}
+
+func (s MockSessionState) MarshalText() ([]byte, error) {
+	return []byte(s), nil
+}
+
+func (s *MockSessionState) UnmarshalText(b []byte) error {
+	state, err := ParseMockSessionState(string(b))
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+var errMockPaymentFailed = errors.New("mock payment provider: simulated failure")
+
+// MockPayment is a payment provider that does not collect any payment. IDs are
+// assigned sequentially so that its behaviour is deterministic.
+type MockPayment struct {
+	// DefaultState is the state of newly created sessions. If it is
+	// MockSessionFailed, session creation fails.
+	DefaultState MockSessionState
+
+	mu           sync.Mutex
+	sessions     map[string]*mockSession
+	coupons      map[string]mockCoupon
+	nextSession  int
+	nextCouponID int
+	nextRefundID int
+}
+
+type mockSession struct {
+	params   PaymentSessionParams
+	state    MockSessionState
+	refunded int64
+}
+
+type mockCoupon struct {
+	name     string
+	discount PaymentDiscount
+}
+
+func NewMockPayment(defaultState MockSessionState) *MockPayment {
+	return &MockPayment{
+		DefaultState: defaultState,
+		sessions:     make(map[string]*mockSession),
+		coupons:      make(map[string]mockCoupon),
+	}
+}
+
+// SetSessionState changes the outcome of an existing session.
+func (p *MockPayment) SetSessionState(sessionID string, state MockSessionState) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("mock payment provider: unknown session %q", sessionID)
+	}
+	session.state = state
+	return nil
+}
+
+func (p *MockPayment) CreateSession(params PaymentSessionParams) (*PaymentSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.DefaultState == MockSessionFailed {
+		return nil, errMockPaymentFailed
+	}
+	p.nextSession++
+	sessionID := fmt.Sprintf("mock_cs_%d", p.nextSession)
+	session := &mockSession{
+		params: params,
+		state:  p.DefaultState,
+	}
+	p.sessions[sessionID] = session
+	return session.toPaymentSession(sessionID, p.sessionTotal(params)), nil
+}
+
+func (p *MockPayment) GetSession(sessionID string) (*PaymentSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[sessionID]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("mock payment provider: unknown session %q", sessionID)
+	case session.state == MockSessionFailed:
+		return nil, errMockPaymentFailed
+	}
+	return session.toPaymentSession(sessionID, p.sessionTotal(session.params)), nil
+}
+
+func (p *MockPayment) ExpireSession(sessionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[sessionID]
+	switch {
+	case !ok:
+		return fmt.Errorf("mock payment provider: unknown session %q", sessionID)
+	case session.state == MockSessionFailed:
+		return errMockPaymentFailed
+	case session.state != MockSessionUnpaid:
+		return fmt.Errorf("mock payment provider: only open sessions can be expired (session is %s)", session.state)
+	}
+	session.state = MockSessionExpired
+	return nil
+}
+
+func (p *MockPayment) Refund(sessionID string, amount *int64, reason string) (*PaymentRefund, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	session, ok := p.sessions[sessionID]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("mock payment provider: unknown session %q", sessionID)
+	case session.state == MockSessionFailed:
+		return nil, errMockPaymentFailed
+	case session.state != MockSessionPaid:
+		return nil, fmt.Errorf("mock payment provider: only paid sessions can be refunded (session is %s)", session.state)
+	}
+	remaining := p.sessionTotal(session.params) - session.refunded
+	refundAmount := remaining
+	if amount != nil {
+		refundAmount = *amount
+	}
+	switch {
+	case refundAmount <= 0:
+		return nil, errors.New("mock payment provider: refund amount must be positive")
+	case refundAmount > remaining:
+		return nil, fmt.Errorf("mock payment provider: refund amount %d exceeds remaining amount %d", refundAmount, remaining)
+	}
+	session.refunded += refundAmount
+	p.nextRefundID++
+	return &PaymentRefund{
+		ID:     fmt.Sprintf("mock_re_%d", p.nextRefundID),
+		Amount: refundAmount,
+	}, nil
+}
+
+func (p *MockPayment) UpsertCoupon(couponID string, name string, discount PaymentDiscount) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if coupon, ok := p.coupons[couponID]; ok && coupon.discount.PercentOff == discount.PercentOff &&
+		coupon.discount.AmountOff == discount.AmountOff && slices.Equal(coupon.discount.Products, discount.Products) {
+		p.coupons[couponID] = mockCoupon{
+			name:     name,
+			discount: discount,
+		}
+		return couponID, nil
+	}
+	p.nextCouponID++
+	couponID = fmt.Sprintf("mock_coupon_%d", p.nextCouponID)
+	p.coupons[couponID] = mockCoupon{
+		name:     name,
+		discount: discount,
+	}
+	return couponID, nil
+}
+
+func (p *MockPayment) CouponName(couponID string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	coupon, ok := p.coupons[couponID]
+	if !ok {
+		return "", fmt.Errorf("mock payment provider: unknown coupon %q", couponID)
+	}
+	return coupon.name, nil
+}
+
+// VerifyWebhook accepts a JSON object containing the event type and session ID
+// without any signature.
+func (p *MockPayment) VerifyWebhook(payload []byte, header http.Header) (*PaymentEvent, error) {
+	var event struct {
+		Type      string `json:"type"`
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("mock payment provider: invalid webhook payload: %w", err)
+	}
+	return &PaymentEvent{
+		Type:      event.Type,
+		SessionID: event.SessionID,
+	}, nil
+}
+
+// Ping fails if the default state is MockSessionFailed.
+func (p *MockPayment) Ping() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.DefaultState == MockSessionFailed {
+		return errMockPaymentFailed
+	}
+	return nil
+}
+
+// sessionTotal returns the amount to be paid for the session, discounting
+// items the way Stripe does.
+func (p *MockPayment) sessionTotal(params PaymentSessionParams) int64 {
+	var total, eligible int64
+	var discount PaymentDiscount
+	if params.CouponID != nil {
+		discount = p.coupons[*params.CouponID].discount
+	}
+	for _, item := range params.Items {
+		total += item.UnitPrice * item.Amount
+		if len(discount.Products) == 0 || item.Discounted && slices.ContainsFunc(discount.Products, func(product PaymentProduct) bool {
+			return product.ID == item.ProductID
+		}) {
+			eligible += item.UnitPrice * item.Amount
+		}
+	}
+	if discount.AmountOff > 0 {
+		return total - min(discount.AmountOff, eligible)
+	}
+	return total - (eligible*int64(discount.PercentOff)+50)/100
+}
+
+func (s *mockSession) toPaymentSession(sessionID string, total int64) *PaymentSession {
+	session := &PaymentSession{
+		ID:          sessionID,
+		URL:         s.params.CompleteURL + "?session_id=" + sessionID,
+		Status:      PaymentSessionOpen,
+		AmountTotal: total,
+	}
+	switch s.state {
+	case MockSessionPaid:
+		session.Status = PaymentSessionComplete
+		session.Paid = true
+	case MockSessionExpired:
+		session.Status = PaymentSessionExpired
+	}
+	if s.params.CouponID != nil {
+		session.CouponID = *s.params.CouponID
+	}
+	return session
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/client"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+var _ PaymentProvider = (*StripePayment)(nil)
+
+type StripePayment struct {
+	api           *client.API
+	webhookSecret string
+}
+
+func NewStripePayment(secretKey string, webhookSecret string) *StripePayment {
+	api := &client.API{}
+	api.Init(secretKey, nil)
+	return &StripePayment{
+		api:           api,
+		webhookSecret: webhookSecret,
+	}
+}
+
+func (p *StripePayment) CreateSession(params PaymentSessionParams) (*PaymentSession, error) {
+	checkoutLineItems := make([]*stripe.CheckoutSessionLineItemParams, 0, len(params.Items))
+	for _, v := range params.Items {
+		priceData := &stripe.CheckoutSessionLineItemPriceDataParams{
+			Currency:   stripe.String("sgd"),
+			UnitAmount: stripe.Int64(v.UnitPrice),
+		}
+		if v.Discounted {
+			// Coupons can only be limited to existing products, so discounted
+			// items are sold as the product created with the coupon.
+			priceData.Product = stripe.String(stripeProductID(v.ProductID))
+		} else {
+			var imageData []*string
+			if v.ImageUrl != "" {
+				imageData = append(imageData, stripe.String(v.ImageUrl))
+			}
+			var desc *string
+			if v.Variant != "" {
+				// Stripe does not like empty values as it assumes we are unsetting it.
+				desc = stripe.String(v.Variant)
+			}
+			priceData.ProductData = &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+				Name:        &v.ProductName,
+				Images:      imageData,
+				Description: desc,
+			}
+		}
+		checkoutLineItems = append(checkoutLineItems, &stripe.CheckoutSessionLineItemParams{
+			PriceData: priceData,
+			Quantity:  &v.Amount,
+		})
+	}
+	var discount []*stripe.CheckoutSessionDiscountParams
+	if params.CouponID != nil {
+		discount = []*stripe.CheckoutSessionDiscountParams{
+			{
+				Coupon: params.CouponID,
+			},
+		}
+	}
+	checkoutParams := &stripe.CheckoutSessionParams{
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL:        stripe.String(params.CompleteURL + "?session_id={CHECKOUT_SESSION_ID}"),
+		CancelURL:         stripe.String(params.CancelURL),
+		LineItems:         checkoutLineItems,
+		Discounts:         discount,
+		ClientReferenceID: stripe.String(params.OrderID),
+		CustomerEmail:     stripe.String(params.Email),
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
+			Description: stripe.String("Your order ID is " + params.OrderID + "."),
+		},
+	}
+	session, err := p.api.CheckoutSessions.New(checkoutParams)
+	if err != nil {
+		return nil, err
+	}
+	return stripeToPaymentSession(session), nil
+}
+
+func (p *StripePayment) GetSession(sessionID string) (*PaymentSession, error) {
+	session, err := p.api.CheckoutSessions.Get(sessionID, &stripe.CheckoutSessionParams{
+		Expand: []*string{stripe.String("total_details.breakdown")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stripeToPaymentSession(session), nil
+}
+
+func (p *StripePayment) ExpireSession(sessionID string) error {
+	_, err := p.api.CheckoutSessions.Expire(sessionID, &stripe.CheckoutSessionExpireParams{})
+	return err
+}
+
+func (p *StripePayment) Refund(sessionID string, amount *int64, reason string) (*PaymentRefund, error) {
+	session, err := p.api.CheckoutSessions.Get(sessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if session.PaymentIntent == nil {
+		return nil, fmt.Errorf("checkout session %q has no payment intent", sessionID)
+	}
+	refundParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(session.PaymentIntent.ID),
+		Amount:        amount,
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	if reason != "" {
+		refundParams.AddMetadata("reason", reason)
+	}
+	refund, err := p.api.Refunds.New(refundParams)
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentRefund{
+		ID:     refund.ID,
+		Amount: refund.Amount,
+	}, nil
+}
+
+func (p *StripePayment) UpsertCoupon(couponID string, name string, discount PaymentDiscount) (string, error) {
+	couponParam := &stripe.CouponParams{
+		Name: &name,
+	}
+	if discount.AmountOff > 0 {
+		couponParam.AmountOff = stripe.Int64(discount.AmountOff)
+		couponParam.Currency = stripe.String("sgd")
+	} else {
+		couponParam.PercentOff = stripe.Float64(float64(discount.PercentOff))
+	}
+	productIDs := make([]string, 0, len(discount.Products))
+	for _, product := range discount.Products {
+		productID, err := p.upsertProduct(product)
+		if err != nil {
+			return "", err
+		}
+		productIDs = append(productIDs, productID)
+	}
+	if len(productIDs) > 0 {
+		couponParam.AppliesTo = &stripe.CouponAppliesToParams{
+			Products: stripe.StringSlice(productIDs),
+		}
+	}
+	tryUpdate := func() (ok bool) {
+		if couponID == "" {
+			return false
+		}
+		coupon, err := p.api.Coupons.Get(couponID, &stripe.CouponParams{
+			Expand: []*string{stripe.String("applies_to")},
+		})
+		if err != nil {
+			slog.Warn("error fetching Stripe coupon, falling back to creating new coupon", "old_coupon_id", couponID, "err", err)
+			return false
+		}
+		var oldProductIDs []string
+		if coupon.AppliesTo != nil {
+			oldProductIDs = coupon.AppliesTo.Products
+		}
+		if int(coupon.PercentOff) != discount.PercentOff || coupon.AmountOff != discount.AmountOff || !sameElements(oldProductIDs, productIDs) {
+			slog.Debug(
+				"falling back to creating new coupon, discount changed",
+				"old_coupon_id", couponID,
+				"old_percentage", coupon.PercentOff,
+				"new_percentage", discount.PercentOff,
+				"old_amount", coupon.AmountOff,
+				"new_amount", discount.AmountOff,
+				"old_products", oldProductIDs,
+				"new_products", productIDs,
+			)
+			return false
+		}
+		if coupon.Name == name {
+			slog.Debug("skipping update, name is equal", "coupon_id", couponID)
+			return true
+		}
+		_, err = p.api.Coupons.Update(couponID, &stripe.CouponParams{
+			Name: &name,
+		})
+		if err != nil {
+			slog.Warn("error updating coupon, falling back to creating new coupon", "old_coupon_id", couponID, "err", err)
+			return false
+		}
+		return true
+	}
+	if tryUpdate() {
+		return couponID, nil
+	}
+	coupon, err := p.api.Coupons.New(couponParam)
+	if err != nil {
+		return "", fmt.Errorf("error creating Stripe coupon (%q): %w", name, err)
+	}
+	return coupon.ID, nil
+}
+
+// upsertProduct creates or updates the Stripe product that discounted items of
+// the product are sold as, returning its ID.
+func (p *StripePayment) upsertProduct(product PaymentProduct) (string, error) {
+	productID := stripeProductID(product.ID)
+	productParam := &stripe.ProductParams{
+		Name: stripe.String(product.Name),
+	}
+	if product.ImageURL != "" {
+		productParam.Images = stripe.StringSlice([]string{product.ImageURL})
+	}
+	_, err := p.api.Products.Update(productID, productParam)
+	var stripeErr *stripe.Error
+	switch {
+	case err == nil:
+		return productID, nil
+	case !errors.As(err, &stripeErr) || stripeErr.Code != stripe.ErrorCodeResourceMissing:
+		return "", fmt.Errorf("error updating Stripe product (%q): %w", productID, err)
+	}
+	productParam.ID = stripe.String(productID)
+	if _, err := p.api.Products.New(productParam); err != nil {
+		return "", fmt.Errorf("error creating Stripe product (%q): %w", productID, err)
+	}
+	return productID, nil
+}
+
+// stripeProductID returns the ID of the Stripe product for the product. It is
+// chosen by us so that it does not have to be stored.
+func stripeProductID(productID string) string {
+	return "ccds_product_" + productID
+}
+
+// sameElements reports whether the slices have the same elements in any order.
+func sameElements(a []string, b []string) bool {
+	a = slices.Sorted(slices.Values(a))
+	b = slices.Sorted(slices.Values(b))
+	return slices.Equal(a, b)
+}
+
+func (p *StripePayment) CouponName(couponID string) (string, error) {
+	coupon, err := p.api.Coupons.Get(couponID, nil)
+	if err != nil {
+		return "", err
+	}
+	return coupon.Name, nil
+}
+
+func (p *StripePayment) VerifyWebhook(payload []byte, header http.Header) (*PaymentEvent, error) {
+	event, err := webhook.ConstructEvent(payload, header.Get("Stripe-Signature"), p.webhookSecret)
+	if err != nil {
+		return nil, err
+	}
+	paymentEvent := &PaymentEvent{
+		Type: string(event.Type),
+	}
+	switch event.Type {
+	case PaymentEventSessionCompleted, PaymentEventSessionExpired:
+		sessionID, ok := event.Data.Object["id"].(string)
+		if !ok {
+			return nil, fmt.Errorf("error reading ID of checkout session: %v", event.Data.Object)
+		}
+		paymentEvent.SessionID = sessionID
+	}
+	return paymentEvent, nil
+}
+
+// Ping fetches the account balance, which fails if the secret key is invalid.
+func (p *StripePayment) Ping() error {
+	if _, err := p.api.Balance.Get(nil); err != nil {
+		return fmt.Errorf("error fetching balance: %w", err)
+	}
+	return nil
+}
+
+func stripeToPaymentSession(session *stripe.CheckoutSession) *PaymentSession {
+	paymentSession := &PaymentSession{
+		ID:          session.ID,
+		URL:         session.URL,
+		Status:      PaymentSessionStatus(session.Status),
+		Paid:        session.PaymentStatus != stripe.CheckoutSessionPaymentStatusUnpaid,
+		AmountTotal: session.AmountTotal,
+		ExpiresAt:   time.Unix(session.ExpiresAt, 0),
+	}
+	if session.TotalDetails != nil && session.TotalDetails.Breakdown != nil && len(session.TotalDetails.Breakdown.Discounts) > 0 {
+		paymentSession.CouponID = session.TotalDetails.Breakdown.Discounts[0].Discount.Coupon.ID
+	}
+	return paymentSession
+}
@@ -1,11 +1,23 @@
 #!/usr/bin/env sh
 
-export SESSION_SECRET
-/app/ccds-shop \
-	"-static=/app/static" \
-	"-frontend=$FRONTEND_URL" \
-	"-client-id=$GOOGLE_CLIENT_ID" \
-	"-client-secret=$GOOGLE_CLIENT_SECRET" \
-	"-stripe-secret=$STRIPE_SECRET_KEY" \
-	"-stripe-webhook=$STRIPE_WEBHOOK_SECRET" \
-	"-image-dir=/app/content"
+# legacy exports the CCDS_* variable $1 as $2 unless it is already set, so that
+# the variables documented in the README keep working.
+legacy() {
+	if [ -z "$(printenv "$1")" ] && [ -n "$2" ]; then
+		export "$1=$2"
+	fi
+}
+
+legacy CCDS_FRONTEND "$FRONTEND_URL"
+legacy CCDS_CLIENT_ID "$GOOGLE_CLIENT_ID"
+legacy CCDS_CLIENT_SECRET "$GOOGLE_CLIENT_SECRET"
+legacy CCDS_STRIPE_SECRET "$STRIPE_SECRET_KEY"
+legacy CCDS_STRIPE_WEBHOOK "$STRIPE_WEBHOOK_SECRET"
+legacy CCDS_SMTP_HOST "$SMTP_HOST"
+legacy CCDS_SMTP_PORT "$SMTP_PORT"
+legacy CCDS_SMTP_USERNAME "$SMTP_USERNAME"
+legacy CCDS_SMTP_PASSWORD "$SMTP_PASSWORD"
+legacy CCDS_EMAIL_FROM "${EMAIL_FROM:-SCDS Merch Store <noreply@ntuscds.com>}"
+legacy CCDS_STATIC /app/static
+legacy CCDS_IMAGE_DIR /app/content
+exec /app/ccds-shop "$@"
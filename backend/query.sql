@@ -1,8 +1,8 @@
 -- name: CreateSalePeriod :one
 INSERT INTO sale_periods (
-	admin_name, start_time, delete_time
+	admin_name, start_time, end_time, closed_message, delete_time
 ) VALUES (
-	?, ?, NULL
+	?, ?, ?, ?, NULL
 ) RETURNING
 	id;
 
@@ -10,37 +10,102 @@ INSERT INTO sale_periods (
 SELECT
 	*
 FROM
-	sale_periods;
+	sale_periods
+WHERE
+	(delete_time IS NOT NULL) = CAST(@archived AS BOOLEAN);
 
--- name: UpdateSalePeriod :exec
+-- name: UpdateSalePeriod :execrows
 UPDATE
 	sale_periods
 SET
 	admin_name = ?,
-	start_time = ?
+	start_time = ?,
+	end_time = ?,
+	closed_message = ?
 WHERE
 	id = ?
 	AND delete_time IS NULL;
 
+-- name: DeleteSalePeriod :execrows
+UPDATE
+	sale_periods
+SET
+	delete_time = ?
+WHERE
+	id = ?
+	AND delete_time IS NULL;
+
+-- name: RestoreSalePeriod :execrows
+UPDATE
+	sale_periods
+SET
+	delete_time = NULL
+WHERE
+	id = ?
+	AND delete_time IS NOT NULL;
+
+-- name: CountPaidOrders :one
+SELECT
+	COUNT(*)
+FROM
+	orders
+WHERE
+	payment_time IS NOT NULL
+	AND cancelled = FALSE
+	AND sale_period = ?;
+
+-- name: CountUncollectedOrders :one
+SELECT
+	COUNT(*)
+FROM
+	orders
+WHERE
+	payment_time IS NOT NULL
+	AND collection_time IS NULL
+	AND cancelled = FALSE
+	AND sale_period = ?;
+
+-- name: SalePeriodByID :one
+SELECT
+	*
+FROM
+	sale_periods
+WHERE
+	id = ?;
+
 -- name: CurrentSalePeriod :one
 SELECT
-	id
+	*
 FROM
 	sale_periods
 WHERE
-	start_time <= date('now')
+	start_time <= @current_time
 	AND delete_time IS NULL
 ORDER BY
 	start_time DESC
 LIMIT
 	1;
 
+-- name: NextSalePeriodStart :one
+SELECT
+	start_time
+FROM
+	sale_periods
+WHERE
+	start_time > @current_time
+	AND delete_time IS NULL
+ORDER BY
+	start_time
+LIMIT
+	1;
+
 -- name: CreateAdminUser :exec
 INSERT INTO admin_users (
-	email
+	email, role
 ) VALUES (
-	?
-) ON CONFLICT DO NOTHING;
+	?, ?
+) ON CONFLICT (email) DO UPDATE SET
+	role = excluded.role;
 
 -- name: CountAdminUsers :one
 SELECT
@@ -48,6 +113,14 @@ SELECT
 FROM
 	admin_users;
 
+-- name: CountAdminUsersWithRole :one
+SELECT
+	COUNT(*)
+FROM
+	admin_users
+WHERE
+	role = ?;
+
 -- name: ListAdminUsers :many
 SELECT
 	*
@@ -68,6 +141,63 @@ DELETE FROM
 WHERE
 	email = ?;
 
+-- name: CreateApiToken :one
+INSERT INTO api_tokens (
+	name, token_hash, admin_email, permissions, create_time, expire_time
+) VALUES (
+	?, ?, ?, ?, ?, ?
+) RETURNING id;
+
+-- name: ApiTokenByHash :one
+SELECT
+	*
+FROM
+	api_tokens
+WHERE
+	token_hash = @token_hash
+	AND revoke_time IS NULL
+	AND expire_time > @now;
+
+-- name: ApiTokenByID :one
+SELECT
+	*
+FROM
+	api_tokens
+WHERE
+	id = ?;
+
+-- name: ListApiTokens :many
+SELECT
+	*
+FROM
+	api_tokens
+WHERE
+	CAST(@admin_email AS TEXT) = ''
+	OR admin_email = @admin_email
+ORDER BY
+	id;
+
+-- name: RevokeApiToken :execrows
+UPDATE
+	api_tokens
+SET
+	revoke_time = ?
+WHERE
+	id = ?
+	AND revoke_time IS NULL;
+
+-- name: TouchApiToken :exec
+UPDATE
+	api_tokens
+SET
+	last_used_time = @now
+WHERE
+	id = @id
+	AND (
+		last_used_time IS NULL
+		OR last_used_time < @stale_time
+	);
+
 -- name: CreateProduct :one
 INSERT INTO products (
 	name, base_price, default_image_url, variants, variant_image_urls, enabled, sale_period
@@ -87,7 +217,7 @@ WHERE
 	)
 	AND sale_period = ?;
 
--- name: UpdateProduct :exec
+-- name: UpdateProduct :execrows
 UPDATE
 	products
 SET
@@ -101,6 +231,14 @@ WHERE
 	product_id = ?
 	AND sale_period = ?;
 
+-- name: ProductByID :one
+SELECT
+	*
+FROM
+	products
+WHERE
+	product_id = ?;
+
 -- name: SetProductEnabled :exec
 UPDATE
 	products
@@ -109,11 +247,80 @@ SET
 WHERE
 	product_id = ?;
 
+-- name: ListProductStock :many
+SELECT
+	*
+FROM
+	product_stock
+WHERE
+	product_id IN (
+		SELECT
+			product_id
+		FROM
+			products
+		WHERE
+			sale_period = ?
+	);
+
+-- name: ProductStockByVariant :one
+SELECT
+	*
+FROM
+	product_stock
+WHERE
+	product_id = ?
+	AND variant = ?;
+
+-- name: SetProductStock :exec
+INSERT INTO product_stock (
+	product_id, variant, available, reserved
+) VALUES (
+	?, ?, ?, 0
+) ON CONFLICT (product_id, variant) DO UPDATE SET
+	available = excluded.available;
+
+-- name: DeleteProductStock :exec
+DELETE FROM
+	product_stock
+WHERE
+	product_id = ?
+	AND variant = ?;
+
+-- name: ReserveStock :execrows
+UPDATE
+	product_stock
+SET
+	available = available - @amount,
+	reserved = reserved + @amount
+WHERE
+	product_id = @product_id
+	AND variant = @variant
+	AND available >= @amount;
+
+-- name: ConfirmStock :exec
+UPDATE
+	product_stock
+SET
+	reserved = reserved - @amount
+WHERE
+	product_id = ?
+	AND variant = ?;
+
+-- name: ReleaseStock :exec
+UPDATE
+	product_stock
+SET
+	available = available + @amount,
+	reserved = reserved - @reserved_amount
+WHERE
+	product_id = ?
+	AND variant = ?;
+
 -- name: CreateCoupon :one
 INSERT INTO coupons (
-	stripe_id, coupon_code, min_purchase_quantity, email_match, discount_percentage, enabled, public, sale_period
+	stripe_id, coupon_code, min_purchase_quantity, email_match, requirements, discount, enabled, public, redemption_limit, customer_redemption_limit, valid_from, valid_until, sale_period, batch_id
 ) VALUES (
-	?, ?, ?, ?, ?, ?, ?, ?
+	?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 ) RETURNING coupon_id;
 
 -- name: ListCoupons :many
@@ -121,6 +328,15 @@ SELECT
 	*
 FROM
 	coupons
+WHERE
+	sale_period = ?
+	AND batch_id IS NULL;
+
+-- name: ListCouponCodes :many
+SELECT
+	coupon_code
+FROM
+	coupons
 WHERE
 	sale_period = ?;
 
@@ -132,7 +348,21 @@ FROM
 WHERE
 	enabled = TRUE
 	AND public = TRUE
-	AND sale_period = ?;
+	AND sale_period = ?
+	AND (valid_from IS NULL OR valid_from <= @current_time)
+	AND (valid_until IS NULL OR valid_until > @current_time)
+	AND (
+		redemption_limit IS NULL
+		OR redemption_limit > (
+			SELECT
+				COUNT(*)
+			FROM
+				orders
+			WHERE
+				orders.coupon_id = coupons.coupon_id
+				AND orders.cancelled = FALSE
+		)
+	);
 
 -- name: CouponByID :one
 SELECT
@@ -150,9 +380,11 @@ FROM
 WHERE
 	coupon_code = ? COLLATE NOCASE
 	AND enabled = TRUE
-	AND sale_period = ?;
+	AND sale_period = ?
+	AND (valid_from IS NULL OR valid_from <= @current_time)
+	AND (valid_until IS NULL OR valid_until > @current_time);
 
--- name: UpdateCoupon :exec
+-- name: UpdateCoupon :execrows
 UPDATE
 	coupons
 SET
@@ -160,13 +392,117 @@ SET
 	coupon_code = ?,
 	min_purchase_quantity = ?,
 	email_match = ?,
-	discount_percentage = ?,
+	requirements = ?,
+	discount = ?,
 	enabled = ?,
-	public = ?
+	public = ?,
+	redemption_limit = ?,
+	customer_redemption_limit = ?,
+	valid_from = ?,
+	valid_until = ?
 WHERE
 	coupon_id = ?
 	AND sale_period = ?;
 
+-- name: CountCouponRedemptions :one
+SELECT
+	COUNT(*) AS total,
+	CAST(COALESCE(SUM(matric_number = @matric_number COLLATE NOCASE OR email = @email COLLATE NOCASE), 0) AS INTEGER) AS customer
+FROM
+	orders
+WHERE
+	coupon_id = @coupon_id
+	AND cancelled = FALSE;
+
+-- name: ListCouponRedemptions :many
+SELECT
+	coupons.coupon_id,
+	COUNT(orders.payment_time) AS paid,
+	COUNT(orders.id) - COUNT(orders.payment_time) AS pending
+FROM
+	coupons
+	JOIN orders ON orders.coupon_id = coupons.coupon_id AND orders.cancelled = FALSE
+WHERE
+	coupons.sale_period = ?
+GROUP BY
+	coupons.coupon_id;
+
+-- name: CreateCouponBatch :one
+INSERT INTO coupon_batches (
+	name, stripe_id, sale_period, create_time
+) VALUES (
+	?, ?, ?, ?
+) RETURNING id;
+
+-- name: CouponBatchByID :one
+SELECT
+	*
+FROM
+	coupon_batches
+WHERE
+	id = ?
+	AND sale_period = ?;
+
+-- name: ListCouponBatches :many
+SELECT
+	coupon_batches.*,
+	(
+		SELECT
+			COUNT(*)
+		FROM
+			coupons
+		WHERE
+			coupons.batch_id = coupon_batches.id
+	) AS coupon_count,
+	(
+		SELECT
+			COUNT(*)
+		FROM
+			orders
+			JOIN coupons ON orders.coupon_id = coupons.coupon_id
+		WHERE
+			coupons.batch_id = coupon_batches.id
+			AND orders.cancelled = FALSE
+			AND orders.payment_time IS NOT NULL
+	) AS redemptions
+FROM
+	coupon_batches
+WHERE
+	sale_period = ?
+ORDER BY
+	id;
+
+-- name: ListCouponBatchCodes :many
+SELECT
+	coupons.coupon_code,
+	coupons.enabled,
+	COUNT(orders.payment_time) AS redemptions
+FROM
+	coupons
+	LEFT JOIN orders ON orders.coupon_id = coupons.coupon_id AND orders.cancelled = FALSE
+WHERE
+	coupons.batch_id = ?
+GROUP BY
+	coupons.coupon_id
+ORDER BY
+	coupons.coupon_id;
+
+-- name: RevokeCouponBatch :exec
+UPDATE
+	coupon_batches
+SET
+	revoke_time = ?
+WHERE
+	id = ?;
+
+-- name: DisableCouponBatch :exec
+UPDATE
+	coupons
+SET
+	enabled = FALSE
+WHERE
+	batch_id = ?;
+
 -- name: SetCouponEnabled :exec
 UPDATE
 	coupons
@@ -190,6 +526,22 @@ SET
 WHERE
 	order_id = ?;
 
+-- name: OrderByID :one
+SELECT
+	*
+FROM
+	orders
+WHERE
+	order_id = ?;
+
+-- name: OrderByPaymentReference :one
+SELECT
+	*
+FROM
+	orders
+WHERE
+	payment_reference = ?;
+
 -- name: LookupOrder :many
 SELECT
 	orders.*,
@@ -229,6 +581,7 @@ WHERE
 			order_items.order_id = orders.order_id
 			AND order_items.product_name = @product_name COLLATE NOCASE
 			AND order_items.variant = @variant COLLATE NOCASE
+			AND order_items.refunded_amount < order_items.amount
 	)
 	AND orders.payment_time IS NOT NULL
 	AND orders.cancelled = FALSE;
@@ -236,7 +589,7 @@ WHERE
 -- name: OrderSummary :many
 SELECT
 	order_items.product_id, order_items.product_name, order_items.variant,
-	SUM(order_items.amount)
+	SUM(order_items.amount - order_items.refunded_amount)
 FROM
 	orders
 	JOIN order_items ON orders.order_id = order_items.order_id
@@ -251,6 +604,24 @@ WHERE
 GROUP BY
 	order_items.product_id, order_items.product_name, order_items.variant;
 
+-- name: ExportOrderItems :many
+SELECT
+	order_items.id, orders.order_id, orders.name, orders.matric_number, orders.email,
+	orders.payment_time, orders.collection_time, orders.cancelled, coupons.coupon_code,
+	order_items.product_name, order_items.variant, order_items.unit_price,
+	order_items.amount, order_items.refunded_amount
+FROM
+	order_items
+	JOIN orders ON orders.order_id = order_items.order_id
+	LEFT JOIN coupons ON orders.coupon_id = coupons.coupon_id
+WHERE
+	orders.sale_period = @sale_period
+	AND order_items.id > @after_id
+ORDER BY
+	order_items.id
+LIMIT
+	@max_count;
+
 -- name: OrderNumberStats :many
 SELECT
 	CAST((orders.collection_time IS NULL) AS BOOLEAN) AS uncollected,
@@ -281,20 +652,7 @@ LIMIT
 UPDATE
 	orders
 SET
-	payment_time = COALESCE(payment_time, ?),
-	coupon_id = (
-		SELECT * FROM (
-			SELECT
-				coupon_id
-			FROM
-				coupons
-			WHERE
-				stripe_id = @coupon_stripe_id
-			UNION ALL
-			SELECT NULL
-		) x
-		LIMIT 1
-	)
+	payment_time = COALESCE(payment_time, ?)
 WHERE
 	orders.payment_reference = ?
 RETURNING order_id;
@@ -326,7 +684,7 @@ WHERE
 	order_id = ?
 	AND cancelled = FALSE
 RETURNING
-	payment_reference;
+	payment_reference, payment_time;
 
 -- name: CreateOrderItem :exec
 INSERT INTO order_items (
@@ -343,6 +701,41 @@ FROM
 WHERE
 	order_id = ?;
 
+-- name: RefundOrderItem :execrows
+UPDATE
+	order_items
+SET
+	refunded_amount = refunded_amount + @amount
+WHERE
+	id = @id
+	AND order_id = @order_id
+	AND refunded_amount + @amount <= amount;
+
+-- name: RefundAllOrderItems :exec
+UPDATE
+	order_items
+SET
+	refunded_amount = amount
+WHERE
+	order_id = ?;
+
+-- name: CreateOrderRefund :exec
+INSERT INTO order_refunds (
+	order_id, amount, reason, refund_time, admin_email, payment_reference
+) VALUES (
+	?, ?, ?, ?, ?, ?
+);
+
+-- name: ListOrderRefunds :many
+SELECT
+	*
+FROM
+	order_refunds
+WHERE
+	order_id = ?
+ORDER BY
+	refund_time;
+
 -- name: CreateStoreClosure :one
 INSERT INTO store_closures (
 	start_time, end_time, user_message, allow_order_check, deleted
@@ -361,6 +754,14 @@ WHERE
 	AND deleted = FALSE
 LIMIT 1;
 
+-- name: StoreClosureByID :one
+SELECT
+	*
+FROM
+	store_closures
+WHERE
+	id = ?;
+
 -- name: ListStoreClosures :many
 SELECT
 	*
@@ -369,7 +770,7 @@ FROM
 WHERE
 	deleted = FALSE;
 
--- name: UpdateStoreClosure :exec
+-- name: UpdateStoreClosure :execrows
 UPDATE
 	store_closures
 SET
@@ -388,3 +789,95 @@ SET
 	deleted = TRUE
 WHERE
 	id = ?;
+
+-- name: CreateOutboxEmail :exec
+INSERT INTO email_outbox (
+	order_id, kind, recipient, subject, text_body, html_body, create_time, next_attempt_time
+) VALUES (
+	?, ?, ?, ?, ?, ?, ?, ?
+);
+
+-- name: PendingOutboxEmails :many
+SELECT
+	*
+FROM
+	email_outbox
+WHERE
+	sent_time IS NULL
+	AND next_attempt_time <= @now
+	AND attempts < @max_attempts
+ORDER BY
+	id
+LIMIT
+	@max_count;
+
+-- name: MarkOutboxEmailSent :exec
+UPDATE
+	email_outbox
+SET
+	sent_time = ?,
+	attempts = attempts + 1
+WHERE
+	id = ?;
+
+-- name: MarkOutboxEmailFailed :exec
+UPDATE
+	email_outbox
+SET
+	attempts = attempts + 1,
+	last_error = ?,
+	next_attempt_time = ?
+WHERE
+	id = ?;
+
+-- name: CreateAuditLog :exec
+INSERT INTO audit_log (
+	actor_email, action_time, entity_type, entity_id, action, before_json, after_json
+) VALUES (
+	?, ?, ?, ?, ?, ?, ?
+);
+
+-- name: ListAuditLog :many
+SELECT
+	*
+FROM
+	audit_log
+WHERE
+	(CAST(@entity_type AS TEXT) = '' OR entity_type = @entity_type)
+	AND (CAST(@entity_id AS TEXT) = '' OR entity_id = @entity_id)
+	AND (CAST(@actor_email AS TEXT) = '' OR actor_email = @actor_email)
+	AND action_time >= @from_time
+	AND action_time < @until_time
+	AND id < @before_id
+ORDER BY
+	id DESC
+LIMIT
+	@max_count;
+
+-- name: CreateAdminLoginLink :exec
+INSERT INTO admin_login_links (
+	token_hash, email, create_time, expire_time
+) VALUES (
+	?, ?, ?, ?
+);
+
+-- name: UseAdminLoginLink :one
+UPDATE
+	admin_login_links
+SET
+	used_time = @now
+WHERE
+	token_hash = @token_hash
+	AND used_time IS NULL
+	AND expire_time > @now
+RETURNING
+	email;
+
+-- name: CountRecentAdminLoginLinks :one
+SELECT
+	COUNT(*)
+FROM
+	admin_login_links
+WHERE
+	email = ?
+	AND create_time > @since;
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// Types of requirements that buyers must meet to use coupons.
+const (
+	requirementPurchaseCount = "purchase_count"
+	requirementEmail         = "email"
+	requirementValidTime     = "valid_time"
+	requirementEmailDomain   = "email_domain"
+	requirementEmailRegex    = "email_regex"
+	requirementMatricPrefix  = "matric_prefix"
+	requirementProduct       = "product"
+	requirementMinSubtotal   = "min_subtotal"
+)
+
+// couponRequirement is a requirement that buyers must meet to use a coupon.
+// purchase_count, email and valid_time requirements are stored in their own
+// columns of coupons, while the rest are stored as JSON in the requirements
+// column.
+type couponRequirement struct {
+	Type string `json:"type"`
+	// Amount is the minimum number of items for purchase_count requirements and
+	// the minimum subtotal in cents for min_subtotal requirements.
+	Amount int `json:"amount,omitempty"`
+	// Value is the email for email requirements, the regular expression that
+	// the lowercased email must match for email_regex requirements and the
+	// product ID for product requirements.
+	Value string `json:"value,omitempty"`
+	// Values are the accepted email domains for email_domain requirements and
+	// the accepted matric number prefixes for matric_prefix requirements.
+	Values []string `json:"values,omitempty"`
+	// Options are the variant options that the product has to be bought in for
+	// product requirements.
+	Options []string `json:"options,omitempty"`
+	// From and Until are the times that valid_time requirements start and
+	// stop accepting the coupon at. Either can be left unset.
+	From  *time.Time `json:"from,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// couponRequirements returns every requirement of the coupon.
+func couponRequirements(coupon db.Coupon) ([]couponRequirement, error) {
+	var requirements []couponRequirement
+	if coupon.MinPurchaseQuantity.Valid {
+		requirements = append(requirements, couponRequirement{
+			Type:   requirementPurchaseCount,
+			Amount: int(coupon.MinPurchaseQuantity.Int64),
+		})
+	}
+	if coupon.EmailMatch.Valid {
+		requirements = append(requirements, couponRequirement{
+			Type:  requirementEmail,
+			Value: coupon.EmailMatch.String,
+		})
+	}
+	if coupon.ValidFrom.Valid || coupon.ValidUntil.Valid {
+		req := couponRequirement{Type: requirementValidTime}
+		if coupon.ValidFrom.Valid {
+			req.From = &coupon.ValidFrom.Time
+		}
+		if coupon.ValidUntil.Valid {
+			req.Until = &coupon.ValidUntil.Time
+		}
+		requirements = append(requirements, req)
+	}
+	var stored []couponRequirement
+	if err := json.Unmarshal([]byte(coupon.Requirements), &stored); err != nil {
+		return nil, fmt.Errorf("error parsing coupon requirements: %w", err)
+	}
+	return append(requirements, stored...), nil
+}
+
+// normalize checks that a requirement stored in the requirements column can be
+// met and normalizes its values for comparison.
+func (r *couponRequirement) normalize(products []Product) error {
+	switch r.Type {
+	case requirementEmailDomain:
+		if len(r.Values) == 0 {
+			return errors.New("email domains are required")
+		}
+		for i, domain := range r.Values {
+			r.Values[i] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+			if r.Values[i] == "" {
+				return errors.New("email domain must not be empty")
+			}
+		}
+	case requirementEmailRegex:
+		if _, err := regexp.Compile(r.Value); err != nil {
+			return fmt.Errorf("invalid email regex: %w", err)
+		}
+	case requirementMatricPrefix:
+		if len(r.Values) == 0 {
+			return errors.New("matric number prefixes are required")
+		}
+		for i, prefix := range r.Values {
+			r.Values[i] = strings.ToUpper(strings.TrimSpace(prefix))
+			if r.Values[i] == "" {
+				return errors.New("matric number prefix must not be empty")
+			}
+		}
+	case requirementProduct:
+		idx := slices.IndexFunc(products, func(p Product) bool {
+			return p.ID == r.Value
+		})
+		if idx < 0 {
+			return fmt.Errorf("unknown product %q", r.Value)
+		}
+		for _, option := range r.Options {
+			if !slices.ContainsFunc(products[idx].Variants, func(v ProductVariant) bool {
+				return slices.ContainsFunc(v.Options, func(o ProductVariantOptions) bool {
+					return o.Text == option
+				})
+			}) {
+				return fmt.Errorf("unknown option %q of product %q", option, r.Value)
+			}
+		}
+	case requirementMinSubtotal:
+		if r.Amount <= 0 {
+			return errors.New("minimum subtotal must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown requirement type %q", r.Type)
+	}
+	return nil
+}
+
+// check returns why the checkout does not meet the requirement to be shown to
+// the buyer, or an empty string if it does.
+func (r couponRequirement) check(req CheckoutRequest, items []db.OrderItem, products []Product, now time.Time) string {
+	email := strings.ToLower(req.Email)
+	switch r.Type {
+	case requirementPurchaseCount:
+		count := 0
+		for _, item := range req.Items {
+			count += item.Amount
+		}
+		if count < r.Amount {
+			return fmt.Sprintf("Coupon requires at least %d items", r.Amount)
+		}
+	case requirementEmail:
+		if !strings.EqualFold(r.Value, req.Email) {
+			return "Coupon is not valid for this email"
+		}
+	case requirementValidTime:
+		if (r.From != nil && now.Before(*r.From)) || (r.Until != nil && !now.Before(*r.Until)) {
+			return "Coupon is not valid at this time"
+		}
+	case requirementEmailDomain:
+		if !slices.ContainsFunc(r.Values, func(domain string) bool {
+			return strings.HasSuffix(email, "@"+domain)
+		}) {
+			return "Coupon is only valid for emails ending in @" + strings.Join(r.Values, " or @")
+		}
+	case requirementEmailRegex:
+		// The regex was checked when the coupon was saved.
+		if matched, _ := regexp.MatchString(r.Value, email); !matched {
+			return "Coupon is not valid for this email"
+		}
+	case requirementMatricPrefix:
+		matricNumber := strings.ToUpper(req.MatricNumber)
+		if !slices.ContainsFunc(r.Values, func(prefix string) bool {
+			return strings.HasPrefix(matricNumber, prefix)
+		}) {
+			return "Coupon is only valid for matric numbers starting with " + strings.Join(r.Values, " or ")
+		}
+	case requirementProduct:
+		if !slices.ContainsFunc(req.Items, r.matches) {
+			name := r.Value
+			if idx := slices.IndexFunc(products, func(p Product) bool { return p.ID == r.Value }); idx >= 0 {
+				name = products[idx].Name
+			}
+			if len(r.Options) > 0 {
+				name += " (" + strings.Join(r.Options, ", ") + ")"
+			}
+			return "Coupon requires " + name + " in the cart"
+		}
+	case requirementMinSubtotal:
+		var subtotal int64
+		for _, item := range items {
+			subtotal += item.UnitPrice * item.Amount
+		}
+		if subtotal < int64(r.Amount) {
+			return "Coupon requires a subtotal of at least $" + formatPrice(int64(r.Amount))
+		}
+	default:
+		return "Invalid Coupon Code"
+	}
+	return ""
+}
+
+// matches reports whether the cart item is the product of a product
+// requirement in all of the required options.
+func (r couponRequirement) matches(item CartItem) bool {
+	if item.ID != r.Value {
+		return false
+	}
+	for _, option := range r.Options {
+		if !slices.ContainsFunc(item.Variant, func(v CartItemVariant) bool {
+			return v.Option == option
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+func TestCheckRequirement(t *testing.T) {
+	products := []Product{testShirt, testSticker}
+	products[0].ID = "1"
+	products[1].ID = "2"
+	req := testCheckoutRequest(shirtItem("1", "M", 2), CartItem{ID: "2", Variant: []CartItemVariant{}, Amount: 1})
+	items := []db.OrderItem{
+		{ProductID: "1", UnitPrice: 1700, Amount: 2, Variant: "M"},
+		{ProductID: "2", UnitPrice: 300, Amount: 1},
+	}
+	now := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		requirement couponRequirement
+		want        string
+	}{
+		{"purchase count", couponRequirement{Type: requirementPurchaseCount, Amount: 3}, ""},
+		{"purchase count not met", couponRequirement{Type: requirementPurchaseCount, Amount: 4}, "Coupon requires at least 4 items"},
+		{"email", couponRequirement{Type: requirementEmail, Value: "TanAhKow@e.ntu.edu.sg"}, ""},
+		{"email not met", couponRequirement{Type: requirementEmail, Value: "someone@e.ntu.edu.sg"}, "Coupon is not valid for this email"},
+		{"valid time", couponRequirement{Type: requirementValidTime, From: &now}, ""},
+		{"valid time not met", couponRequirement{Type: requirementValidTime, Until: &now}, "Coupon is not valid at this time"},
+		{"email domain", couponRequirement{Type: requirementEmailDomain, Values: []string{"ntu.edu.sg", "e.ntu.edu.sg"}}, ""},
+		{"email domain not met", couponRequirement{Type: requirementEmailDomain, Values: []string{"ntu.edu.sg"}}, "Coupon is only valid for emails ending in @ntu.edu.sg"},
+		{"email regex", couponRequirement{Type: requirementEmailRegex, Value: `^tan`}, ""},
+		{"email regex not met", couponRequirement{Type: requirementEmailRegex, Value: `^lim`}, "Coupon is not valid for this email"},
+		{"matric prefix", couponRequirement{Type: requirementMatricPrefix, Values: []string{"U21", "U23"}}, ""},
+		{"matric prefix not met", couponRequirement{Type: requirementMatricPrefix, Values: []string{"U22", "G"}}, "Coupon is only valid for matric numbers starting with U22 or G"},
+		{"product", couponRequirement{Type: requirementProduct, Value: "2"}, ""},
+		{"product option", couponRequirement{Type: requirementProduct, Value: "1", Options: []string{"M"}}, ""},
+		{"product option not met", couponRequirement{Type: requirementProduct, Value: "1", Options: []string{"S"}}, "Coupon requires Shirt (S) in the cart"},
+		{"min subtotal", couponRequirement{Type: requirementMinSubtotal, Amount: 3700}, ""},
+		{"min subtotal not met", couponRequirement{Type: requirementMinSubtotal, Amount: 5000}, "Coupon requires a subtotal of at least $50.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.requirement.check(req, items, products, now); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRequirement(t *testing.T) {
+	products := []Product{testShirt}
+	products[0].ID = "1"
+	tests := []struct {
+		requirement couponRequirement
+		want        string
+	}{
+		{couponRequirement{Type: requirementEmailDomain, Values: []string{" @E.NTU.edu.sg"}}, "{email_domain 0  [e.ntu.edu.sg] []}"},
+		{couponRequirement{Type: requirementEmailDomain}, "email domains are required"},
+		{couponRequirement{Type: requirementEmailRegex, Value: "("}, "invalid email regex: error parsing regexp: missing closing ): `(`"},
+		{couponRequirement{Type: requirementMatricPrefix, Values: []string{"u23"}}, "{matric_prefix 0  [U23] []}"},
+		{couponRequirement{Type: requirementMatricPrefix, Values: []string{" "}}, "matric number prefix must not be empty"},
+		{couponRequirement{Type: requirementProduct, Value: "1", Options: []string{"M"}}, "{product 0 1 [] [M]}"},
+		{couponRequirement{Type: requirementProduct, Value: "2"}, `unknown product "2"`},
+		{couponRequirement{Type: requirementProduct, Value: "1", Options: []string{"XL"}}, `unknown option "XL" of product "1"`},
+		{couponRequirement{Type: requirementMinSubtotal}, "minimum subtotal must be positive"},
+		{couponRequirement{Type: "birthday"}, `unknown requirement type "birthday"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			var got string
+			if err := tt.requirement.normalize(products); err != nil {
+				got = err.Error()
+			} else {
+				r := tt.requirement
+				got = fmt.Sprintf("{%s %d %s %v %v}", r.Type, r.Amount, r.Value, r.Values, r.Options)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
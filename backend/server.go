@@ -15,21 +15,25 @@ import (
 	"image/png"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
 	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
 	"github.com/markbates/goth/providers/google"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/stripe/stripe-go/v81/client"
 )
 
 //go:embed db/migrations/*.sql
@@ -39,72 +43,202 @@ type Server struct {
 	Config  *ServerConfig
 	DB      *sql.DB
 	Queries *db.Queries
-	Stripe  *client.API
+	Payment PaymentProvider
+	Mailer  Mailer
+	Metrics *Metrics
+
+	migrations *dbmate.DB
+	// couponNames caches the names of payment provider coupons by their ID.
+	couponNames sync.Map
 }
 
 func NewServer(cfg *ServerConfig) (*Server, error) {
+	// The mock payment provider marks orders as paid without collecting any
+	// payment, so it must never be used in production.
+	if cfg.StripeSecretKey == "" && !cfg.IsDev() {
+		return nil, errors.New("stripe secret is required outside of dev mode")
+	}
+	if cfg.SessionSecret != "" {
+		store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+		store.Options.HttpOnly = true
+		gothic.Store = store
+	}
 	if cfg.authenticationOK() {
 		goth.UseProviders(google.New(cfg.GoogleClientID, cfg.GoogleClientSecret, cfg.FrontendURL+"/api/v0/auth/callback"))
+	} else if cfg.IsDev() {
+		slog.Warn("client id or secret missing, admins log in with the development login form")
 	} else {
 		slog.Warn("client id or secret missing, admin authentication will not work")
 	}
-	connURL, err := url.Parse(cfg.Sqlite3ConnStr)
+	sqlDB, dbmateDB, err := openDatabase(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse connection URL: %w", err)
+		return nil, err
 	}
-	connURL.Scheme = "sqlite3"
-	dbmateDB := dbmate.New(connURL)
-	dbmateDB.FS = migrationFS
-	if err := dbmateDB.CreateAndMigrate(); err != nil {
-		return nil, fmt.Errorf("failed to create and migrate database: %w", err)
-	}
-	sqlDB, err := sql.Open("sqlite3", cfg.Sqlite3ConnStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	if err := dbmateDB.Migrate(); err != nil {
+		_ = sqlDB.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
-	var stripe *client.API
+	var payment PaymentProvider
 	if cfg.StripeSecretKey == "" {
-		slog.Warn("stripe secret missing, payment will be skipped")
+		slog.Warn("stripe secret missing, using mock payment provider", "state", cfg.MockPaymentState)
+		payment = NewMockPayment(cfg.MockPaymentState)
 	} else {
-		stripe = &client.API{}
-		stripe.Init(cfg.StripeSecretKey, nil)
+		payment = NewStripePayment(cfg.StripeSecretKey, cfg.StripeWebhookSecret)
 	}
+	var mailer Mailer
+	if cfg.SMTPHost == "" {
+		slog.Warn("smtp host missing, emails will not be delivered", "dir", cfg.EmailDir)
+		mailer = NewLogMailer(cfg.EmailDir, cfg.EmailFrom)
+	} else {
+		if _, err := parseAddress(cfg.EmailFrom); err != nil {
+			_ = sqlDB.Close()
+			return nil, fmt.Errorf("invalid email sender: %w", err)
+		}
+		mailer = NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.EmailFrom)
+	}
+	queries := db.New(sqlDB)
 	return &Server{
-		Config:  cfg,
-		DB:      sqlDB,
-		Queries: db.New(sqlDB),
-		Stripe:  stripe,
+		Config:     cfg,
+		DB:         sqlDB,
+		Queries:    queries,
+		Payment:    payment,
+		Mailer:     mailer,
+		Metrics:    NewMetrics(queries),
+		migrations: dbmateDB,
 	}, nil
 }
 
-func (s *Server) HTTPMux() *http.ServeMux {
+// openDatabase opens the database and the migrations for it without applying
+// them.
+func openDatabase(cfg *ServerConfig) (*sql.DB, *dbmate.DB, error) {
+	// The database is opened before migrating so that in-memory databases
+	// outlive the connections used by dbmate.
+	sqlDB, err := sql.Open("sqlite3", cfg.Sqlite3ConnStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		_ = sqlDB.Close()
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	// The connection string is passed to dbmate as-is so that options such as
+	// mode=memory are preserved.
+	dbmateDB := dbmate.New(&url.URL{Scheme: "sqlite3", Opaque: cfg.Sqlite3ConnStr})
+	dbmateDB.FS = migrationFS
+	dbmateDB.AutoDumpSchema = !cfg.SkipSchemaDump
+	return sqlDB, dbmateDB, nil
+}
+
+// Serve serves HTTP requests on the listener, sends emails in the outbox and
+// backs up the database until the context is cancelled. Requests in flight are
+// then given up to ShutdownTimeout to finish.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	httpServer := &http.Server{
+		Handler:      s.HTTPMux(),
+		ReadTimeout:  s.Config.ReadTimeout,
+		WriteTimeout: s.Config.WriteTimeout,
+		IdleTimeout:  s.Config.IdleTimeout,
+	}
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	outboxDone := make(chan struct{})
+	go func() {
+		defer close(outboxDone)
+		s.RunOutbox(outboxCtx)
+	}()
+	defer func() {
+		stopOutbox()
+		<-outboxDone
+	}()
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	backupsDone := make(chan struct{})
+	go func() {
+		defer close(backupsDone)
+		s.RunBackups(backupCtx)
+	}()
+	defer func() {
+		stopBackups()
+		<-backupsDone
+	}()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(ln)
+	}()
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down, waiting for requests to finish")
+	shutdownCtx := context.Background()
+	if s.Config.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.Config.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down: %w", err)
+	}
+	return nil
+}
+
+// Close releases the resources of the server. It should only be called after
+// Serve returns.
+func (s *Server) Close() error {
+	return s.DB.Close()
+}
+
+func (s *Server) HTTPMux() http.Handler {
 	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.withPermission(PermViewOrders, s.Metrics.Handler().ServeHTTP))
+	mux.HandleFunc("GET /healthz", s.Healthz)
+	mux.HandleFunc("GET /readyz", s.Readyz)
 	// Called from frontend.
 	mux.HandleFunc("GET /api/v0/sales/{sale_id}/coupons", s.Coupons)
 	mux.HandleFunc("GET /api/v0/sales/{sale_id}/coupons/{id}", s.CouponLookup)
 	mux.HandleFunc("GET /api/v0/sales/{sale_id}/products", s.Products)
 	mux.HandleFunc("GET /api/v0/orders/{id}", s.OrderLookup)
 	mux.HandleFunc("POST /api/v0/checkout", s.Checkout)
-	mux.HandleFunc("POST /api/v0/checkout/stripe", s.StripeWebhook)
+	mux.HandleFunc("POST /api/v0/checkout/stripe", s.PaymentWebhook)
 	mux.HandleFunc("GET /api/v0/checkout/complete", s.CheckoutComplete)
 	// Admin paths.
 	mux.HandleFunc("GET /api/v0/auth", s.Auth)
 	mux.HandleFunc("GET /api/v0/auth/callback", s.AuthCallback)
-	mux.HandleFunc("POST /api/v0/sales/{sale_id}/coupons", s.SaveCoupon)
-	mux.HandleFunc("POST /api/v0/sales/{sale_id}/products", s.SaveProduct)
-	mux.HandleFunc("POST /api/v0/image_upload", s.ImageUpload)
-	mux.HandleFunc("POST /api/v0/orders/{id}/collect", s.OrderCollect)
-	mux.HandleFunc("POST /api/v0/orders/{id}/cancel", s.OrderCancel)
-	mux.HandleFunc("GET /api/v0/perm_check", s.PermissionCheck)
-	mux.HandleFunc("GET /api/v0/users", s.AdminUsers)
-	mux.HandleFunc("POST /api/v0/users", s.CreateAdminUser)
-	mux.HandleFunc("DELETE /api/v0/users", s.DeleteAdminUser)
-	mux.HandleFunc("GET /api/v0/closures", s.StoreClosures)
-	mux.HandleFunc("POST /api/v0/closures", s.SaveStoreClosure)
-	mux.HandleFunc("GET /api/v0/sales", s.SalePeriods)
-	mux.HandleFunc("POST /api/v0/sales", s.SaveSalePeriod)
-	mux.HandleFunc("DELETE /api/v0/closures/{id}", s.DeleteStoreClosure)
-	mux.HandleFunc("GET /api/v0/sales/{sale_id}/order_summary", s.OrderSummary)
+	mux.HandleFunc("GET /api/v0/auth/google", s.GoogleAuth)
+	mux.HandleFunc("GET /api/v0/auth/dev", s.DevLoginForm)
+	mux.HandleFunc("POST /api/v0/auth/dev", s.DevLogin)
+	mux.HandleFunc("POST /api/v0/auth/magic", s.RequestMagicLink)
+	mux.HandleFunc("GET /api/v0/auth/magic", s.MagicLinkForm)
+	mux.HandleFunc("POST /api/v0/auth/magic/login", s.MagicLinkLogin)
+	mux.HandleFunc("POST /api/v0/sales/{sale_id}/coupons", s.withPermission(PermEditStore, s.SaveCoupon))
+	mux.HandleFunc("GET /api/v0/sales/{sale_id}/coupon_batches", s.withPermission(PermViewOrders, s.CouponBatches))
+	mux.HandleFunc("POST /api/v0/sales/{sale_id}/coupons/batch", s.withPermission(PermEditStore, s.CreateCouponBatch))
+	mux.HandleFunc("GET /api/v0/sales/{sale_id}/coupon_batches/{batch_id}", s.withPermission(PermEditStore, s.CouponBatchExport))
+	mux.HandleFunc("DELETE /api/v0/sales/{sale_id}/coupons/batch/{batch_id}", s.withPermission(PermEditStore, s.RevokeCouponBatch))
+	mux.HandleFunc("POST /api/v0/sales/{sale_id}/products", s.withPermission(PermEditStore, s.SaveProduct))
+	mux.HandleFunc("POST /api/v0/image_upload", s.withPermission(PermEditStore, s.ImageUpload))
+	mux.HandleFunc("POST /api/v0/orders/{id}/collect", s.withPermission(PermCollectOrders, s.OrderCollect))
+	mux.HandleFunc("POST /api/v0/orders/{id}/cancel", s.withPermission(PermManageOrders, s.OrderCancel))
+	mux.HandleFunc("POST /api/v0/orders/{id}/refund", s.withPermission(PermManageOrders, s.OrderRefund))
+	mux.HandleFunc("GET /api/v0/perm_check", s.withPermission(PermViewOrders, s.PermissionCheck))
+	mux.HandleFunc("GET /api/v0/users/me", s.withPermission(PermViewOrders, s.CurrentAdminUser))
+	mux.HandleFunc("GET /api/v0/users", s.withPermission(PermManageUsers, s.AdminUsers))
+	mux.HandleFunc("POST /api/v0/users", s.withPermission(PermManageUsers, s.CreateAdminUser))
+	mux.HandleFunc("DELETE /api/v0/users", s.withPermission(PermManageUsers, s.DeleteAdminUser))
+	mux.HandleFunc("GET /api/v0/tokens", s.withPermission(PermViewOrders, s.APITokens))
+	mux.HandleFunc("POST /api/v0/tokens", s.withPermission(PermViewOrders, s.CreateAPIToken))
+	mux.HandleFunc("DELETE /api/v0/tokens/{id}", s.withPermission(PermViewOrders, s.RevokeAPIToken))
+	mux.HandleFunc("GET /api/v0/audit_log", s.withPermission(PermViewAuditLog, s.AuditLog))
+	mux.HandleFunc("GET /api/v0/backup", s.withPermission(PermDownloadBackup, s.DownloadBackup))
+	mux.HandleFunc("GET /api/v0/closures", s.withPermission(PermViewOrders, s.StoreClosures))
+	mux.HandleFunc("POST /api/v0/closures", s.withPermission(PermEditStore, s.SaveStoreClosure))
+	mux.HandleFunc("GET /api/v0/sales", s.withPermission(PermViewOrders, s.SalePeriods))
+	mux.HandleFunc("POST /api/v0/sales", s.withPermission(PermEditStore, s.SaveSalePeriod))
+	mux.HandleFunc("POST /api/v0/sales/{sale_id}/clone", s.withPermission(PermEditStore, s.CloneSalePeriod))
+	mux.HandleFunc("DELETE /api/v0/sales/{sale_id}", s.withPermission(PermEditStore, s.DeleteSalePeriod))
+	mux.HandleFunc("POST /api/v0/sales/{sale_id}/restore", s.withPermission(PermEditStore, s.RestoreSalePeriod))
+	mux.HandleFunc("DELETE /api/v0/closures/{id}", s.withPermission(PermEditStore, s.DeleteStoreClosure))
+	mux.HandleFunc("GET /api/v0/sales/{sale_id}/order_summary", s.withPermission(PermViewOrders, s.OrderSummary))
+	mux.HandleFunc("GET /api/v0/sales/{sale_id}/orders/export", s.withPermission(PermViewOrders, s.OrderExport))
 	mux.Handle("/api/", http.NotFoundHandler())
 	if s.Config.ImageDir != "" {
 		mux.Handle("GET /content/", http.StripPrefix("/content/", noDirListing(http.FileServer(http.Dir(s.Config.ImageDir)))))
@@ -119,14 +253,21 @@ func (s *Server) HTTPMux() *http.ServeMux {
 	case s.Config.StaticDir != nil:
 		mux.Handle("/", http.FileServer(singlePageAppFS{s.Config.StaticDir}))
 	}
-	return mux
+	return s.Metrics.Instrument(mux)
 }
 
 type (
-	User               string
+	User struct {
+		Email string `json:"email"`
+		Role  Role   `json:"role"`
+	}
 	AdminUsersResponse struct {
 		Users []User `json:"users"`
 	}
+	CurrentUserResponse struct {
+		User
+		Permissions []Permission `json:"permissions"`
+	}
 )
 
 func (s *Server) AdminUsers(w http.ResponseWriter, req *http.Request) {
@@ -142,7 +283,10 @@ func (s *Server) AdminUsers(w http.ResponseWriter, req *http.Request) {
 	}
 	users := make([]User, 0, len(dbUsers))
 	for _, u := range dbUsers {
-		users = append(users, User(u))
+		users = append(users, User{
+			Email: u.Email,
+			Role:  Role(u.Role),
+		})
 	}
 	if err := json.NewEncoder(w).Encode(AdminUsersResponse{
 		Users: users,
@@ -152,6 +296,25 @@ func (s *Server) AdminUsers(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// CurrentAdminUser returns the logged in admin and what they are allowed to do.
+func (s *Server) CurrentAdminUser(w http.ResponseWriter, req *http.Request) {
+	admin, ok := s.sessionAdmin(w, req)
+	if !ok {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(CurrentUserResponse{
+		User: User{
+			Email: admin.Email,
+			Role:  Role(admin.Role),
+		},
+		Permissions: admin.Permissions(),
+	}); err != nil {
+		slog.Error("error writing current user response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// CreateAdminUser adds an admin user, or changes the role of an existing one.
 func (s *Server) CreateAdminUser(w http.ResponseWriter, req *http.Request) {
 	if !s.authCheck(w, req) {
 		return
@@ -162,13 +325,16 @@ func (s *Server) CreateAdminUser(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
-	ctx := req.Context()
-	if err := s.Queries.CreateAdminUser(ctx, string(user)); err != nil {
-		slog.Error("error creating admin user", "err", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if !user.Role.Valid() {
+		http.Error(w, "Invalid Role", http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	s.updateAdminUsers(w, req, user.Email, func(ctx context.Context, queries *db.Queries) error {
+		return queries.CreateAdminUser(ctx, db.CreateAdminUserParams{
+			Email: user.Email,
+			Role:  string(user.Role),
+		})
+	})
 }
 
 func (s *Server) DeleteAdminUser(w http.ResponseWriter, req *http.Request) {
@@ -181,15 +347,91 @@ func (s *Server) DeleteAdminUser(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
-	ctx := req.Context()
-	if err := s.Queries.DeleteAdminUser(ctx, string(user)); err != nil {
-		slog.Error("error deleting admin user", "err", err)
+	s.updateAdminUsers(w, req, user.Email, func(ctx context.Context, queries *db.Queries) error {
+		return queries.DeleteAdminUser(ctx, user.Email)
+	})
+}
+
+// errNoOwner is returned when a change to the admin users would leave the
+// store without an owner.
+var errNoOwner = errors.New("at least one owner is required")
+
+// updateAdminUsers applies the update to the admin user with the email and
+// writes the response.
+func (s *Server) updateAdminUsers(w http.ResponseWriter, req *http.Request, email string, update func(ctx context.Context, queries *db.Queries) error) {
+	err := s.changeAdminUser(req.Context(), sessionUser(req), email, update)
+	switch {
+	case errors.Is(err, errNoOwner):
+		http.Error(w, "At least one owner is required", http.StatusBadRequest)
+		return
+	case err != nil:
+		slog.Error("error updating admin user", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// changeAdminUser applies the update to the admin user with the email in a
+// transaction on behalf of the actor. The update is refused with errNoOwner if
+// it would leave the store without an owner.
+func (s *Server) changeAdminUser(ctx context.Context, actor string, email string, update func(ctx context.Context, queries *db.Queries) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error creating transaction for admin user: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, err := adminUserForAudit(ctx, queries, email)
+	if err != nil {
+		return fmt.Errorf("error looking up admin user: %w", err)
+	}
+	if err := update(ctx, queries); err != nil {
+		return err
+	}
+	after, err := adminUserForAudit(ctx, queries, email)
+	if err != nil {
+		return fmt.Errorf("error looking up admin user: %w", err)
+	}
+	var action string
+	switch {
+	case before == nil:
+		action = "create"
+	case after == nil:
+		action = "delete"
+	default:
+		action = "update"
+	}
+	if before != nil || after != nil {
+		if err := recordAudit(ctx, queries, actor, auditAdminUser, email, action, before, after); err != nil {
+			return fmt.Errorf("error recording admin user change: %w", err)
+		}
+	}
+	owners, err := queries.CountAdminUsersWithRole(ctx, string(RoleOwner))
+	if err != nil {
+		return fmt.Errorf("error counting owners: %w", err)
+	}
+	if owners == 0 {
+		return errNoOwner
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error commiting admin user: %w", err)
+	}
+	return nil
+}
+
+// adminUserForAudit returns the admin user with the email, or nil if there is
+// none.
+func adminUserForAudit(ctx context.Context, queries *db.Queries, email string) (any, error) {
+	user, err := queries.AuthAdminUser(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
 const (
 	MaxImageSizeBytes        = 8 * 1024 * 1024
 	MaxImageSizePixel        = 16384
@@ -292,13 +534,30 @@ func (s *Server) PermissionCheck(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Auth starts the admin login with whichever methods are configured.
 func (s *Server) Auth(w http.ResponseWriter, req *http.Request) {
-	if err := s.completeAuth(w, req); err == nil {
-		return
+	switch {
+	case s.Config.MagicLinkLogin:
+		renderAuthPage(w, http.StatusOK, "login.html", loginData{
+			Google: s.Config.authenticationOK(),
+		})
+	case s.Config.authenticationOK():
+		s.GoogleAuth(w, req)
+	case s.Config.IsDev():
+		http.Redirect(w, req, s.Config.FrontendURL+"/api/v0/auth/dev", http.StatusTemporaryRedirect)
+	default:
+		slog.Error("denying admin login as no login method is configured")
+		http.Error(w, "Admin login is not configured", http.StatusServiceUnavailable)
 	}
+}
+
+// GoogleAuth logs in with Google.
+func (s *Server) GoogleAuth(w http.ResponseWriter, req *http.Request) {
 	if !s.Config.authenticationOK() {
-		slog.Error("denying authentication anyways as authentication was previously configured")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		http.NotFound(w, req)
+		return
+	}
+	if err := s.completeAuth(w, req); err == nil {
 		return
 	}
 	req = req.WithContext(context.WithValue(req.Context(), gothic.ProviderParamKey, "google"))
@@ -314,26 +573,40 @@ func (s *Server) AuthCallback(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// authCheck checks that the user is logged in as an admin of any role. Actions
+// that need more than viewing are guarded by withPermission in HTTPMux.
 func (s *Server) authCheck(w http.ResponseWriter, req *http.Request) bool {
-	if _, err := gothic.GetFromSession("user", req); err != nil {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return false
+	_, ok := s.sessionAdmin(w, req)
+	return ok
+}
+
+// sessionUser returns the email of the logged in admin, or an empty string if
+// there is none.
+func sessionUser(req *http.Request) string {
+	if admin, ok := req.Context().Value(adminUserContextKey{}).(adminSession); ok {
+		return admin.Email
+	}
+	user, err := gothic.GetFromSession("user", req)
+	if err != nil {
+		return ""
 	}
-	return true
+	return user
 }
 
 func (s *Server) completeAuth(w http.ResponseWriter, req *http.Request) error {
-	email := "GOOGLE_AUTH_NOT_CONFIGURED"
-	if s.Config.authenticationOK() {
-		req = req.WithContext(context.WithValue(req.Context(), gothic.ProviderParamKey, "google"))
-		user, err := gothic.CompleteUserAuth(w, req)
-		if err != nil {
-			return err
-		}
-		email = user.Email
-	} else {
-		slog.Warn("potentially allowing admin login due to misconfigured Google authentication")
+	if !s.Config.authenticationOK() {
+		return errors.New("google authentication is not configured")
+	}
+	req = req.WithContext(context.WithValue(req.Context(), gothic.ProviderParamKey, "google"))
+	user, err := gothic.CompleteUserAuth(w, req)
+	if err != nil {
+		return err
 	}
+	return s.login(w, req, user.Email)
+}
+
+// login stores the admin in the session and sends them to the admin page.
+func (s *Server) login(w http.ResponseWriter, req *http.Request, email string) error {
 	ctx := req.Context()
 	ok, err := s.validAdminUser(ctx, email)
 	if err != nil {
@@ -366,9 +639,12 @@ func (s *Server) validAdminUser(ctx context.Context, email string) (bool, error)
 		if err != nil {
 			return false, fmt.Errorf("cannot count admin users: %w", err)
 		}
-		if count == 0 {
+		if count == 0 && s.canBootstrap(email) {
 			// Auto-create first admin user.
-			if err := queries.CreateAdminUser(ctx, email); err != nil {
+			if err := queries.CreateAdminUser(ctx, db.CreateAdminUserParams{
+				Email: email,
+				Role:  string(RoleOwner),
+			}); err != nil {
 				return false, fmt.Errorf("cannot auto-create admin user: %w", err)
 			}
 		}
@@ -389,16 +665,27 @@ func (s *Server) validAdminUser(ctx context.Context, email string) (bool, error)
 	return false, fmt.Errorf("too many transaction failures")
 }
 
+// canBootstrap returns whether the email may become the first owner when there
+// are no admins. In development, anyone that logs in first does.
+func (s *Server) canBootstrap(email string) bool {
+	if s.Config.IsDev() {
+		return true
+	}
+	return slices.ContainsFunc(s.Config.BootstrapAdmins, func(admin string) bool {
+		return strings.EqualFold(admin, email)
+	})
+}
+
 func (s *Server) resolveSalePeriod(w http.ResponseWriter, req *http.Request, period string) (periodID int64, ok bool) {
 	var err error
 	if period == "current" {
-		periodID, err = s.Queries.CurrentSalePeriod(req.Context())
+		period, err := s.Queries.CurrentSalePeriod(req.Context(), time.Now().UTC())
 		if err != nil {
 			slog.Error("error fetching current sale period", "err", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return 0, false
 		}
-		return periodID, true
+		return period.ID, true
 	}
 	if !s.authCheck(w, req) {
 		return 0, false
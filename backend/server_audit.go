@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// Entity types recorded in the audit log.
+const (
+	auditAdminUser    = "admin_user"
+	auditAPIToken     = "api_token"
+	auditBackup       = "backup"
+	auditCoupon       = "coupon"
+	auditCouponBatch  = "coupon_batch"
+	auditOrder        = "order"
+	auditProduct      = "product"
+	auditSalePeriod   = "sale_period"
+	auditStoreClosure = "store_closure"
+)
+
+const (
+	auditLogDefaultLimit = 100
+	auditLogMaxLimit     = 1000
+)
+
+type AuditLogResponse struct {
+	Entries []AuditLogEntry `json:"entries"`
+}
+
+type AuditLogEntry struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Time       time.Time       `json:"time"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+}
+
+// auditedProduct is a product with the available stock of each variant, as
+// stock is edited together with the product.
+type auditedProduct struct {
+	db.Product
+	Stock map[string]int64
+}
+
+// productForAudit fetches the product and its stock for recording in the audit
+// log.
+func productForAudit(ctx context.Context, queries *db.Queries, productID int64) (auditedProduct, error) {
+	product, err := queries.ProductByID(ctx, productID)
+	if err != nil {
+		return auditedProduct{}, err
+	}
+	stock, err := queries.ListProductStock(ctx, product.SalePeriod)
+	if err != nil {
+		return auditedProduct{}, fmt.Errorf("error fetching product stock: %w", err)
+	}
+	audited := auditedProduct{
+		Product: product,
+		Stock:   make(map[string]int64),
+	}
+	for _, v := range stock {
+		if v.ProductID == productID {
+			audited.Stock[v.Variant] = v.Available
+		}
+	}
+	return audited, nil
+}
+
+// auditedRefund is a refund issued by an admin.
+type auditedRefund struct {
+	Items  []RefundItem
+	Amount int64
+	Reason string
+}
+
+// auditedClone is a sale period created by cloning another one.
+type auditedClone struct {
+	db.SalePeriod
+	ClonedFrom   int64
+	ProductCount int
+	CouponCount  int
+}
+
+// AuditLog lists the changes made by admins, newest first. The entries can be
+// filtered by entity_type, entity_id, actor and a from/until time range, and
+// paged through with before, which is the ID of the last entry seen.
+func (s *Server) AuditLog(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	query := req.URL.Query()
+	params := db.ListAuditLogParams{
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+		ActorEmail: query.Get("actor"),
+		UntilTime:  time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC),
+		BeforeID:   math.MaxInt64,
+		MaxCount:   auditLogDefaultLimit,
+	}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if params.FromTime, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid from time", http.StatusBadRequest)
+			return
+		}
+		params.FromTime = params.FromTime.UTC()
+	}
+	if v := query.Get("until"); v != "" {
+		if params.UntilTime, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid until time", http.StatusBadRequest)
+			return
+		}
+		params.UntilTime = params.UntilTime.UTC()
+	}
+	if v := query.Get("before"); v != "" {
+		if params.BeforeID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "Invalid before ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		params.MaxCount, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || params.MaxCount <= 0 || params.MaxCount > auditLogMaxLimit {
+			http.Error(w, fmt.Sprintf("Limit must be between 1 and %d", auditLogMaxLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	dbEntries, err := s.Queries.ListAuditLog(req.Context(), params)
+	if err != nil {
+		slog.Error("error fetching audit log", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	entries := make([]AuditLogEntry, 0, len(dbEntries))
+	for _, v := range dbEntries {
+		entry := AuditLogEntry{
+			ID:         v.ID,
+			Actor:      v.ActorEmail,
+			Time:       v.ActionTime,
+			EntityType: v.EntityType,
+			EntityID:   v.EntityID,
+			Action:     v.Action,
+		}
+		if v.BeforeJson.Valid {
+			entry.Before = json.RawMessage(v.BeforeJson.String)
+		}
+		if v.AfterJson.Valid {
+			entry.After = json.RawMessage(v.AfterJson.String)
+		}
+		entries = append(entries, entry)
+	}
+	if err := json.NewEncoder(w).Encode(AuditLogResponse{
+		Entries: entries,
+	}); err != nil {
+		slog.Error("error writing audit log response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// recordAudit records a change made by the actor, which is the email of the
+// admin. before is nil if the entity was created and after is nil if it was
+// deleted. Only the fields that changed are recorded, and nothing is recorded
+// if none did.
+func recordAudit(ctx context.Context, queries *db.Queries, actor string, entityType string, entityID any, action string, before, after any) error {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return err
+	}
+	if beforeFields != nil && afterFields != nil {
+		for k, v := range beforeFields {
+			if bytes.Equal(v, afterFields[k]) {
+				delete(beforeFields, k)
+				delete(afterFields, k)
+			}
+		}
+		if len(beforeFields) == 0 && len(afterFields) == 0 {
+			return nil
+		}
+	}
+	beforeJSON, err := auditJSON(beforeFields)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(afterFields)
+	if err != nil {
+		return err
+	}
+	err = queries.CreateAuditLog(ctx, db.CreateAuditLogParams{
+		ActorEmail: actor,
+		ActionTime: time.Now().UTC(),
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		Action:     action,
+		BeforeJson: beforeJSON,
+		AfterJson:  afterJSON,
+	})
+	if err != nil {
+		return fmt.Errorf("error recording audit log: %w", err)
+	}
+	return nil
+}
+
+func auditJSON(fields map[string]json.RawMessage) (sql.NullString, error) {
+	if fields == nil {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("error encoding audit log: %w", err)
+	}
+	return sql.NullString{
+		String: string(encoded),
+		Valid:  true,
+	}, nil
+}
+
+// auditFields returns the JSON encoded fields of the struct, keyed by their
+// snake_case names so that they match the database columns. Embedded structs
+// are flattened and fields tagged with audit:"-" are skipped. Pointers to
+// structs are followed. It returns nil if v is nil.
+func auditFields(v any) (map[string]json.RawMessage, error) {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if !value.IsValid() {
+		return nil, nil
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot record %T in audit log", v)
+	}
+	fields := make(map[string]json.RawMessage)
+	addAuditFields(fields, value)
+	return fields, nil
+}
+
+func addAuditFields(fields map[string]json.RawMessage, v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("audit") == "-" {
+			continue
+		}
+		if embedded := reflect.Indirect(v.Field(i)); field.Anonymous && embedded.Kind() == reflect.Struct {
+			addAuditFields(fields, embedded)
+			continue
+		}
+		value := v.Field(i).Interface()
+		if valuer, ok := value.(driver.Valuer); ok {
+			// Nullable columns are recorded as null or their value.
+			value, _ = valuer.Value()
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(strconv.Quote(fmt.Sprint(value)))
+		}
+		fields[snakeCase(field.Name)] = encoded
+	}
+}
+
+// snakeCase converts a Go field name such as CouponID to coupon_id.
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditLog returns the audit log entries matching the query, newest first.
+func (ts *testServer) auditLog(query string) []AuditLogEntry {
+	ts.t.Helper()
+	var resp AuditLogResponse
+	ts.requestOK("GET", "/api/v0/audit_log?"+query, nil, true, &resp)
+	return resp.Entries
+}
+
+func TestAuditLogCouponChange(t *testing.T) {
+	ts := newTestServer(t)
+	// Disabled coupons keep their Stripe ID, so only the discount changes.
+	coupon := Coupon{
+		Requirements: []json.RawMessage{},
+		CouponCode:   "TEN",
+		Discount:     json.RawMessage(`{"type":"percentage","amount":10}`),
+		Enabled:      ptr(false),
+		Public:       ptr(true),
+	}
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", coupon, true, &coupon)
+	coupon.Discount = json.RawMessage(`{"type":"percentage","amount":20}`)
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", coupon, true, nil)
+	// Saving without changes should not be recorded.
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", coupon, true, nil)
+
+	entries := ts.auditLog("entity_type=coupon")
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit log entries, want 2: %+v", len(entries), entries)
+	}
+	update, create := entries[0], entries[1]
+	if create.Action != "create" || string(create.Before) != "null" || !strings.Contains(string(create.After), `"coupon_code":"TEN"`) {
+		t.Errorf("got create entry %+v, want the new coupon", create)
+	}
+	if update.Action != "update" || update.Actor != testAdminEmail || update.EntityID != strconv.FormatInt(*coupon.ID, 10) {
+		t.Errorf("got update entry %+v, want update of coupon %d by %s", update, *coupon.ID, testAdminEmail)
+	}
+	if string(update.Before) != `{"discount":"{\"type\":\"percentage\",\"amount\":10}"}` || string(update.After) != `{"discount":"{\"type\":\"percentage\",\"amount\":20}"}` {
+		t.Errorf("got update from %s to %s, want only the discount", update.Before, update.After)
+	}
+}
+
+func TestAuditLogAdminChanges(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		name       string
+		action     func(ts *testServer)
+		entityType string
+		wantAction string
+		wantBefore string
+		wantAfter  string
+	}{
+		{
+			name: "disable product",
+			action: func(ts *testServer) {
+				product := testShirt
+				product.ID = "1"
+				product.Enabled = ptr(false)
+				ts.requestOK("POST", "/api/v0/sales/1/products", product, true, nil)
+			},
+			entityType: auditProduct,
+			wantAction: "update",
+			wantBefore: `{"enabled":true}`,
+			wantAfter:  `{"enabled":false}`,
+		},
+		{
+			name: "product stock",
+			action: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/sales/1/products", withStock(testShirt, ProductStock{Variant: "S", Available: 5}), true, nil)
+			},
+			entityType: auditProduct,
+			wantAction: "create",
+			wantBefore: "null",
+			wantAfter:  `"stock":{"S":5}`,
+		},
+		{
+			name: "collect order",
+			action: func(ts *testServer) {
+				orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/collect", nil, true, nil)
+			},
+			entityType: auditOrder,
+			wantAction: "collect",
+			wantBefore: `{"collection_time":null}`,
+			wantAfter:  `"collection_time":"`,
+		},
+		{
+			name: "cancel order",
+			action: func(ts *testServer) {
+				orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/cancel", CancelRequest{Reason: "Duplicate"}, true, nil)
+			},
+			entityType: auditOrder,
+			wantAction: "cancel",
+			wantBefore: `{"cancelled":false}`,
+			wantAfter:  `{"cancelled":true}`,
+		},
+		{
+			name: "refund order",
+			action: func(ts *testServer) {
+				orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 2)))
+				order := ts.lookupOrder(orderID)
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/refund", RefundRequest{
+					Reason: "Wrong size",
+					Items:  []RefundItem{{ID: order.Items[0].ItemID, Amount: 1}},
+				}, true, nil)
+			},
+			entityType: auditOrder,
+			wantAction: "refund",
+			wantBefore: "null",
+			wantAfter:  `"reason":"Wrong size"`,
+		},
+		{
+			name: "create store closure",
+			action: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/closures", StoreClosure{StartTime: now, EndTime: now.Add(time.Hour), Message: "Closed"}, true, nil)
+			},
+			entityType: auditStoreClosure,
+			wantAction: "create",
+			wantBefore: "null",
+			wantAfter:  `"user_message":"Closed"`,
+		},
+		{
+			name: "delete store closure",
+			action: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/closures", StoreClosure{StartTime: now, EndTime: now.Add(time.Hour), Message: "Closed"}, true, nil)
+				ts.requestOK("DELETE", "/api/v0/closures/1", nil, true, nil)
+			},
+			entityType: auditStoreClosure,
+			wantAction: "delete",
+			wantBefore: `"user_message":"Closed"`,
+			wantAfter:  "null",
+		},
+		{
+			name: "rename sale period",
+			action: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/sales", SalePeriod{ID: "1", Name: "Renamed", StartTime: time.Unix(0, 0)}, true, nil)
+			},
+			entityType: auditSalePeriod,
+			wantAction: "update",
+			wantBefore: `"admin_name":"Default"`,
+			wantAfter:  `"admin_name":"Renamed"`,
+		},
+		{
+			name: "archive sale period",
+			action: func(ts *testServer) {
+				ts.requestOK("DELETE", "/api/v0/sales/1", nil, true, nil)
+			},
+			entityType: auditSalePeriod,
+			wantAction: "delete",
+			wantBefore: `{"delete_time":null}`,
+			wantAfter:  `{"delete_time":"`,
+		},
+		{
+			name: "restore sale period",
+			action: func(ts *testServer) {
+				ts.requestOK("DELETE", "/api/v0/sales/1", nil, true, nil)
+				ts.requestOK("POST", "/api/v0/sales/1/restore", nil, true, nil)
+			},
+			entityType: auditSalePeriod,
+			wantAction: "restore",
+			wantBefore: `{"delete_time":"`,
+			wantAfter:  `{"delete_time":null}`,
+		},
+		{
+			name: "clone sale period",
+			action: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/sales/1/clone", CloneSalePeriodRequest{
+					SalePeriod: SalePeriod{Name: "Next Sale", StartTime: now},
+				}, true, nil)
+			},
+			entityType: auditSalePeriod,
+			wantAction: "clone",
+			wantBefore: "null",
+			wantAfter:  `"cloned_from":1`,
+		},
+		{
+			name: "add admin",
+			action: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/users", User{Email: "helper@e.ntu.edu.sg", Role: RoleCollector}, true, nil)
+			},
+			entityType: auditAdminUser,
+			wantAction: "create",
+			wantBefore: "null",
+			wantAfter:  `{"email":"helper@e.ntu.edu.sg","role":"collector"}`,
+		},
+		{
+			name: "change admin role",
+			action: func(ts *testServer) {
+				ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+				ts.requestOK("POST", "/api/v0/users", User{Email: "helper@e.ntu.edu.sg", Role: RoleViewer}, true, nil)
+			},
+			entityType: auditAdminUser,
+			wantAction: "update",
+			wantBefore: `{"role":"collector"}`,
+			wantAfter:  `{"role":"viewer"}`,
+		},
+		{
+			name: "remove admin",
+			action: func(ts *testServer) {
+				ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+				ts.requestOK("DELETE", "/api/v0/users", User{Email: "helper@e.ntu.edu.sg"}, true, nil)
+			},
+			entityType: auditAdminUser,
+			wantAction: "delete",
+			wantBefore: `"email":"helper@e.ntu.edu.sg"`,
+			wantAfter:  "null",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			tt.action(ts)
+			entries := ts.auditLog("entity_type=" + tt.entityType)
+			if len(entries) == 0 {
+				t.Fatalf("got no %s audit log entries", tt.entityType)
+			}
+			got := entries[0]
+			if got.Action != tt.wantAction || got.Actor != testAdminEmail {
+				t.Errorf("got %s by %s, want %s by %s", got.Action, got.Actor, tt.wantAction, testAdminEmail)
+			}
+			for _, v := range []struct {
+				name string
+				got  json.RawMessage
+				want string
+			}{
+				{"before", got.Before, tt.wantBefore},
+				{"after", got.After, tt.wantAfter},
+			} {
+				// Created entities have nothing before and deleted ones have
+				// nothing after.
+				if v.want == "null" && string(v.got) != "null" || !strings.Contains(string(v.got), v.want) {
+					t.Errorf("got %s %s, want it to contain %s", v.name, v.got, v.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditLogNotRecordedOnFailure(t *testing.T) {
+	ts := newTestServer(t)
+	ts.requestOK("POST", "/api/v0/users", User{Email: "helper@e.ntu.edu.sg", Role: RoleCollector}, true, nil)
+	// Demoting the last owner is refused, so it should not be recorded either.
+	expectResponse(t, ts.request("POST", "/api/v0/users", User{Email: testAdminEmail, Role: RoleViewer}, true), http.StatusBadRequest, "At least one owner is required")
+	if entries := ts.auditLog("entity_type=admin_user"); len(entries) != 1 {
+		t.Errorf("got %d audit log entries, want only the added admin: %+v", len(entries), entries)
+	}
+}
+
+func TestAuditLogNotRecordedForMissingEntity(t *testing.T) {
+	ts := newTestServer(t)
+	coupon := ts.createCoupon("TEN", 10)
+	ts.requestOK("POST", "/api/v0/sales", SalePeriod{Name: "Recess Week", StartTime: time.Now()}, true, nil)
+	// The coupon exists, but not in the sale period being edited.
+	coupon.CouponCode = "TWENTY"
+	expectResponse(t, ts.request("POST", "/api/v0/sales/2/coupons", coupon, true), http.StatusNotFound, "Invalid Coupon ID")
+	product := ts.createProduct(testShirt)
+	product.Name = "Renamed"
+	expectResponse(t, ts.request("POST", "/api/v0/sales/2/products", product, true), http.StatusNotFound, "Invalid Product ID")
+	for _, entityType := range []string{auditCoupon, auditProduct} {
+		if entries := ts.auditLog("entity_type=" + entityType); len(entries) != 1 {
+			t.Errorf("got %d %s audit log entries, want only the creation: %+v", len(entries), entityType, entries)
+		}
+	}
+}
+
+func TestAuditLogFilters(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	collector := ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+	rec := ts.requestWithCookies("POST", "/api/v0/orders/"+orderID+"/collect", nil, collector)
+	expectResponse(t, rec, http.StatusNoContent, "")
+	ts.createCoupon("TEN", 10)
+	ts.createCoupon("TWENTY", 20)
+
+	all := ts.auditLog("")
+	if len(all) != 4 {
+		t.Fatalf("got %d audit log entries, want 4: %+v", len(all), all)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name        string
+		query       string
+		wantActions []string
+	}{
+		{"all", "", []string{"create", "create", "collect", "create"}},
+		{"entity type", "entity_type=coupon", []string{"create", "create"}},
+		{"entity", "entity_type=order&entity_id=" + orderID, []string{"collect"}},
+		{"actor", "actor=helper@e.ntu.edu.sg", []string{"collect"}},
+		{"from", "from=" + future, nil},
+		{"until", "until=" + future, []string{"create", "create", "collect", "create"}},
+		{"limit", "limit=1", []string{"create"}},
+		{"before", "before=" + strconv.FormatInt(all[1].ID, 10), []string{"collect", "create"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := ts.auditLog(tt.query)
+			actions := make([]string, 0, len(entries))
+			for _, v := range entries {
+				actions = append(actions, v.Action)
+			}
+			if strings.Join(actions, ",") != strings.Join(tt.wantActions, ",") {
+				t.Errorf("got actions %v, want %v", actions, tt.wantActions)
+			}
+		})
+	}
+
+	for _, query := range []string{"from=yesterday", "until=1", "before=abc", "limit=0", "limit=100000"} {
+		rec := ts.request("GET", "/api/v0/audit_log?"+query, nil, true)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("got status %d for %q, want 400", rec.Code, query)
+		}
+	}
+}
+
+func TestAuditFields(t *testing.T) {
+	type Entity struct {
+		ID     int64
+		Name   string
+		Secret string `audit:"-"`
+	}
+	type wrapped struct {
+		*Entity
+		CouponCount int
+	}
+	e := &Entity{ID: 1, Name: "Shirt", Secret: "hidden"}
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"nil", nil, "null"},
+		{"nil pointer", (*Entity)(nil), "null"},
+		{"struct", *e, `{"id":1,"name":"Shirt"}`},
+		{"pointer", e, `{"id":1,"name":"Shirt"}`},
+		{"embedded pointer", wrapped{Entity: e, CouponCount: 2}, `{"coupon_count":2,"id":1,"name":"Shirt"}`},
+		{"not a struct", "Shirt", "cannot record string in audit log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if fields, err := auditFields(tt.v); err != nil {
+				got = err.Error()
+			} else {
+				encoded, _ := json.Marshal(fields)
+				got = string(encoded)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"ID":               "id",
+		"CouponID":         "coupon_id",
+		"DefaultImageUrl":  "default_image_url",
+		"AllowOrderCheck":  "allow_order_check",
+		"HTMLBody":         "html_body",
+		"Stock":            "stock",
+		"DiscountPercent2": "discount_percent2",
+	}
+	for in, want := range tests {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+const (
+	magicLinkLifetime = 15 * time.Minute
+	// magicLinkMaxRecent is how many login links can be sent to an email
+	// within magicLinkLifetime so that the form cannot be used to flood
+	// inboxes.
+	magicLinkMaxRecent   = 5
+	magicLinkSentMessage = "If the email belongs to an admin, a login link has been sent to it."
+)
+
+//go:embed templates/*.html
+var authTemplateFS embed.FS
+
+var authTemplates = template.Must(template.ParseFS(authTemplateFS, "templates/*.html"))
+
+type devLoginData struct {
+	Email string
+	Error string
+}
+
+type loginData struct {
+	// Google is whether Google authentication is offered.
+	Google  bool
+	Message string
+	Error   string
+}
+
+type magicLinkData struct {
+	Token string
+}
+
+type adminLoginEmailData struct {
+	LoginURL   string
+	ExpireTime string
+}
+
+// DevLoginForm shows a form to log in as any admin without Google
+// authentication. It is only available in development mode.
+func (s *Server) DevLoginForm(w http.ResponseWriter, req *http.Request) {
+	if !s.Config.IsDev() {
+		http.NotFound(w, req)
+		return
+	}
+	renderAuthPage(w, http.StatusOK, "dev_login.html", devLoginData{})
+}
+
+// DevLogin logs in as the admin entered in the development login form.
+func (s *Server) DevLogin(w http.ResponseWriter, req *http.Request) {
+	if !s.Config.IsDev() {
+		http.NotFound(w, req)
+		return
+	}
+	email := strings.TrimSpace(req.PostFormValue("email"))
+	if email == "" {
+		renderAuthPage(w, http.StatusBadRequest, "dev_login.html", devLoginData{Error: "Email is required"})
+		return
+	}
+	if err := s.login(w, req, email); err != nil {
+		slog.Error("error logging in with development login", "email", email, "err", err)
+		renderAuthPage(w, http.StatusForbidden, "dev_login.html", devLoginData{Email: email, Error: "Not an admin"})
+	}
+}
+
+// RequestMagicLink emails a one-time login link to the admin. The response is
+// the same whether or not the email belongs to an admin so that the form
+// cannot be used to find out who the admins are.
+func (s *Server) RequestMagicLink(w http.ResponseWriter, req *http.Request) {
+	if !s.Config.MagicLinkLogin {
+		http.NotFound(w, req)
+		return
+	}
+	data := loginData{Google: s.Config.authenticationOK()}
+	email := strings.TrimSpace(req.PostFormValue("email"))
+	if email == "" {
+		data.Error = "Email is required"
+		renderAuthPage(w, http.StatusBadRequest, "login.html", data)
+		return
+	}
+	if err := s.sendMagicLink(req.Context(), email); err != nil {
+		slog.Error("error sending login link", "email", email, "err", err)
+		data.Error = "Failed to send login link, please try again later."
+		renderAuthPage(w, http.StatusInternalServerError, "login.html", data)
+		return
+	}
+	data.Message = magicLinkSentMessage
+	renderAuthPage(w, http.StatusOK, "login.html", data)
+}
+
+// MagicLinkForm asks the admin to confirm logging in with the link. Links are
+// not used up when opened so that email scanners that follow links do not use
+// them before the admin does.
+func (s *Server) MagicLinkForm(w http.ResponseWriter, req *http.Request) {
+	if !s.Config.MagicLinkLogin {
+		http.NotFound(w, req)
+		return
+	}
+	renderAuthPage(w, http.StatusOK, "magic_link.html", magicLinkData{
+		Token: req.URL.Query().Get("token"),
+	})
+}
+
+// MagicLinkLogin logs in with a login link, which can only be used once.
+func (s *Server) MagicLinkLogin(w http.ResponseWriter, req *http.Request) {
+	if !s.Config.MagicLinkLogin {
+		http.NotFound(w, req)
+		return
+	}
+	email, err := s.Queries.UseAdminLoginLink(req.Context(), db.UseAdminLoginLinkParams{
+		Now: sql.NullTime{
+			Time:  time.Now().UTC(),
+			Valid: true,
+		},
+		TokenHash: hashAPIToken(req.PostFormValue("token")),
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		renderAuthPage(w, http.StatusBadRequest, "login.html", loginData{
+			Google: s.Config.authenticationOK(),
+			Error:  "The login link is invalid, expired or has already been used.",
+		})
+		return
+	case err != nil:
+		slog.Error("error using login link", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.login(w, req, email); err != nil {
+		slog.Error("error logging in with login link", "email", email, "err", err)
+		http.Error(w, "Invalid authentication request", http.StatusForbidden)
+	}
+}
+
+// sendMagicLink emails a login link if the email belongs to an admin, or could
+// become the first admin.
+func (s *Server) sendMagicLink(ctx context.Context, email string) error {
+	ok, err := s.mayLogIn(ctx, email)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		slog.Warn("not sending login link to non-admin", "email", email)
+		return nil
+	}
+	now := time.Now().UTC()
+	recent, err := s.Queries.CountRecentAdminLoginLinks(ctx, db.CountRecentAdminLoginLinksParams{
+		Email: email,
+		Since: now.Add(-magicLinkLifetime),
+	})
+	if err != nil {
+		return fmt.Errorf("error counting recent login links: %w", err)
+	}
+	if recent >= magicLinkMaxRecent {
+		slog.Warn("not sending login link as too many were sent recently", "email", email)
+		return nil
+	}
+	token, err := newAPIToken()
+	if err != nil {
+		return fmt.Errorf("error generating login link: %w", err)
+	}
+	expireTime := now.Add(magicLinkLifetime)
+	if err := s.Queries.CreateAdminLoginLink(ctx, db.CreateAdminLoginLinkParams{
+		TokenHash:  hashAPIToken(token),
+		Email:      email,
+		CreateTime: now,
+		ExpireTime: expireTime,
+	}); err != nil {
+		return fmt.Errorf("error creating login link: %w", err)
+	}
+	loginEmail, err := renderEmail("admin_login", email, adminLoginEmailData{
+		LoginURL:   s.Config.FrontendURL + "/api/v0/auth/magic?token=" + url.QueryEscape(token),
+		ExpireTime: expireTime.In(storeLocation).Format("2 Jan 2006 3:04 PM"),
+	})
+	if err != nil {
+		return err
+	}
+	// The email is sent right away instead of through the outbox as the admin
+	// is waiting for it.
+	if err := s.Mailer.Send(loginEmail); err != nil {
+		return fmt.Errorf("error sending login link: %w", err)
+	}
+	return nil
+}
+
+// mayLogIn returns whether the email belongs to an admin or would become the
+// first admin when logging in.
+func (s *Server) mayLogIn(ctx context.Context, email string) (bool, error) {
+	_, err := s.Queries.AuthAdminUser(ctx, email)
+	switch {
+	case err == nil:
+		return true, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return false, fmt.Errorf("error looking up admin: %w", err)
+	}
+	count, err := s.Queries.CountAdminUsers(ctx)
+	if err != nil {
+		return false, fmt.Errorf("cannot count admin users: %w", err)
+	}
+	return count == 0 && s.canBootstrap(email), nil
+}
+
+func renderAuthPage(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := authTemplates.ExecuteTemplate(w, name, data); err != nil {
+		slog.Error("error writing login page", "page", name, "err", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (ts *testServer) postForm(target string, form url.Values) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// requestMagicLink requests a login link and returns the token sent, if any.
+func (ts *testServer) requestMagicLink(email string) string {
+	ts.t.Helper()
+	sent := len(ts.mailer.sent)
+	expectResponse(ts.t, ts.postForm("/api/v0/auth/magic", url.Values{"email": {email}}), http.StatusOK, magicLinkSentMessage)
+	if len(ts.mailer.sent) == sent {
+		return ""
+	}
+	text := ts.mailer.sent[len(ts.mailer.sent)-1].Text
+	_, after, ok := strings.Cut(text, "/api/v0/auth/magic?token=")
+	if !ok {
+		ts.t.Fatalf("got login email %q without a link", text)
+	}
+	token, _ := url.QueryUnescape(strings.Fields(after)[0])
+	return token
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Config.MagicLinkLogin = true
+	expectResponse(t, ts.request("GET", "/api/v0/auth", nil, false), http.StatusOK, "Email Me a Login Link")
+
+	token := ts.requestMagicLink(testAdminEmail)
+	if token == "" {
+		t.Fatalf("got no login link sent to admin")
+	}
+	if email := ts.mailer.sent[0]; email.To != testAdminEmail || !strings.Contains(email.HTML, url.QueryEscape(token)) {
+		t.Errorf("got login email %+v, want it sent to the admin with the link", email)
+	}
+	// Opening the link only asks for confirmation.
+	expectResponse(t, ts.request("GET", "/api/v0/auth/magic?token="+url.QueryEscape(token), nil, false), http.StatusOK, `value="`+token+`"`)
+
+	rec := ts.postForm("/api/v0/auth/magic/login", url.Values{"token": {token}})
+	if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != "http://shop.test/admin" {
+		t.Fatalf("got status %d to %q, want redirect to admin page", rec.Code, rec.Header().Get("Location"))
+	}
+	expectResponse(t, ts.requestWithCookies("GET", "/api/v0/perm_check", nil, rec.Result().Cookies()), http.StatusNoContent, "")
+
+	// Links can only be used once.
+	expectResponse(t, ts.postForm("/api/v0/auth/magic/login", url.Values{"token": {token}}), http.StatusBadRequest, "already been used")
+}
+
+func TestMagicLinkNotSent(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ts *testServer)
+		email string
+	}{
+		{"not an admin", func(ts *testServer) {}, "other@e.ntu.edu.sg"},
+		{"too many links", func(ts *testServer) {
+			for range magicLinkMaxRecent {
+				ts.requestMagicLink(testAdminEmail)
+			}
+		}, testAdminEmail},
+		{"no admins and not bootstrapped", func(ts *testServer) {
+			// Development mode makes the first admin on login.
+			ts.Config.Mode = ModeProduction
+			if err := ts.Queries.DeleteAdminUser(context.Background(), testAdminEmail); err != nil {
+				ts.t.Fatalf("error deleting admin user: %v", err)
+			}
+		}, "other@e.ntu.edu.sg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Config.MagicLinkLogin = true
+			tt.setup(ts)
+			if token := ts.requestMagicLink(tt.email); token != "" {
+				t.Errorf("got login link sent, want none")
+			}
+		})
+	}
+}
+
+func TestMagicLinkBootstrap(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Config.MagicLinkLogin = true
+	ts.Config.BootstrapAdmins = []string{"owner@e.ntu.edu.sg"}
+	if err := ts.Queries.DeleteAdminUser(context.Background(), testAdminEmail); err != nil {
+		t.Fatalf("error deleting admin user: %v", err)
+	}
+	token := ts.requestMagicLink("owner@e.ntu.edu.sg")
+	if token == "" {
+		t.Fatalf("got no login link sent to bootstrap admin")
+	}
+	rec := ts.postForm("/api/v0/auth/magic/login", url.Values{"token": {token}})
+	expectResponse(t, rec, http.StatusTemporaryRedirect, "")
+	rec = ts.requestWithCookies("GET", "/api/v0/users/me", nil, rec.Result().Cookies())
+	var me CurrentUserResponse
+	if err := json.NewDecoder(rec.Body).Decode(&me); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if me.Email != "owner@e.ntu.edu.sg" || me.Role != RoleOwner {
+		t.Errorf("got current user %+v, want owner", me)
+	}
+}
+
+func TestMagicLinkInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		token func(ts *testServer) string
+	}{
+		{"unknown", func(ts *testServer) string { return "ccds_unknown" }},
+		{"empty", func(ts *testServer) string { return "" }},
+		{"expired", func(ts *testServer) string {
+			token := ts.requestMagicLink(testAdminEmail)
+			if _, err := ts.DB.Exec("UPDATE admin_login_links SET expire_time = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+				ts.t.Fatalf("error expiring login link: %v", err)
+			}
+			return token
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Config.MagicLinkLogin = true
+			token := tt.token(ts)
+			expectResponse(t, ts.postForm("/api/v0/auth/magic/login", url.Values{"token": {token}}), http.StatusBadRequest, "invalid, expired")
+		})
+	}
+}
+
+func TestMagicLinkDisabled(t *testing.T) {
+	ts := newTestServer(t)
+	expectResponse(t, ts.postForm("/api/v0/auth/magic", url.Values{"email": {testAdminEmail}}), http.StatusNotFound, "")
+	expectResponse(t, ts.request("GET", "/api/v0/auth/magic?token=abc", nil, false), http.StatusNotFound, "")
+	expectResponse(t, ts.postForm("/api/v0/auth/magic/login", url.Values{"token": {"abc"}}), http.StatusNotFound, "")
+	if len(ts.mailer.sent) != 0 {
+		t.Errorf("got %d emails sent, want none", len(ts.mailer.sent))
+	}
+}
@@ -11,12 +11,11 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
-	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/webhook"
 )
 
 type CheckoutRequest struct {
@@ -63,76 +62,73 @@ func (s *Server) CheckoutComplete(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, s.Config.FrontendURL+"/orders/"+orderID, http.StatusTemporaryRedirect)
 }
 
-func (s *Server) StripeWebhook(w http.ResponseWriter, req *http.Request) {
+func (s *Server) PaymentWebhook(w http.ResponseWriter, req *http.Request) {
 	const MaxBodyBytes = int64(65536)
 	req.Body = http.MaxBytesReader(w, req.Body, MaxBodyBytes)
 	payload, err := io.ReadAll(req.Body)
 	if err != nil {
-		slog.Error("error reading request body for Stripe webhook", "err", err)
+		slog.Error("error reading request body for payment webhook", "err", err)
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
-	event, err := webhook.ConstructEvent(payload, req.Header.Get("Stripe-Signature"), s.Config.StripeWebhookSecret)
+	event, err := s.Payment.VerifyWebhook(payload, req.Header)
 	if err != nil {
-		slog.Error("error verifying webhook signature", "err", err)
+		slog.Error("error verifying webhook", "err", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	s.Metrics.webhookEvents.WithLabelValues(event.Type).Inc()
 	switch event.Type {
-	case "checkout.session.completed":
-		fallthrough
-	case "checkout.session.expired":
-		sessionID, ok := event.Data.Object["id"].(string)
-		if !ok {
-			slog.Error("error reading ID of checkout session", "obj", event.Data.Object)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		if _, err := s.checkAndFulfill(req.Context(), sessionID); err != nil {
-			slog.Error("failed to check and fulfill checkout", "sessionID", sessionID, "err", err)
-			http.Error(w, "failed to validate Stripe checkout", http.StatusBadRequest)
+	case PaymentEventSessionCompleted, PaymentEventSessionExpired:
+		if _, err := s.checkAndFulfill(req.Context(), event.SessionID); err != nil {
+			slog.Error("failed to check and fulfill checkout", "sessionID", event.SessionID, "err", err)
+			http.Error(w, "failed to validate checkout", http.StatusBadRequest)
 			return
 		}
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// latePaymentRefundReason is recorded for the refunds issued when a payment
+// comes in after its order has been cancelled.
+const latePaymentRefundReason = "Payment received after the order was cancelled"
+
 func (s *Server) checkAndFulfill(ctx context.Context, sessionID string) (string, error) {
-	// We would ideally use sql.NullString but sqlc does not play well with our query so we just use a non-sensical value instead.
-	couponCode := "INVALID_STRIPE_COUPON_CODE"
-	if s.Stripe == nil {
-		slog.Warn("skipping Stripe validation as Stripe is not configured", "session_id", sessionID)
-	} else {
-		session, err := s.Stripe.CheckoutSessions.Get(sessionID, &stripe.CheckoutSessionParams{
-			Expand: []*string{stripe.String("total_details.breakdown")},
-		})
-		if err != nil {
-			return "", fmt.Errorf("failed to fetch checkout session: %w", err)
-		}
-		if session.Status == "expired" {
-			slog.Debug("expiring checkout session", "session_id", sessionID)
-			orderID, err := s.Queries.ExpireCheckout(ctx, sql.NullString{
-				String: sessionID,
-				Valid:  true,
-			})
-			if err != nil {
-				return "", fmt.Errorf("error expiring checkout: %w", err)
-			}
-			return orderID, nil
-		}
-		if session.PaymentStatus == "unpaid" {
-			return "", fmt.Errorf("payment status of checkout session is unpaid, expiry time %d", session.ExpiresAt)
-		}
-		if len(session.TotalDetails.Breakdown.Discounts) > 0 {
-			couponCode = session.TotalDetails.Breakdown.Discounts[0].Discount.Coupon.ID
+	session, err := s.Payment.GetSession(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch checkout session: %w", err)
+	}
+	if session.Status == PaymentSessionExpired {
+		slog.Debug("expiring checkout session", "session_id", sessionID)
+		return s.expireCheckout(ctx, sessionID)
+	}
+	if !session.Paid {
+		return "", fmt.Errorf("payment status of checkout session is unpaid, expiry time %s", session.ExpiresAt)
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return "", fmt.Errorf("cannot start transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	paymentRef := sql.NullString{
+		String: sessionID,
+		Valid:  true,
+	}
+	order, err := queries.OrderByPaymentReference(ctx, paymentRef)
+	if err != nil {
+		return "", fmt.Errorf("error fetching order: %w", err)
+	}
+	newlyPaid := !order.PaymentTime.Valid
+	if newlyPaid && !order.Cancelled {
+		if err := confirmStock(ctx, queries, order.OrderID); err != nil {
+			return "", err
 		}
 	}
-	orderID, err := s.Queries.CompleteCheckout(ctx, db.CompleteCheckoutParams{
-		PaymentReference: sql.NullString{
-			String: sessionID,
-			Valid:  true,
-		},
-		CouponStripeID: couponCode,
+	orderID, err := queries.CompleteCheckout(ctx, db.CompleteCheckoutParams{
+		PaymentReference: paymentRef,
 		PaymentTime: sql.NullTime{
 			Time:  time.Now(),
 			Valid: true,
@@ -141,13 +137,114 @@ func (s *Server) checkAndFulfill(ctx context.Context, sessionID string) (string,
 	if err != nil {
 		return "", fmt.Errorf("error updating payment time: %w", err)
 	}
+	var refund *PaymentRefund
+	if newlyPaid {
+		kind := orderEmailConfirmation
+		var emailData orderEmailData
+		if order.Cancelled {
+			// The order expired or was cancelled before the payment came in,
+			// so the stock is gone and the buyer has to be refunded.
+			slog.Warn("payment received for cancelled order, refunding", "order_id", orderID, "session_id", sessionID)
+			refund, err = s.refundRemaining(ctx, queries, orderID, latePaymentRefundReason, "")
+			if err != nil {
+				return "", fmt.Errorf("error refunding cancelled order: %w", err)
+			}
+			kind = orderEmailPaidCancelled
+			if refund != nil {
+				emailData.RefundAmount = formatPrice(refund.Amount)
+			}
+		}
+		if err := s.enqueueOrderEmail(ctx, queries, kind, orderID, emailData); err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		if refund != nil {
+			// The refund has already been issued, so it is logged for
+			// reconciling by hand.
+			slog.Error("error commiting refund of cancelled order", "err", err, "order_id", orderID, "refund", refund)
+		}
+		return "", fmt.Errorf("error commiting checkout: %w", err)
+	}
 	return orderID, nil
 }
 
+// expireCheckout cancels the order associated with the checkout session and
+// releases the stock that it has reserved.
+func (s *Server) expireCheckout(ctx context.Context, sessionID string) (string, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return "", fmt.Errorf("cannot start transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	paymentRef := sql.NullString{
+		String: sessionID,
+		Valid:  true,
+	}
+	order, err := queries.OrderByPaymentReference(ctx, paymentRef)
+	if err != nil {
+		return "", fmt.Errorf("error fetching order: %w", err)
+	}
+	if order.Cancelled {
+		// Stock has been released when the order was cancelled.
+		return order.OrderID, nil
+	}
+	if err := releaseStock(ctx, queries, order.OrderID, order.PaymentTime.Valid); err != nil {
+		return "", err
+	}
+	orderID, err := queries.ExpireCheckout(ctx, paymentRef)
+	if err != nil {
+		return "", fmt.Errorf("error expiring checkout: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error commiting checkout expiry: %w", err)
+	}
+	return orderID, nil
+}
+
+// abandonOrder cancels an order that cannot be paid for, such as when its
+// checkout session could not be created, and releases its reserved stock.
+func (s *Server) abandonOrder(ctx context.Context, orderID string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	if _, err := queries.UpdateCancelled(ctx, db.UpdateCancelledParams{
+		Cancelled: true,
+		OrderID:   orderID,
+	}); err != nil {
+		return fmt.Errorf("error marking order as cancelled: %w", err)
+	}
+	if err := releaseStock(ctx, queries, orderID, false); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error commiting order cancellation: %w", err)
+	}
+	return nil
+}
+
 func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
+	s.Metrics.checkoutAttempts.Inc()
+	// failure is the reason recorded if the checkout does not make it to the
+	// payment provider.
+	failure := checkoutFailureClosed
+	defer func() {
+		if failure != "" {
+			s.Metrics.checkoutFailures.WithLabelValues(failure).Inc()
+		}
+	}()
 	if !s.closureCheck(w, req) {
 		return
 	}
+	failure = checkoutFailureInvalidRequest
 	ctx := req.Context()
 	var checkoutReq CheckoutRequest
 	if err := json.NewDecoder(req.Body).Decode(&checkoutReq); err != nil {
@@ -171,6 +268,7 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "At least one item is required", http.StatusBadRequest)
 		return
 	}
+	failure = checkoutFailureInternal
 	period, ok := s.resolveSalePeriod(w, req, "current")
 	if !ok {
 		return
@@ -184,7 +282,7 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	products, err := dbProductsToProducts(dbProducts, false)
+	products, err := dbProductsToProducts(dbProducts, nil, false)
 	if err != nil {
 		slog.Error("error parsing products", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -193,13 +291,18 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 	// Validate the order.
 	var couponID *int64
 	var couponStripeID *string
+	var discount *couponDiscount
+	var requirements []couponRequirement
+	var redemptionLimit, customerRedemptionLimit sql.NullInt64
 	if checkoutReq.Coupon != nil {
 		coupon, err := s.Queries.CouponEnabledByCode(ctx, db.CouponEnabledByCodeParams{
-			CouponCode: *checkoutReq.Coupon,
-			SalePeriod: period,
+			CouponCode:  *checkoutReq.Coupon,
+			SalePeriod:  period,
+			CurrentTime: time.Now().UTC(),
 		})
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			failure = checkoutFailureInvalidCoupon
 			http.Error(w, "Invalid coupon code", http.StatusBadRequest)
 			return
 		case err != nil:
@@ -207,31 +310,58 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		if coupon.EmailMatch.Valid && !strings.EqualFold(coupon.EmailMatch.String, checkoutReq.Email) {
-			slog.Error("email mismatch", "db", coupon.EmailMatch.String, "req", checkoutReq.Email)
-			http.Error(w, "Invalid Coupon Code", http.StatusBadRequest)
+		requirements, err = couponRequirements(coupon)
+		if err != nil {
+			slog.Error("error parsing coupon requirements", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		if coupon.MinPurchaseQuantity.Valid {
-			itemCount := 0
-			for _, item := range checkoutReq.Items {
-				itemCount += item.Amount
-			}
-			if itemCount < int(coupon.MinPurchaseQuantity.Int64) {
-				slog.Error("insufficient purchase count", "db", coupon.MinPurchaseQuantity.Int64, "req", itemCount)
-				http.Error(w, "Invalid Coupon Code", http.StatusBadRequest)
-				return
-			}
+		parsedDiscount, err := parseCouponDiscount(coupon.Discount)
+		if err != nil {
+			slog.Error("error parsing coupon discount", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 		couponID = &coupon.CouponID
 		couponStripeID = &coupon.StripeID
+		discount = &parsedDiscount
+		redemptionLimit = coupon.RedemptionLimit
+		customerRedemptionLimit = coupon.CustomerRedemptionLimit
 	}
 	items, err := constructOrder(checkoutReq, products)
 	if err != nil {
 		slog.Error("error constructing order", "err", err)
+		failure = checkoutFailureInvalidVariant
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	now := time.Now()
+	for _, requirement := range requirements {
+		if reason := requirement.check(checkoutReq, items, products, now); reason != "" {
+			slog.Info("coupon requirement not met", "type", requirement.Type, "reason", reason)
+			failure = checkoutFailureInvalidCoupon
+			http.Error(w, reason, http.StatusBadRequest)
+			return
+		}
+	}
+	// The total is calculated here as well so that we notice if the payment
+	// provider would charge something else.
+	itemDiscounts := make([]int64, len(items))
+	paymentItems := make([]PaymentItem, 0, len(items))
+	if discount != nil {
+		itemDiscounts = discount.itemDiscounts(items)
+		if !slices.ContainsFunc(itemDiscounts, func(v int64) bool { return v > 0 }) {
+			failure = checkoutFailureInvalidCoupon
+			http.Error(w, "Coupon does not apply to any item in the cart", http.StatusBadRequest)
+			return
+		}
+		paymentItems = discount.paymentItems(items)
+	} else {
+		for _, item := range items {
+			paymentItems = append(paymentItems, PaymentItem{OrderItem: item})
+		}
+	}
+	total := paidTotal(items, itemDiscounts)
 	var nullCouponID sql.NullInt64
 	if checkoutReq.Coupon != nil {
 		nullCouponID = sql.NullInt64{
@@ -260,8 +390,37 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 		if err := queries.CreateOrder(ctx, order); err != nil {
 			// Try a different order ID.
 			slog.Error("error creating order", "err", err)
+			_ = tx.Rollback()
+			s.Metrics.orderIDRetries.Inc()
 			continue
 		}
+		// Redemptions are counted after the order is created so that concurrent
+		// checkouts cannot both take the last one.
+		if nullCouponID.Valid {
+			redemptions, err := queries.CountCouponRedemptions(ctx, db.CountCouponRedemptionsParams{
+				MatricNumber: checkoutReq.MatricNumber,
+				Email:        checkoutReq.Email,
+				CouponID:     nullCouponID,
+			})
+			if err != nil {
+				slog.Error("error counting coupon redemptions", "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				_ = tx.Rollback()
+				return
+			}
+			if redemptionLimit.Valid && redemptions.Total > redemptionLimit.Int64 {
+				failure = checkoutFailureInvalidCoupon
+				http.Error(w, "Coupon has been fully redeemed", http.StatusBadRequest)
+				_ = tx.Rollback()
+				return
+			}
+			if customerRedemptionLimit.Valid && redemptions.Customer > customerRedemptionLimit.Int64 {
+				failure = checkoutFailureInvalidCoupon
+				http.Error(w, "Coupon has already been redeemed by this buyer", http.StatusBadRequest)
+				_ = tx.Rollback()
+				return
+			}
+		}
 		for _, item := range items {
 			if err := queries.CreateOrderItem(ctx, db.CreateOrderItemParams{
 				OrderID:     orderID,
@@ -274,32 +433,59 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 			}); err != nil {
 				slog.Error("error creating order item", "err", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				_ = tx.Rollback()
 				return
 			}
 		}
+		outOfStock, err := reserveStock(ctx, queries, items)
+		switch {
+		case err != nil:
+			slog.Error("error reserving stock", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			_ = tx.Rollback()
+			return
+		case outOfStock != nil:
+			failure = checkoutFailureOutOfStock
+			http.Error(w, insufficientStockMessage(outOfStock), http.StatusBadRequest)
+			_ = tx.Rollback()
+			return
+		}
 		if err := tx.Commit(); err != nil {
 			slog.Error("error commiting order", "err", err)
 			continue
 		}
-		var redirectURL string
-		var paymentRef string
-		if s.Stripe == nil {
-			paymentRef = "nonstripe_mock_" + randomOrderID()
-			slog.Warn("skipping checkout session creation as Stripe is not configured")
-			redirectURL = s.Config.FrontendURL + "/api/v0/checkout/complete?session_id=" + paymentRef
-		} else {
-			checkoutSession, err := s.createStripeCheckoutSession(orderID, checkoutReq.Email, items, couponStripeID)
-			if err != nil {
-				slog.Error("error creating checkout session", "err", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
+		checkoutSession, err := s.Payment.CreateSession(PaymentSessionParams{
+			OrderID:     orderID,
+			Email:       checkoutReq.Email,
+			Items:       paymentItems,
+			CouponID:    couponStripeID,
+			CompleteURL: s.Config.FrontendURL + "/api/v0/checkout/complete",
+			CancelURL:   s.Config.FrontendURL,
+		})
+		if err != nil {
+			slog.Error("error creating checkout session", "err", err)
+			failure = checkoutFailurePaymentProvider
+			if err := s.abandonOrder(ctx, orderID); err != nil {
+				slog.Error("error cancelling order without checkout session", "err", err)
 			}
-			redirectURL = checkoutSession.URL
-			paymentRef = checkoutSession.ID
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if checkoutSession.AmountTotal != total {
+			slog.Error("payment provider total does not match order total", "order_id", orderID, "payment_total", checkoutSession.AmountTotal, "order_total", total)
+			failure = checkoutFailurePaymentProvider
+			if err := s.Payment.ExpireSession(checkoutSession.ID); err != nil {
+				slog.Error("error expiring checkout session with wrong total", "err", err)
+			}
+			if err := s.abandonOrder(ctx, orderID); err != nil {
+				slog.Error("error cancelling order with wrong total", "err", err)
+			}
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 		if err := s.Queries.AssociateOrder(ctx, db.AssociateOrderParams{
 			PaymentReference: sql.NullString{
-				String: paymentRef,
+				String: checkoutSession.ID,
 				Valid:  true,
 			},
 			OrderID: orderID,
@@ -308,8 +494,10 @@ func (s *Server) Checkout(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		failure = ""
+		s.Metrics.checkoutSuccess.Inc()
 		if err := json.NewEncoder(w).Encode(CheckoutResponse{
-			CheckoutURL: redirectURL,
+			CheckoutURL: checkoutSession.URL,
 		}); err != nil {
 			slog.Error("error writing checkout response", "err", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -405,54 +593,6 @@ func constructOrder(req CheckoutRequest, products []Product) ([]db.OrderItem, er
 	return orderItems, nil
 }
 
-func (s *Server) createStripeCheckoutSession(orderID string, email string, items []db.OrderItem, couponID *string) (*stripe.CheckoutSession, error) {
-	checkoutLineItems := make([]*stripe.CheckoutSessionLineItemParams, 0, len(items))
-	for _, v := range items {
-		var imageData []*string
-		if v.ImageUrl != "" {
-			imageData = append(imageData, stripe.String(v.ImageUrl))
-		}
-		var desc *string
-		if v.Variant != "" {
-			// Stripe does not like empty values as it assumes we are unsetting it.
-			desc = stripe.String(v.Variant)
-		}
-		checkoutLineItems = append(checkoutLineItems, &stripe.CheckoutSessionLineItemParams{
-			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-				Currency:   stripe.String("sgd"),
-				UnitAmount: stripe.Int64(v.UnitPrice),
-				ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-					Name:        &v.ProductName,
-					Images:      imageData,
-					Description: desc,
-				},
-			},
-			Quantity: &v.Amount,
-		})
-	}
-	var discount []*stripe.CheckoutSessionDiscountParams
-	if couponID != nil {
-		discount = []*stripe.CheckoutSessionDiscountParams{
-			{
-				Coupon: couponID,
-			},
-		}
-	}
-	checkoutParams := &stripe.CheckoutSessionParams{
-		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
-		SuccessURL:        stripe.String(s.Config.FrontendURL + "/api/v0/checkout/complete?session_id={CHECKOUT_SESSION_ID}"),
-		CancelURL:         stripe.String(s.Config.FrontendURL),
-		LineItems:         checkoutLineItems,
-		Discounts:         discount,
-		ClientReferenceID: stripe.String(orderID),
-		CustomerEmail:     stripe.String(email),
-		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
-			Description: stripe.String("Your order ID is " + orderID + "."),
-		},
-	}
-	return s.Stripe.CheckoutSessions.New(checkoutParams)
-}
-
 // Alphabets that are easily disambiguated.
 var alphabet = "CDEFHJKMNPRTVWXY"
 
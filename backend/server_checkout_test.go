@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+func TestCheckout(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(ts *testServer)
+		req         CheckoutRequest
+		code        int
+		body        string
+		storeClosed bool
+	}{
+		{
+			name: "valid",
+			req:  testCheckoutRequest(shirtItem("1", "M", 2), CartItem{ID: "2", Variant: []CartItemVariant{}, Amount: 1}),
+			code: http.StatusOK,
+			body: "session_id=mock_cs_1",
+		},
+		{
+			name: "invalid name",
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 1))
+				req.Name = " "
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Invalid Name",
+		},
+		{
+			name: "invalid matric number",
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 1))
+				req.MatricNumber = "A1234567B"
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Invalid Matric Number",
+		},
+		{
+			name: "invalid email",
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 1))
+				req.Email = "tanahkow@gmail.com"
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Invalid Email",
+		},
+		{
+			name: "no items",
+			req:  testCheckoutRequest(),
+			code: http.StatusBadRequest,
+			body: "At least one item is required",
+		},
+		{
+			name: "unknown product",
+			req:  testCheckoutRequest(shirtItem("99", "M", 1)),
+			code: http.StatusBadRequest,
+			body: `invalid product ID "99"`,
+		},
+		{
+			name: "invalid variant",
+			req:  testCheckoutRequest(shirtItem("1", "XXL", 1)),
+			code: http.StatusBadRequest,
+			body: `has option "XXL"`,
+		},
+		{
+			name: "invalid amount",
+			req:  testCheckoutRequest(shirtItem("1", "M", 0)),
+			code: http.StatusBadRequest,
+			body: "amount must be between 1-100",
+		},
+		{
+			name: "insufficient stock",
+			req:  testCheckoutRequest(shirtItem("1", "S", 3)),
+			code: http.StatusBadRequest,
+			body: "Insufficient stock for Shirt (S)",
+		},
+		{
+			name: "invalid coupon",
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 1))
+				req.Coupon = ptr("NOPE")
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Invalid coupon code",
+		},
+		{
+			name: "coupon for another email",
+			setup: func(ts *testServer) {
+				ts.createCoupon("PERSONAL", 10, `{"type":"email","value":"someone@e.ntu.edu.sg"}`)
+			},
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 1))
+				req.Coupon = ptr("PERSONAL")
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Coupon is not valid for this email",
+		},
+		{
+			name: "coupon purchase count not met",
+			setup: func(ts *testServer) {
+				ts.createCoupon("BULK", 10, `{"type":"purchase_count","amount":3}`)
+			},
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 2))
+				req.Coupon = ptr("BULK")
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Coupon requires at least 3 items",
+		},
+		{
+			name: "coupon purchase count met",
+			setup: func(ts *testServer) {
+				ts.createCoupon("BULK", 10, `{"type":"purchase_count","amount":3}`)
+			},
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 3))
+				req.Coupon = ptr("BULK")
+				return req
+			}(),
+			code: http.StatusOK,
+			body: "session_id=",
+		},
+		{
+			name: "coupon product requirement not met",
+			setup: func(ts *testServer) {
+				ts.createCoupon("SMALL", 10, `{"type":"product","value":"1","options":["S"]}`, `{"type":"matric_prefix","values":["U23"]}`)
+			},
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "M", 1))
+				req.Coupon = ptr("SMALL")
+				return req
+			}(),
+			code: http.StatusBadRequest,
+			body: "Coupon requires Shirt (S) in the cart",
+		},
+		{
+			name: "coupon stored requirements met",
+			setup: func(ts *testServer) {
+				ts.createCoupon("SMALL", 10, `{"type":"product","value":"1","options":["S"]}`, `{"type":"min_subtotal","amount":1500}`)
+			},
+			req: func() CheckoutRequest {
+				req := testCheckoutRequest(shirtItem("1", "S", 1))
+				req.Coupon = ptr("SMALL")
+				return req
+			}(),
+			code: http.StatusOK,
+			body: "session_id=",
+		},
+		{
+			name: "store closed",
+			setup: func(ts *testServer) {
+				ts.requestOK("POST", "/api/v0/closures", StoreClosure{
+					StartTime: time.Now().Add(-time.Hour),
+					EndTime:   time.Now().Add(time.Hour),
+					Message:   "Closed for stocktake",
+				}, true, nil)
+			},
+			req:         testCheckoutRequest(shirtItem("1", "M", 1)),
+			code:        http.StatusOK,
+			body:        "Closed for stocktake",
+			storeClosed: true,
+		},
+		{
+			name: "payment provider failure",
+			setup: func(ts *testServer) {
+				ts.payment.DefaultState = MockSessionFailed
+			},
+			req:  testCheckoutRequest(shirtItem("1", "M", 1)),
+			code: http.StatusInternalServerError,
+			body: "Internal Server Error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			ts.createProduct(testSticker)
+			if tt.setup != nil {
+				tt.setup(ts)
+			}
+			rec := ts.request("POST", "/api/v0/checkout", tt.req, false)
+			expectResponse(t, rec, tt.code, tt.body)
+			if tt.code == http.StatusOK && !tt.storeClosed {
+				return
+			}
+			// Nothing should be left behind by failed checkouts.
+			var count int
+			if err := ts.DB.QueryRow("SELECT COUNT(*) FROM orders WHERE cancelled = FALSE").Scan(&count); err != nil {
+				t.Fatalf("error counting orders: %v", err)
+			}
+			if count != 0 {
+				t.Errorf("got %d active orders after failed checkout, want 0", count)
+			}
+			if available, reserved := ts.stock("1", "S"); available != 2 || reserved != 0 {
+				t.Errorf("got stock %d available, %d reserved after failed checkout, want 2 and 0", available, reserved)
+			}
+		})
+	}
+}
+
+func TestCheckoutCreatesOrder(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.createProduct(testSticker)
+	ts.createCoupon("TEN", 10)
+	req := testCheckoutRequest(shirtItem("1", "M", 2), shirtItem("1", "S", 1), CartItem{ID: "2", Variant: []CartItemVariant{}, Amount: 1})
+	req.Coupon = ptr("TEN")
+	orderID, sessionID := ts.checkout(req)
+
+	items, err := ts.Queries.ListOrderItems(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("error listing order items: %v", err)
+	}
+	want := []db.OrderItem{
+		{ProductID: "1", ProductName: "Shirt", UnitPrice: 1700, Amount: 2, ImageUrl: "shirt-m.png", Variant: "M"},
+		{ProductID: "1", ProductName: "Shirt", UnitPrice: 1500, Amount: 1, ImageUrl: "shirt.png", Variant: "S"},
+		{ProductID: "2", ProductName: "Sticker", UnitPrice: 300, Amount: 1, ImageUrl: "", Variant: ""},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d order items, want %d", len(items), len(want))
+	}
+	for i := range want {
+		want[i].ID = items[i].ID
+		want[i].OrderID = orderID
+		if items[i] != want[i] {
+			t.Errorf("got order item %+v, want %+v", items[i], want[i])
+		}
+	}
+	if available, reserved := ts.stock("1", "S"); available != 1 || reserved != 1 {
+		t.Errorf("got stock %d available, %d reserved, want 1 and 1", available, reserved)
+	}
+	order := ts.lookupOrder(orderID)
+	if order.PaymentTime != nil || order.Cancelled {
+		t.Errorf("got order %+v, want it to be pending payment", order)
+	}
+	if order.PaymentReference != censorFront(sessionID, 8, 10, ' ') {
+		t.Errorf("got payment reference %q, want session %q", order.PaymentReference, sessionID)
+	}
+	if order.Coupon == nil || order.Coupon.CouponCode != "TEN" {
+		t.Errorf("got coupon %+v, want TEN", order.Coupon)
+	}
+}
+
+func TestCheckoutComplete(t *testing.T) {
+	tests := []struct {
+		name          string
+		state         MockSessionState
+		code          int
+		wantPaid      bool
+		wantCancelled bool
+		wantAvailable int64
+		wantReserved  int64
+	}{
+		{"paid", MockSessionPaid, http.StatusTemporaryRedirect, true, false, 1, 0},
+		{"unpaid", MockSessionUnpaid, http.StatusBadRequest, false, false, 1, 1},
+		{"expired", MockSessionExpired, http.StatusTemporaryRedirect, false, true, 2, 0},
+		{"provider failure", MockSessionFailed, http.StatusBadRequest, false, false, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			orderID, sessionID := ts.checkout(testCheckoutRequest(shirtItem("1", "S", 1)))
+			if err := ts.payment.SetSessionState(sessionID, tt.state); err != nil {
+				t.Fatalf("error setting session state: %v", err)
+			}
+			rec := ts.request("GET", "/api/v0/checkout/complete?session_id="+sessionID, nil, false)
+			expectResponse(t, rec, tt.code, "")
+			if tt.code == http.StatusTemporaryRedirect {
+				if got := rec.Header().Get("Location"); got != "http://shop.test/orders/"+orderID {
+					t.Errorf("got redirect to %q, want order page", got)
+				}
+			}
+			order := ts.lookupOrder(orderID)
+			if (order.PaymentTime != nil) != tt.wantPaid || order.Cancelled != tt.wantCancelled {
+				t.Errorf("got payment time %v and cancelled %t, want paid %t and cancelled %t", order.PaymentTime, order.Cancelled, tt.wantPaid, tt.wantCancelled)
+			}
+			if available, reserved := ts.stock("1", "S"); available != tt.wantAvailable || reserved != tt.wantReserved {
+				t.Errorf("got stock %d available, %d reserved, want %d and %d", available, reserved, tt.wantAvailable, tt.wantReserved)
+			}
+		})
+	}
+}
+
+func TestCheckoutCompleteTwice(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, sessionID := ts.paidOrder(testCheckoutRequest(shirtItem("1", "S", 1)))
+	// Stock must only be confirmed once even if the user refreshes the page.
+	rec := ts.request("GET", "/api/v0/checkout/complete?session_id="+sessionID, nil, false)
+	expectResponse(t, rec, http.StatusTemporaryRedirect, "")
+	if available, reserved := ts.stock("1", "S"); available != 1 || reserved != 0 {
+		t.Errorf("got stock %d available, %d reserved, want 1 and 0", available, reserved)
+	}
+	if order := ts.lookupOrder(orderID); order.PaymentTime == nil {
+		t.Errorf("got unpaid order, want it to be paid")
+	}
+}
+
+func TestCheckoutCompleteMissingSession(t *testing.T) {
+	ts := newTestServer(t)
+	expectResponse(t, ts.request("GET", "/api/v0/checkout/complete", nil, false), http.StatusBadRequest, "Session ID not provided")
+	expectResponse(t, ts.request("GET", "/api/v0/checkout/complete?session_id=unknown", nil, false), http.StatusBadRequest, "Failed to complete checkout")
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	tests := []struct {
+		name          string
+		state         MockSessionState
+		payload       string
+		code          int
+		wantPaid      bool
+		wantCancelled bool
+	}{
+		{"completed", MockSessionPaid, `{"type":"checkout.session.completed","session_id":"mock_cs_1"}`, http.StatusOK, true, false},
+		{"completed but unpaid", MockSessionUnpaid, `{"type":"checkout.session.completed","session_id":"mock_cs_1"}`, http.StatusBadRequest, false, false},
+		{"expired", MockSessionExpired, `{"type":"checkout.session.expired","session_id":"mock_cs_1"}`, http.StatusOK, false, true},
+		{"unknown session", MockSessionPaid, `{"type":"checkout.session.completed","session_id":"mock_cs_2"}`, http.StatusBadRequest, false, false},
+		{"ignored event", MockSessionPaid, `{"type":"charge.succeeded"}`, http.StatusOK, false, false},
+		{"invalid payload", MockSessionPaid, `not json`, http.StatusBadRequest, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			orderID, sessionID := ts.checkout(testCheckoutRequest(shirtItem("1", "M", 1)))
+			if err := ts.payment.SetSessionState(sessionID, tt.state); err != nil {
+				t.Fatalf("error setting session state: %v", err)
+			}
+			rec := ts.request("POST", "/api/v0/checkout/stripe", tt.payload, false)
+			expectResponse(t, rec, tt.code, "")
+			order := ts.lookupOrder(orderID)
+			if (order.PaymentTime != nil) != tt.wantPaid || order.Cancelled != tt.wantCancelled {
+				t.Errorf("got payment time %v and cancelled %t, want paid %t and cancelled %t", order.PaymentTime, order.Cancelled, tt.wantPaid, tt.wantCancelled)
+			}
+		})
+	}
+}
+
+func TestCheckoutRecordsCoupon(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	coupon := ts.createCoupon("TEN", 10)
+	req := testCheckoutRequest(shirtItem("1", "M", 1))
+	req.Coupon = ptr("TEN")
+	orderID, _ := ts.paidOrder(req)
+	order, err := ts.Queries.OrderByID(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("error looking up order: %v", err)
+	}
+	if order.CouponID != (sql.NullInt64{Int64: *coupon.ID, Valid: true}) {
+		t.Errorf("got coupon ID %v, want %d", order.CouponID, *coupon.ID)
+	}
+}
+
+func TestCheckoutRedemptionLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	coupon := ts.createCoupon("ONCE", 10)
+	coupon.RedemptionLimit = ptr(int64(1))
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", coupon, true, nil)
+
+	req := testCheckoutRequest(shirtItem("1", "S", 1))
+	req.Coupon = ptr("ONCE")
+	_, sessionID := ts.checkout(req)
+	other := req
+	other.MatricNumber = "U3456789B"
+	other.Email = "other@e.ntu.edu.sg"
+	// The pending order holds the only redemption.
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", other, false), http.StatusBadRequest, "Coupon has been fully redeemed")
+	if available, reserved := ts.stock("1", "S"); available != 1 || reserved != 1 {
+		t.Errorf("got stock %d available, %d reserved, want only the first order reserved", available, reserved)
+	}
+	var resp CouponsResponse
+	ts.requestOK("GET", "/api/v0/sales/current/coupons", nil, false, &resp)
+	if len(resp.Coupons) != 0 {
+		t.Errorf("got public coupons %+v, want the fully redeemed coupon hidden", resp.Coupons)
+	}
+
+	// Expiring the checkout releases the redemption.
+	if err := ts.payment.SetSessionState(sessionID, MockSessionExpired); err != nil {
+		t.Fatalf("error setting session state: %v", err)
+	}
+	rec := ts.request("POST", "/api/v0/checkout/stripe", `{"type":"checkout.session.expired","session_id":"`+sessionID+`"}`, false)
+	expectResponse(t, rec, http.StatusOK, "")
+	ts.paidOrder(other)
+	ts.requestOK("GET", "/api/v0/sales/1/coupons?include_disabled=1", nil, true, &resp)
+	if len(resp.Coupons) != 1 || *resp.Coupons[0].Redemptions != 1 || *resp.Coupons[0].PendingRedemptions != 0 {
+		t.Errorf("got coupons %+v, want 1 paid redemption", resp.Coupons)
+	}
+}
+
+func TestCheckoutCustomerRedemptionLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	coupon := ts.createCoupon("ONCE", 10)
+	coupon.CustomerRedemptionLimit = ptr(int64(1))
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", coupon, true, nil)
+
+	req := testCheckoutRequest(shirtItem("1", "S", 1))
+	req.Coupon = ptr("ONCE")
+	ts.paidOrder(req)
+	sameMatric := req
+	sameMatric.Email = "other@e.ntu.edu.sg"
+	sameEmail := req
+	sameEmail.MatricNumber = "U3456789B"
+	for _, r := range []CheckoutRequest{req, sameMatric, sameEmail} {
+		expectResponse(t, ts.request("POST", "/api/v0/checkout", r, false), http.StatusBadRequest, "Coupon has already been redeemed by this buyer")
+	}
+	other := sameEmail
+	other.Email = "other@e.ntu.edu.sg"
+	ts.checkout(other)
+	var resp CouponsResponse
+	ts.requestOK("GET", "/api/v0/sales/1/coupons?include_disabled=1", nil, true, &resp)
+	if len(resp.Coupons) != 1 || *resp.Coupons[0].Redemptions != 1 || *resp.Coupons[0].PendingRedemptions != 1 {
+		t.Errorf("got coupons %+v, want 1 paid and 1 pending redemption", resp.Coupons)
+	}
+}
+
+func TestCheckoutDiscounts(t *testing.T) {
+	tests := []struct {
+		name      string
+		discount  string
+		wantTotal int
+	}{
+		{"percentage", `{"type":"percentage","amount":10}`, 5220},
+		{"fixed", `{"type":"fixed","amount":500,"products":["1"]}`, 5300},
+		{"free item", `{"type":"free_item","amount":1,"products":["2"]}`, 5500},
+		{"buy x get y", `{"type":"buy_x_get_y","buy":2,"free":1,"products":["1"]}`, 4300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			ts.createProduct(testSticker)
+			ts.createDiscountCoupon("DEAL", tt.discount)
+			// The cart costs 58.00 before discounts.
+			req := testCheckoutRequest(shirtItem("1", "M", 2), shirtItem("1", "S", 1), CartItem{ID: "2", Variant: []CartItemVariant{}, Amount: 3})
+			req.Coupon = ptr("DEAL")
+			orderID, sessionID := ts.paidOrder(req)
+			session, err := ts.payment.GetSession(sessionID)
+			if err != nil {
+				t.Fatalf("error fetching session: %v", err)
+			}
+			if session.AmountTotal != int64(tt.wantTotal) {
+				t.Errorf("got total %d, want %d", session.AmountTotal, tt.wantTotal)
+			}
+			// Cancelling refunds exactly what was paid.
+			if err := ts.cancelOrder(context.Background(), orderID, "", testAdminEmail); err != nil {
+				t.Fatalf("error cancelling order: %v", err)
+			}
+			if order := ts.lookupOrder(orderID); len(order.Refunds) != 1 || order.Refunds[0].Amount != tt.wantTotal {
+				t.Errorf("got refunds %+v, want %d refunded", order.Refunds, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestCheckoutDiscountNotApplicable(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.createProduct(testSticker)
+	ts.createDiscountCoupon("STICKER", `{"type":"free_item","amount":1,"products":["2"]}`)
+	req := testCheckoutRequest(shirtItem("1", "S", 1))
+	req.Coupon = ptr("STICKER")
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", req, false), http.StatusBadRequest, "Coupon does not apply to any item in the cart")
+}
+
+func TestCheckoutTotalMismatch(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	coupon := ts.createCoupon("TEN", 10)
+	// Simulate the payment provider coupon giving a different discount.
+	ts.payment.mu.Lock()
+	mockCoupon := ts.payment.coupons[*coupon.StripeID]
+	mockCoupon.discount.PercentOff = 20
+	ts.payment.coupons[*coupon.StripeID] = mockCoupon
+	ts.payment.DefaultState = MockSessionUnpaid
+	ts.payment.mu.Unlock()
+
+	req := testCheckoutRequest(shirtItem("1", "S", 1))
+	req.Coupon = ptr("TEN")
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", req, false), http.StatusInternalServerError, "Internal Server Error")
+	if available, reserved := ts.stock("1", "S"); available != 2 || reserved != 0 {
+		t.Errorf("got stock %d available, %d reserved, want the order to be abandoned", available, reserved)
+	}
+	session, err := ts.payment.GetSession("mock_cs_1")
+	if err != nil || session.Status != PaymentSessionExpired {
+		t.Errorf("got session %+v (err: %v), want it expired", session, err)
+	}
+}
+
+func TestConstructOrder(t *testing.T) {
+	products := []Product{testShirt, testSticker}
+	products[0].ID = "1"
+	products[1].ID = "2"
+	tests := []struct {
+		name    string
+		items   []CartItem
+		want    []db.OrderItem
+		wantErr bool
+	}{
+		{
+			name:  "base price",
+			items: []CartItem{shirtItem("1", "S", 1)},
+			want:  []db.OrderItem{{ProductID: "1", ProductName: "Shirt", UnitPrice: 1500, Amount: 1, ImageUrl: "shirt.png", Variant: "S"}},
+		},
+		{
+			name:  "additional price and matching image",
+			items: []CartItem{shirtItem("1", "M", 3)},
+			want:  []db.OrderItem{{ProductID: "1", ProductName: "Shirt", UnitPrice: 1700, Amount: 3, ImageUrl: "shirt-m.png", Variant: "M"}},
+		},
+		{
+			name:  "no variants",
+			items: []CartItem{{ID: "2", Amount: 100}},
+			want:  []db.OrderItem{{ProductID: "2", ProductName: "Sticker", UnitPrice: 300, Amount: 100}},
+		},
+		{name: "unknown product", items: []CartItem{{ID: "3", Amount: 1}}, wantErr: true},
+		{name: "missing variant", items: []CartItem{{ID: "1", Amount: 1}}, wantErr: true},
+		{name: "wrong variant type", items: []CartItem{{ID: "1", Variant: []CartItemVariant{{Type: "Colour", Option: "S"}}, Amount: 1}}, wantErr: true},
+		{name: "invalid option", items: []CartItem{shirtItem("1", "L", 1)}, wantErr: true},
+		{name: "too many", items: []CartItem{{ID: "2", Amount: 101}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := constructOrder(testCheckoutRequest(tt.items...), products)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d items, want %d", len(got), len(tt.want))
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("got item %+v, want %+v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
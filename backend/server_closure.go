@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -19,8 +20,12 @@ type StoreClosureError struct {
 	AllowOrderCheck bool   `json:"show_order_check"`
 }
 
+// defaultSaleEndedMessage is shown after a sale period ends if it does not
+// have its own message.
+const defaultSaleEndedMessage = "The sale has ended. Thank you for your support, and see you at the next sale!"
+
 func (s *Server) closureCheck(w http.ResponseWriter, req *http.Request) (ok bool) {
-	closure, err := s.Queries.StoreClosureCurrent(req.Context(), time.Now().UTC())
+	closure, err := s.currentClosure(req.Context(), time.Now().UTC())
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return true
@@ -31,7 +36,7 @@ func (s *Server) closureCheck(w http.ResponseWriter, req *http.Request) (ok bool
 	}
 	var endTime *int
 	actualEndTime := time.Until(closure.EndTime)
-	if actualEndTime < 24*time.Hour {
+	if !closure.EndTime.IsZero() && actualEndTime < 24*time.Hour {
 		secondsLeft := int(actualEndTime / time.Second)
 		endTime = &secondsLeft
 	}
@@ -48,6 +53,38 @@ func (s *Server) closureCheck(w http.ResponseWriter, req *http.Request) (ok bool
 	return false
 }
 
+// currentClosure returns the closure that the store is in at the given time,
+// or sql.ErrNoRows if the store is open. Besides the configured store
+// closures, the store is closed from the end of a sale period to the start of
+// the next one. EndTime is zero if there is no next sale period yet.
+func (s *Server) currentClosure(ctx context.Context, now time.Time) (db.StoreClosure, error) {
+	closure, err := s.Queries.StoreClosureCurrent(ctx, now)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return closure, err
+	}
+	period, err := s.Queries.CurrentSalePeriod(ctx, now)
+	if err != nil {
+		return db.StoreClosure{}, err
+	}
+	if !period.EndTime.Valid || now.Before(period.EndTime.Time) {
+		return db.StoreClosure{}, sql.ErrNoRows
+	}
+	nextStart, err := s.Queries.NextSalePeriodStart(ctx, now)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return db.StoreClosure{}, err
+	}
+	message := period.ClosedMessage
+	if message == "" {
+		message = defaultSaleEndedMessage
+	}
+	return db.StoreClosure{
+		StartTime:       period.EndTime.Time,
+		EndTime:         nextStart,
+		UserMessage:     message,
+		AllowOrderCheck: true,
+	}, nil
+}
+
 type StoreClosureResponse struct {
 	Closures []StoreClosure `json:"closures"`
 }
@@ -99,18 +136,29 @@ func (s *Server) SaveStoreClosure(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for store closure", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	var before any
+	var closureID int64
 	var sqlErr error
 	switch closure.ID {
 	case "":
 		// Create new store closure.
-		var newID int64
-		newID, sqlErr = s.Queries.CreateStoreClosure(ctx, db.CreateStoreClosureParams{
+		closureID, sqlErr = queries.CreateStoreClosure(ctx, db.CreateStoreClosureParams{
 			StartTime:       closure.StartTime,
 			EndTime:         closure.EndTime,
 			UserMessage:     closure.Message,
 			AllowOrderCheck: closure.AllowOrderCheck,
 		})
-		closure.ID = strconv.Itoa(int(newID))
+		closure.ID = strconv.Itoa(int(closureID))
 	default:
 		// Update existing ID.
 		id, err := strconv.Atoi(closure.ID)
@@ -118,23 +166,53 @@ func (s *Server) SaveStoreClosure(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Invalid closure ID", http.StatusBadRequest)
 			return
 		}
-		sqlErr = s.Queries.UpdateStoreClosure(ctx, db.UpdateStoreClosureParams{
-			ID:              int64(id),
+		closureID = int64(id)
+		before, sqlErr = queries.StoreClosureByID(ctx, closureID)
+		if sqlErr != nil {
+			break
+		}
+		var rows int64
+		rows, sqlErr = queries.UpdateStoreClosure(ctx, db.UpdateStoreClosureParams{
+			ID:              closureID,
 			StartTime:       closure.StartTime,
 			EndTime:         closure.EndTime,
 			UserMessage:     closure.Message,
 			AllowOrderCheck: closure.AllowOrderCheck,
 		})
+		if sqlErr == nil && rows == 0 {
+			// The store closure has been deleted.
+			sqlErr = sql.ErrNoRows
+		}
 	}
 	switch {
 	case errors.Is(sqlErr, sql.ErrNoRows):
-		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
+		http.Error(w, "Invalid closure ID", http.StatusNotFound)
 		return
 	case sqlErr != nil:
 		slog.Error("error updating store closure", "err", sqlErr)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	after, err := queries.StoreClosureByID(ctx, closureID)
+	if err != nil {
+		slog.Error("error fetching updated store closure", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	action := "update"
+	if before == nil {
+		action = "create"
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditStoreClosure, closureID, action, before, after); err != nil {
+		slog.Error("error recording store closure change", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting store closure", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	if err := json.NewEncoder(w).Encode(closure); err != nil {
 		slog.Error("error writing update closure response", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -151,9 +229,35 @@ func (s *Server) DeleteStoreClosure(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
 		return
 	}
-	if err := s.Queries.DeleteStoreClosure(req.Context(), int64(id)); err != nil {
+	ctx := req.Context()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for store closure deletion", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, err := queries.StoreClosureByID(ctx, int64(id))
+	if err != nil || before.Deleted {
+		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
+		return
+	}
+	if err := queries.DeleteStoreClosure(ctx, int64(id)); err != nil {
 		http.Error(w, "Invalid closure ID", http.StatusBadRequest)
 		return
 	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditStoreClosure, id, "delete", before, nil); err != nil {
+		slog.Error("error recording store closure deletion", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting store closure deletion", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
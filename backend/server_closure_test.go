@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSaveStoreClosure(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		name string
+		body any
+		code int
+		want string
+	}{
+		{"closure", StoreClosure{StartTime: now, EndTime: now.Add(time.Hour), Message: "Closed"}, http.StatusOK, `"id":"1"`},
+		{"not json", "not json", http.StatusBadRequest, "Invalid Body"},
+		{"invalid closure ID", StoreClosure{ID: "abc"}, http.StatusBadRequest, "Invalid closure ID"},
+		{"unknown closure ID", StoreClosure{ID: "5", StartTime: now, EndTime: now.Add(time.Hour)}, http.StatusNotFound, "Invalid closure ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			expectResponse(t, ts.request("POST", "/api/v0/closures", tt.body, true), tt.code, tt.want)
+		})
+	}
+}
+
+func TestStoreClosures(t *testing.T) {
+	ts := newTestServer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	var closure StoreClosure
+	ts.requestOK("POST", "/api/v0/closures", StoreClosure{
+		StartTime: now.Add(time.Hour),
+		EndTime:   now.Add(2 * time.Hour),
+		Message:   "Closed for restocking",
+	}, true, &closure)
+	closure.AllowOrderCheck = true
+	ts.requestOK("POST", "/api/v0/closures", closure, true, nil)
+
+	var resp StoreClosureResponse
+	ts.requestOK("GET", "/api/v0/closures", nil, true, &resp)
+	if len(resp.Closures) != 1 {
+		t.Fatalf("got %d closures, want 1", len(resp.Closures))
+	}
+	got := resp.Closures[0]
+	if got.ID != closure.ID || got.Message != "Closed for restocking" || !got.AllowOrderCheck || !got.StartTime.Equal(closure.StartTime) {
+		t.Errorf("got closure %+v, want %+v", got, closure)
+	}
+
+	expectResponse(t, ts.request("DELETE", "/api/v0/closures/"+closure.ID, nil, true), http.StatusNoContent, "")
+	expectResponse(t, ts.request("DELETE", "/api/v0/closures/abc", nil, true), http.StatusBadRequest, "Invalid closure ID")
+	ts.requestOK("GET", "/api/v0/closures", nil, true, &resp)
+	if len(resp.Closures) != 0 {
+		t.Errorf("got closures %+v after deleting, want none", resp.Closures)
+	}
+}
+
+func TestClosureCheck(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name        string
+		start       time.Time
+		end         time.Time
+		closed      bool
+		wantEndTime bool
+	}{
+		{"ending soon", now.Add(-time.Hour), now.Add(time.Hour), true, true},
+		{"ending after a day", now.Add(-time.Hour), now.Add(48 * time.Hour), true, false},
+		{"upcoming", now.Add(time.Hour), now.Add(2 * time.Hour), false, false},
+		{"over", now.Add(-2 * time.Hour), now.Add(-time.Hour), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.requestOK("POST", "/api/v0/closures", StoreClosure{
+				StartTime:       tt.start,
+				EndTime:         tt.end,
+				Message:         "Closed",
+				AllowOrderCheck: true,
+			}, true, nil)
+			rec := ts.request("GET", "/api/v0/sales/current/products", nil, false)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", rec.Code)
+			}
+			var resp StoreClosureError
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if closed := resp.Type == "store_closure"; closed != tt.closed {
+				t.Fatalf("got closed %t, want %t", closed, tt.closed)
+			}
+			if !tt.closed {
+				return
+			}
+			if resp.Message != "Closed" || !resp.AllowOrderCheck {
+				t.Errorf("got closure %+v, want message and order check to be passed on", resp)
+			}
+			if (resp.EndTime != nil) != tt.wantEndTime {
+				t.Errorf("got end time %v, want it to be shown only within a day", resp.EndTime)
+			}
+		})
+	}
+}
+
+func TestSalePeriodEndClosure(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name        string
+		end         time.Time
+		message     string
+		nextStart   *time.Time
+		closed      bool
+		wantMessage string
+		wantEndTime bool
+	}{
+		{"not ended", now.Add(time.Hour), "", nil, false, "", false},
+		{"ended", now.Add(-time.Hour), "See you next semester!", nil, true, "See you next semester!", false},
+		{"ended without message", now.Add(-time.Hour), "", nil, true, defaultSaleEndedMessage, false},
+		{"next sale soon", now.Add(-time.Hour), "", ptr(now.Add(time.Hour)), true, defaultSaleEndedMessage, true},
+		{"next sale later", now.Add(-time.Hour), "", ptr(now.Add(72 * time.Hour)), true, defaultSaleEndedMessage, false},
+		{"next sale started", now.Add(-2 * time.Hour), "", ptr(now.Add(-time.Hour)), false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.requestOK("POST", "/api/v0/sales", SalePeriod{
+				ID:            "1",
+				Name:          "Default",
+				StartTime:     now.Add(-72 * time.Hour),
+				EndTime:       &tt.end,
+				ClosedMessage: tt.message,
+			}, true, nil)
+			if tt.nextStart != nil {
+				ts.requestOK("POST", "/api/v0/sales", SalePeriod{Name: "Next Sale", StartTime: *tt.nextStart}, true, nil)
+			}
+			rec := ts.request("GET", "/api/v0/sales/current/products", nil, false)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d, want 200", rec.Code)
+			}
+			var resp StoreClosureError
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if closed := resp.Type == "store_closure"; closed != tt.closed {
+				t.Fatalf("got closed %t, want %t", closed, tt.closed)
+			}
+			if !tt.closed {
+				return
+			}
+			if resp.Message != tt.wantMessage || !resp.AllowOrderCheck {
+				t.Errorf("got closure %+v, want message %q with order check allowed", resp, tt.wantMessage)
+			}
+			if (resp.EndTime != nil) != tt.wantEndTime {
+				t.Errorf("got end time %v, want it to be shown only if the next sale starts within a day", resp.EndTime)
+			}
+			// Checkout should be refused while the store is closed.
+			expectResponse(t, ts.request("POST", "/api/v0/checkout", testCheckoutRequest(shirtItem("1", "M", 1)), false), http.StatusOK, `"type":"store_closure"`)
+		})
+	}
+}
@@ -5,12 +5,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
-	"github.com/stripe/stripe-go/v81"
 )
 
 type CouponsResponse struct {
@@ -29,6 +28,16 @@ type Coupon struct {
 	Enabled    *bool   `json:"enabled,omitempty"`
 	Public     *bool   `json:"public,omitempty"`
 	StripeDesc *string `json:"stripe_desc,omitempty"`
+	// RedemptionLimit is the number of orders that can use the coupon, and
+	// CustomerRedemptionLimit is the number of those orders that can be made
+	// by the same matric number or email. There is no limit if they are unset.
+	RedemptionLimit         *int64 `json:"redemption_limit,omitempty"`
+	CustomerRedemptionLimit *int64 `json:"customer_redemption_limit,omitempty"`
+	// Redemptions and PendingRedemptions are the number of paid and unpaid
+	// orders that use the coupon, excluding cancelled orders. They are ignored
+	// when saving coupons.
+	Redemptions        *int64 `json:"redemptions,omitempty"`
+	PendingRedemptions *int64 `json:"pending_redemptions,omitempty"`
 }
 
 func (s *Server) Coupons(w http.ResponseWriter, req *http.Request) {
@@ -48,7 +57,10 @@ func (s *Server) Coupons(w http.ResponseWriter, req *http.Request) {
 	if includeDisabled {
 		dbCoupons, err = s.Queries.ListCoupons(req.Context(), salePeriod)
 	} else {
-		dbCoupons, err = s.Queries.ListPublicCoupons(req.Context(), salePeriod)
+		dbCoupons, err = s.Queries.ListPublicCoupons(req.Context(), db.ListPublicCouponsParams{
+			SalePeriod:  salePeriod,
+			CurrentTime: time.Now().UTC(),
+		})
 	}
 	switch {
 	case errors.Is(err, context.Canceled):
@@ -59,9 +71,27 @@ func (s *Server) Coupons(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	redemptions := make(map[int64]db.ListCouponRedemptionsRow)
+	if includeDisabled {
+		rows, err := s.Queries.ListCouponRedemptions(req.Context(), salePeriod)
+		if err != nil {
+			slog.Error("error fetching coupon redemptions", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		for _, row := range rows {
+			redemptions[row.CouponID] = row
+		}
+	}
 	coupons := make([]Coupon, 0, len(dbCoupons))
-	for _, coupon := range dbCoupons {
-		coupons = append(coupons, s.dbCouponToCoupon(coupon, includeDisabled))
+	for _, dbCoupon := range dbCoupons {
+		coupon := s.dbCouponToCoupon(dbCoupon, includeDisabled)
+		if includeDisabled {
+			row := redemptions[dbCoupon.CouponID]
+			coupon.Redemptions = &row.Paid
+			coupon.PendingRedemptions = &row.Pending
+		}
+		coupons = append(coupons, coupon)
 	}
 	if err := json.NewEncoder(w).Encode(CouponsResponse{
 		Coupons: coupons,
@@ -82,8 +112,9 @@ func (s *Server) CouponLookup(w http.ResponseWriter, req *http.Request) {
 	couponCode := req.PathValue("id")
 	ctx := req.Context()
 	dbCoupon, err := s.Queries.CouponEnabledByCode(ctx, db.CouponEnabledByCodeParams{
-		CouponCode: couponCode,
-		SalePeriod: salePeriod,
+		CouponCode:  couponCode,
+		SalePeriod:  salePeriod,
+		CurrentTime: time.Now().UTC(),
 	})
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
@@ -101,74 +132,160 @@ func (s *Server) CouponLookup(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-type couponRequirement struct {
-	Type   string `json:"type"`
-	Amount int    `json:"amount"`
-	Value  string `json:"value"`
-}
-
-type couponDiscount struct {
-	Type   string `json:"type"`
-	Amount int    `json:"amount"`
+// couponSettings are the requirements and discount of a coupon as they are
+// stored in the database.
+type couponSettings struct {
+	MinPurchaseQuantity sql.NullInt64
+	EmailMatch          sql.NullString
+	ValidFrom           sql.NullTime
+	ValidUntil          sql.NullTime
+	Requirements        string
+	Discount            string
+	// PaymentDiscount is the discount as the payment provider applies it.
+	PaymentDiscount PaymentDiscount
 }
 
-func (s *Server) SaveCoupon(w http.ResponseWriter, req *http.Request) {
-	if !s.authCheck(w, req) {
-		return
-	}
-	ctx := req.Context()
-	var coupon Coupon
-	if err := json.NewDecoder(req.Body).Decode(&coupon); err != nil {
-		slog.Error("error parsing request", "err", err)
-		http.Error(w, "Invalid Body", http.StatusBadRequest)
-		return
-	}
+// parseCouponSettings parses the requirements and discount of a coupon in the
+// sale period. The error is written to the response if they are invalid.
+func (s *Server) parseCouponSettings(w http.ResponseWriter, req *http.Request, salePeriod int64, requirements []json.RawMessage, rawDiscount json.RawMessage) (couponSettings, bool) {
 	var minPurchaseQuantity sql.NullInt64
 	var email sql.NullString
-	for _, marshalledReq := range coupon.Requirements {
-		var req couponRequirement
-		if err := json.Unmarshal(marshalledReq, &req); err != nil {
+	var validFrom, validUntil sql.NullTime
+	hasValidTime := false
+	// Requirements without their own column, validated after fetching the
+	// products.
+	storedRequirements := make([]couponRequirement, 0)
+	for _, marshalledReq := range requirements {
+		var requirement couponRequirement
+		if err := json.Unmarshal(marshalledReq, &requirement); err != nil {
 			slog.Error("error parsing request: coupon requirement is invalid", "err", err)
 			http.Error(w, "Invalid Body", http.StatusBadRequest)
-			return
+			return couponSettings{}, false
 		}
-		switch req.Type {
-		case "purchase_count":
-			if !minPurchaseQuantity.Valid || int64(req.Amount) > minPurchaseQuantity.Int64 {
+		switch requirement.Type {
+		case requirementPurchaseCount:
+			if !minPurchaseQuantity.Valid || int64(requirement.Amount) > minPurchaseQuantity.Int64 {
 				minPurchaseQuantity = sql.NullInt64{
 					Valid: true,
-					Int64: int64(req.Amount),
+					Int64: int64(requirement.Amount),
 				}
 			}
-		case "email":
+		case requirementEmail:
 			if email.Valid {
 				slog.Error("error parsing request: multiple emails provided")
 				http.Error(w, "Invalid Body", http.StatusBadRequest)
-				return
+				return couponSettings{}, false
 			}
 			email = sql.NullString{
 				Valid:  true,
-				String: req.Value,
+				String: requirement.Value,
+			}
+		case requirementValidTime:
+			if hasValidTime {
+				slog.Error("error parsing request: multiple valid times provided")
+				http.Error(w, "Invalid Body", http.StatusBadRequest)
+				return couponSettings{}, false
+			}
+			hasValidTime = true
+			if requirement.From == nil && requirement.Until == nil {
+				http.Error(w, "Invalid Coupon Requirement: Missing Valid Time", http.StatusBadRequest)
+				return couponSettings{}, false
+			}
+			if requirement.From != nil && requirement.Until != nil && !requirement.Until.After(*requirement.From) {
+				http.Error(w, "Invalid Coupon Requirement: Valid Until must be after Valid From", http.StatusBadRequest)
+				return couponSettings{}, false
+			}
+			// Times are compared as strings in SQLite, so they must be in UTC.
+			if requirement.From != nil {
+				validFrom = sql.NullTime{Time: requirement.From.UTC(), Valid: true}
+			}
+			if requirement.Until != nil {
+				validUntil = sql.NullTime{Time: requirement.Until.UTC(), Valid: true}
 			}
 		default:
-			slog.Error("error parsing request: coupon requirement is invalid", "type", req.Type)
-			http.Error(w, "Invalid Coupon Requirement", http.StatusBadRequest)
-			return
+			storedRequirements = append(storedRequirements, requirement)
 		}
 	}
 	var discount couponDiscount
-	var discountPercentage int
-	if err := json.Unmarshal(coupon.Discount, &discount); err != nil {
+	if err := json.Unmarshal(rawDiscount, &discount); err != nil {
 		slog.Error("error parsing request: coupon discount is invalid", "err", err)
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return couponSettings{}, false
+	}
+	if err := discount.validate(); err != nil {
+		slog.Error("error parsing request: coupon discount is invalid", "err", err)
+		http.Error(w, "Invalid Coupon Discount: "+err.Error(), http.StatusBadRequest)
+		return couponSettings{}, false
+	}
+	dbProducts, err := s.Queries.ListProducts(req.Context(), db.ListProductsParams{
+		IncludeDisabled: true,
+		SalePeriod:      salePeriod,
+	})
+	if err != nil {
+		slog.Error("error fetching products", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return couponSettings{}, false
+	}
+	products, err := dbProductsToProducts(dbProducts, nil, true)
+	if err != nil {
+		slog.Error("error parsing products", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return couponSettings{}, false
+	}
+	for i := range storedRequirements {
+		if err := storedRequirements[i].normalize(products); err != nil {
+			slog.Error("error parsing request: coupon requirement is invalid", "type", storedRequirements[i].Type, "err", err)
+			http.Error(w, "Invalid Coupon Requirement: "+err.Error(), http.StatusBadRequest)
+			return couponSettings{}, false
+		}
+	}
+	marshalledRequirements, err := json.Marshal(storedRequirements)
+	if err != nil {
+		slog.Error("error marshalling coupon requirements", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return couponSettings{}, false
+	}
+	paymentDiscount, err := discount.paymentDiscount(products)
+	if err != nil {
+		http.Error(w, "Invalid Coupon Discount: "+err.Error(), http.StatusBadRequest)
+		return couponSettings{}, false
+	}
+	marshalledDiscount, err := json.Marshal(discount)
+	if err != nil {
+		slog.Error("error marshalling coupon discount", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return couponSettings{}, false
+	}
+	return couponSettings{
+		MinPurchaseQuantity: minPurchaseQuantity,
+		EmailMatch:          email,
+		ValidFrom:           validFrom,
+		ValidUntil:          validUntil,
+		Requirements:        string(marshalledRequirements),
+		Discount:            string(marshalledDiscount),
+		PaymentDiscount:     paymentDiscount,
+	}, true
+}
+
+func (s *Server) SaveCoupon(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
 		return
 	}
-	switch discount.Type {
-	case "percentage":
-		discountPercentage = discount.Amount
-	default:
-		slog.Error("error parsing request: coupon discount is invalid", "type", discount.Type)
-		http.Error(w, "Invalid Coupon Discount", http.StatusBadRequest)
+	ctx := req.Context()
+	var coupon Coupon
+	if err := json.NewDecoder(req.Body).Decode(&coupon); err != nil {
+		slog.Error("error parsing request", "err", err)
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	redemptionLimit, ok := parseRedemptionLimit(coupon.RedemptionLimit)
+	if !ok {
+		http.Error(w, "Invalid Redemption Limit", http.StatusBadRequest)
+		return
+	}
+	customerRedemptionLimit, ok := parseRedemptionLimit(coupon.CustomerRedemptionLimit)
+	if !ok {
+		http.Error(w, "Invalid Customer Redemption Limit", http.StatusBadRequest)
 		return
 	}
 	couponEnabled := coupon.Enabled != nil && *coupon.Enabled
@@ -177,6 +294,10 @@ func (s *Server) SaveCoupon(w http.ResponseWriter, req *http.Request) {
 	if !ok {
 		return
 	}
+	settings, ok := s.parseCouponSettings(w, req, salePeriod, coupon.Requirements, coupon.Discount)
+	if !ok {
+		return
+	}
 	var stripeID string
 	if couponEnabled {
 		if coupon.StripeID != nil {
@@ -186,74 +307,141 @@ func (s *Server) SaveCoupon(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Invalid Body: Missing Stripe Desc", http.StatusBadRequest)
 			return
 		}
-		couponID, err := s.upsertStripeCoupon(stripeID, *coupon.StripeDesc, discountPercentage)
+		couponID, err := s.Payment.UpsertCoupon(stripeID, *coupon.StripeDesc, settings.PaymentDiscount)
 		if err != nil {
-			slog.Error("error upserting Stripe coupon", "err", err)
+			slog.Error("error upserting payment provider coupon", "err", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 		coupon.StripeID = &couponID
 		stripeID = couponID
+		s.couponNames.Store(couponID, *coupon.StripeDesc)
 	} else if coupon.StripeID != nil {
 		stripeID = *coupon.StripeID
 	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for coupon", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	var before any
 	var sqlErr error
 	switch coupon.ID {
 	case nil:
 		var newID int64
-		newID, sqlErr = s.Queries.CreateCoupon(ctx, db.CreateCouponParams{
-			StripeID:            stripeID,
-			CouponCode:          coupon.CouponCode,
-			MinPurchaseQuantity: minPurchaseQuantity,
-			EmailMatch:          email,
-			DiscountPercentage:  int64(discountPercentage),
-			Enabled:             couponEnabled,
-			Public:              couponPublic,
-			SalePeriod:          salePeriod,
+		newID, sqlErr = queries.CreateCoupon(ctx, db.CreateCouponParams{
+			StripeID:                stripeID,
+			CouponCode:              coupon.CouponCode,
+			MinPurchaseQuantity:     settings.MinPurchaseQuantity,
+			EmailMatch:              settings.EmailMatch,
+			Requirements:            settings.Requirements,
+			Discount:                settings.Discount,
+			Enabled:                 couponEnabled,
+			Public:                  couponPublic,
+			RedemptionLimit:         redemptionLimit,
+			CustomerRedemptionLimit: customerRedemptionLimit,
+			ValidFrom:               settings.ValidFrom,
+			ValidUntil:              settings.ValidUntil,
+			SalePeriod:              salePeriod,
 		})
 		coupon.ID = &newID
 	default:
-		sqlErr = s.Queries.UpdateCoupon(ctx, db.UpdateCouponParams{
-			CouponID:            *coupon.ID,
-			StripeID:            stripeID,
-			CouponCode:          coupon.CouponCode,
-			MinPurchaseQuantity: minPurchaseQuantity,
-			EmailMatch:          email,
-			DiscountPercentage:  int64(discountPercentage),
-			Enabled:             couponEnabled,
-			Public:              couponPublic,
-			SalePeriod:          salePeriod,
+		before, sqlErr = queries.CouponByID(ctx, *coupon.ID)
+		if sqlErr != nil {
+			break
+		}
+		var rows int64
+		rows, sqlErr = queries.UpdateCoupon(ctx, db.UpdateCouponParams{
+			CouponID:                *coupon.ID,
+			StripeID:                stripeID,
+			CouponCode:              coupon.CouponCode,
+			MinPurchaseQuantity:     settings.MinPurchaseQuantity,
+			EmailMatch:              settings.EmailMatch,
+			Requirements:            settings.Requirements,
+			Discount:                settings.Discount,
+			Enabled:                 couponEnabled,
+			Public:                  couponPublic,
+			RedemptionLimit:         redemptionLimit,
+			CustomerRedemptionLimit: customerRedemptionLimit,
+			ValidFrom:               settings.ValidFrom,
+			ValidUntil:              settings.ValidUntil,
+			SalePeriod:              salePeriod,
 		})
+		if sqlErr == nil && rows == 0 {
+			// The coupon is in another sale period.
+			sqlErr = sql.ErrNoRows
+		}
 	}
 	switch {
 	case errors.Is(sqlErr, sql.ErrNoRows):
-		http.Error(w, "Invalid Coupon ID", http.StatusBadRequest)
+		http.Error(w, "Invalid Coupon ID", http.StatusNotFound)
 		return
 	case sqlErr != nil:
 		slog.Error("error updating coupon", "err", sqlErr)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	after, err := queries.CouponByID(ctx, *coupon.ID)
+	if err != nil {
+		slog.Error("error fetching updated coupon", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	action := "update"
+	if before == nil {
+		action = "create"
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditCoupon, *coupon.ID, action, before, after); err != nil {
+		slog.Error("error recording coupon change", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting coupon", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	if err := json.NewEncoder(w).Encode(coupon); err != nil {
 		slog.Error("error writing update coupon response", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-var descCache = make(map[int64]*string)
+// parseRedemptionLimit converts a redemption limit from the request, which must
+// be positive if it is set.
+func parseRedemptionLimit(limit *int64) (sql.NullInt64, bool) {
+	if limit == nil {
+		return sql.NullInt64{}, true
+	}
+	if *limit <= 0 {
+		return sql.NullInt64{}, false
+	}
+	return sql.NullInt64{Int64: *limit, Valid: true}, true
+}
 
 func (s *Server) dbCouponToCoupon(dbCoupon db.Coupon, includeSensitiveFields bool) Coupon {
 	requirements := make([]json.RawMessage, 0)
-	if dbCoupon.MinPurchaseQuantity.Valid {
-		requirements = append(requirements, json.RawMessage(fmt.Sprintf(`{"type":"purchase_count","amount":%d}`, dbCoupon.MinPurchaseQuantity.Int64)))
+	parsedRequirements, err := couponRequirements(dbCoupon)
+	if err != nil {
+		slog.Error("error parsing coupon requirements", "coupon_id", dbCoupon.CouponID, "err", err)
 	}
-	if dbCoupon.EmailMatch.Valid {
-		requirements = append(requirements, json.RawMessage(fmt.Sprintf(`{"type":"email","value":"%s"}`, dbCoupon.EmailMatch.String)))
+	for _, req := range parsedRequirements {
+		marshalledReq, err := json.Marshal(req)
+		if err != nil {
+			slog.Error("error marshalling coupon requirement", "coupon_id", dbCoupon.CouponID, "err", err)
+			continue
+		}
+		requirements = append(requirements, marshalledReq)
 	}
 	coupon := Coupon{
 		Requirements: requirements,
 		CouponCode:   dbCoupon.CouponCode,
-		Discount:     json.RawMessage(fmt.Sprintf(`{"type":"percentage","amount":%d}`, dbCoupon.DiscountPercentage)),
+		Discount:     json.RawMessage(dbCoupon.Discount),
 	}
 	if includeSensitiveFields {
 		coupon.ID = &dbCoupon.CouponID
@@ -261,71 +449,27 @@ func (s *Server) dbCouponToCoupon(dbCoupon db.Coupon, includeSensitiveFields boo
 		coupon.Enabled = &dbCoupon.Enabled
 		coupon.Public = &dbCoupon.Public
 		coupon.SalePeriod = &dbCoupon.SalePeriod
-		if s.Stripe == nil || dbCoupon.StripeID == "" {
-			return coupon
+		if dbCoupon.RedemptionLimit.Valid {
+			coupon.RedemptionLimit = &dbCoupon.RedemptionLimit.Int64
 		}
-		if _, ok := descCache[dbCoupon.CouponID]; !ok {
-			stripeCoupon, err := s.Stripe.Coupons.Get(dbCoupon.StripeID, nil)
-			if err != nil {
-				slog.Warn("error fetching Stripe coupon", "stripe_id", dbCoupon.StripeID, "err", err)
-				return coupon
-			}
-			descCache[dbCoupon.CouponID] = &stripeCoupon.Name
+		if dbCoupon.CustomerRedemptionLimit.Valid {
+			coupon.CustomerRedemptionLimit = &dbCoupon.CustomerRedemptionLimit.Int64
 		}
-		coupon.StripeDesc = descCache[dbCoupon.CouponID]
-	}
-	return coupon
-}
-
-// upsertStripeCoupon attempts to update the given Stripe coupon but creates a
-// new one if it is not possible to do so.
-// It returns the Stripe coupon ID with the given attribute.
-func (s *Server) upsertStripeCoupon(couponID string, name string, discountPercentage int) (string, error) {
-	if s.Stripe == nil {
-		slog.Warn("not updating actual Stripe coupon %q: Stripe not configured", "coupon_id", couponID)
-		return "mock_stripe_coupon_" + randomOrderID(), nil
-	}
-	couponParam := &stripe.CouponParams{
-		Name:       &name,
-		PercentOff: stripe.Float64(float64(discountPercentage)),
-	}
-	tryUpdate := func() (ok bool) {
-		if couponID == "" {
-			return false
-		}
-		coupon, err := s.Stripe.Coupons.Get(couponID, nil)
-		if err != nil {
-			slog.Warn("error fetching Stripe coupon, falling back to creating new coupon", "old_coupon_id", couponID, "err", err)
-			return false
-		}
-		if int(coupon.PercentOff) != discountPercentage {
-			slog.Debug(
-				"falling back to creating new coupon, discount percentage changed",
-				"old_coupon_id", couponID,
-				"old_percentage", coupon.PercentOff,
-				"new_percentage", discountPercentage,
-			)
-			return false
+		if dbCoupon.StripeID == "" {
+			return coupon
 		}
-		if coupon.Name == name {
-			slog.Debug("skipping update, name is equal", "coupon_id", couponID)
-			return true
+		if cached, ok := s.couponNames.Load(dbCoupon.StripeID); ok {
+			name := cached.(string)
+			coupon.StripeDesc = &name
+			return coupon
 		}
-		_, err = s.Stripe.Coupons.Update(couponID, &stripe.CouponParams{
-			Name: &name,
-		})
+		name, err := s.Payment.CouponName(dbCoupon.StripeID)
 		if err != nil {
-			slog.Warn("error updating coupon, falling back to creating new coupon", "old_coupon_id", couponID, "err", err)
-			return false
+			slog.Warn("error fetching payment provider coupon", "stripe_id", dbCoupon.StripeID, "err", err)
+			return coupon
 		}
-		return true
-	}
-	if tryUpdate() {
-		return couponID, nil
+		s.couponNames.Store(dbCoupon.StripeID, name)
+		coupon.StripeDesc = &name
 	}
-	coupon, err := s.Stripe.Coupons.New(couponParam)
-	if err != nil {
-		return "", fmt.Errorf("error creating Stripe coupon (%q): %w", name, err)
-	}
-	return coupon.ID, nil
+	return coupon
 }
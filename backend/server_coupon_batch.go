@@ -0,0 +1,374 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+const (
+	// couponBatchMaxCount is the most codes that can be generated in a batch.
+	couponBatchMaxCount = 1000
+	// couponBatchCodeLength is the number of random characters in each code.
+	couponBatchCodeLength = 8
+)
+
+var couponBatchPrefixRegex = regexp.MustCompile(`^[A-Z0-9-]{0,16}$`)
+
+type CouponBatchRequest struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// Prefix is put in front of every generated code.
+	Prefix       string            `json:"prefix"`
+	Requirements []json.RawMessage `json:"requirements"`
+	Discount     json.RawMessage   `json:"discount"`
+	StripeDesc   string            `json:"stripe_desc"`
+}
+
+type CouponBatchesResponse struct {
+	Batches []CouponBatch `json:"batches"`
+}
+
+type CouponBatch struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	StripeID    string     `json:"stripe_id"`
+	CreateTime  time.Time  `json:"create_time"`
+	RevokeTime  *time.Time `json:"revoke_time,omitempty"`
+	CouponCount int64      `json:"coupon_count"`
+	// Redemptions is the number of paid orders using codes in the batch.
+	Redemptions int64 `json:"redemptions"`
+	// Codes are only returned when the batch is created. They can be
+	// downloaded as CSV afterwards.
+	Codes []string `json:"codes,omitempty"`
+}
+
+type auditedCouponBatch struct {
+	db.CouponBatch
+	CouponCount int
+}
+
+// CouponBatches lists the batches of coupon codes in the sale period.
+func (s *Server) CouponBatches(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	salePeriod, ok := s.resolveSalePeriod(w, req, req.PathValue("sale_id"))
+	if !ok {
+		return
+	}
+	rows, err := s.Queries.ListCouponBatches(req.Context(), salePeriod)
+	if err != nil {
+		slog.Error("error fetching coupon batches", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	batches := make([]CouponBatch, 0, len(rows))
+	for _, row := range rows {
+		batch := CouponBatch{
+			ID:          row.ID,
+			Name:        row.Name,
+			StripeID:    row.StripeID,
+			CreateTime:  row.CreateTime,
+			CouponCount: row.CouponCount,
+			Redemptions: row.Redemptions,
+		}
+		if row.RevokeTime.Valid {
+			batch.RevokeTime = &row.RevokeTime.Time
+		}
+		batches = append(batches, batch)
+	}
+	if err := json.NewEncoder(w).Encode(CouponBatchesResponse{
+		Batches: batches,
+	}); err != nil {
+		slog.Error("error writing coupon batches response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// CreateCouponBatch generates a batch of random single-use coupon codes that
+// share a payment provider coupon.
+func (s *Server) CreateCouponBatch(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	ctx := req.Context()
+	var batchReq CouponBatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batchReq); err != nil {
+		slog.Error("error parsing request", "err", err)
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	if batchReq.Name == "" {
+		http.Error(w, "Invalid Body: Missing Name", http.StatusBadRequest)
+		return
+	}
+	if batchReq.StripeDesc == "" {
+		http.Error(w, "Invalid Body: Missing Stripe Desc", http.StatusBadRequest)
+		return
+	}
+	if batchReq.Count <= 0 || batchReq.Count > couponBatchMaxCount {
+		http.Error(w, fmt.Sprintf("Count must be between 1 and %d", couponBatchMaxCount), http.StatusBadRequest)
+		return
+	}
+	prefix := strings.ToUpper(batchReq.Prefix)
+	if !couponBatchPrefixRegex.MatchString(prefix) {
+		http.Error(w, "Invalid Prefix", http.StatusBadRequest)
+		return
+	}
+	salePeriod, ok := s.resolveSalePeriod(w, req, req.PathValue("sale_id"))
+	if !ok {
+		return
+	}
+	settings, ok := s.parseCouponSettings(w, req, salePeriod, batchReq.Requirements, batchReq.Discount)
+	if !ok {
+		return
+	}
+	stripeID, err := s.Payment.UpsertCoupon("", batchReq.StripeDesc, settings.PaymentDiscount)
+	if err != nil {
+		slog.Error("error creating payment provider coupon", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.couponNames.Store(stripeID, batchReq.StripeDesc)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	existingCodes, err := queries.ListCouponCodes(ctx, salePeriod)
+	if err != nil {
+		slog.Error("error fetching coupon codes", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// Codes are looked up case-insensitively.
+	usedCodes := make(map[string]bool, len(existingCodes)+batchReq.Count)
+	for _, code := range existingCodes {
+		usedCodes[strings.ToUpper(code)] = true
+	}
+	batchID, err := queries.CreateCouponBatch(ctx, db.CreateCouponBatchParams{
+		Name:       batchReq.Name,
+		StripeID:   stripeID,
+		SalePeriod: salePeriod,
+		CreateTime: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Error("error creating coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	codes := make([]string, 0, batchReq.Count)
+	for range batchReq.Count {
+		code := prefix + randomCouponCode()
+		for usedCodes[code] {
+			code = prefix + randomCouponCode()
+		}
+		usedCodes[code] = true
+		if _, err := queries.CreateCoupon(ctx, db.CreateCouponParams{
+			StripeID:            stripeID,
+			CouponCode:          code,
+			MinPurchaseQuantity: settings.MinPurchaseQuantity,
+			EmailMatch:          settings.EmailMatch,
+			Requirements:        settings.Requirements,
+			Discount:            settings.Discount,
+			Enabled:             true,
+			Public:              false,
+			RedemptionLimit:     sql.NullInt64{Int64: 1, Valid: true},
+			ValidFrom:           settings.ValidFrom,
+			ValidUntil:          settings.ValidUntil,
+			SalePeriod:          salePeriod,
+			BatchID:             sql.NullInt64{Int64: batchID, Valid: true},
+		}); err != nil {
+			slog.Error("error creating batch coupon", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		codes = append(codes, code)
+	}
+	batch, err := queries.CouponBatchByID(ctx, db.CouponBatchByIDParams{
+		ID:         batchID,
+		SalePeriod: salePeriod,
+	})
+	if err != nil {
+		slog.Error("error fetching created coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditCouponBatch, batchID, "create", nil, auditedCouponBatch{
+		CouponBatch: batch,
+		CouponCount: len(codes),
+	}); err != nil {
+		slog.Error("error recording coupon batch creation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(CouponBatch{
+		ID:          batch.ID,
+		Name:        batch.Name,
+		StripeID:    batch.StripeID,
+		CreateTime:  batch.CreateTime,
+		CouponCount: int64(len(codes)),
+		Codes:       codes,
+	}); err != nil {
+		slog.Error("error writing coupon batch response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// CouponBatchExport downloads the codes in the batch as CSV.
+func (s *Server) CouponBatchExport(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	batch, ok := s.resolveCouponBatch(w, req, s.Queries)
+	if !ok {
+		return
+	}
+	rows, err := s.Queries.ListCouponBatchCodes(req.Context(), sql.NullInt64{Int64: batch.ID, Valid: true})
+	if err != nil {
+		slog.Error("error fetching coupon batch codes", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="coupons-%d.csv"`, batch.ID))
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"Coupon Code", "Enabled", "Redemptions"}); err != nil {
+		slog.Error("error writing coupon batch export", "err", err)
+		return
+	}
+	for _, row := range rows {
+		if err := writer.Write([]string{
+			row.CouponCode,
+			strconv.FormatBool(row.Enabled),
+			strconv.FormatInt(row.Redemptions, 10),
+		}); err != nil {
+			slog.Error("error writing coupon batch export", "err", err)
+			return
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		slog.Error("error writing coupon batch export", "err", err)
+	}
+}
+
+// RevokeCouponBatch disables every code in the batch.
+func (s *Server) RevokeCouponBatch(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	ctx := req.Context()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for coupon batch revocation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, ok := s.resolveCouponBatch(w, req, queries)
+	if !ok {
+		return
+	}
+	if before.RevokeTime.Valid {
+		http.Error(w, "Coupon batch has already been revoked", http.StatusBadRequest)
+		return
+	}
+	if err := queries.RevokeCouponBatch(ctx, db.RevokeCouponBatchParams{
+		RevokeTime: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+		ID:         before.ID,
+	}); err != nil {
+		slog.Error("error revoking coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := queries.DisableCouponBatch(ctx, sql.NullInt64{Int64: before.ID, Valid: true}); err != nil {
+		slog.Error("error disabling batch coupons", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	after, err := queries.CouponBatchByID(ctx, db.CouponBatchByIDParams{
+		ID:         before.ID,
+		SalePeriod: before.SalePeriod,
+	})
+	if err != nil {
+		slog.Error("error fetching revoked coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditCouponBatch, before.ID, "revoke", before, after); err != nil {
+		slog.Error("error recording coupon batch revocation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting coupon batch revocation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveCouponBatch looks up the batch in the path of the request, writing an
+// error to the response if it is not in the sale period.
+func (s *Server) resolveCouponBatch(w http.ResponseWriter, req *http.Request, queries *db.Queries) (db.CouponBatch, bool) {
+	salePeriod, ok := s.resolveSalePeriod(w, req, req.PathValue("sale_id"))
+	if !ok {
+		return db.CouponBatch{}, false
+	}
+	batchID, err := strconv.ParseInt(req.PathValue("batch_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return db.CouponBatch{}, false
+	}
+	batch, err := queries.CouponBatchByID(req.Context(), db.CouponBatchByIDParams{
+		ID:         batchID,
+		SalePeriod: salePeriod,
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Invalid batch ID", http.StatusNotFound)
+		return db.CouponBatch{}, false
+	case err != nil:
+		slog.Error("error fetching coupon batch", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return db.CouponBatch{}, false
+	}
+	return batch, true
+}
+
+// randomCouponCode returns random characters for a batch coupon code, using
+// the same alphabet as order IDs so that they cannot be misread.
+func randomCouponCode() string {
+	code := make([]byte, couponBatchCodeLength)
+	for i := range code {
+		code[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(code)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCreateCouponBatch(t *testing.T) {
+	batch := func(count int, prefix string) CouponBatchRequest {
+		return CouponBatchRequest{
+			Name:       "Giveaway",
+			Count:      count,
+			Prefix:     prefix,
+			Discount:   []byte(`{"type":"percentage","amount":100}`),
+			StripeDesc: "Giveaway",
+		}
+	}
+	withoutName := batch(5, "")
+	withoutName.Name = ""
+	withoutDesc := batch(5, "")
+	withoutDesc.StripeDesc = ""
+	invalidDiscount := batch(5, "")
+	invalidDiscount.Discount = []byte(`{"type":"percentage","amount":120}`)
+
+	tests := []struct {
+		name string
+		body any
+		code int
+		want string
+	}{
+		{"batch", batch(50, ""), http.StatusOK, `"coupon_count":50`},
+		{"prefix", batch(5, "gift-"), http.StatusOK, `"GIFT-`},
+		{"not json", "not json", http.StatusBadRequest, "Invalid Body"},
+		{"missing name", withoutName, http.StatusBadRequest, "Missing Name"},
+		{"missing stripe desc", withoutDesc, http.StatusBadRequest, "Missing Stripe Desc"},
+		{"zero count", batch(0, ""), http.StatusBadRequest, "Count must be between 1 and 1000"},
+		{"too many", batch(1001, ""), http.StatusBadRequest, "Count must be between 1 and 1000"},
+		{"invalid prefix", batch(5, "GIFT "), http.StatusBadRequest, "Invalid Prefix"},
+		{"invalid discount", invalidDiscount, http.StatusBadRequest, "percentage must be between 1 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			rec := ts.request("POST", "/api/v0/sales/1/coupons/batch", tt.body, true)
+			expectResponse(t, rec, tt.code, tt.want)
+		})
+	}
+}
+
+func TestCouponBatch(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testSticker)
+	ts.createCoupon("TEN", 10)
+	var created CouponBatch
+	ts.requestOK("POST", "/api/v0/sales/1/coupons/batch", CouponBatchRequest{
+		Name:       "Giveaway",
+		Count:      20,
+		Discount:   []byte(`{"type":"percentage","amount":100}`),
+		StripeDesc: "Giveaway",
+	}, true, &created)
+	if len(created.Codes) != 20 {
+		t.Fatalf("got %d codes, want 20", len(created.Codes))
+	}
+	seen := make(map[string]bool)
+	for _, code := range created.Codes {
+		if len(code) != couponBatchCodeLength || strings.Trim(code, alphabet) != "" {
+			t.Errorf("got code %q, want %d characters from %q", code, couponBatchCodeLength, alphabet)
+		}
+		if seen[code] {
+			t.Errorf("got code %q twice, want unique codes", code)
+		}
+		seen[code] = true
+	}
+	ts.payment.mu.Lock()
+	stripeCoupons := len(ts.payment.coupons)
+	ts.payment.mu.Unlock()
+	if stripeCoupons != 2 {
+		t.Errorf("got %d payment provider coupons, want the batch to share 1", stripeCoupons-1)
+	}
+
+	// Batch codes are kept out of the coupon list.
+	var coupons CouponsResponse
+	ts.requestOK("GET", "/api/v0/sales/1/coupons?include_disabled=1", nil, true, &coupons)
+	if len(coupons.Coupons) != 1 || coupons.Coupons[0].CouponCode != "TEN" {
+		t.Errorf("got coupons %+v, want only TEN", coupons.Coupons)
+	}
+
+	// Each code can only be redeemed once.
+	req := testCheckoutRequest(CartItem{ID: "1", Variant: []CartItemVariant{}, Amount: 1})
+	req.Coupon = ptr(strings.ToLower(created.Codes[0]))
+	ts.paidOrder(req)
+	other := req
+	other.MatricNumber = "U3456789B"
+	other.Email = "other@e.ntu.edu.sg"
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", other, false), http.StatusBadRequest, "Coupon has been fully redeemed")
+	// Codes share a payment provider coupon, so later codes have to keep their
+	// own redemptions.
+	other.Coupon = ptr(created.Codes[1])
+	ts.paidOrder(other)
+	third := other
+	third.MatricNumber = "U4567890C"
+	third.Email = "third@e.ntu.edu.sg"
+	third.Coupon = ptr(created.Codes[1])
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", third, false), http.StatusBadRequest, "Coupon has been fully redeemed")
+	third.Coupon = ptr(created.Codes[3])
+	ts.checkout(third)
+
+	var batches CouponBatchesResponse
+	ts.requestOK("GET", "/api/v0/sales/1/coupon_batches", nil, true, &batches)
+	if len(batches.Batches) != 1 {
+		t.Fatalf("got %d batches, want 1", len(batches.Batches))
+	}
+	got := batches.Batches[0]
+	if got.ID != created.ID || got.StripeID != created.StripeID || got.CouponCount != 20 || got.Redemptions != 2 || got.RevokeTime != nil || got.Codes != nil {
+		t.Errorf("got batch %+v, want 20 codes with 2 redemptions", got)
+	}
+
+	exportTarget := "/api/v0/sales/1/coupon_batches/" + strconv.FormatInt(created.ID, 10)
+	rec := ts.request("GET", exportTarget, nil, true)
+	expectResponse(t, rec, http.StatusOK, "")
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="coupons-1.csv"` {
+		t.Errorf("got Content-Disposition %q, want coupons-1.csv", got)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading CSV: %v", err)
+	}
+	if len(records) != 21 || strings.Join(records[0], ",") != "Coupon Code,Enabled,Redemptions" {
+		t.Fatalf("got CSV %v, want a header and 20 codes", records)
+	}
+	if strings.Join(records[1], ",") != created.Codes[0]+",true,1" || strings.Join(records[2], ",") != created.Codes[1]+",true,1" || strings.Join(records[4], ",") != created.Codes[3]+",true,0" {
+		t.Errorf("got rows %v, want only paid redemptions counted", records[1:5])
+	}
+
+	revokeTarget := "/api/v0/sales/1/coupons/batch/" + strconv.FormatInt(created.ID, 10)
+	expectResponse(t, ts.request("DELETE", revokeTarget, nil, true), http.StatusNoContent, "")
+	expectResponse(t, ts.request("DELETE", revokeTarget, nil, true), http.StatusBadRequest, "Coupon batch has already been revoked")
+	other.Coupon = ptr(created.Codes[2])
+	expectResponse(t, ts.request("POST", "/api/v0/checkout", other, false), http.StatusBadRequest, "Invalid coupon code")
+	ts.requestOK("GET", "/api/v0/sales/1/coupon_batches", nil, true, &batches)
+	if len(batches.Batches) != 1 || batches.Batches[0].RevokeTime == nil {
+		t.Errorf("got batches %+v, want the batch revoked", batches.Batches)
+	}
+	rec = ts.request("GET", exportTarget, nil, true)
+	expectResponse(t, rec, http.StatusOK, created.Codes[2]+",false,0")
+
+	var entries AuditLogResponse
+	ts.requestOK("GET", "/api/v0/audit_log?entity_type=coupon_batch", nil, true, &entries)
+	if len(entries.Entries) != 2 {
+		t.Errorf("got %d audit log entries, want creation and revocation", len(entries.Entries))
+	}
+}
+
+func TestCouponBatchNotFound(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		method string
+		target string
+		code   int
+		want   string
+	}{
+		{"GET", "/api/v0/sales/1/coupon_batches/1", http.StatusNotFound, "Invalid batch ID"},
+		{"DELETE", "/api/v0/sales/1/coupons/batch/1", http.StatusNotFound, "Invalid batch ID"},
+		{"GET", "/api/v0/sales/1/coupon_batches/abc", http.StatusBadRequest, "Invalid batch ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			expectResponse(t, ts.request(tt.method, tt.target, nil, true), tt.code, tt.want)
+		})
+	}
+}
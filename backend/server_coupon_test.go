@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSaveCoupon(t *testing.T) {
+	coupon := func(discount string, requirements ...string) Coupon {
+		c := Coupon{
+			Requirements: []json.RawMessage{},
+			CouponCode:   "TEN",
+			Discount:     json.RawMessage(discount),
+			Enabled:      ptr(true),
+			Public:       ptr(true),
+			StripeDesc:   ptr("10% off"),
+		}
+		for _, v := range requirements {
+			c.Requirements = append(c.Requirements, json.RawMessage(v))
+		}
+		return c
+	}
+	percentage := `{"type":"percentage","amount":10}`
+	withoutDesc := coupon(percentage)
+	withoutDesc.StripeDesc = nil
+	disabledWithoutDesc := withoutDesc
+	disabledWithoutDesc.Enabled = ptr(false)
+	limited := coupon(percentage)
+	limited.RedemptionLimit = ptr(int64(100))
+	limited.CustomerRedemptionLimit = ptr(int64(1))
+	zeroLimit := coupon(percentage)
+	zeroLimit.RedemptionLimit = ptr(int64(0))
+	negativeCustomerLimit := coupon(percentage)
+	negativeCustomerLimit.CustomerRedemptionLimit = ptr(int64(-1))
+
+	tests := []struct {
+		name         string
+		body         any
+		code         int
+		want         string
+		wantStripeID bool
+	}{
+		{"coupon", coupon(percentage), http.StatusOK, `"couponCode":"TEN"`, true},
+		{"requirements", coupon(percentage, `{"type":"purchase_count","amount":2}`, `{"type":"email","value":"tanahkow@e.ntu.edu.sg"}`), http.StatusOK, `"couponCode":"TEN"`, true},
+		{"disabled without stripe desc", disabledWithoutDesc, http.StatusOK, `"couponCode":"TEN"`, false},
+		{"not json", "not json", http.StatusBadRequest, "Invalid Body", false},
+		{"unknown requirement", coupon(percentage, `{"type":"birthday"}`), http.StatusBadRequest, "Invalid Coupon Requirement", false},
+		{"multiple emails", coupon(percentage, `{"type":"email","value":"a@e.ntu.edu.sg"}`, `{"type":"email","value":"b@e.ntu.edu.sg"}`), http.StatusBadRequest, "Invalid Body", false},
+		{"fixed", coupon(`{"type":"fixed","amount":500}`), http.StatusOK, `"amount":500`, true},
+		{"unknown discount", coupon(`{"type":"free_shipping"}`), http.StatusBadRequest, "Invalid Coupon Discount", false},
+		{"invalid percentage", coupon(`{"type":"percentage","amount":120}`), http.StatusBadRequest, "percentage must be between 1 and 100", false},
+		{"unknown product", coupon(`{"type":"percentage","amount":10,"products":["5"]}`), http.StatusBadRequest, `unknown product "5"`, false},
+		{"redemption limits", limited, http.StatusOK, `"redemption_limit":100,"customer_redemption_limit":1`, true},
+		{"zero redemption limit", zeroLimit, http.StatusBadRequest, "Invalid Redemption Limit", false},
+		{"negative customer redemption limit", negativeCustomerLimit, http.StatusBadRequest, "Invalid Customer Redemption Limit", false},
+		{"valid time", coupon(percentage, `{"type":"valid_time","from":"2025-07-01T08:00:00+08:00"}`), http.StatusOK, `"type":"valid_time"`, true},
+		{"valid time without times", coupon(percentage, `{"type":"valid_time"}`), http.StatusBadRequest, "Missing Valid Time", false},
+		{"valid time ends before start", coupon(percentage, `{"type":"valid_time","from":"2025-07-02T00:00:00Z","until":"2025-07-01T00:00:00Z"}`), http.StatusBadRequest, "Valid Until must be after Valid From", false},
+		{"stored requirements", coupon(percentage, `{"type":"email_domain","values":["@E.NTU.EDU.SG"]}`, `{"type":"min_subtotal","amount":2000}`), http.StatusOK, `"couponCode":"TEN"`, true},
+		{"invalid stored requirement", coupon(percentage, `{"type":"email_regex","value":"("}`), http.StatusBadRequest, "Invalid Coupon Requirement: invalid email regex", false},
+		{"enabled without stripe desc", withoutDesc, http.StatusBadRequest, "Missing Stripe Desc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			rec := ts.request("POST", "/api/v0/sales/1/coupons", tt.body, true)
+			expectResponse(t, rec, tt.code, tt.want)
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp Coupon
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if resp.ID == nil {
+				t.Errorf("got no coupon ID, want the new ID")
+			}
+			if hasStripeID := resp.StripeID != nil && *resp.StripeID != ""; hasStripeID != tt.wantStripeID {
+				t.Errorf("got stripe ID %v, want it to be set only for enabled coupons", resp.StripeID)
+			}
+		})
+	}
+}
+
+func TestSaveCouponProducts(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.createProduct(testSticker)
+	discount := `{"type":"buy_x_get_y","buy":2,"free":1,"products":["1","2"]}`
+	coupon := ts.createDiscountCoupon("DEAL", discount)
+	if string(coupon.Discount) != discount {
+		t.Errorf("got discount %s, want %s", coupon.Discount, discount)
+	}
+	ts.payment.mu.Lock()
+	got := ts.payment.coupons[*coupon.StripeID].discount
+	ts.payment.mu.Unlock()
+	want := PaymentDiscount{
+		PercentOff: 100,
+		Products: []PaymentProduct{
+			{ID: "1", Name: "Shirt", ImageURL: "shirt.png"},
+			{ID: "2", Name: "Sticker"},
+		},
+	}
+	if got.PercentOff != want.PercentOff || got.AmountOff != want.AmountOff || !slices.Equal(got.Products, want.Products) {
+		t.Errorf("got payment provider discount %+v, want %+v", got, want)
+	}
+	var resp CouponsResponse
+	ts.requestOK("GET", "/api/v0/sales/1/coupons?include_disabled=1", nil, true, &resp)
+	if len(resp.Coupons) != 1 || string(resp.Coupons[0].Discount) != discount {
+		t.Errorf("got coupons %+v, want the discount stored", resp.Coupons)
+	}
+}
+
+func TestCoupons(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createCoupon("TEN", 10, `{"type":"purchase_count","amount":2}`)
+	hidden := ts.createCoupon("HIDDEN", 20)
+	hidden.Public = ptr(false)
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", hidden, true, nil)
+
+	tests := []struct {
+		name      string
+		target    string
+		admin     bool
+		wantCodes []string
+	}{
+		{"public", "/api/v0/sales/current/coupons", false, []string{"TEN"}},
+		{"include disabled", "/api/v0/sales/1/coupons?include_disabled=1", true, []string{"TEN", "HIDDEN"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp CouponsResponse
+			ts.requestOK("GET", tt.target, nil, tt.admin, &resp)
+			if len(resp.Coupons) != len(tt.wantCodes) {
+				t.Fatalf("got %d coupons, want %v", len(resp.Coupons), tt.wantCodes)
+			}
+			for i, coupon := range resp.Coupons {
+				if coupon.CouponCode != tt.wantCodes[i] {
+					t.Errorf("got coupon %q, want %q", coupon.CouponCode, tt.wantCodes[i])
+				}
+				if (coupon.ID != nil) != tt.admin {
+					t.Errorf("got coupon ID %v, want it to be only shown to admins", coupon.ID)
+				}
+			}
+			if string(resp.Coupons[0].Discount) != `{"type":"percentage","amount":10}` {
+				t.Errorf("got discount %s, want 10%%", resp.Coupons[0].Discount)
+			}
+			if len(resp.Coupons[0].Requirements) != 1 || string(resp.Coupons[0].Requirements[0]) != `{"type":"purchase_count","amount":2}` {
+				t.Errorf("got requirements %s, want a purchase count of 2", resp.Coupons[0].Requirements)
+			}
+		})
+	}
+}
+
+func TestCouponValidTime(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.createCoupon("EXPIRED", 10, `{"type":"valid_time","until":"2020-01-01T00:00:00Z"}`)
+	ts.createCoupon("UPCOMING", 10, `{"type":"valid_time","from":"2999-01-01T00:00:00Z"}`)
+	ts.createCoupon("ACTIVE", 10, `{"type":"valid_time","from":"2020-01-01T08:00:00+08:00","until":"2999-01-01T00:00:00Z"}`)
+
+	var resp CouponsResponse
+	ts.requestOK("GET", "/api/v0/sales/current/coupons", nil, false, &resp)
+	if len(resp.Coupons) != 1 || resp.Coupons[0].CouponCode != "ACTIVE" {
+		t.Fatalf("got coupons %+v, want only ACTIVE", resp.Coupons)
+	}
+	want := `{"type":"valid_time","from":"2020-01-01T00:00:00Z","until":"2999-01-01T00:00:00Z"}`
+	if len(resp.Coupons[0].Requirements) != 1 || string(resp.Coupons[0].Requirements[0]) != want {
+		t.Errorf("got requirements %s, want %s", resp.Coupons[0].Requirements, want)
+	}
+	ts.requestOK("GET", "/api/v0/sales/1/coupons?include_disabled=1", nil, true, &resp)
+	if len(resp.Coupons) != 3 {
+		t.Errorf("got %d coupons, want admins to see all 3", len(resp.Coupons))
+	}
+
+	req := testCheckoutRequest(shirtItem("1", "S", 1))
+	for _, code := range []string{"EXPIRED", "UPCOMING"} {
+		req.Coupon = ptr(code)
+		expectResponse(t, ts.request("POST", "/api/v0/checkout", req, false), http.StatusBadRequest, "Invalid coupon code")
+	}
+	req.Coupon = ptr("ACTIVE")
+	ts.checkout(req)
+}
+
+func TestCouponValidTimeZones(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	now := time.Now()
+	east := time.FixedZone("UTC+8", 8*60*60)
+	west := time.FixedZone("UTC-8", -8*60*60)
+	validTime := func(field string, at time.Time) string {
+		return `{"type":"valid_time","` + field + `":"` + at.Format(time.RFC3339Nano) + `"}`
+	}
+	// The offsets put the local times on the other side of the boundary, so
+	// comparing them as text would get these wrong.
+	tests := []struct {
+		code  string
+		req   string
+		valid bool
+	}{
+		{"STARTED", validTime("from", now.Add(-time.Minute).In(east)), true},
+		{"STARTING", validTime("from", now.Add(time.Minute).In(west)), false},
+		{"ENDING", validTime("until", now.Add(time.Minute).In(west)), true},
+		{"ENDED", validTime("until", now.Add(-time.Minute).In(east)), false},
+	}
+	for _, tt := range tests {
+		ts.createCoupon(tt.code, 10, tt.req)
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			rec := ts.request("GET", "/api/v0/sales/current/coupons/"+tt.code, nil, false)
+			req := testCheckoutRequest(shirtItem("1", "S", 1))
+			req.Coupon = ptr(tt.code)
+			if tt.valid {
+				expectResponse(t, rec, http.StatusOK, `"couponCode":"`+tt.code+`"`)
+				ts.checkout(req)
+				return
+			}
+			expectResponse(t, rec, http.StatusNotFound, "Invalid coupon ID")
+			expectResponse(t, ts.request("POST", "/api/v0/checkout", req, false), http.StatusBadRequest, "Invalid coupon code")
+		})
+	}
+}
+
+func TestCouponLookup(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createCoupon("TEN", 10)
+	ts.createCoupon("batch", 10)
+	ts.createCoupon("EXPIRED", 10, `{"type":"valid_time","until":"2020-01-01T00:00:00Z"}`)
+	ts.createCoupon("UPCOMING", 10, `{"type":"valid_time","from":"2999-01-01T00:00:00Z"}`)
+	hidden := ts.createCoupon("HIDDEN", 20)
+	hidden.Public = ptr(false)
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", hidden, true, nil)
+	disabled := ts.createCoupon("DISABLED", 30)
+	disabled.Enabled = ptr(false)
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", disabled, true, nil)
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		want   string
+	}{
+		{"public", "/api/v0/sales/current/coupons/TEN", http.StatusOK, `"couponCode":"TEN"`},
+		{"named like a route", "/api/v0/sales/current/coupons/batch", http.StatusOK, `"couponCode":"batch"`},
+		{"not public", "/api/v0/sales/current/coupons/HIDDEN", http.StatusOK, `"couponCode":"HIDDEN"`},
+		{"disabled", "/api/v0/sales/current/coupons/DISABLED", http.StatusNotFound, "Invalid coupon ID"},
+		{"expired", "/api/v0/sales/current/coupons/EXPIRED", http.StatusNotFound, "Invalid coupon ID"},
+		{"upcoming", "/api/v0/sales/current/coupons/UPCOMING", http.StatusNotFound, "Invalid coupon ID"},
+		{"unknown", "/api/v0/sales/current/coupons/UNKNOWN", http.StatusNotFound, "Invalid coupon ID"},
+		{"other sale period requires auth", "/api/v0/sales/1/coupons/TEN", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectResponse(t, ts.request("GET", tt.target, nil, false), tt.code, tt.want)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+//go:embed templates/email
+var emailTemplateFS embed.FS
+
+var (
+	textEmailTemplates = template.Must(template.ParseFS(emailTemplateFS, "templates/email/*.txt"))
+	htmlEmailTemplates = htmltemplate.Must(htmltemplate.ParseFS(emailTemplateFS, "templates/email/*.html"))
+)
+
+type orderEmailKind string
+
+const (
+	orderEmailConfirmation orderEmailKind = "order_confirmation"
+	orderEmailCollected    orderEmailKind = "order_collected"
+	orderEmailCancelled    orderEmailKind = "order_cancelled"
+	// orderEmailPaidCancelled tells buyers that their payment arrived after
+	// the order was cancelled and has to be refunded.
+	orderEmailPaidCancelled orderEmailKind = "order_paid_cancelled"
+)
+
+// storeLocation is the time zone that times are shown in to users.
+var storeLocation = time.FixedZone("SGT", 8*60*60)
+
+const (
+	// outboxInterval is how often the outbox is checked for emails to send.
+	outboxInterval = 10 * time.Second
+	// outboxBatchSize is the maximum number of emails sent per check.
+	outboxBatchSize = 50
+	// outboxMaxAttempts is the number of times sending an email is attempted
+	// before giving up on it.
+	outboxMaxAttempts = 10
+	// outboxMaxBackoff is the maximum time between attempts to send an email.
+	outboxMaxBackoff = time.Hour
+)
+
+type orderEmailData struct {
+	OrderID    string
+	Name       string
+	Items      []orderEmailItem
+	CouponCode string
+	OrderURL   string
+	// Time is when the order was collected.
+	Time string
+	// Reason and RefundAmount are provided for cancellations.
+	Reason       string
+	RefundAmount string
+}
+
+type orderEmailItem struct {
+	Name      string
+	Variant   string
+	Amount    int64
+	UnitPrice string
+}
+
+// enqueueOrderEmail renders the email about the order and adds it to the
+// outbox. It should be called in the same transaction as the change that the
+// email is about so that the email is sent if and only if the change is
+// committed.
+func (s *Server) enqueueOrderEmail(ctx context.Context, queries *db.Queries, kind orderEmailKind, orderID string, data orderEmailData) error {
+	order, err := queries.OrderByID(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("error looking up order: %w", err)
+	}
+	items, err := queries.ListOrderItems(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("error looking up order items: %w", err)
+	}
+	if order.CouponID.Valid {
+		coupon, err := queries.CouponByID(ctx, order.CouponID.Int64)
+		if err != nil {
+			return fmt.Errorf("error looking up coupon: %w", err)
+		}
+		data.CouponCode = coupon.CouponCode
+	}
+	data.OrderID = order.OrderID
+	data.Name = order.Name
+	data.OrderURL = s.Config.FrontendURL + "/orders/" + order.OrderID
+	for _, item := range items {
+		if item.RefundedAmount >= item.Amount {
+			continue
+		}
+		data.Items = append(data.Items, orderEmailItem{
+			Name:      item.ProductName,
+			Variant:   item.Variant,
+			Amount:    item.Amount - item.RefundedAmount,
+			UnitPrice: formatPrice(item.UnitPrice),
+		})
+	}
+	email, err := renderEmail(string(kind), order.Email, data)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := queries.CreateOutboxEmail(ctx, db.CreateOutboxEmailParams{
+		OrderID:         order.OrderID,
+		Kind:            string(kind),
+		Recipient:       email.To,
+		Subject:         email.Subject,
+		TextBody:        email.Text,
+		HtmlBody:        email.HTML,
+		CreateTime:      now,
+		NextAttemptTime: now,
+	}); err != nil {
+		return fmt.Errorf("error adding email to outbox: %w", err)
+	}
+	return nil
+}
+
+// renderEmail renders the email from the templates in templates/email with
+// the given name.
+func renderEmail(name string, to string, data any) (Email, error) {
+	var subject, text, html bytes.Buffer
+	if err := textEmailTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return Email{}, fmt.Errorf("error rendering email: %w", err)
+	}
+	if err := textEmailTemplates.ExecuteTemplate(&subject, name+"_subject", data); err != nil {
+		return Email{}, fmt.Errorf("error rendering email subject: %w", err)
+	}
+	if err := htmlEmailTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return Email{}, fmt.Errorf("error rendering email: %w", err)
+	}
+	return Email{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// RunOutbox sends the emails in the outbox until the context is cancelled.
+func (s *Server) RunOutbox(ctx context.Context) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sendOutbox(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("error sending emails in outbox", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendOutbox attempts to send the emails in the outbox that are due. Emails
+// that cannot be sent are retried with exponential backoff.
+func (s *Server) sendOutbox(ctx context.Context) error {
+	emails, err := s.Queries.PendingOutboxEmails(ctx, db.PendingOutboxEmailsParams{
+		Now:         time.Now(),
+		MaxAttempts: outboxMaxAttempts,
+		MaxCount:    outboxBatchSize,
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching pending emails: %w", err)
+	}
+	for _, email := range emails {
+		sendErr := s.Mailer.Send(Email{
+			To:      email.Recipient,
+			Subject: email.Subject,
+			Text:    email.TextBody,
+			HTML:    email.HtmlBody,
+		})
+		if sendErr == nil {
+			err = s.Queries.MarkOutboxEmailSent(ctx, db.MarkOutboxEmailSentParams{
+				SentTime: sql.NullTime{
+					Time:  time.Now(),
+					Valid: true,
+				},
+				ID: email.ID,
+			})
+		} else {
+			if email.Attempts+1 >= outboxMaxAttempts {
+				slog.Error("giving up on sending email", "err", sendErr, "id", email.ID, "order_id", email.OrderID)
+			} else {
+				slog.Warn("error sending email", "err", sendErr, "id", email.ID, "order_id", email.OrderID, "attempts", email.Attempts+1)
+			}
+			err = s.Queries.MarkOutboxEmailFailed(ctx, db.MarkOutboxEmailFailedParams{
+				LastError:       sendErr.Error(),
+				NextAttemptTime: time.Now().Add(outboxBackoff(email.Attempts + 1)),
+				ID:              email.ID,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("error updating email %d: %w", email.ID, err)
+		}
+	}
+	return nil
+}
+
+// outboxBackoff returns the time to wait before the next attempt after the
+// given number of failed attempts.
+func outboxBackoff(attempts int64) time.Duration {
+	return min(time.Minute<<attempts, outboxMaxBackoff)
+}
+
+// formatPrice formats the price in cents as dollars, e.g. 1500 as "15.00".
+func formatPrice(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMailer records the emails sent. If err is set, sending fails.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []Email
+	err  error
+}
+
+func (m *testMailer) Send(email Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+// sendOutbox sends the emails in the outbox and returns the emails sent.
+func (ts *testServer) sendOutbox() []Email {
+	ts.t.Helper()
+	if err := ts.Server.sendOutbox(context.Background()); err != nil {
+		ts.t.Fatalf("error sending outbox: %v", err)
+	}
+	ts.mailer.mu.Lock()
+	defer ts.mailer.mu.Unlock()
+	sent := ts.mailer.sent
+	ts.mailer.sent = nil
+	return sent
+}
+
+func TestOrderEmails(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      func(ts *testServer)
+		wantSubject string
+		wantText    []string
+		wantHTML    []string
+	}{
+		{
+			name: "confirmation",
+			action: func(ts *testServer) {
+				ts.createCoupon("TEN", 10)
+				req := testCheckoutRequest(shirtItem("1", "M", 2))
+				req.Coupon = ptr("TEN")
+				ts.paidOrder(req)
+			},
+			wantSubject: "confirmed",
+			wantText:    []string{"Hi Tan Ah Kow,", "- 2x Shirt (M) at $17.00 each", "Coupon: TEN", "http://shop.test/orders/"},
+			wantHTML:    []string{"<td>Shirt</td><td>M</td>", "$17.00", `href="http://shop.test/orders/`},
+		},
+		{
+			name: "collection receipt",
+			action: func(ts *testServer) {
+				orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 2)))
+				ts.sendOutbox()
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/collect", nil, true, nil)
+				// Collecting again should not send another receipt.
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/collect", nil, true, nil)
+			},
+			wantSubject: "collected",
+			wantText:    []string{"was collected on", "- 2x Shirt (M)"},
+			wantHTML:    []string{"<li>2x Shirt (M)</li>"},
+		},
+		{
+			name: "cancellation with refund",
+			action: func(ts *testServer) {
+				orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 2)))
+				ts.sendOutbox()
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/cancel", CancelRequest{Reason: "Out of stock <M>"}, true, nil)
+			},
+			wantSubject: "cancelled",
+			wantText:    []string{"Reason: Out of stock <M>", "A refund of $34.00 has been issued"},
+			wantHTML:    []string{"Reason: Out of stock &lt;M&gt;", "A refund of $34.00"},
+		},
+		{
+			name: "cancellation without payment",
+			action: func(ts *testServer) {
+				ts.payment.DefaultState = MockSessionUnpaid
+				orderID, _ := ts.checkout(testCheckoutRequest(shirtItem("1", "M", 2)))
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/cancel", nil, true, nil)
+			},
+			wantSubject: "cancelled",
+			wantText:    []string{"has been cancelled."},
+		},
+		{
+			name: "payment after expiry",
+			action: func(ts *testServer) {
+				ts.payment.DefaultState = MockSessionExpired
+				orderID, sessionID := ts.checkout(testCheckoutRequest(shirtItem("1", "M", 2)))
+				rec := ts.request("POST", "/api/v0/checkout/stripe", `{"type":"checkout.session.expired","session_id":"`+sessionID+`"}`, false)
+				expectResponse(ts.t, rec, http.StatusOK, "")
+				if err := ts.payment.SetSessionState(sessionID, MockSessionPaid); err != nil {
+					ts.t.Fatalf("error setting session state: %v", err)
+				}
+				rec = ts.request("GET", "/api/v0/checkout/complete?session_id="+sessionID, nil, false)
+				expectResponse(ts.t, rec, http.StatusTemporaryRedirect, "")
+				order := ts.lookupOrder(orderID)
+				if order.RefundStatus != RefundStatusFull || len(order.Refunds) != 1 || order.Refunds[0].Amount != 3400 {
+					ts.t.Errorf("got refund status %q with refunds %+v, want the payment refunded in full", order.RefundStatus, order.Refunds)
+				}
+			},
+			wantSubject: "Payment received for cancelled order",
+			wantText:    []string{"the order had already\nbeen cancelled, so it will not be fulfilled.\n\nA refund of $34.00 has been issued"},
+			wantHTML:    []string{"will not be fulfilled", "A refund of $34.00 has been issued"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			tt.action(ts)
+			sent := ts.sendOutbox()
+			if len(sent) != 1 {
+				t.Fatalf("got %d emails sent, want 1: %+v", len(sent), sent)
+			}
+			email := sent[0]
+			if email.To != "tanahkow@e.ntu.edu.sg" {
+				t.Errorf("got email sent to %q, want the buyer", email.To)
+			}
+			if !strings.Contains(email.Subject, tt.wantSubject) {
+				t.Errorf("got subject %q, want it to contain %q", email.Subject, tt.wantSubject)
+			}
+			for _, want := range tt.wantText {
+				if !strings.Contains(email.Text, want) {
+					t.Errorf("got text body %q, want it to contain %q", email.Text, want)
+				}
+			}
+			for _, want := range tt.wantHTML {
+				if !strings.Contains(email.HTML, want) {
+					t.Errorf("got HTML body %q, want it to contain %q", email.HTML, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderConfirmationSentOnce(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	_, sessionID := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	// The webhook and the redirect after payment can both complete the order.
+	expectResponse(t, ts.request("GET", "/api/v0/checkout/complete?session_id="+sessionID, nil, false), http.StatusTemporaryRedirect, "")
+	if sent := ts.sendOutbox(); len(sent) != 1 {
+		t.Errorf("got %d emails sent, want 1", len(sent))
+	}
+	if sent := ts.sendOutbox(); len(sent) != 0 {
+		t.Errorf("got %d emails sent again, want 0", len(sent))
+	}
+}
+
+func TestSendOutboxRetries(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	ts.mailer.err = errors.New("connection refused")
+	ts.sendOutbox()
+
+	var attempts int64
+	var lastError string
+	var nextAttempt time.Time
+	row := ts.DB.QueryRow("SELECT attempts, last_error, next_attempt_time FROM email_outbox")
+	if err := row.Scan(&attempts, &lastError, &nextAttempt); err != nil {
+		t.Fatalf("error reading outbox: %v", err)
+	}
+	if attempts != 1 || lastError != "connection refused" || !nextAttempt.After(time.Now()) {
+		t.Errorf("got %d attempts with error %q and next attempt at %s, want 1 failed attempt retried later", attempts, lastError, nextAttempt)
+	}
+
+	// The email should not be retried before the next attempt is due.
+	ts.mailer.err = nil
+	if sent := ts.sendOutbox(); len(sent) != 0 {
+		t.Fatalf("got %d emails sent before retry is due, want 0", len(sent))
+	}
+	if _, err := ts.DB.Exec("UPDATE email_outbox SET next_attempt_time = ?", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("error updating outbox: %v", err)
+	}
+	if sent := ts.sendOutbox(); len(sent) != 1 {
+		t.Fatalf("got %d emails sent after retry is due, want 1", len(sent))
+	}
+	if sent := ts.sendOutbox(); len(sent) != 0 {
+		t.Errorf("got %d emails sent after success, want 0", len(sent))
+	}
+}
+
+func TestSendOutboxGivesUp(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	if _, err := ts.DB.Exec("UPDATE email_outbox SET attempts = ?", outboxMaxAttempts); err != nil {
+		t.Fatalf("error updating outbox: %v", err)
+	}
+	if sent := ts.sendOutbox(); len(sent) != 0 {
+		t.Errorf("got %d emails sent after too many attempts, want 0", len(sent))
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int64
+		want     time.Duration
+	}{
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{5, 32 * time.Minute},
+		{6, time.Hour},
+		{outboxMaxAttempts, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+	"github.com/xuri/excelize/v2"
+)
+
+// orderExportBatchSize is the number of order items fetched from the database
+// at once while exporting.
+const orderExportBatchSize = 500
+
+var orderExportHeader = []string{
+	"Order ID", "Name", "Matric Number", "Email", "Payment Time (UTC)",
+	"Collection Time (UTC)", "Cancelled", "Coupon Code", "Product", "Variant",
+	"Unit Price", "Amount", "Refunded Amount",
+}
+
+// orderExportWriter writes the rows of an order export in a specific format.
+type orderExportWriter interface {
+	WriteRow(row db.ExportOrderItemsRow) error
+	// Flush sends the rows written so far to the client if the format allows
+	// it.
+	Flush() error
+	// Finish writes the rest of the export after the last row.
+	Finish() error
+	// Close releases the resources used by the writer.
+	Close() error
+}
+
+// OrderExport exports every order item in the sale period as a CSV or XLSX
+// file for reconciliation in a spreadsheet.
+func (s *Server) OrderExport(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	salePeriod, ok := s.resolveSalePeriod(w, req, req.PathValue("sale_id"))
+	if !ok {
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var newWriter func(w io.Writer) (orderExportWriter, error)
+	var contentType string
+	switch format {
+	case "csv":
+		newWriter = newCSVOrderExportWriter
+		contentType = "text/csv; charset=utf-8"
+	case "xlsx":
+		newWriter = newXLSXOrderExportWriter
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	// The first batch is fetched before anything is written so that database
+	// errors can still be reported with a proper status code.
+	rows, err := s.Queries.ExportOrderItems(ctx, db.ExportOrderItemsParams{
+		SalePeriod: salePeriod,
+		AfterID:    0,
+		MaxCount:   orderExportBatchSize,
+	})
+	if err != nil {
+		slog.Error("error fetching order items for export", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="orders-%d.%s"`, salePeriod, format))
+	writer, err := newWriter(w)
+	if err != nil {
+		slog.Error("error creating order export", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = writer.Close()
+	}()
+	if err := s.writeOrderExport(ctx, writer, salePeriod, rows); err != nil {
+		// The response may have already started so the client can only notice
+		// the truncated file.
+		slog.Error("error writing order export", "err", err, "format", format)
+		return
+	}
+	if err := writer.Finish(); err != nil {
+		slog.Error("error writing order export", "err", err, "format", format)
+	}
+}
+
+// writeOrderExport writes the given rows and every following batch of the sale
+// period to the writer.
+func (s *Server) writeOrderExport(ctx context.Context, writer orderExportWriter, salePeriod int64, rows []db.ExportOrderItemsRow) error {
+	for len(rows) > 0 {
+		for _, row := range rows {
+			if err := writer.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if len(rows) < orderExportBatchSize {
+			return nil
+		}
+		var err error
+		rows, err = s.Queries.ExportOrderItems(ctx, db.ExportOrderItemsParams{
+			SalePeriod: salePeriod,
+			AfterID:    rows[len(rows)-1].ID,
+			MaxCount:   orderExportBatchSize,
+		})
+		if err != nil {
+			return fmt.Errorf("error fetching order items: %w", err)
+		}
+	}
+	return nil
+}
+
+type csvOrderExportWriter struct {
+	w   io.Writer
+	csv *csv.Writer
+}
+
+func newCSVOrderExportWriter(w io.Writer) (orderExportWriter, error) {
+	writer := &csvOrderExportWriter{
+		w:   w,
+		csv: csv.NewWriter(w),
+	}
+	if err := writer.csv.Write(orderExportHeader); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (e *csvOrderExportWriter) WriteRow(row db.ExportOrderItemsRow) error {
+	return e.csv.Write([]string{
+		row.OrderID,
+		escapeExportCell(row.Name),
+		row.MatricNumber,
+		escapeExportCell(row.Email),
+		formatExportTime(row.PaymentTime),
+		formatExportTime(row.CollectionTime),
+		strconv.FormatBool(row.Cancelled),
+		escapeExportCell(row.CouponCode.String),
+		escapeExportCell(row.ProductName),
+		escapeExportCell(row.Variant),
+		formatPrice(row.UnitPrice),
+		strconv.FormatInt(row.Amount, 10),
+		strconv.FormatInt(row.RefundedAmount, 10),
+	})
+}
+
+func (e *csvOrderExportWriter) Flush() error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (e *csvOrderExportWriter) Finish() error {
+	return e.Flush()
+}
+
+func (e *csvOrderExportWriter) Close() error {
+	return nil
+}
+
+// escapeExportCell prefixes text that spreadsheets would run as a formula with a
+// quote so that buyers cannot inject formulas into CSV exports. XLSX exports do
+// not need it as excelize writes strings as text cells.
+func escapeExportCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatExportTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.DateTime)
+}
+
+// xlsxOrderExportWriter writes the export as a spreadsheet. As XLSX files are
+// zip archives, the rows are kept by excelize (spilling to a temporary file
+// for large exports) and only sent to the client on Finish.
+type xlsxOrderExportWriter struct {
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+const xlsxOrderExportSheet = "Orders"
+
+func newXLSXOrderExportWriter(w io.Writer) (orderExportWriter, error) {
+	file := excelize.NewFile()
+	if err := file.SetSheetName("Sheet1", xlsxOrderExportSheet); err != nil {
+		return nil, err
+	}
+	stream, err := file.NewStreamWriter(xlsxOrderExportSheet)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]any, 0, len(orderExportHeader))
+	for _, v := range orderExportHeader {
+		header = append(header, v)
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return nil, err
+	}
+	return &xlsxOrderExportWriter{
+		w:      w,
+		file:   file,
+		stream: stream,
+		row:    1,
+	}, nil
+}
+
+func (e *xlsxOrderExportWriter) WriteRow(row db.ExportOrderItemsRow) error {
+	e.row++
+	cell, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+	return e.stream.SetRow(cell, []any{
+		row.OrderID,
+		row.Name,
+		row.MatricNumber,
+		row.Email,
+		xlsxExportTime(row.PaymentTime),
+		xlsxExportTime(row.CollectionTime),
+		row.Cancelled,
+		row.CouponCode.String,
+		row.ProductName,
+		row.Variant,
+		float64(row.UnitPrice) / 100,
+		row.Amount,
+		row.RefundedAmount,
+	})
+}
+
+func (e *xlsxOrderExportWriter) Flush() error {
+	return nil
+}
+
+func (e *xlsxOrderExportWriter) Finish() error {
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+	return e.file.Write(e.w)
+}
+
+func (e *xlsxOrderExportWriter) Close() error {
+	return e.file.Close()
+}
+
+func xlsxExportTime(t sql.NullTime) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.UTC()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestOrderExport(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.createCoupon("TEN", 10)
+	req := testCheckoutRequest(shirtItem("1", "M", 2), shirtItem("1", "S", 1))
+	req.Coupon = ptr("TEN")
+	paidID, _ := ts.paidOrder(req)
+	ts.requestOK("POST", "/api/v0/orders/"+paidID+"/collect", nil, true, nil)
+	ts.payment.DefaultState = MockSessionUnpaid
+	unpaidID, _ := ts.checkout(testCheckoutRequest(shirtItem("1", "M", 1)))
+	ts.requestOK("POST", "/api/v0/orders/"+unpaidID+"/cancel", nil, true, nil)
+
+	tests := []struct {
+		name          string
+		format        string
+		contentType   string
+		read          func(t *testing.T, body []byte) [][]string
+		wantPrice     string
+		wantCancelled string
+	}{
+		{"default", "", "text/csv; charset=utf-8", readCSVExport, "17.00", "true"},
+		{"csv", "csv", "text/csv; charset=utf-8", readCSVExport, "17.00", "true"},
+		{"xlsx", "xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", readXLSXExport, "17", "TRUE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.request("GET", "/api/v0/sales/1/orders/export?format="+tt.format, nil, true)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("got content type %q, want %q", got, tt.contentType)
+			}
+			rows := tt.read(t, rec.Body.Bytes())
+			if len(rows) != 4 {
+				t.Fatalf("got %d rows, want header and 3 order items: %v", len(rows), rows)
+			}
+			if !slices.Equal(rows[0], orderExportHeader) {
+				t.Errorf("got header %v, want %v", rows[0], orderExportHeader)
+			}
+			wantFirst := []string{paidID, "Tan Ah Kow", "U2345678A", "tanahkow@e.ntu.edu.sg", "TEN", "Shirt", "M", tt.wantPrice, "2", "0"}
+			gotFirst := append(slices.Clone(rows[1][:4]), rows[1][7:]...)
+			if !slices.Equal(gotFirst, wantFirst) {
+				t.Errorf("got first row %v, want %v", gotFirst, wantFirst)
+			}
+			if rows[1][4] == "" || rows[1][5] == "" {
+				t.Errorf("got payment time %q and collection time %q, want both to be set", rows[1][4], rows[1][5])
+			}
+			if rows[3][0] != unpaidID || rows[3][4] != "" || rows[3][6] != tt.wantCancelled {
+				t.Errorf("got last row %v, want unpaid cancelled order %s", rows[3], unpaidID)
+			}
+		})
+	}
+}
+
+func TestOrderExportErrors(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		want   string
+	}{
+		{"unknown format", "/api/v0/sales/1/orders/export?format=pdf", http.StatusBadRequest, "Invalid format"},
+		{"invalid sale period", "/api/v0/sales/abc/orders/export", http.StatusBadRequest, "Invalid sales period"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectResponse(t, ts.request("GET", tt.target, nil, true), tt.code, tt.want)
+		})
+	}
+}
+
+func TestOrderExportFormulas(t *testing.T) {
+	ts := newTestServer(t)
+	if err := ts.Queries.CreateOrder(context.Background(), db.CreateOrderParams{
+		OrderID:    "CD0000",
+		Name:       `=HYPERLINK("http://evil.test","Click")`,
+		Email:      "+tanahkow@e.ntu.edu.sg",
+		SalePeriod: 1,
+	}); err != nil {
+		t.Fatalf("error creating order: %v", err)
+	}
+	if err := ts.Queries.CreateOrderItem(context.Background(), db.CreateOrderItemParams{
+		OrderID:     "CD0000",
+		ProductID:   "2",
+		ProductName: "-Sticker",
+		UnitPrice:   300,
+		Amount:      1,
+	}); err != nil {
+		t.Fatalf("error creating order item: %v", err)
+	}
+	for _, tt := range []struct {
+		format string
+		read   func(t *testing.T, body []byte) [][]string
+		want   []string
+	}{
+		{"csv", readCSVExport, []string{`'=HYPERLINK("http://evil.test","Click")`, "'+tanahkow@e.ntu.edu.sg", "'-Sticker"}},
+		// Text cells are never run as formulas, so they are left as is.
+		{"xlsx", readXLSXExport, []string{`=HYPERLINK("http://evil.test","Click")`, "+tanahkow@e.ntu.edu.sg", "-Sticker"}},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			rec := ts.request("GET", "/api/v0/sales/1/orders/export?format="+tt.format, nil, true)
+			rows := tt.read(t, rec.Body.Bytes())
+			if len(rows) != 2 {
+				t.Fatalf("got %d rows, want header and 1 order item", len(rows))
+			}
+			if got := []string{rows[1][1], rows[1][3], rows[1][8]}; !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	rec := ts.request("GET", "/api/v0/sales/1/orders/export?format=xlsx", nil, true)
+	file, err := excelize.OpenReader(rec.Body)
+	if err != nil {
+		t.Fatalf("error reading XLSX export: %v", err)
+	}
+	defer file.Close()
+	if formula, err := file.GetCellFormula(xlsxOrderExportSheet, "B2"); err != nil || formula != "" {
+		t.Errorf("got formula %q (err %v) in the name cell, want text", formula, err)
+	}
+}
+
+func TestOrderExportBatches(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	itemCount := orderExportBatchSize*2 + 1
+	for i := range itemCount {
+		orderID := fmt.Sprintf("CD%04d", i)
+		if err := ts.Queries.CreateOrder(ctx, db.CreateOrderParams{
+			OrderID:    orderID,
+			Name:       "Tan Ah Kow",
+			SalePeriod: 1,
+		}); err != nil {
+			t.Fatalf("error creating order: %v", err)
+		}
+		if err := ts.Queries.CreateOrderItem(ctx, db.CreateOrderItemParams{
+			OrderID:     orderID,
+			ProductID:   "2",
+			ProductName: "Sticker",
+			UnitPrice:   300,
+			Amount:      1,
+		}); err != nil {
+			t.Fatalf("error creating order item: %v", err)
+		}
+	}
+	rec := ts.request("GET", "/api/v0/sales/1/orders/export?format=csv", nil, true)
+	rows := readCSVExport(t, rec.Body.Bytes())
+	if len(rows) != itemCount+1 {
+		t.Fatalf("got %d rows, want %d", len(rows), itemCount+1)
+	}
+	for i, row := range rows[1:] {
+		if want := fmt.Sprintf("CD%04d", i); row[0] != want {
+			t.Fatalf("got order %q in row %d, want %q", row[0], i+1, want)
+		}
+	}
+}
+
+func readCSVExport(t *testing.T, body []byte) [][]string {
+	t.Helper()
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading CSV export: %v", err)
+	}
+	return rows
+}
+
+func readXLSXExport(t *testing.T, body []byte) [][]string {
+	t.Helper()
+	file, err := excelize.OpenReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error reading XLSX export: %v", err)
+	}
+	defer file.Close()
+	rows, err := file.GetRows(xlsxOrderExportSheet)
+	if err != nil {
+		t.Fatalf("error reading XLSX export rows: %v", err)
+	}
+	return rows
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// Statuses of readiness checks.
+const (
+	checkOK      = "ok"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+type ReadinessCheck struct {
+	Status string `json:"status"`
+	// Error is only logged as the readiness endpoint is public.
+	Error string `json:"-"`
+}
+
+// Healthz reports that the process is alive.
+func (s *Server) Healthz(w http.ResponseWriter, req *http.Request) {
+	if err := json.NewEncoder(w).Encode(HealthResponse{
+		Status: checkOK,
+	}); err != nil {
+		slog.Error("error writing health response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// Readyz reports whether the server is able to serve requests. It responds
+// with 503 Service Unavailable if any of the checks fail.
+func (s *Server) Readyz(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readinessTimeout)
+	defer cancel()
+	resp := ReadinessResponse{
+		Status: checkOK,
+		Checks: map[string]ReadinessCheck{
+			"database":   readinessCheck(s.DB.PingContext(ctx)),
+			"migrations": readinessCheck(s.checkMigrations()),
+			"image_dir":  s.checkImageDir(),
+			"payment":    s.checkPayment(),
+		},
+	}
+	status := http.StatusOK
+	for name, check := range resp.Checks {
+		if check.Status == checkFailed {
+			slog.Warn("readiness check failed", "check", name, "err", check.Error)
+			resp.Status = checkFailed
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("error writing readiness response", "err", err)
+	}
+}
+
+// checkMigrations checks that every migration embedded in the binary has been
+// applied.
+func (s *Server) checkMigrations() error {
+	migrations, err := s.migrations.FindMigrations()
+	if err != nil {
+		return fmt.Errorf("error listing migrations: %w", err)
+	}
+	for _, m := range migrations {
+		if !m.Applied {
+			return fmt.Errorf("migration %s is not applied", m.FileName)
+		}
+	}
+	return nil
+}
+
+// checkImageDir checks that uploaded images can be written to ImageDir.
+func (s *Server) checkImageDir() ReadinessCheck {
+	if s.Config.ImageDir == "" {
+		return ReadinessCheck{Status: checkSkipped}
+	}
+	f, err := os.CreateTemp(s.Config.ImageDir, ".readyz-*")
+	if err != nil {
+		return readinessCheck(fmt.Errorf("image directory is not writable: %w", err))
+	}
+	err = errors.Join(f.Close(), os.Remove(f.Name()))
+	return readinessCheck(err)
+}
+
+// checkPayment checks the credentials of the payment provider. It is opt-in as
+// it calls the payment provider on every probe.
+func (s *Server) checkPayment() ReadinessCheck {
+	if !s.Config.ReadyCheckPayment {
+		return ReadinessCheck{Status: checkSkipped}
+	}
+	return readinessCheck(s.Payment.Ping())
+}
+
+func readinessCheck(err error) ReadinessCheck {
+	if err != nil {
+		return ReadinessCheck{
+			Status: checkFailed,
+			Error:  err.Error(),
+		}
+	}
+	return ReadinessCheck{Status: checkOK}
+}
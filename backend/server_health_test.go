@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	ts := newTestServer(t)
+	expectResponse(t, ts.request("GET", "/healthz", nil, false), http.StatusOK, `"status":"ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(ts *testServer)
+		code   int
+		checks map[string]string
+	}{
+		{"ready", func(ts *testServer) {}, http.StatusOK, map[string]string{
+			"database":   checkOK,
+			"migrations": checkOK,
+			"image_dir":  checkOK,
+			"payment":    checkSkipped,
+		}},
+		{"payment checked", func(ts *testServer) {
+			ts.Config.ReadyCheckPayment = true
+		}, http.StatusOK, map[string]string{
+			"payment": checkOK,
+		}},
+		{"payment failing", func(ts *testServer) {
+			ts.Config.ReadyCheckPayment = true
+			ts.payment.DefaultState = MockSessionFailed
+		}, http.StatusServiceUnavailable, map[string]string{
+			"database": checkOK,
+			"payment":  checkFailed,
+		}},
+		{"migration missing", func(ts *testServer) {
+			if _, err := ts.DB.Exec("DELETE FROM schema_migrations WHERE version = '20250623090000'"); err != nil {
+				ts.t.Fatalf("error removing migration: %v", err)
+			}
+		}, http.StatusServiceUnavailable, map[string]string{
+			"migrations": checkFailed,
+		}},
+		{"image directory missing", func(ts *testServer) {
+			if err := os.RemoveAll(ts.Config.ImageDir); err != nil {
+				ts.t.Fatalf("error removing image directory: %v", err)
+			}
+		}, http.StatusServiceUnavailable, map[string]string{
+			"image_dir": checkFailed,
+		}},
+		{"image directory not configured", func(ts *testServer) {
+			ts.Config.ImageDir = ""
+		}, http.StatusOK, map[string]string{
+			"image_dir": checkSkipped,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			tt.setup(ts)
+			rec := ts.request("GET", "/readyz", nil, false)
+			if rec.Code != tt.code {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.code, rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), "error") {
+				t.Errorf("got response %s, want the check errors kept out of it", rec.Body.String())
+			}
+			var resp ReadinessResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			for name, want := range tt.checks {
+				if got := resp.Checks[name]; got.Status != want {
+					t.Errorf("got check %s %+v, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
-	"github.com/stripe/stripe-go/v81"
 )
 
 type OrderResponse struct {
@@ -18,32 +20,44 @@ type OrderResponse struct {
 }
 
 type Order struct {
-	OrderID          string      `json:"id"`
-	Name             string      `json:"name"`
-	Email            string      `json:"email"`
-	MatricNumber     string      `json:"matricNumber"`
-	PaymentReference string      `json:"paymentRef"`
-	SalePeriod       string      `json:"salePeriod"`
-	PaymentTime      *time.Time  `json:"paymentTime"`
-	CollectionTime   *time.Time  `json:"collectionTime"`
-	Cancelled        bool        `json:"cancelled"`
-	Coupon           *Coupon     `json:"coupon"`
-	Items            []OrderItem `json:"items"`
+	OrderID          string        `json:"id"`
+	Name             string        `json:"name"`
+	Email            string        `json:"email"`
+	MatricNumber     string        `json:"matricNumber"`
+	PaymentReference string        `json:"paymentRef"`
+	SalePeriod       string        `json:"salePeriod"`
+	PaymentTime      *time.Time    `json:"paymentTime"`
+	CollectionTime   *time.Time    `json:"collectionTime"`
+	Cancelled        bool          `json:"cancelled"`
+	Coupon           *Coupon       `json:"coupon"`
+	Items            []OrderItem   `json:"items"`
+	RefundStatus     RefundStatus  `json:"refundStatus"`
+	Refunds          []OrderRefund `json:"refunds"`
 }
 
 type OrderItem struct {
-	ProductID string `json:"id"`
-	Name      string `json:"name"`
-	Variant   string `json:"variant"`
-	ImageURL  string `json:"imageURL"`
-	Amount    int    `json:"amount"`
-	UnitPrice int    `json:"unitPrice"`
+	ProductID      string `json:"id"`
+	Name           string `json:"name"`
+	Variant        string `json:"variant"`
+	ImageURL       string `json:"imageURL"`
+	Amount         int    `json:"amount"`
+	UnitPrice      int    `json:"unitPrice"`
+	RefundedAmount int    `json:"refundedAmount"`
+
+	// Admin fields.
+	ItemID int64 `json:"itemID,omitempty"`
+}
+
+type CancelRequest struct {
+	// Reason is recorded with the refund if the order has been paid.
+	Reason string `json:"reason"`
 }
 
 func (s *Server) OrderLookup(w http.ResponseWriter, req *http.Request) {
 	includeCancelled := req.URL.Query().Get("include_cancelled") != ""
 	allowFromItem := req.URL.Query().Get("from_item") != ""
-	if (includeCancelled || allowFromItem) && !s.authCheck(w, req) {
+	isAdmin := includeCancelled || allowFromItem
+	if isAdmin && !s.authCheck(w, req) {
 		return
 	}
 	ctx := req.Context()
@@ -93,14 +107,37 @@ func (s *Server) OrderLookup(w http.ResponseWriter, req *http.Request) {
 		}
 		orderItems := make([]OrderItem, 0, len(dbOrderItems))
 		for _, item := range dbOrderItems {
-			orderItems = append(orderItems, OrderItem{
-				ProductID: item.ProductID,
-				Name:      item.ProductName,
-				Variant:   item.Variant,
-				ImageURL:  item.ImageUrl,
-				Amount:    int(item.Amount),
-				UnitPrice: int(item.UnitPrice),
-			})
+			orderItem := OrderItem{
+				ProductID:      item.ProductID,
+				Name:           item.ProductName,
+				Variant:        item.Variant,
+				ImageURL:       item.ImageUrl,
+				Amount:         int(item.Amount),
+				UnitPrice:      int(item.UnitPrice),
+				RefundedAmount: int(item.RefundedAmount),
+			}
+			if isAdmin {
+				orderItem.ItemID = item.ID
+			}
+			orderItems = append(orderItems, orderItem)
+		}
+		dbRefunds, err := s.Queries.ListOrderRefunds(ctx, dbOrder.OrderID)
+		if err != nil {
+			slog.Error("error looking up order refunds", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		refunds := make([]OrderRefund, 0, len(dbRefunds))
+		for _, refund := range dbRefunds {
+			orderRefund := OrderRefund{
+				Amount:     int(refund.Amount),
+				Reason:     refund.Reason,
+				RefundTime: refund.RefundTime,
+			}
+			if isAdmin {
+				orderRefund.AdminEmail = refund.AdminEmail
+			}
+			refunds = append(refunds, orderRefund)
 		}
 		emailSplit := strings.SplitN(dbOrder.Email, "@", 2)
 		emailSplit[0] = censorBack(emailSplit[0], 3, 10, ' ')
@@ -114,6 +151,8 @@ func (s *Server) OrderLookup(w http.ResponseWriter, req *http.Request) {
 			Cancelled:        dbOrder.Cancelled,
 			Coupon:           coupon,
 			Items:            orderItems,
+			RefundStatus:     refundStatus(dbOrderItems),
+			Refunds:          refunds,
 		}
 		if dbOrder.PaymentTime.Valid {
 			order.PaymentTime = &dbOrder.PaymentTime.Time
@@ -131,56 +170,171 @@ func (s *Server) OrderLookup(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// errOrderNotFound is returned when there is no order with the ID that can be
+// changed.
+var errOrderNotFound = errors.New("order not found")
+
 func (s *Server) OrderCollect(w http.ResponseWriter, req *http.Request) {
 	if !s.authCheck(w, req) {
 		return
 	}
-	ctx := req.Context()
-	orderID := req.PathValue("id")
-	err := s.Queries.UpdateCollectionTime(ctx, db.UpdateCollectionTimeParams{
+	_, err := s.collectOrder(req.Context(), req.PathValue("id"), sessionUser(req))
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		http.Error(w, "Invalid order ID", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("error collecting order", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// collectOrder marks the order as collected by the actor and sends the
+// collection receipt. It returns false if the order was already collected, in
+// which case the original collection time is kept.
+func (s *Server) collectOrder(ctx context.Context, orderID string, actor string) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error creating transaction for order collection: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	order, err := queries.OrderByID(ctx, orderID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, errOrderNotFound
+	case err != nil:
+		return false, fmt.Errorf("error looking up order: %w", err)
+	}
+	if order.CollectionTime.Valid {
+		return false, nil
+	}
+	collectionTime := time.Now()
+	err = queries.UpdateCollectionTime(ctx, db.UpdateCollectionTimeParams{
 		CollectionTime: sql.NullTime{
-			Time:  time.Now(),
+			Time:  collectionTime,
 			Valid: true,
 		},
 		OrderID: orderID,
 	})
+	if err != nil {
+		return false, fmt.Errorf("error marking order as collected: %w", err)
+	}
+	if err := recordOrderAudit(ctx, queries, actor, "collect", order); err != nil {
+		return false, fmt.Errorf("error recording order collection: %w", err)
+	}
+	if err := s.enqueueOrderEmail(ctx, queries, orderEmailCollected, orderID, orderEmailData{
+		Time: collectionTime.In(storeLocation).Format("2 Jan 2006 3:04 PM"),
+	}); err != nil {
+		return false, fmt.Errorf("error sending collection receipt: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error commiting order collection: %w", err)
+	}
+	return true, nil
+}
+
+func (s *Server) OrderCancel(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	var cancelReq CancelRequest
+	if err := json.NewDecoder(req.Body).Decode(&cancelReq); err != nil && !errors.Is(err, io.EOF) {
+		slog.Error("error parsing request", "err", err)
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	err := s.cancelOrder(req.Context(), req.PathValue("id"), cancelReq.Reason, sessionUser(req))
 	switch {
-	case errors.Is(err, sql.ErrNoRows):
+	case errors.Is(err, errOrderNotFound):
 		http.Error(w, "Invalid order ID", http.StatusNotFound)
 		return
 	case err != nil:
-		slog.Error("error marking order as collected", "err", err)
+		slog.Error("error cancelling order", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) OrderCancel(w http.ResponseWriter, req *http.Request) {
-	if !s.authCheck(w, req) {
-		return
+// cancelOrder cancels the order on behalf of the actor and releases its stock.
+// Paid orders are refunded, while the checkout session of unpaid orders is
+// expired. The customer is told about the cancellation with the reason.
+func (s *Server) cancelOrder(ctx context.Context, orderID string, reason string, actor string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error creating transaction for order cancellation: %w", err)
 	}
-	ctx := req.Context()
-	orderID := req.PathValue("id")
-	paymentRef, err := s.Queries.UpdateCancelled(ctx, db.UpdateCancelledParams{
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, err := queries.OrderByID(ctx, orderID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return errOrderNotFound
+	case err != nil:
+		return fmt.Errorf("error looking up order: %w", err)
+	}
+	cancelled, err := queries.UpdateCancelled(ctx, db.UpdateCancelledParams{
 		Cancelled: true,
 		OrderID:   orderID,
 	})
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
-		http.Error(w, "Invalid order ID", http.StatusNotFound)
-		return
+		return errOrderNotFound
 	case err != nil:
-		slog.Error("error marking order as cancelled", "err", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("error marking order as cancelled: %w", err)
 	}
-	if _, err := s.Stripe.CheckoutSessions.Expire(paymentRef.String, &stripe.CheckoutSessionExpireParams{}); err != nil {
-		slog.Error("error expiring checkout sessions on Stripe", "err", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	paid := cancelled.PaymentTime.Valid
+	if err := releaseStock(ctx, queries, orderID, paid); err != nil {
+		return fmt.Errorf("error releasing stock of cancelled order: %w", err)
 	}
-	w.WriteHeader(http.StatusNoContent)
+	var refund *PaymentRefund
+	if paid {
+		refund, err = s.refundRemaining(ctx, queries, orderID, reason, actor)
+		if err != nil {
+			return fmt.Errorf("error refunding cancelled order: %w", err)
+		}
+	}
+	if err := recordOrderAudit(ctx, queries, actor, "cancel", before); err != nil {
+		return fmt.Errorf("error recording order cancellation: %w", err)
+	}
+	emailData := orderEmailData{
+		Reason: reason,
+	}
+	if refund != nil {
+		emailData.RefundAmount = formatPrice(refund.Amount)
+	}
+	if err := s.enqueueOrderEmail(ctx, queries, orderEmailCancelled, orderID, emailData); err != nil {
+		return fmt.Errorf("error sending cancellation notice: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		// The refund has already been issued, so it is logged for reconciling
+		// by hand.
+		slog.Error("error commiting order cancellation", "err", err, "order_id", orderID, "refund", refund)
+		return fmt.Errorf("error commiting order cancellation: %w", err)
+	}
+	if paid {
+		return nil
+	}
+	if err := s.Payment.ExpireSession(cancelled.PaymentReference.String); err != nil {
+		return fmt.Errorf("error expiring checkout session: %w", err)
+	}
+	return nil
+}
+
+// recordOrderAudit records the change to the order since before.
+func recordOrderAudit(ctx context.Context, queries *db.Queries, actor string, action string, before db.Order) error {
+	after, err := queries.OrderByID(ctx, before.OrderID)
+	if err != nil {
+		return fmt.Errorf("error looking up updated order: %w", err)
+	}
+	return recordAudit(ctx, queries, actor, auditOrder, before.OrderID, action, before, after)
 }
 
 type OrderSummaryEntry struct {
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOrderLookup(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	paidID, sessionID := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	ts.payment.DefaultState = MockSessionUnpaid
+	cancelledID, _ := ts.checkout(testCheckoutRequest(shirtItem("1", "S", 1)))
+	ts.requestOK("POST", "/api/v0/orders/"+cancelledID+"/cancel", nil, true, nil)
+
+	tests := []struct {
+		name   string
+		target string
+		admin  bool
+		code   int
+		want   []string
+	}{
+		{"order ID", "/api/v0/orders/" + paidID, false, http.StatusOK, []string{paidID}},
+		{"order ID is case insensitive", "/api/v0/orders/" + strings.ToLower(paidID), false, http.StatusOK, []string{paidID}},
+		{"matric number", "/api/v0/orders/u2345678a", false, http.StatusOK, []string{paidID}},
+		{"email", "/api/v0/orders/tanahkow@e.ntu.edu.sg", false, http.StatusOK, []string{paidID}},
+		{"email without domain", "/api/v0/orders/tanahkow", false, http.StatusOK, []string{paidID}},
+		{"payment reference", "/api/v0/orders/" + sessionID, false, http.StatusOK, []string{paidID}},
+		{"cancelled order by ID", "/api/v0/orders/" + cancelledID, false, http.StatusOK, []string{cancelledID}},
+		{"include cancelled", "/api/v0/orders/U2345678A?include_cancelled=1", true, http.StatusOK, []string{paidID, cancelledID}},
+		{"from item", "/api/v0/orders/shirt,%20m?from_item=1", true, http.StatusOK, []string{paidID}},
+		{"from item excludes cancelled", "/api/v0/orders/Shirt,%20S?from_item=1", true, http.StatusOK, []string{}},
+		{"no match", "/api/v0/orders/CD0000", false, http.StatusOK, []string{}},
+		{"include cancelled requires auth", "/api/v0/orders/U2345678A?include_cancelled=1", false, http.StatusUnauthorized, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := ts.request("GET", tt.target, nil, tt.admin)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d (body: %q)", rec.Code, tt.code, rec.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp OrderResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			got := make([]string, 0, len(resp.Orders))
+			for _, order := range resp.Orders {
+				got = append(got, order.OrderID)
+			}
+			slices.Sort(got)
+			slices.Sort(tt.want)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got orders %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderLookupCensorsDetails(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 2)))
+	tests := []struct {
+		name  string
+		admin bool
+	}{
+		{"user", false},
+		{"admin", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/api/v0/orders/" + orderID
+			if tt.admin {
+				target += "?include_cancelled=1"
+			}
+			var resp OrderResponse
+			ts.requestOK("GET", target, nil, tt.admin, &resp)
+			order := resp.Orders[0]
+			if order.Name != "Tan ******" || order.MatricNumber != "*****678A" || order.Email != "tan*****@e.ntu.edu.sg" {
+				t.Errorf("got name %q, matric number %q and email %q, want them censored", order.Name, order.MatricNumber, order.Email)
+			}
+			if order.SalePeriod != "Default" || order.PaymentTime == nil || order.RefundStatus != RefundStatusNone {
+				t.Errorf("got order %+v, want paid order in default sale period", order)
+			}
+			item := order.Items[0]
+			if item.Name != "Shirt" || item.Variant != "M" || item.Amount != 2 || item.UnitPrice != 1700 {
+				t.Errorf("got item %+v, want 2 shirts of size M", item)
+			}
+			if (item.ItemID != 0) != tt.admin {
+				t.Errorf("got item ID %d, want it to be only shown to admins", item.ItemID)
+			}
+		})
+	}
+}
+
+func TestOrderCollect(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	expectResponse(t, ts.request("POST", "/api/v0/orders/"+orderID+"/collect", nil, true), http.StatusNoContent, "")
+	collected := ts.lookupOrder(orderID).CollectionTime
+	if collected == nil {
+		t.Fatalf("got uncollected order, want it to be collected")
+	}
+	// Collecting again should keep the original collection time.
+	expectResponse(t, ts.request("POST", "/api/v0/orders/"+orderID+"/collect", nil, true), http.StatusNoContent, "")
+	if got := ts.lookupOrder(orderID).CollectionTime; got == nil || !got.Equal(*collected) {
+		t.Errorf("got collection time %v, want %v", got, collected)
+	}
+}
+
+func TestOrderCancel(t *testing.T) {
+	tests := []struct {
+		name              string
+		paid              bool
+		body              any
+		wantSessionState  MockSessionState
+		wantRefundStatus  RefundStatus
+		wantRefundAmount  int
+		wantRefundReason  string
+		wantAvailableSize int64
+	}{
+		{"unpaid", false, nil, MockSessionExpired, RefundStatusNone, 0, "", 2},
+		{"paid", true, CancelRequest{Reason: "Changed mind"}, MockSessionPaid, RefundStatusFull, 3000, "Changed mind", 2},
+		{"paid without reason", true, nil, MockSessionPaid, RefundStatusFull, 3000, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			ts.payment.DefaultState = MockSessionUnpaid
+			orderID, sessionID := ts.checkout(testCheckoutRequest(shirtItem("1", "S", 2)))
+			if tt.paid {
+				if err := ts.payment.SetSessionState(sessionID, MockSessionPaid); err != nil {
+					t.Fatalf("error setting session state: %v", err)
+				}
+				expectResponse(t, ts.request("GET", "/api/v0/checkout/complete?session_id="+sessionID, nil, false), http.StatusTemporaryRedirect, "")
+			}
+			expectResponse(t, ts.request("POST", "/api/v0/orders/"+orderID+"/cancel", tt.body, true), http.StatusNoContent, "")
+
+			order := ts.lookupOrder(orderID)
+			if !order.Cancelled || order.RefundStatus != tt.wantRefundStatus {
+				t.Errorf("got cancelled %t with refund status %q, want cancelled with %q", order.Cancelled, order.RefundStatus, tt.wantRefundStatus)
+			}
+			session, err := ts.payment.GetSession(sessionID)
+			if err != nil {
+				t.Fatalf("error fetching session: %v", err)
+			}
+			if (session.Status == PaymentSessionExpired) != (tt.wantSessionState == MockSessionExpired) {
+				t.Errorf("got session status %q, want state %q", session.Status, tt.wantSessionState)
+			}
+			if tt.wantRefundAmount == 0 {
+				if len(order.Refunds) != 0 {
+					t.Errorf("got refunds %+v, want none", order.Refunds)
+				}
+			} else {
+				if len(order.Refunds) != 1 {
+					t.Fatalf("got %d refunds, want 1", len(order.Refunds))
+				}
+				refund := order.Refunds[0]
+				if refund.Amount != tt.wantRefundAmount || refund.Reason != tt.wantRefundReason || refund.AdminEmail != testAdminEmail {
+					t.Errorf("got refund %+v, want %d refunded for %q by %s", refund, tt.wantRefundAmount, tt.wantRefundReason, testAdminEmail)
+				}
+			}
+			if available, reserved := ts.stock("1", "S"); available != tt.wantAvailableSize || reserved != 0 {
+				t.Errorf("got stock %d available, %d reserved, want %d and 0", available, reserved, tt.wantAvailableSize)
+			}
+			// Cancelling again should fail as the order is already cancelled.
+			expectResponse(t, ts.request("POST", "/api/v0/orders/"+orderID+"/cancel", nil, true), http.StatusNotFound, "Invalid order ID")
+		})
+	}
+}
+
+func TestOrderRefund(t *testing.T) {
+	tests := []struct {
+		name             string
+		coupon           bool
+		items            func(order Order) []RefundItem
+		code             int
+		body             string
+		wantRefundStatus RefundStatus
+		wantAmount       int
+	}{
+		{
+			name: "single unit",
+			items: func(order Order) []RefundItem {
+				return []RefundItem{{ID: order.Items[0].ItemID, Amount: 1}}
+			},
+			code:             http.StatusNoContent,
+			wantRefundStatus: RefundStatusPartial,
+			wantAmount:       1500,
+		},
+		{
+			name:   "discounted line",
+			coupon: true,
+			items: func(order Order) []RefundItem {
+				return []RefundItem{{ID: order.Items[1].ItemID, Amount: 1}}
+			},
+			code:             http.StatusNoContent,
+			wantRefundStatus: RefundStatusPartial,
+			wantAmount:       1530,
+		},
+		{
+			name: "every item",
+			items: func(order Order) []RefundItem {
+				return []RefundItem{{ID: order.Items[0].ItemID, Amount: 2}, {ID: order.Items[1].ItemID, Amount: 1}}
+			},
+			code:             http.StatusNoContent,
+			wantRefundStatus: RefundStatusFull,
+			wantAmount:       4700,
+		},
+		{
+			name: "more than ordered",
+			items: func(order Order) []RefundItem {
+				return []RefundItem{{ID: order.Items[0].ItemID, Amount: 3}}
+			},
+			code:             http.StatusBadRequest,
+			body:             "Cannot refund more than the ordered amount of Shirt",
+			wantRefundStatus: RefundStatusNone,
+		},
+		{
+			name: "unknown item",
+			items: func(order Order) []RefundItem {
+				return []RefundItem{{ID: 999, Amount: 1}}
+			},
+			code:             http.StatusBadRequest,
+			body:             "Invalid item to refund",
+			wantRefundStatus: RefundStatusNone,
+		},
+		{
+			name: "no items",
+			items: func(order Order) []RefundItem {
+				return []RefundItem{}
+			},
+			code:             http.StatusBadRequest,
+			body:             "No items to refund",
+			wantRefundStatus: RefundStatusNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			req := testCheckoutRequest(shirtItem("1", "S", 2), shirtItem("1", "M", 1))
+			if tt.coupon {
+				ts.createCoupon("TEN", 10)
+				req.Coupon = ptr("TEN")
+			}
+			orderID, _ := ts.paidOrder(req)
+			order := ts.lookupOrder(orderID)
+			rec := ts.request("POST", "/api/v0/orders/"+orderID+"/refund", RefundRequest{
+				Reason: "Wrong size",
+				Items:  tt.items(order),
+			}, true)
+			expectResponse(t, rec, tt.code, tt.body)
+
+			order = ts.lookupOrder(orderID)
+			if order.Cancelled || order.RefundStatus != tt.wantRefundStatus {
+				t.Errorf("got cancelled %t with refund status %q, want active order with %q", order.Cancelled, order.RefundStatus, tt.wantRefundStatus)
+			}
+			refunded := 0
+			for _, refund := range order.Refunds {
+				refunded += refund.Amount
+			}
+			if refunded != tt.wantAmount {
+				t.Errorf("got %d refunded, want %d", refunded, tt.wantAmount)
+			}
+		})
+	}
+}
+
+func TestOrderRefundRestocksAndCancelRefundsRest(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "S", 2), shirtItem("1", "M", 1)))
+	order := ts.lookupOrder(orderID)
+	ts.requestOK("POST", "/api/v0/orders/"+orderID+"/refund", RefundRequest{
+		Items: []RefundItem{{ID: order.Items[0].ItemID, Amount: 1}},
+	}, true, nil)
+	if available, reserved := ts.stock("1", "S"); available != 1 || reserved != 0 {
+		t.Errorf("got stock %d available, %d reserved after refund, want 1 and 0", available, reserved)
+	}
+	// Refunded units should not be counted as waiting for collection.
+	var summary OrderSummaryResponse
+	ts.requestOK("GET", "/api/v0/sales/1/order_summary", nil, true, &summary)
+	for _, entry := range summary.Unfulfilled {
+		if entry.Variant == "S" && entry.Count != 1 {
+			t.Errorf("got %d shirts of size S to collect, want 1", entry.Count)
+		}
+	}
+
+	ts.requestOK("POST", "/api/v0/orders/"+orderID+"/cancel", CancelRequest{Reason: "Changed mind"}, true, nil)
+	if available, reserved := ts.stock("1", "S"); available != 2 || reserved != 0 {
+		t.Errorf("got stock %d available, %d reserved after cancellation, want 2 and 0", available, reserved)
+	}
+	order = ts.lookupOrder(orderID)
+	if len(order.Refunds) != 2 || order.Refunds[1].Amount != 3200 {
+		t.Errorf("got refunds %+v, want the remaining 3200 to be refunded on cancellation", order.Refunds)
+	}
+	if order.RefundStatus != RefundStatusFull {
+		t.Errorf("got refund status %q, want %q", order.RefundStatus, RefundStatusFull)
+	}
+}
+
+func TestOrderRefundRequiresPaidOrder(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.payment.DefaultState = MockSessionUnpaid
+	orderID, _ := ts.checkout(testCheckoutRequest(shirtItem("1", "S", 1)))
+	order := ts.lookupOrder(orderID)
+	rec := ts.request("POST", "/api/v0/orders/"+orderID+"/refund", RefundRequest{
+		Items: []RefundItem{{ID: order.Items[0].ItemID, Amount: 1}},
+	}, true)
+	expectResponse(t, rec, http.StatusBadRequest, "Only paid orders")
+	rec = ts.request("POST", "/api/v0/orders/CD0000/refund", RefundRequest{
+		Items: []RefundItem{{ID: 1, Amount: 1}},
+	}, true)
+	expectResponse(t, rec, http.StatusNotFound, "Invalid order ID")
+}
+
+func TestOrderSummary(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	collectedID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 2)))
+	uncollectedID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1), shirtItem("1", "S", 1)))
+	// Unpaid orders should not be counted.
+	ts.payment.DefaultState = MockSessionUnpaid
+	ts.checkout(testCheckoutRequest(shirtItem("1", "M", 5)))
+	ts.requestOK("POST", "/api/v0/orders/"+collectedID+"/collect", nil, true, nil)
+
+	tests := []struct {
+		name   string
+		target string
+		want   map[string]int
+	}{
+		{"uncollected", "/api/v0/sales/1/order_summary", map[string]int{"M": 1, "S": 1}},
+		{"show collected", "/api/v0/sales/1/order_summary?show_collected=1", map[string]int{"M": 3, "S": 1}},
+		{"current sale period", "/api/v0/sales/current/order_summary", map[string]int{"M": 1, "S": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp OrderSummaryResponse
+			ts.requestOK("GET", tt.target, nil, true, &resp)
+			got := make(map[string]int)
+			for _, entry := range resp.Unfulfilled {
+				got[entry.Variant] = entry.Count
+			}
+			if len(got) != len(tt.want) || got["M"] != tt.want["M"] || got["S"] != tt.want["S"] {
+				t.Errorf("got summary %v, want %v", got, tt.want)
+			}
+			if resp.UnfulfilledOrderCount != 1 || resp.FulfilledOrderCount != 1 {
+				t.Errorf("got %d unfulfilled and %d fulfilled orders, want 1 each", resp.UnfulfilledOrderCount, resp.FulfilledOrderCount)
+			}
+			if len(resp.OrderIDSamples) != 1 || resp.OrderIDSamples[0] != uncollectedID {
+				t.Errorf("got order ID samples %v, want [%s]", resp.OrderIDSamples, uncollectedID)
+			}
+		})
+	}
+	expectResponse(t, ts.request("GET", "/api/v0/sales/abc/order_summary", nil, true), http.StatusBadRequest, "Invalid sales period")
+}
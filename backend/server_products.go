@@ -26,6 +26,9 @@ type Product struct {
 	ImageURLs       []ProductImageURL `json:"imageURLs"`
 	Enabled         *bool             `json:"enabled,omitempty"`
 	SalePeriod      int               `json:"salePeriod"`
+	// Stock of the variants that are tracked. Variants that are not listed
+	// can be bought without limit.
+	Stock []ProductStock `json:"stock"`
 }
 
 type ProductVariant struct {
@@ -74,7 +77,17 @@ func (s *Server) Products(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
-	products, err := dbProductsToProducts(dbProducts, includeDisabled)
+	dbStock, err := s.Queries.ListProductStock(req.Context(), salePeriod)
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Cancelled by user. Do nothing.
+		return
+	case err != nil:
+		slog.Error("error fetching product stock", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	products, err := dbProductsToProducts(dbProducts, dbStock, includeDisabled)
 	if err != nil {
 		slog.Error("error parsing products", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -104,6 +117,12 @@ func (s *Server) SaveProduct(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
+	if product.Stock != nil {
+		if err := validateStock(product); err != nil {
+			http.Error(w, "Invalid Stock: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	salePeriod, ok := s.resolveSalePeriod(w, req, req.PathValue("sale_id"))
 	if !ok {
 		return
@@ -118,12 +137,23 @@ func (s *Server) SaveProduct(w http.ResponseWriter, req *http.Request) {
 		slog.Error("error marshalling image URLs", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for product", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	var before any
+	var productID int64
 	var sqlErr error
 	switch product.ID {
 	case "":
 		// Create new product.
-		var newID int64
-		newID, sqlErr = s.Queries.CreateProduct(ctx, db.CreateProductParams{
+		productID, sqlErr = queries.CreateProduct(ctx, db.CreateProductParams{
 			Name:             product.Name,
 			BasePrice:        int64(product.BasePrice),
 			DefaultImageUrl:  product.DefaultImageURL,
@@ -132,7 +162,7 @@ func (s *Server) SaveProduct(w http.ResponseWriter, req *http.Request) {
 			Enabled:          *product.Enabled,
 			SalePeriod:       salePeriod,
 		})
-		product.ID = strconv.Itoa(int(newID))
+		product.ID = strconv.Itoa(int(productID))
 	default:
 		// Update existing ID.
 		id, err := strconv.Atoi(product.ID)
@@ -140,8 +170,14 @@ func (s *Server) SaveProduct(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Invalid product ID", http.StatusBadRequest)
 			return
 		}
-		sqlErr = s.Queries.UpdateProduct(ctx, db.UpdateProductParams{
-			ProductID:        int64(id),
+		productID = int64(id)
+		before, sqlErr = productForAudit(ctx, queries, productID)
+		if sqlErr != nil {
+			break
+		}
+		var rows int64
+		rows, sqlErr = queries.UpdateProduct(ctx, db.UpdateProductParams{
+			ProductID:        productID,
 			Name:             product.Name,
 			BasePrice:        int64(product.BasePrice),
 			DefaultImageUrl:  product.DefaultImageURL,
@@ -150,23 +186,54 @@ func (s *Server) SaveProduct(w http.ResponseWriter, req *http.Request) {
 			Enabled:          *product.Enabled,
 			SalePeriod:       salePeriod,
 		})
+		if sqlErr == nil && rows == 0 {
+			// The product is in another sale period.
+			sqlErr = sql.ErrNoRows
+		}
 	}
 	switch {
 	case errors.Is(sqlErr, sql.ErrNoRows):
-		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		http.Error(w, "Invalid Product ID", http.StatusNotFound)
 		return
 	case sqlErr != nil:
 		slog.Error("error updating product", "err", sqlErr)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if product.Stock != nil {
+		if err := saveStock(ctx, queries, productID, salePeriod, product.Stock); err != nil {
+			slog.Error("error updating product stock", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	after, err := productForAudit(ctx, queries, productID)
+	if err != nil {
+		slog.Error("error fetching updated product", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	action := "update"
+	if before == nil {
+		action = "create"
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditProduct, productID, action, before, after); err != nil {
+		slog.Error("error recording product change", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting product", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	if err := json.NewEncoder(w).Encode(product); err != nil {
 		slog.Error("error writing update product response", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-func dbProductsToProducts(dbProducts []db.Product, includeDisabled bool) ([]Product, error) {
+func dbProductsToProducts(dbProducts []db.Product, dbStock []db.ProductStock, includeDisabled bool) ([]Product, error) {
 	products := make([]Product, 0, len(dbProducts))
 	for _, p := range dbProducts {
 		var variants []ProductVariant
@@ -185,10 +252,25 @@ func dbProductsToProducts(dbProducts []db.Product, includeDisabled bool) ([]Prod
 			DefaultImageURL: p.DefaultImageUrl,
 			ImageURLs:       imageURLs,
 			SalePeriod:      int(p.SalePeriod),
+			Stock:           make([]ProductStock, 0),
 		}
 		if includeDisabled {
 			product.Enabled = &p.Enabled
 		}
+		for _, v := range dbStock {
+			if v.ProductID != p.ProductID {
+				continue
+			}
+			stock := ProductStock{
+				Variant:   v.Variant,
+				Available: int(v.Available),
+			}
+			if includeDisabled {
+				reserved := int(v.Reserved)
+				stock.Reserved = &reserved
+			}
+			product.Stock = append(product.Stock, stock)
+		}
 		products = append(products, product)
 	}
 	return products, nil
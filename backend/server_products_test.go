@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSaveProduct(t *testing.T) {
+	tests := []struct {
+		name string
+		body any
+		code int
+		want string
+	}{
+		{"product", testShirt, http.StatusOK, `"id":"1"`},
+		{"without variants", testSticker, http.StatusOK, `"id":"1"`},
+		{"not json", "not json", http.StatusBadRequest, "Invalid Body"},
+		{"missing enabled", Product{Name: "Shirt"}, http.StatusBadRequest, "Invalid Body"},
+		{"invalid product ID", Product{ID: "abc", Name: "Shirt", Enabled: ptr(true)}, http.StatusBadRequest, "Invalid product ID"},
+		{"unknown product ID", Product{ID: "5", Name: "Shirt", Enabled: ptr(true)}, http.StatusNotFound, "Invalid Product ID"},
+		{"stock of unknown variant", withStock(testShirt, ProductStock{Variant: "XL", Available: 1}), http.StatusBadRequest, `invalid variant "XL"`},
+		{"stock set twice", withStock(testShirt, ProductStock{Variant: "S", Available: 1}, ProductStock{Variant: "S", Available: 2}), http.StatusBadRequest, "multiple times"},
+		{"negative stock", withStock(testShirt, ProductStock{Variant: "M", Available: -1}), http.StatusBadRequest, "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			expectResponse(t, ts.request("POST", "/api/v0/sales/1/products", tt.body, true), tt.code, tt.want)
+		})
+	}
+}
+
+func TestProducts(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	disabled := testSticker
+	disabled.Enabled = ptr(false)
+	ts.createProduct(disabled)
+	ts.checkout(testCheckoutRequest(shirtItem("1", "S", 1)))
+
+	tests := []struct {
+		name      string
+		target    string
+		admin     bool
+		wantNames []string
+	}{
+		{"public", "/api/v0/sales/current/products", false, []string{"Shirt"}},
+		{"include disabled", "/api/v0/sales/1/products?include_disabled=1", true, []string{"Shirt", "Sticker"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp ProductsResponse
+			ts.requestOK("GET", tt.target, nil, tt.admin, &resp)
+			if len(resp.Products) != len(tt.wantNames) {
+				t.Fatalf("got %d products, want %v", len(resp.Products), tt.wantNames)
+			}
+			for i, product := range resp.Products {
+				if product.Name != tt.wantNames[i] {
+					t.Errorf("got product %q, want %q", product.Name, tt.wantNames[i])
+				}
+			}
+			shirt := resp.Products[0]
+			if len(shirt.Stock) != 1 || shirt.Stock[0].Variant != "S" || shirt.Stock[0].Available != 1 {
+				t.Fatalf("got stock %+v, want 1 of size S available", shirt.Stock)
+			}
+			if (shirt.Stock[0].Reserved != nil) != tt.admin || (shirt.Enabled != nil) != tt.admin {
+				t.Errorf("got reserved stock %v and enabled %v, want them to be only shown to admins", shirt.Stock[0].Reserved, shirt.Enabled)
+			}
+			if tt.admin && *shirt.Stock[0].Reserved != 1 {
+				t.Errorf("got %d reserved, want 1", *shirt.Stock[0].Reserved)
+			}
+		})
+	}
+}
+
+func TestSaveProductUpdatesStock(t *testing.T) {
+	ts := newTestServer(t)
+	shirt := ts.createProduct(testShirt)
+	shirt.Stock = []ProductStock{{Variant: "M", Available: 5}}
+	ts.createProduct(shirt)
+	if available, _ := ts.stock("1", "M"); available != 5 {
+		t.Errorf("got %d of size M available, want 5", available)
+	}
+	// Size S is no longer tracked and can be bought without limit.
+	ts.checkout(testCheckoutRequest(shirtItem("1", "S", 10)))
+
+	// Leaving out the stock keeps the existing stock.
+	shirt.Stock = nil
+	ts.createProduct(shirt)
+	if available, _ := ts.stock("1", "M"); available != 5 {
+		t.Errorf("got %d of size M available after saving without stock, want 5", available)
+	}
+}
+
+func withStock(product Product, stock ...ProductStock) Product {
+	product.Stock = stock
+	return product
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+type RefundStatus string
+
+const (
+	RefundStatusNone    RefundStatus = "none"
+	RefundStatusPartial RefundStatus = "partial"
+	RefundStatusFull    RefundStatus = "full"
+)
+
+type OrderRefund struct {
+	Amount     int       `json:"amount"`
+	Reason     string    `json:"reason"`
+	RefundTime time.Time `json:"refundTime"`
+
+	// Admin fields.
+	AdminEmail string `json:"adminEmail,omitempty"`
+}
+
+type RefundRequest struct {
+	Reason string       `json:"reason"`
+	Items  []RefundItem `json:"items"`
+}
+
+type RefundItem struct {
+	// ID is the ID of the order item being refunded.
+	ID     int64 `json:"id"`
+	Amount int   `json:"amount"`
+}
+
+// OrderRefund partially refunds a paid order by line item. The order itself
+// remains valid for collection of the remaining items.
+func (s *Server) OrderRefund(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	var refundReq RefundRequest
+	if err := json.NewDecoder(req.Body).Decode(&refundReq); err != nil {
+		slog.Error("error parsing request", "err", err)
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	if len(refundReq.Items) == 0 {
+		http.Error(w, "No items to refund", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	orderID := req.PathValue("id")
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for order refund", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	order, err := queries.OrderByID(ctx, orderID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Invalid order ID", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("error looking up order", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !order.PaymentTime.Valid || order.Cancelled {
+		http.Error(w, "Only paid orders that are not cancelled can be refunded", http.StatusBadRequest)
+		return
+	}
+	items, err := queries.ListOrderItems(ctx, orderID)
+	if err != nil {
+		slog.Error("error looking up order items", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	paid, err := orderPaidAmounts(ctx, queries, order, items)
+	if err != nil {
+		slog.Error("error looking up order discount", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var amount int64
+	for _, v := range refundReq.Items {
+		idx := slices.IndexFunc(items, func(item db.OrderItem) bool {
+			return item.ID == v.ID
+		})
+		if idx < 0 || v.Amount <= 0 {
+			http.Error(w, "Invalid item to refund", http.StatusBadRequest)
+			return
+		}
+		refunded, err := queries.RefundOrderItem(ctx, db.RefundOrderItemParams{
+			Amount:  int64(v.Amount),
+			ID:      v.ID,
+			OrderID: orderID,
+		})
+		if err != nil {
+			slog.Error("error marking order item as refunded", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if refunded == 0 {
+			http.Error(w, "Cannot refund more than the ordered amount of "+items[idx].ProductName, http.StatusBadRequest)
+			return
+		}
+		if err := restockItem(ctx, queries, items[idx], int64(v.Amount)); err != nil {
+			slog.Error("error restocking refunded item", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		amount += lineRefundAmount(paid[idx], int64(v.Amount), items[idx].Amount)
+	}
+	var refund *PaymentRefund
+	if amount > 0 {
+		refund, err = s.issueRefund(ctx, queries, order, &amount, refundReq.Reason, sessionUser(req))
+		if err != nil {
+			slog.Error("error refunding order", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	err = recordAudit(ctx, queries, sessionUser(req), auditOrder, orderID, "refund", nil, auditedRefund{
+		Items:  refundReq.Items,
+		Amount: amount,
+		Reason: refundReq.Reason,
+	})
+	if err != nil {
+		slog.Error("error recording order refund", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting order refund", "err", err, "order_id", orderID, "refund", refund)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refundRemaining refunds everything in the paid order that has not been
+// refunded yet. The returned refund is nil if there is nothing to refund.
+func (s *Server) refundRemaining(ctx context.Context, queries *db.Queries, orderID string, reason string, adminEmail string) (*PaymentRefund, error) {
+	order, err := queries.OrderByID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up order: %w", err)
+	}
+	items, err := queries.ListOrderItems(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up order items: %w", err)
+	}
+	paid, err := orderPaidAmounts(ctx, queries, order, items)
+	if err != nil {
+		return nil, err
+	}
+	if err := queries.RefundAllOrderItems(ctx, orderID); err != nil {
+		return nil, fmt.Errorf("error marking order items as refunded: %w", err)
+	}
+	fullyRefunded := !slices.ContainsFunc(items, func(item db.OrderItem) bool {
+		return item.RefundedAmount < item.Amount
+	})
+	if fullyRefunded || !slices.ContainsFunc(paid, func(v int64) bool { return v > 0 }) {
+		return nil, nil
+	}
+	return s.issueRefund(ctx, queries, order, nil, reason, adminEmail)
+}
+
+// issueRefund refunds the order through the payment provider and records it.
+// If amount is nil, everything that has not been refunded is refunded. As the
+// refund cannot be undone, it should be the last step before the transaction
+// is committed.
+func (s *Server) issueRefund(ctx context.Context, queries *db.Queries, order db.Order, amount *int64, reason string, adminEmail string) (*PaymentRefund, error) {
+	refund, err := s.Payment.Refund(order.PaymentReference.String, amount, reason)
+	if err != nil {
+		return nil, fmt.Errorf("error issuing refund: %w", err)
+	}
+	if err := queries.CreateOrderRefund(ctx, db.CreateOrderRefundParams{
+		OrderID:          order.OrderID,
+		Amount:           refund.Amount,
+		Reason:           reason,
+		RefundTime:       time.Now(),
+		AdminEmail:       adminEmail,
+		PaymentReference: refund.ID,
+	}); err != nil {
+		slog.Error("refund was issued but could not be recorded", "order_id", order.OrderID, "refund_id", refund.ID)
+		return nil, fmt.Errorf("error recording refund: %w", err)
+	}
+	return refund, nil
+}
+
+// orderPaidAmounts returns the amount paid for each of the items in the order
+// after its coupon is applied.
+func orderPaidAmounts(ctx context.Context, queries *db.Queries, order db.Order, items []db.OrderItem) ([]int64, error) {
+	discounts := make([]int64, len(items))
+	if order.CouponID.Valid {
+		coupon, err := queries.CouponByID(ctx, order.CouponID.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up coupon: %w", err)
+		}
+		discount, err := parseCouponDiscount(coupon.Discount)
+		if err != nil {
+			return nil, err
+		}
+		discounts = discount.itemDiscounts(items)
+	}
+	paid := make([]int64, len(items))
+	for i, item := range items {
+		paid[i] = item.UnitPrice*item.Amount - discounts[i]
+	}
+	return paid, nil
+}
+
+// lineRefundAmount returns the amount paid for the given units of an order
+// item that was paid in total for the ordered units. It is rounded down so that
+// refunds of individual lines never add up to more than what was paid.
+func lineRefundAmount(paid int64, amount int64, ordered int64) int64 {
+	return paid * amount / ordered
+}
+
+func refundStatus(items []db.OrderItem) RefundStatus {
+	var amount, refunded int64
+	for _, item := range items {
+		amount += item.Amount
+		refunded += item.RefundedAmount
+	}
+	switch {
+	case refunded == 0:
+		return RefundStatusNone
+	case refunded < amount:
+		return RefundStatusPartial
+	default:
+		return RefundStatusFull
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// Role is the role of an admin user, which decides what they are allowed to
+// do.
+type Role string
+
+const (
+	// RoleOwner can do everything, including managing other admins.
+	RoleOwner Role = "owner"
+	// RoleMerchManager runs the store but cannot manage other admins.
+	RoleMerchManager Role = "merch_manager"
+	// RoleCollector hands out merch at the collection booth.
+	RoleCollector Role = "collector"
+	// RoleViewer can only look at orders and the store setup.
+	RoleViewer Role = "viewer"
+)
+
+// Permission is an action that admin handlers are guarded by.
+type Permission string
+
+const (
+	// PermViewOrders allows viewing orders, sales and the store setup.
+	PermViewOrders Permission = "view_orders"
+	// PermCollectOrders allows marking orders as collected.
+	PermCollectOrders Permission = "collect_orders"
+	// PermManageOrders allows cancelling and refunding orders.
+	PermManageOrders Permission = "manage_orders"
+	// PermEditStore allows editing products, coupons, sale periods and store
+	// closures.
+	PermEditStore Permission = "edit_store"
+	// PermManageUsers allows adding and removing admins and changing roles.
+	PermManageUsers Permission = "manage_users"
+	// PermViewAuditLog allows viewing the changes made by every admin.
+	PermViewAuditLog Permission = "view_audit_log"
+	// PermDownloadBackup allows downloading a backup of the whole database,
+	// including the personal details of every buyer.
+	PermDownloadBackup Permission = "download_backup"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner:        {PermViewOrders, PermCollectOrders, PermManageOrders, PermEditStore, PermManageUsers, PermViewAuditLog, PermDownloadBackup},
+	RoleMerchManager: {PermViewOrders, PermCollectOrders, PermManageOrders, PermEditStore},
+	RoleCollector:    {PermViewOrders, PermCollectOrders},
+	RoleViewer:       {PermViewOrders},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+func (r Role) Has(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// adminSession is the admin that a request is made by.
+type adminSession struct {
+	db.AdminUser
+	// Token is the API token that the request was authenticated with, or nil
+	// if the session cookie was used.
+	Token *db.ApiToken
+}
+
+// Permissions returns what the admin is allowed to do. Requests made with an
+// API token are limited to the scope of the token as well.
+func (a adminSession) Permissions() []Permission {
+	perms := rolePermissions[Role(a.Role)]
+	if a.Token == nil {
+		return perms
+	}
+	scope := parsePermissions(a.Token.Permissions)
+	return slices.DeleteFunc(slices.Clone(perms), func(perm Permission) bool {
+		return !slices.Contains(scope, perm)
+	})
+}
+
+func (a adminSession) Has(perm Permission) bool {
+	return slices.Contains(a.Permissions(), perm)
+}
+
+type adminUserContextKey struct{}
+
+// withPermission only calls the handler if the logged in admin has the
+// permission.
+func (s *Server) withPermission(perm Permission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		admin, ok := s.sessionAdmin(w, req)
+		if !ok {
+			return
+		}
+		if !admin.Has(perm) {
+			http.Error(w, "Insufficient permissions", http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(req.Context(), adminUserContextKey{}, admin)
+		handler(w, req.WithContext(ctx))
+	}
+}
+
+// sessionAdmin returns the admin user that is logged in, either with the
+// session cookie or an API token. If the user is not logged in or is no longer
+// an admin, an error is written and ok is false.
+func (s *Server) sessionAdmin(w http.ResponseWriter, req *http.Request) (admin adminSession, ok bool) {
+	if admin, ok := req.Context().Value(adminUserContextKey{}).(adminSession); ok {
+		return admin, true
+	}
+	email := sessionUser(req)
+	if token, ok := bearerToken(req); ok {
+		apiToken, err := s.apiToken(req.Context(), token)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "Invalid API token", http.StatusUnauthorized)
+			return adminSession{}, false
+		case err != nil:
+			slog.Error("error looking up API token", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return adminSession{}, false
+		}
+		admin.Token = &apiToken
+		email = apiToken.AdminEmail
+	}
+	if email == "" {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return adminSession{}, false
+	}
+	user, err := s.Queries.AuthAdminUser(req.Context(), email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return adminSession{}, false
+	case err != nil:
+		slog.Error("error looking up admin user", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return adminSession{}, false
+	}
+	admin.AdminUser = user
+	return admin, true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+// createAdmin adds an admin user with the role and returns their session.
+func (ts *testServer) createAdmin(email string, role Role) []*http.Cookie {
+	ts.t.Helper()
+	if err := ts.Queries.CreateAdminUser(context.Background(), db.CreateAdminUserParams{
+		Email: email,
+		Role:  string(role),
+	}); err != nil {
+		ts.t.Fatalf("error creating admin user: %v", err)
+	}
+	return sessionCookies(ts.t, email)
+}
+
+func TestRolePermissions(t *testing.T) {
+	routes := []struct {
+		method string
+		target string
+		perm   Permission
+	}{
+		{"GET", "/api/v0/perm_check", PermViewOrders},
+		{"GET", "/api/v0/sales", PermViewOrders},
+		{"GET", "/api/v0/closures", PermViewOrders},
+		{"GET", "/api/v0/sales/1/order_summary", PermViewOrders},
+		{"GET", "/api/v0/sales/1/orders/export", PermViewOrders},
+		{"POST", "/api/v0/orders/CD0000/collect", PermCollectOrders},
+		{"POST", "/api/v0/orders/CD0000/cancel", PermManageOrders},
+		{"POST", "/api/v0/orders/CD0000/refund", PermManageOrders},
+		{"POST", "/api/v0/sales/1/products", PermEditStore},
+		{"POST", "/api/v0/sales/1/coupons", PermEditStore},
+		{"POST", "/api/v0/image_upload", PermEditStore},
+		{"POST", "/api/v0/sales", PermEditStore},
+		{"POST", "/api/v0/sales/1/clone", PermEditStore},
+		{"DELETE", "/api/v0/sales/1", PermEditStore},
+		{"POST", "/api/v0/sales/1/restore", PermEditStore},
+		{"POST", "/api/v0/closures", PermEditStore},
+		{"DELETE", "/api/v0/closures/1", PermEditStore},
+		{"GET", "/api/v0/users", PermManageUsers},
+		{"POST", "/api/v0/users", PermManageUsers},
+		{"DELETE", "/api/v0/users", PermManageUsers},
+		{"GET", "/api/v0/audit_log", PermViewAuditLog},
+		{"GET", "/api/v0/backup", PermDownloadBackup},
+		{"GET", "/api/v0/tokens", PermViewOrders},
+		{"POST", "/api/v0/tokens", PermViewOrders},
+		{"DELETE", "/api/v0/tokens/1", PermViewOrders},
+	}
+	ts := newTestServer(t)
+	for _, role := range []Role{RoleOwner, RoleMerchManager, RoleCollector, RoleViewer} {
+		cookies := ts.createAdmin(string(role)+"@e.ntu.edu.sg", role)
+		for _, route := range routes {
+			t.Run(string(role)+" "+route.method+" "+route.target, func(t *testing.T) {
+				rec := ts.requestWithCookies(route.method, route.target, "not json", cookies)
+				forbidden := rec.Code == http.StatusForbidden
+				if want := !role.Has(route.perm); forbidden != want {
+					t.Errorf("got status %d, want forbidden %t (body: %q)", rec.Code, want, rec.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestRoleHierarchy(t *testing.T) {
+	// Every role should be able to do everything the roles below it can do.
+	roles := []Role{RoleViewer, RoleCollector, RoleMerchManager, RoleOwner}
+	for i := 1; i < len(roles); i++ {
+		for _, perm := range rolePermissions[roles[i-1]] {
+			if !roles[i].Has(perm) {
+				t.Errorf("%s cannot %s but %s can", roles[i], perm, roles[i-1])
+			}
+		}
+	}
+}
+
+func TestRemovedAdminLosesAccess(t *testing.T) {
+	ts := newTestServer(t)
+	cookies := ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+	expectResponse(t, ts.requestWithCookies("GET", "/api/v0/perm_check", nil, cookies), http.StatusNoContent, "")
+	ts.requestOK("DELETE", "/api/v0/users", User{Email: "helper@e.ntu.edu.sg"}, true, nil)
+	expectResponse(t, ts.requestWithCookies("GET", "/api/v0/perm_check", nil, cookies), http.StatusUnauthorized, "User not authenticated")
+	// Admin views of public routes should also be refused.
+	expectResponse(t, ts.requestWithCookies("GET", "/api/v0/sales/1/products?include_disabled=1", nil, cookies), http.StatusUnauthorized, "User not authenticated")
+}
+
+func TestCurrentAdminUser(t *testing.T) {
+	ts := newTestServer(t)
+	cookies := ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+	rec := ts.requestWithCookies("GET", "/api/v0/users/me", nil, cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", rec.Code)
+	}
+	var resp CurrentUserResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	want := []Permission{PermViewOrders, PermCollectOrders}
+	if resp.Email != "helper@e.ntu.edu.sg" || resp.Role != RoleCollector || !slices.Equal(resp.Permissions, want) {
+		t.Errorf("got current user %+v, want collector with permissions %v", resp, want)
+	}
+	expectResponse(t, ts.request("GET", "/api/v0/users/me", nil, false), http.StatusUnauthorized, "User not authenticated")
+}
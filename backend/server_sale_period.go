@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -17,16 +20,23 @@ type SalePeriodsResponse struct {
 }
 
 type SalePeriod struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	StartTime time.Time `json:"start_time"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	// ClosedMessage is shown to users after EndTime until the next sale
+	// period starts.
+	ClosedMessage string `json:"closed_message"`
+	// DeleteTime is set if the sale period is archived.
+	DeleteTime *time.Time `json:"delete_time,omitempty"`
 }
 
 func (s *Server) SalePeriods(w http.ResponseWriter, req *http.Request) {
 	if !s.authCheck(w, req) {
 		return
 	}
-	dbSalePeriods, err := s.Queries.ListSalePeriods(req.Context())
+	archived := req.URL.Query().Get("archived") != ""
+	dbSalePeriods, err := s.Queries.ListSalePeriods(req.Context(), archived)
 	if err != nil {
 		slog.Error("error fetching sale periods", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -34,10 +44,20 @@ func (s *Server) SalePeriods(w http.ResponseWriter, req *http.Request) {
 	}
 	salePeriods := make([]SalePeriod, 0, len(dbSalePeriods))
 	for _, v := range dbSalePeriods {
+		var endTime, deleteTime *time.Time
+		if v.EndTime.Valid {
+			endTime = &v.EndTime.Time
+		}
+		if v.DeleteTime.Valid {
+			deleteTime = &v.DeleteTime.Time
+		}
 		salePeriods = append(salePeriods, SalePeriod{
-			ID:        strconv.Itoa(int(v.ID)),
-			Name:      v.AdminName,
-			StartTime: v.StartTime,
+			ID:            strconv.Itoa(int(v.ID)),
+			Name:          v.AdminName,
+			StartTime:     v.StartTime,
+			EndTime:       endTime,
+			ClosedMessage: v.ClosedMessage,
+			DeleteTime:    deleteTime,
 		})
 	}
 	if err := json.NewEncoder(w).Encode(SalePeriodsResponse{
@@ -59,40 +79,541 @@ func (s *Server) SaveSalePeriod(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Invalid Body", http.StatusBadRequest)
 		return
 	}
+	endTime, err := normalizeSalePeriodTimes(&salePeriod)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	var before any
+	var periodID int64
 	var sqlErr error
 	switch salePeriod.ID {
 	case "":
 		// Create new product.
-		var newID int64
-		newID, sqlErr = s.Queries.CreateSalePeriod(ctx, db.CreateSalePeriodParams{
-			AdminName: salePeriod.Name,
-			StartTime: salePeriod.StartTime,
+		periodID, sqlErr = queries.CreateSalePeriod(ctx, db.CreateSalePeriodParams{
+			AdminName:     salePeriod.Name,
+			StartTime:     salePeriod.StartTime,
+			EndTime:       endTime,
+			ClosedMessage: salePeriod.ClosedMessage,
 		})
-		salePeriod.ID = strconv.Itoa(int(newID))
+		salePeriod.ID = strconv.Itoa(int(periodID))
 	default:
 		// Update existing ID.
 		id, err := strconv.Atoi(salePeriod.ID)
 		if err != nil {
-			http.Error(w, "Invalid product ID", http.StatusBadRequest)
+			http.Error(w, "Invalid sale period ID", http.StatusBadRequest)
 			return
 		}
-		sqlErr = s.Queries.UpdateSalePeriod(ctx, db.UpdateSalePeriodParams{
-			ID:        int64(id),
-			AdminName: salePeriod.Name,
-			StartTime: salePeriod.StartTime,
+		periodID = int64(id)
+		before, sqlErr = queries.SalePeriodByID(ctx, periodID)
+		if sqlErr != nil {
+			break
+		}
+		var rows int64
+		rows, sqlErr = queries.UpdateSalePeriod(ctx, db.UpdateSalePeriodParams{
+			ID:            periodID,
+			AdminName:     salePeriod.Name,
+			StartTime:     salePeriod.StartTime,
+			EndTime:       endTime,
+			ClosedMessage: salePeriod.ClosedMessage,
 		})
+		if sqlErr == nil && rows == 0 {
+			// The sale period has been deleted.
+			sqlErr = sql.ErrNoRows
+		}
 	}
 	switch {
 	case errors.Is(sqlErr, sql.ErrNoRows):
-		http.Error(w, "Invalid Product ID", http.StatusBadRequest)
+		http.Error(w, "Invalid Sale Period ID", http.StatusNotFound)
 		return
 	case sqlErr != nil:
 		slog.Error("error updating sale period", "err", sqlErr)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	after, err := queries.SalePeriodByID(ctx, periodID)
+	if err != nil {
+		slog.Error("error fetching updated sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	action := "update"
+	if before == nil {
+		action = "create"
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditSalePeriod, periodID, action, before, after); err != nil {
+		slog.Error("error recording sale period change", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	if err := json.NewEncoder(w).Encode(salePeriod); err != nil {
 		slog.Error("error writing update sale period response", "err", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// DeleteSalePeriod archives the sale period. Sale periods with paid orders that
+// have not been collected are only archived if forced.
+func (s *Server) DeleteSalePeriod(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	ctx := req.Context()
+	id, err := strconv.ParseInt(req.PathValue("sale_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sales period", http.StatusBadRequest)
+		return
+	}
+	force := req.URL.Query().Get("force") != ""
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for sale period deletion", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, err := queries.SalePeriodByID(ctx, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Invalid sales period", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("error fetching sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !force {
+		uncollected, err := queries.CountUncollectedOrders(ctx, id)
+		if err != nil {
+			slog.Error("error counting uncollected orders", "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if uncollected > 0 {
+			http.Error(w, fmt.Sprintf("Sale period has %d paid orders that have not been collected", uncollected), http.StatusConflict)
+			return
+		}
+	}
+	deleted, err := queries.DeleteSalePeriod(ctx, db.DeleteSalePeriodParams{
+		DeleteTime: sql.NullTime{
+			Time:  time.Now().UTC(),
+			Valid: true,
+		},
+		ID: id,
+	})
+	switch {
+	case err != nil:
+		slog.Error("error deleting sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	case deleted == 0:
+		http.Error(w, "Invalid sales period", http.StatusNotFound)
+		return
+	}
+	if err := recordSalePeriodAudit(ctx, queries, sessionUser(req), "delete", before); err != nil {
+		slog.Error("error recording sale period deletion", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting sale period deletion", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreSalePeriod restores an archived sale period.
+func (s *Server) RestoreSalePeriod(w http.ResponseWriter, req *http.Request) {
+	if !s.authCheck(w, req) {
+		return
+	}
+	id, err := strconv.ParseInt(req.PathValue("sale_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sales period", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for sale period restoration", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, err := queries.SalePeriodByID(ctx, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Invalid sales period", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("error fetching sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	restored, err := queries.RestoreSalePeriod(ctx, id)
+	switch {
+	case err != nil:
+		slog.Error("error restoring sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	case restored == 0:
+		http.Error(w, "Invalid sales period", http.StatusNotFound)
+		return
+	}
+	if err := recordSalePeriodAudit(ctx, queries, sessionUser(req), "restore", before); err != nil {
+		slog.Error("error recording sale period restoration", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting sale period restoration", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordSalePeriodAudit records the change to the sale period since before.
+func recordSalePeriodAudit(ctx context.Context, queries *db.Queries, actor string, action string, before db.SalePeriod) error {
+	after, err := queries.SalePeriodByID(ctx, before.ID)
+	if err != nil {
+		return fmt.Errorf("error fetching updated sale period: %w", err)
+	}
+	return recordAudit(ctx, queries, actor, auditSalePeriod, before.ID, action, before, after)
+}
+
+type CloneSalePeriodRequest struct {
+	// SalePeriod is the new sale period to create. If the name is empty, it is
+	// named after the sale period being cloned.
+	SalePeriod
+	// SkipDisabled skips products and coupons that are disabled.
+	SkipDisabled bool `json:"skip_disabled"`
+	// NewStripeCoupons creates a new coupon on the payment provider for every
+	// enabled coupon instead of sharing them between the sale periods.
+	NewStripeCoupons bool `json:"new_stripe_coupons"`
+}
+
+type CloneSalePeriodResponse struct {
+	Period       SalePeriod `json:"period"`
+	ProductCount int        `json:"product_count"`
+	CouponCount  int        `json:"coupon_count"`
+}
+
+// CloneSalePeriod creates a new sale period with the products and coupons of
+// an existing one, which may be archived. The available stock of products is
+// carried over as well.
+func (s *Server) CloneSalePeriod(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if !s.authCheck(w, req) {
+		return
+	}
+	sourceID, ok := s.resolveSalePeriod(w, req, req.PathValue("sale_id"))
+	if !ok {
+		return
+	}
+	var cloneReq CloneSalePeriodRequest
+	if err := json.NewDecoder(req.Body).Decode(&cloneReq); err != nil {
+		slog.Error("error parsing request", "err", err)
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	salePeriod := cloneReq.SalePeriod
+	if salePeriod.StartTime.IsZero() {
+		http.Error(w, "Invalid start time", http.StatusBadRequest)
+		return
+	}
+	endTime, err := normalizeSalePeriodTimes(&salePeriod)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for sale period clone", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	source, err := queries.SalePeriodByID(ctx, sourceID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Invalid sales period", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("error fetching sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if salePeriod.Name == "" {
+		salePeriod.Name = source.AdminName + " (Copy)"
+	}
+	products, err := queries.ListProducts(ctx, db.ListProductsParams{
+		IncludeDisabled: !cloneReq.SkipDisabled,
+		SalePeriod:      sourceID,
+	})
+	if err != nil {
+		slog.Error("error fetching products", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	stock, err := queries.ListProductStock(ctx, sourceID)
+	if err != nil {
+		slog.Error("error fetching product stock", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	coupons, err := queries.ListCoupons(ctx, sourceID)
+	if err != nil {
+		slog.Error("error fetching coupons", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if cloneReq.SkipDisabled {
+		coupons = slices.DeleteFunc(coupons, func(c db.Coupon) bool {
+			return !c.Enabled
+		})
+	}
+	// Coupons limited to products are limited to the cloned products instead,
+	// which the payment provider coupon does not know about. They are cloned
+	// disabled and get a new payment provider coupon when they are enabled.
+	discounts := make(map[int64]couponDiscount, len(coupons))
+	// Product requirements are also moved to the cloned products.
+	requirements := make(map[int64][]couponRequirement, len(coupons))
+	for i, coupon := range coupons {
+		discount, err := parseCouponDiscount(coupon.Discount)
+		if err != nil {
+			slog.Error("error parsing coupon discount", "coupon_id", coupon.CouponID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		var stored []couponRequirement
+		if err := json.Unmarshal([]byte(coupon.Requirements), &stored); err != nil {
+			slog.Error("error parsing coupon requirements", "coupon_id", coupon.CouponID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if slices.ContainsFunc(stored, func(r couponRequirement) bool {
+			return r.Type == requirementProduct && !slices.ContainsFunc(products, func(p db.Product) bool {
+				return strconv.FormatInt(p.ProductID, 10) == r.Value
+			})
+		}) {
+			// The requirement could never be met without the product.
+			continue
+		}
+		requirements[coupon.CouponID] = stored
+		if len(discount.Products) == 0 {
+			discounts[coupon.CouponID] = discount
+			continue
+		}
+		discount.Products = slices.DeleteFunc(discount.Products, func(id string) bool {
+			return !slices.ContainsFunc(products, func(p db.Product) bool {
+				return strconv.FormatInt(p.ProductID, 10) == id
+			})
+		})
+		if len(discount.Products) == 0 {
+			// None of its products are cloned, so it is not cloned either.
+			continue
+		}
+		discounts[coupon.CouponID] = discount
+		coupons[i].Enabled = false
+		coupons[i].StripeID = ""
+	}
+	coupons = slices.DeleteFunc(coupons, func(c db.Coupon) bool {
+		_, ok := discounts[c.CouponID]
+		return !ok
+	})
+	if cloneReq.NewStripeCoupons {
+		// Disabled coupons get a new payment provider coupon when they are
+		// enabled. The rest are created before anything is written so that
+		// the database is not locked while waiting on the payment provider.
+		for i, coupon := range coupons {
+			if !coupon.Enabled || coupon.StripeID == "" {
+				coupons[i].StripeID = ""
+				continue
+			}
+			name, err := s.Payment.CouponName(coupon.StripeID)
+			if err != nil {
+				slog.Error("error fetching payment provider coupon", "stripe_id", coupon.StripeID, "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			paymentDiscount, err := discounts[coupon.CouponID].paymentDiscount(nil)
+			if err != nil {
+				slog.Error("error converting coupon discount", "coupon_id", coupon.CouponID, "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			stripeID, err := s.Payment.UpsertCoupon("", name, paymentDiscount)
+			if err != nil {
+				slog.Error("error creating payment provider coupon", "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			coupons[i].StripeID = stripeID
+		}
+	}
+
+	newID, err := queries.CreateSalePeriod(ctx, db.CreateSalePeriodParams{
+		AdminName:     salePeriod.Name,
+		StartTime:     salePeriod.StartTime,
+		EndTime:       endTime,
+		ClosedMessage: salePeriod.ClosedMessage,
+	})
+	if err != nil {
+		slog.Error("error creating sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	salePeriod.ID = strconv.Itoa(int(newID))
+	// clonedProductIDs maps the IDs of the products to the IDs of their clones.
+	clonedProductIDs := make(map[string]string, len(products))
+	for _, product := range products {
+		productID, err := queries.CreateProduct(ctx, db.CreateProductParams{
+			Name:             product.Name,
+			BasePrice:        product.BasePrice,
+			DefaultImageUrl:  product.DefaultImageUrl,
+			Variants:         product.Variants,
+			VariantImageUrls: product.VariantImageUrls,
+			Enabled:          product.Enabled,
+			SalePeriod:       newID,
+		})
+		if err != nil {
+			slog.Error("error cloning product", "product_id", product.ProductID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		clonedProductIDs[strconv.FormatInt(product.ProductID, 10)] = strconv.FormatInt(productID, 10)
+		for _, v := range stock {
+			if v.ProductID != product.ProductID {
+				continue
+			}
+			if err := queries.SetProductStock(ctx, db.SetProductStockParams{
+				ProductID: productID,
+				Variant:   v.Variant,
+				Available: v.Available,
+			}); err != nil {
+				slog.Error("error cloning product stock", "product_id", product.ProductID, "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	for _, coupon := range coupons {
+		discount := discounts[coupon.CouponID]
+		for i, id := range discount.Products {
+			discount.Products[i] = clonedProductIDs[id]
+		}
+		marshalledDiscount, err := json.Marshal(discount)
+		if err != nil {
+			slog.Error("error marshalling coupon discount", "coupon_id", coupon.CouponID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		stored := requirements[coupon.CouponID]
+		for i, r := range stored {
+			if r.Type == requirementProduct {
+				stored[i].Value = clonedProductIDs[r.Value]
+			}
+		}
+		marshalledRequirements, err := json.Marshal(stored)
+		if err != nil {
+			slog.Error("error marshalling coupon requirements", "coupon_id", coupon.CouponID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := queries.CreateCoupon(ctx, db.CreateCouponParams{
+			StripeID:                coupon.StripeID,
+			CouponCode:              coupon.CouponCode,
+			MinPurchaseQuantity:     coupon.MinPurchaseQuantity,
+			EmailMatch:              coupon.EmailMatch,
+			Requirements:            string(marshalledRequirements),
+			Discount:                string(marshalledDiscount),
+			Enabled:                 coupon.Enabled,
+			Public:                  coupon.Public,
+			RedemptionLimit:         coupon.RedemptionLimit,
+			CustomerRedemptionLimit: coupon.CustomerRedemptionLimit,
+			ValidFrom:               coupon.ValidFrom,
+			ValidUntil:              coupon.ValidUntil,
+			SalePeriod:              newID,
+		}); err != nil {
+			slog.Error("error cloning coupon", "coupon_id", coupon.CouponID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	clone, err := queries.SalePeriodByID(ctx, newID)
+	if err != nil {
+		slog.Error("error fetching cloned sale period", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = recordAudit(ctx, queries, sessionUser(req), auditSalePeriod, newID, "clone", nil, auditedClone{
+		SalePeriod:   clone,
+		ClonedFrom:   sourceID,
+		ProductCount: len(products),
+		CouponCount:  len(coupons),
+	})
+	if err != nil {
+		slog.Error("error recording sale period clone", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting sale period clone", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(CloneSalePeriodResponse{
+		Period:       salePeriod,
+		ProductCount: len(products),
+		CouponCount:  len(coupons),
+	}); err != nil {
+		slog.Error("error writing clone sale period response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// normalizeSalePeriodTimes validates the times of the sale period and converts
+// them to UTC, as times are compared as strings in SQLite. The end time is
+// returned for storing in the database.
+func normalizeSalePeriodTimes(salePeriod *SalePeriod) (sql.NullTime, error) {
+	salePeriod.StartTime = salePeriod.StartTime.UTC()
+	if salePeriod.EndTime == nil {
+		return sql.NullTime{}, nil
+	}
+	if !salePeriod.EndTime.After(salePeriod.StartTime) {
+		return sql.NullTime{}, errors.New("End time must be after start time")
+	}
+	endTime := salePeriod.EndTime.UTC()
+	salePeriod.EndTime = &endTime
+	return sql.NullTime{
+		Time:  endTime,
+		Valid: true,
+	}, nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSaveSalePeriod(t *testing.T) {
+	start := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		body any
+		code int
+		want string
+	}{
+		{"sale period", SalePeriod{Name: "Freshmen Orientation", StartTime: start}, http.StatusOK, `"id":"2"`},
+		{"rename", SalePeriod{ID: "1", Name: "Launch", StartTime: start}, http.StatusOK, `"name":"Launch"`},
+		{"end time", SalePeriod{Name: "Recess Week", StartTime: start, EndTime: ptr(start.Add(7 * 24 * time.Hour))}, http.StatusOK, `"end_time":"2025-08-08T00:00:00Z"`},
+		{"end before start", SalePeriod{Name: "Recess Week", StartTime: start, EndTime: ptr(start.Add(-time.Hour))}, http.StatusBadRequest, "End time must be after start time"},
+		{"end at start", SalePeriod{Name: "Recess Week", StartTime: start, EndTime: &start}, http.StatusBadRequest, "End time must be after start time"},
+		{"not json", "not json", http.StatusBadRequest, "Invalid Body"},
+		{"invalid ID", SalePeriod{ID: "abc"}, http.StatusBadRequest, "Invalid sale period ID"},
+		{"unknown ID", SalePeriod{ID: "5", StartTime: start}, http.StatusNotFound, "Invalid Sale Period ID"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			expectResponse(t, ts.request("POST", "/api/v0/sales", tt.body, true), tt.code, tt.want)
+		})
+	}
+}
+
+func TestSalePeriods(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	future := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	ts.requestOK("POST", "/api/v0/sales", SalePeriod{Name: "Next Sale", StartTime: future}, true, nil)
+
+	var resp SalePeriodsResponse
+	ts.requestOK("GET", "/api/v0/sales", nil, true, &resp)
+	if len(resp.Periods) != 2 {
+		t.Fatalf("got %d sale periods, want 2", len(resp.Periods))
+	}
+	names := map[string]string{}
+	for _, period := range resp.Periods {
+		names[period.ID] = period.Name
+	}
+	if names["1"] != "Default" || names["2"] != "Next Sale" {
+		t.Errorf("got sale periods %v, want Default and Next Sale", names)
+	}
+	if got := resp.Periods[1]; got.EndTime != nil || got.ClosedMessage != "" {
+		t.Errorf("got sale period %+v, want no end time", got)
+	}
+	end := future.Add(24 * time.Hour)
+	ts.requestOK("POST", "/api/v0/sales", SalePeriod{ID: "2", Name: "Next Sale", StartTime: future, EndTime: &end, ClosedMessage: "Closed"}, true, nil)
+	ts.requestOK("GET", "/api/v0/sales", nil, true, &resp)
+	if got := resp.Periods[1]; got.EndTime == nil || !got.EndTime.Equal(end) || got.ClosedMessage != "Closed" {
+		t.Errorf("got sale period %+v, want end time %s with closed message", got, end)
+	}
+
+	// The next sale has not started so the current sale should still be used.
+	var products ProductsResponse
+	ts.requestOK("GET", "/api/v0/sales/current/products", nil, false, &products)
+	if len(products.Products) != 1 {
+		t.Errorf("got %d products in the current sale, want 1", len(products.Products))
+	}
+	ts.requestOK("POST", "/api/v0/sales", SalePeriod{ID: "2", Name: "Next Sale", StartTime: future.Add(-48 * time.Hour)}, true, nil)
+	ts.requestOK("GET", "/api/v0/sales/current/products", nil, false, &products)
+	if len(products.Products) != 0 {
+		t.Errorf("got %d products after the next sale started, want 0", len(products.Products))
+	}
+}
+
+func TestCloneSalePeriod(t *testing.T) {
+	start := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name             string
+		req              CloneSalePeriodRequest
+		wantName         string
+		wantProducts     []string
+		wantCoupons      []string
+		wantNewStripeIDs bool
+	}{
+		{
+			name:         "everything",
+			req:          CloneSalePeriodRequest{SalePeriod: SalePeriod{Name: "AY25/26 Sem 1", StartTime: start}},
+			wantName:     "AY25/26 Sem 1",
+			wantProducts: []string{"Shirt", "Sticker"},
+			wantCoupons:  []string{"TEN", "OLD"},
+		},
+		{
+			name:         "skip disabled",
+			req:          CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: start}, SkipDisabled: true},
+			wantName:     "Default (Copy)",
+			wantProducts: []string{"Shirt"},
+			wantCoupons:  []string{"TEN"},
+		},
+		{
+			name:             "new stripe coupons",
+			req:              CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: start}, NewStripeCoupons: true},
+			wantName:         "Default (Copy)",
+			wantProducts:     []string{"Shirt", "Sticker"},
+			wantCoupons:      []string{"TEN", "OLD"},
+			wantNewStripeIDs: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			sticker := testSticker
+			sticker.Enabled = ptr(false)
+			ts.createProduct(sticker)
+			ten := ts.createCoupon("TEN", 10, `{"type":"purchase_count","amount":2}`)
+			old := ts.createCoupon("OLD", 20)
+			old.Enabled = ptr(false)
+			ts.requestOK("POST", "/api/v0/sales/1/coupons", old, true, nil)
+
+			var resp CloneSalePeriodResponse
+			ts.requestOK("POST", "/api/v0/sales/1/clone", tt.req, true, &resp)
+			if resp.Period.ID != "2" || resp.Period.Name != tt.wantName || !resp.Period.StartTime.Equal(start) {
+				t.Errorf("got sale period %+v, want ID 2 named %q", resp.Period, tt.wantName)
+			}
+			if resp.ProductCount != len(tt.wantProducts) || resp.CouponCount != len(tt.wantCoupons) {
+				t.Errorf("got %d products and %d coupons cloned, want %d and %d", resp.ProductCount, resp.CouponCount, len(tt.wantProducts), len(tt.wantCoupons))
+			}
+
+			var products ProductsResponse
+			ts.requestOK("GET", "/api/v0/sales/2/products?include_disabled=1", nil, true, &products)
+			var productNames []string
+			for _, product := range products.Products {
+				productNames = append(productNames, product.Name)
+				if product.SalePeriod != 2 {
+					t.Errorf("got product %q in sale period %d, want 2", product.Name, product.SalePeriod)
+				}
+				if product.Name == "Shirt" {
+					if len(product.Variants) != 1 || len(product.ImageURLs) != 1 || !*product.Enabled {
+						t.Errorf("got cloned shirt %+v, want variants, images and enabled flag copied", product)
+					}
+					if len(product.Stock) != 1 || product.Stock[0].Available != 2 {
+						t.Errorf("got cloned shirt stock %+v, want available stock copied", product.Stock)
+					}
+				}
+			}
+			if fmt.Sprint(productNames) != fmt.Sprint(tt.wantProducts) {
+				t.Errorf("got products %v, want %v", productNames, tt.wantProducts)
+			}
+
+			var coupons CouponsResponse
+			ts.requestOK("GET", "/api/v0/sales/2/coupons?include_disabled=1", nil, true, &coupons)
+			var couponCodes []string
+			for _, coupon := range coupons.Coupons {
+				couponCodes = append(couponCodes, coupon.CouponCode)
+				if coupon.CouponCode != "TEN" {
+					continue
+				}
+				if len(coupon.Requirements) != 1 {
+					t.Errorf("got cloned coupon requirements %s, want them copied", coupon.Requirements)
+				}
+				if newID := *coupon.StripeID != *ten.StripeID; newID != tt.wantNewStripeIDs {
+					t.Errorf("got Stripe ID %q for cloned coupon with original %q, want new ID %t", *coupon.StripeID, *ten.StripeID, tt.wantNewStripeIDs)
+				}
+				if coupon.StripeDesc == nil || *coupon.StripeDesc != "TEN discount" {
+					t.Errorf("got Stripe description %v, want it to match the original", coupon.StripeDesc)
+				}
+			}
+			if fmt.Sprint(couponCodes) != fmt.Sprint(tt.wantCoupons) {
+				t.Errorf("got coupons %v, want %v", couponCodes, tt.wantCoupons)
+			}
+
+			// The original sale period should be unchanged.
+			ts.requestOK("GET", "/api/v0/sales/1/products?include_disabled=1", nil, true, &products)
+			if len(products.Products) != 2 {
+				t.Errorf("got %d products in the original sale period, want 2", len(products.Products))
+			}
+		})
+	}
+}
+
+func TestCloneSalePeriodProductCoupons(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	sticker := testSticker
+	sticker.Enabled = ptr(false)
+	ts.createProduct(sticker)
+	ts.createDiscountCoupon("SHIRT", `{"type":"percentage","amount":10,"products":["1"]}`)
+	ts.createDiscountCoupon("STICKER", `{"type":"free_item","amount":1,"products":["2"]}`)
+	ts.createCoupon("WITH_SHIRT", 10, `{"type":"product","value":"1"}`)
+	ts.createCoupon("WITH_STICKER", 10, `{"type":"product","value":"2"}`)
+
+	var resp CloneSalePeriodResponse
+	req := CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)}, SkipDisabled: true}
+	ts.requestOK("POST", "/api/v0/sales/1/clone", req, true, &resp)
+	if resp.ProductCount != 1 || resp.CouponCount != 2 {
+		t.Errorf("got %d products and %d coupons cloned, want the shirt and its coupons", resp.ProductCount, resp.CouponCount)
+	}
+	var coupons CouponsResponse
+	ts.requestOK("GET", "/api/v0/sales/2/coupons?include_disabled=1", nil, true, &coupons)
+	if len(coupons.Coupons) != 2 {
+		t.Fatalf("got coupons %+v, want only SHIRT and WITH_SHIRT", coupons.Coupons)
+	}
+	// The payment provider coupon is limited to the original shirt, so the
+	// clone needs a new one.
+	coupon := coupons.Coupons[0]
+	if coupon.CouponCode != "SHIRT" || *coupon.Enabled || *coupon.StripeID != "" {
+		t.Errorf("got coupon %+v, want SHIRT disabled without a payment provider coupon", coupon)
+	}
+	if string(coupon.Discount) != `{"type":"percentage","amount":10,"products":["3"]}` {
+		t.Errorf("got discount %s, want it limited to the cloned shirt", coupon.Discount)
+	}
+	// Requirements do not involve the payment provider, so the coupon stays
+	// enabled.
+	coupon = coupons.Coupons[1]
+	if coupon.CouponCode != "WITH_SHIRT" || !*coupon.Enabled || len(coupon.Requirements) != 1 || string(coupon.Requirements[0]) != `{"type":"product","value":"3"}` {
+		t.Errorf("got coupon %+v with requirements %s, want WITH_SHIRT requiring the cloned shirt", coupon, coupon.Requirements)
+	}
+}
+
+func TestCloneSalePeriodRedemptions(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	ts.createCoupon("TEN", 10)
+	// The cloned coupon shares the payment provider coupon of the original.
+	req := CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: time.Now().Add(-time.Hour)}}
+	ts.requestOK("POST", "/api/v0/sales/1/clone", req, true, nil)
+
+	checkoutReq := testCheckoutRequest(shirtItem("2", "S", 1))
+	checkoutReq.Coupon = ptr("TEN")
+	ts.paidOrder(checkoutReq)
+	for _, tt := range []struct {
+		period string
+		want   int64
+	}{{"1", 0}, {"2", 1}} {
+		var coupons CouponsResponse
+		ts.requestOK("GET", "/api/v0/sales/"+tt.period+"/coupons?include_disabled=1", nil, true, &coupons)
+		if len(coupons.Coupons) != 1 || *coupons.Coupons[0].Redemptions != tt.want {
+			t.Errorf("got coupons %+v in sale period %s, want %d redemptions", coupons.Coupons, tt.period, tt.want)
+		}
+	}
+}
+
+func TestCloneSalePeriodErrors(t *testing.T) {
+	start := time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		target string
+		body   any
+		code   int
+		want   string
+	}{
+		{"invalid sale period", "/api/v0/sales/abc/clone", CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: start}}, http.StatusBadRequest, "Invalid sales period"},
+		{"unknown sale period", "/api/v0/sales/5/clone", CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: start}}, http.StatusNotFound, "Invalid sales period"},
+		{"not json", "/api/v0/sales/1/clone", "not json", http.StatusBadRequest, "Invalid Body"},
+		{"missing start time", "/api/v0/sales/1/clone", CloneSalePeriodRequest{}, http.StatusBadRequest, "Invalid start time"},
+		{"end before start", "/api/v0/sales/1/clone", CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: start, EndTime: ptr(start.Add(-time.Hour))}}, http.StatusBadRequest, "End time must be after start time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			expectResponse(t, ts.request("POST", tt.target, tt.body, true), tt.code, tt.want)
+			var resp SalePeriodsResponse
+			ts.requestOK("GET", "/api/v0/sales", nil, true, &resp)
+			if len(resp.Periods) != 1 {
+				t.Errorf("got %d sale periods after failed clone, want 1", len(resp.Periods))
+			}
+		})
+	}
+}
+
+func TestDeleteSalePeriod(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    string
+		target   string
+		code     int
+		want     string
+		archived bool
+	}{
+		{"no orders", "", "/api/v0/sales/1", http.StatusNoContent, "", true},
+		{"uncollected order", "paid", "/api/v0/sales/1", http.StatusConflict, "1 paid orders that have not been collected", false},
+		{"forced", "paid", "/api/v0/sales/1?force=1", http.StatusNoContent, "", true},
+		{"collected order", "collected", "/api/v0/sales/1", http.StatusNoContent, "", true},
+		{"unpaid order", "unpaid", "/api/v0/sales/1", http.StatusNoContent, "", true},
+		{"unknown", "", "/api/v0/sales/5", http.StatusNotFound, "Invalid sales period", false},
+		{"invalid", "", "/api/v0/sales/abc", http.StatusBadRequest, "Invalid sales period", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createProduct(testShirt)
+			switch tt.order {
+			case "paid":
+				ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+			case "collected":
+				orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+				ts.requestOK("POST", "/api/v0/orders/"+orderID+"/collect", nil, true, nil)
+			case "unpaid":
+				ts.payment.DefaultState = MockSessionUnpaid
+				ts.checkout(testCheckoutRequest(shirtItem("1", "M", 1)))
+			}
+			expectResponse(t, ts.request("DELETE", tt.target, nil, true), tt.code, tt.want)
+
+			var active, archived SalePeriodsResponse
+			ts.requestOK("GET", "/api/v0/sales", nil, true, &active)
+			ts.requestOK("GET", "/api/v0/sales?archived=1", nil, true, &archived)
+			if gotArchived := len(archived.Periods) == 1; gotArchived != tt.archived {
+				t.Fatalf("got archived sale periods %+v, want archived %t", archived.Periods, tt.archived)
+			}
+			if len(active.Periods)+len(archived.Periods) != 1 {
+				t.Errorf("got active %+v and archived %+v, want the sale period in exactly one", active.Periods, archived.Periods)
+			}
+			if tt.archived && archived.Periods[0].DeleteTime == nil {
+				t.Errorf("got archived sale period %+v, want delete time", archived.Periods[0])
+			}
+		})
+	}
+}
+
+func TestRestoreSalePeriod(t *testing.T) {
+	ts := newTestServer(t)
+	expectResponse(t, ts.request("POST", "/api/v0/sales/1/restore", nil, true), http.StatusNotFound, "Invalid sales period")
+	expectResponse(t, ts.request("DELETE", "/api/v0/sales/1", nil, true), http.StatusNoContent, "")
+	expectResponse(t, ts.request("DELETE", "/api/v0/sales/1", nil, true), http.StatusNotFound, "Invalid sales period")
+
+	// Archived sale periods can still be cloned.
+	ts.requestOK("POST", "/api/v0/sales/1/clone", CloneSalePeriodRequest{SalePeriod: SalePeriod{StartTime: time.Now()}}, true, nil)
+
+	expectResponse(t, ts.request("POST", "/api/v0/sales/1/restore", nil, true), http.StatusNoContent, "")
+	expectResponse(t, ts.request("POST", "/api/v0/sales/abc/restore", nil, true), http.StatusBadRequest, "Invalid sales period")
+	var resp SalePeriodsResponse
+	ts.requestOK("GET", "/api/v0/sales", nil, true, &resp)
+	if len(resp.Periods) != 2 || resp.Periods[0].DeleteTime != nil {
+		t.Errorf("got sale periods %+v after restoring, want both active", resp.Periods)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+type ProductStock struct {
+	Variant   string `json:"variant"`
+	Available int    `json:"available"`
+
+	// Admin fields.
+	Reserved *int `json:"reserved,omitempty"`
+}
+
+// variantCombinations returns the variant text of every possible combination
+// of options, formatted the same way as the variant of an order item.
+func variantCombinations(variants []ProductVariant) []string {
+	combinations := []string{""}
+	for i, variant := range variants {
+		next := make([]string, 0, len(combinations)*len(variant.Options))
+		for _, prefix := range combinations {
+			for _, option := range variant.Options {
+				if i == 0 {
+					next = append(next, option.Text)
+					continue
+				}
+				next = append(next, prefix+", "+option.Text)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+func validateStock(product Product) error {
+	combinations := variantCombinations(product.Variants)
+	seen := make(map[string]bool, len(product.Stock))
+	for _, stock := range product.Stock {
+		found := false
+		for _, v := range combinations {
+			if v == stock.Variant {
+				found = true
+				break
+			}
+		}
+		switch {
+		case !found:
+			return fmt.Errorf("stock is set for invalid variant %q", stock.Variant)
+		case seen[stock.Variant]:
+			return fmt.Errorf("stock is set multiple times for variant %q", stock.Variant)
+		case stock.Available < 0:
+			return fmt.Errorf("stock for variant %q must not be negative", stock.Variant)
+		}
+		seen[stock.Variant] = true
+	}
+	return nil
+}
+
+// saveStock replaces the tracked stock of the given product. Variants that
+// are not provided are no longer tracked and can be bought without limit.
+func saveStock(ctx context.Context, queries *db.Queries, productID int64, salePeriod int64, stock []ProductStock) error {
+	existing, err := queries.ListProductStock(ctx, salePeriod)
+	if err != nil {
+		return fmt.Errorf("error fetching existing stock: %w", err)
+	}
+	for _, v := range existing {
+		if v.ProductID != productID {
+			continue
+		}
+		stillTracked := false
+		for _, w := range stock {
+			if v.Variant == w.Variant {
+				stillTracked = true
+				break
+			}
+		}
+		if stillTracked {
+			continue
+		}
+		if err := queries.DeleteProductStock(ctx, db.DeleteProductStockParams{
+			ProductID: productID,
+			Variant:   v.Variant,
+		}); err != nil {
+			return fmt.Errorf("error deleting stock of variant %q: %w", v.Variant, err)
+		}
+	}
+	for _, v := range stock {
+		if err := queries.SetProductStock(ctx, db.SetProductStockParams{
+			ProductID: productID,
+			Variant:   v.Variant,
+			Available: int64(v.Available),
+		}); err != nil {
+			return fmt.Errorf("error setting stock of variant %q: %w", v.Variant, err)
+		}
+	}
+	return nil
+}
+
+// reserveStock holds stock for every item in the order until the payment is
+// confirmed or the order is cancelled. If any of the items do not have enough
+// stock, the offending item is returned and the transaction should be rolled
+// back.
+func reserveStock(ctx context.Context, queries *db.Queries, items []db.OrderItem) (*db.OrderItem, error) {
+	for i, item := range items {
+		productID, err := strconv.ParseInt(item.ProductID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product ID %q: %w", item.ProductID, err)
+		}
+		reserved, err := queries.ReserveStock(ctx, db.ReserveStockParams{
+			Amount:    item.Amount,
+			ProductID: productID,
+			Variant:   item.Variant,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reserving stock: %w", err)
+		}
+		if reserved > 0 {
+			continue
+		}
+		_, err = queries.ProductStockByVariant(ctx, db.ProductStockByVariantParams{
+			ProductID: productID,
+			Variant:   item.Variant,
+		})
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Stock is not tracked for this variant.
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("error fetching stock: %w", err)
+		}
+		return &items[i], nil
+	}
+	return nil, nil
+}
+
+// confirmStock converts the reservation held by the order into a sale.
+func confirmStock(ctx context.Context, queries *db.Queries, orderID string) error {
+	items, err := queries.ListOrderItems(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("error fetching order items: %w", err)
+	}
+	for _, item := range items {
+		productID, err := strconv.ParseInt(item.ProductID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid product ID %q: %w", item.ProductID, err)
+		}
+		if err := queries.ConfirmStock(ctx, db.ConfirmStockParams{
+			Amount:    item.Amount,
+			ProductID: productID,
+			Variant:   item.Variant,
+		}); err != nil {
+			return fmt.Errorf("error confirming stock: %w", err)
+		}
+	}
+	return nil
+}
+
+// releaseStock returns the stock held by the order. paid should be set if the
+// payment of the order has been confirmed, in which case there is no
+// reservation left to release.
+func releaseStock(ctx context.Context, queries *db.Queries, orderID string, paid bool) error {
+	items, err := queries.ListOrderItems(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("error fetching order items: %w", err)
+	}
+	for _, item := range items {
+		productID, err := strconv.ParseInt(item.ProductID, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid product ID %q: %w", item.ProductID, err)
+		}
+		var reservedAmount int64
+		if !paid {
+			reservedAmount = item.Amount
+		}
+		if err := queries.ReleaseStock(ctx, db.ReleaseStockParams{
+			// Refunded units have already been restocked.
+			Amount:         item.Amount - item.RefundedAmount,
+			ReservedAmount: reservedAmount,
+			ProductID:      productID,
+			Variant:        item.Variant,
+		}); err != nil {
+			return fmt.Errorf("error releasing stock: %w", err)
+		}
+	}
+	return nil
+}
+
+// restockItem returns refunded units of a paid order item to the available
+// stock.
+func restockItem(ctx context.Context, queries *db.Queries, item db.OrderItem, amount int64) error {
+	productID, err := strconv.ParseInt(item.ProductID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid product ID %q: %w", item.ProductID, err)
+	}
+	if err := queries.ReleaseStock(ctx, db.ReleaseStockParams{
+		Amount:         amount,
+		ReservedAmount: 0,
+		ProductID:      productID,
+		Variant:        item.Variant,
+	}); err != nil {
+		return fmt.Errorf("error releasing stock: %w", err)
+	}
+	return nil
+}
+
+func insufficientStockMessage(item *db.OrderItem) string {
+	if item.Variant == "" {
+		return "Insufficient stock for " + item.ProductName
+	}
+	return fmt.Sprintf("Insufficient stock for %s (%s)", item.ProductName, item.Variant)
+}
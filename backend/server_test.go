@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth/gothic"
+)
+
+const testAdminEmail = "admin@e.ntu.edu.sg"
+
+func TestMain(m *testing.M) {
+	gothic.Store = sessions.NewCookieStore([]byte("test session secret"))
+	os.Exit(m.Run())
+}
+
+var testDBCount atomic.Int64
+
+// testServer is a Server backed by an in-memory database, the mock payment
+// provider and a mailer that records emails.
+type testServer struct {
+	*Server
+	t       *testing.T
+	handler http.Handler
+	payment *MockPayment
+	mailer  *testMailer
+	// adminCookies holds the session of testAdminEmail.
+	adminCookies []*http.Cookie
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	cfg := &ServerConfig{
+		Sqlite3ConnStr:   fmt.Sprintf("file:test%d?mode=memory&cache=shared", testDBCount.Add(1)),
+		FrontendURL:      "http://shop.test",
+		ImageDir:         t.TempDir(),
+		Mode:             ModeDev,
+		MockPaymentState: MockSessionPaid,
+		SkipSchemaDump:   true,
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	if err := server.Queries.CreateAdminUser(context.Background(), db.CreateAdminUserParams{
+		Email: testAdminEmail,
+		Role:  string(RoleOwner),
+	}); err != nil {
+		t.Fatalf("error creating admin user: %v", err)
+	}
+	mailer := &testMailer{}
+	server.Mailer = mailer
+	return &testServer{
+		Server:       server,
+		t:            t,
+		handler:      server.HTTPMux(),
+		payment:      server.Payment.(*MockPayment),
+		mailer:       mailer,
+		adminCookies: sessionCookies(t, testAdminEmail),
+	}
+}
+
+// sessionCookies returns the cookies of a session logged in as the given user.
+func sessionCookies(t *testing.T, email string) []*http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := gothic.StoreInSession("user", email, req, rec); err != nil {
+		t.Fatalf("error storing session: %v", err)
+	}
+	return rec.Result().Cookies()
+}
+
+// request sends a request to the server. body is encoded as JSON unless it is
+// a string or nil. If admin is set, the request is sent as testAdminEmail.
+func (ts *testServer) request(method string, target string, body any, admin bool) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var cookies []*http.Cookie
+	if admin {
+		cookies = ts.adminCookies
+	}
+	return ts.requestWithCookies(method, target, body, cookies)
+}
+
+func (ts *testServer) requestWithCookies(method string, target string, body any, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	req := ts.newRequest(method, target, body)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// newRequest creates a request with body encoded as JSON unless it is a string
+// or nil.
+func (ts *testServer) newRequest(method string, target string, body any) *http.Request {
+	ts.t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("error encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	return httptest.NewRequest(method, target, reader)
+}
+
+// requestOK sends the request and decodes the JSON response into resp. It
+// fails the test if the request is not successful.
+func (ts *testServer) requestOK(method string, target string, body any, admin bool, resp any) {
+	ts.t.Helper()
+	rec := ts.request(method, target, body, admin)
+	if rec.Code != http.StatusOK && rec.Code != http.StatusNoContent {
+		ts.t.Fatalf("%s %s: got status %d: %s", method, target, rec.Code, rec.Body.String())
+	}
+	if resp == nil {
+		return
+	}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		ts.t.Fatalf("%s %s: error decoding response: %v", method, target, err)
+	}
+}
+
+func expectResponse(t *testing.T, rec *httptest.ResponseRecorder, code int, bodyContains string) {
+	t.Helper()
+	if rec.Code != code {
+		t.Errorf("got status %d, want %d (body: %q)", rec.Code, code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), bodyContains) {
+		t.Errorf("got body %q, want it to contain %q", rec.Body.String(), bodyContains)
+	}
+}
+
+func TestAdminRoutesRequireAuth(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		method string
+		target string
+	}{
+		{"GET", "/metrics"},
+		{"GET", "/api/v0/sales/1/coupons?include_disabled=1"},
+		{"GET", "/api/v0/sales/1/products?include_disabled=1"},
+		{"GET", "/api/v0/orders/CD0000?include_cancelled=1"},
+		{"GET", "/api/v0/orders/CD0000?from_item=1"},
+		{"POST", "/api/v0/sales/1/coupons"},
+		{"GET", "/api/v0/sales/1/coupon_batches"},
+		{"POST", "/api/v0/sales/1/coupons/batch"},
+		{"GET", "/api/v0/sales/1/coupon_batches/1"},
+		{"DELETE", "/api/v0/sales/1/coupons/batch/1"},
+		{"POST", "/api/v0/sales/1/products"},
+		{"POST", "/api/v0/image_upload"},
+		{"POST", "/api/v0/orders/CD0000/collect"},
+		{"POST", "/api/v0/orders/CD0000/cancel"},
+		{"POST", "/api/v0/orders/CD0000/refund"},
+		{"GET", "/api/v0/perm_check"},
+		{"GET", "/api/v0/users/me"},
+		{"GET", "/api/v0/audit_log"},
+		{"GET", "/api/v0/backup"},
+		{"GET", "/api/v0/tokens"},
+		{"POST", "/api/v0/tokens"},
+		{"DELETE", "/api/v0/tokens/1"},
+		{"GET", "/api/v0/users"},
+		{"POST", "/api/v0/users"},
+		{"DELETE", "/api/v0/users"},
+		{"GET", "/api/v0/closures"},
+		{"POST", "/api/v0/closures"},
+		{"GET", "/api/v0/sales"},
+		{"POST", "/api/v0/sales"},
+		{"POST", "/api/v0/sales/1/clone"},
+		{"DELETE", "/api/v0/sales/1"},
+		{"POST", "/api/v0/sales/1/restore"},
+		{"DELETE", "/api/v0/closures/1"},
+		{"GET", "/api/v0/sales/1/order_summary"},
+		{"GET", "/api/v0/sales/current/orders/export"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := ts.request(tt.method, tt.target, "{}", false)
+			expectResponse(t, rec, http.StatusUnauthorized, "User not authenticated")
+		})
+	}
+}
+
+func TestUnknownAPIRoute(t *testing.T) {
+	ts := newTestServer(t)
+	rec := ts.request("GET", "/api/v0/unknown", nil, true)
+	expectResponse(t, rec, http.StatusNotFound, "")
+}
+
+func TestServeShutdown(t *testing.T) {
+	ts := newTestServer(t)
+	// Requests to the frontend are forwarded to a backend that only responds
+	// once released so that a request is in flight when shutting down.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "storefront")
+	}))
+	defer frontend.Close()
+	frontendURL, _ := url.Parse(frontend.URL)
+	ts.Config.Forwarder = httputil.NewSingleHostReverseProxy(frontendURL)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- ts.Serve(ctx, ln)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- result{string(body), err}
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-serveErr:
+		t.Fatalf("got Serve returning %v before the request finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if res := <-inFlight; res.err != nil || res.body != "storefront" {
+		t.Errorf("got in-flight response %q (err: %v), want it to finish", res.body, res.err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("got Serve error %v, want nil", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String() + "/"); err == nil {
+		t.Errorf("got request served after shutdown, want error")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   Mode
+		target string
+		code   int
+		want   string
+	}{
+		{"production", ModeProduction, "/api/v0/auth", http.StatusServiceUnavailable, "Admin login is not configured"},
+		{"dev", ModeDev, "/api/v0/auth", http.StatusTemporaryRedirect, ""},
+		{"callback", ModeDev, "/api/v0/auth/callback", http.StatusBadRequest, "Invalid authentication request"},
+		{"dev login form", ModeDev, "/api/v0/auth/dev", http.StatusOK, "Development Login"},
+		{"dev login form in production", ModeProduction, "/api/v0/auth/dev", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Config.Mode = tt.mode
+			rec := ts.request("GET", tt.target, nil, false)
+			expectResponse(t, rec, tt.code, tt.want)
+			if rec.Code == http.StatusTemporaryRedirect {
+				if got := rec.Header().Get("Location"); got != "http://shop.test/api/v0/auth/dev" {
+					t.Errorf("got redirect to %q, want the development login form", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDevLogin(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   Mode
+		admins []string
+		email  string
+		code   int
+	}{
+		{"first login creates admin", ModeDev, nil, "first@e.ntu.edu.sg", http.StatusTemporaryRedirect},
+		{"existing admin", ModeDev, []string{testAdminEmail}, testAdminEmail, http.StatusTemporaryRedirect},
+		{"not an admin", ModeDev, []string{testAdminEmail}, "other@e.ntu.edu.sg", http.StatusForbidden},
+		{"missing email", ModeDev, nil, "", http.StatusBadRequest},
+		{"production", ModeProduction, []string{testAdminEmail}, testAdminEmail, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Config.Mode = tt.mode
+			// Start without any admin users to exercise first-login creation.
+			if err := ts.Queries.DeleteAdminUser(context.Background(), testAdminEmail); err != nil {
+				t.Fatalf("error deleting admin user: %v", err)
+			}
+			for _, email := range tt.admins {
+				ts.createAdmin(email, RoleOwner)
+			}
+			req := httptest.NewRequest("POST", "/api/v0/auth/dev", strings.NewReader(url.Values{"email": {tt.email}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			ts.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d (body: %q)", rec.Code, tt.code, rec.Body.String())
+			}
+			if rec.Code != http.StatusTemporaryRedirect {
+				return
+			}
+			if got := rec.Header().Get("Location"); got != "http://shop.test/admin" {
+				t.Errorf("got redirect to %q, want admin page", got)
+			}
+			// The session cookie should now be accepted.
+			expectResponse(t, ts.requestWithCookies("GET", "/api/v0/perm_check", nil, rec.Result().Cookies()), http.StatusNoContent, "")
+		})
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      Mode
+		bootstrap []string
+		email     string
+		want      bool
+	}{
+		{"dev", ModeDev, nil, "anyone@e.ntu.edu.sg", true},
+		{"production", ModeProduction, nil, "anyone@e.ntu.edu.sg", false},
+		{"production allowlisted", ModeProduction, []string{"Owner@e.ntu.edu.sg"}, "owner@e.ntu.edu.sg", true},
+		{"production not allowlisted", ModeProduction, []string{"owner@e.ntu.edu.sg"}, "anyone@e.ntu.edu.sg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Config.Mode = tt.mode
+			ts.Config.BootstrapAdmins = tt.bootstrap
+			ctx := context.Background()
+			if err := ts.Queries.DeleteAdminUser(ctx, testAdminEmail); err != nil {
+				t.Fatalf("error deleting admin user: %v", err)
+			}
+			ok, err := ts.validAdminUser(ctx, tt.email)
+			if err != nil {
+				t.Fatalf("error validating admin: %v", err)
+			}
+			if ok != tt.want {
+				t.Errorf("got valid admin %t, want %t", ok, tt.want)
+			}
+			// Once there is an admin, no one else is bootstrapped.
+			if ok {
+				if ok, _ := ts.validAdminUser(ctx, "late@e.ntu.edu.sg"); ok {
+					t.Errorf("got second admin bootstrapped, want only the first")
+				}
+			}
+		})
+	}
+}
+
+func TestPermissionCheck(t *testing.T) {
+	ts := newTestServer(t)
+	expectResponse(t, ts.request("GET", "/api/v0/perm_check", nil, true), http.StatusNoContent, "")
+	expectResponse(t, ts.request("GET", "/api/v0/perm_check", nil, false), http.StatusUnauthorized, "")
+}
+
+func TestAdminUsers(t *testing.T) {
+	owner := User{Email: testAdminEmail, Role: RoleOwner}
+	collector := User{Email: "collector@e.ntu.edu.sg", Role: RoleCollector}
+	tests := []struct {
+		name   string
+		method string
+		body   any
+		code   int
+		want   []User
+	}{
+		{"add", "POST", User{Email: "new@e.ntu.edu.sg", Role: RoleViewer}, http.StatusNoContent, []User{owner, collector, {"new@e.ntu.edu.sg", RoleViewer}}},
+		{"change role", "POST", User{Email: collector.Email, Role: RoleMerchManager}, http.StatusNoContent, []User{owner, {collector.Email, RoleMerchManager}}},
+		{"add another owner", "POST", User{Email: collector.Email, Role: RoleOwner}, http.StatusNoContent, []User{owner, {collector.Email, RoleOwner}}},
+		{"add invalid role", "POST", User{Email: "new@e.ntu.edu.sg", Role: "admin"}, http.StatusBadRequest, []User{owner, collector}},
+		{"add invalid body", "POST", "not json", http.StatusBadRequest, []User{owner, collector}},
+		{"demote last owner", "POST", User{Email: testAdminEmail, Role: RoleViewer}, http.StatusBadRequest, []User{owner, collector}},
+		{"remove", "DELETE", collector, http.StatusNoContent, []User{owner}},
+		{"remove last owner", "DELETE", owner, http.StatusBadRequest, []User{owner, collector}},
+		{"remove invalid body", "DELETE", "not json", http.StatusBadRequest, []User{owner, collector}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.createAdmin(collector.Email, collector.Role)
+			expectResponse(t, ts.request(tt.method, "/api/v0/users", tt.body, true), tt.code, "")
+			var resp AdminUsersResponse
+			ts.requestOK("GET", "/api/v0/users", nil, true, &resp)
+			if fmt.Sprint(resp.Users) != fmt.Sprint(tt.want) {
+				t.Errorf("got users %v, want %v", resp.Users, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageUpload(t *testing.T) {
+	pngImage := func(width, height int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatalf("error encoding image: %v", err)
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name       string
+		raw        string
+		file       []byte
+		code       int
+		wantWidth  int
+		wantHeight int
+	}{
+		{"square", "0", pngImage(40, 20), http.StatusOK, 20, 20},
+		{"raw", "1", pngImage(40, 20), http.StatusOK, 40, 20},
+		{"invalid raw", "2", pngImage(40, 20), http.StatusBadRequest, 0, 0},
+		{"not an image", "0", []byte("hello"), http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			_ = writer.WriteField("raw", tt.raw)
+			part, _ := writer.CreateFormFile("file", "image.png")
+			_, _ = part.Write(tt.file)
+			_ = writer.Close()
+			req := httptest.NewRequest("POST", "/api/v0/image_upload", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			for _, cookie := range ts.adminCookies {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			ts.handler.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("got status %d, want %d (body: %q)", rec.Code, tt.code, rec.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var resp ImageUploadResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			imageName := strings.TrimPrefix(resp.URL, "http://shop.test/content/")
+			f, err := os.Open(path.Join(ts.Config.ImageDir, imageName))
+			if err != nil {
+				t.Fatalf("error opening uploaded image: %v", err)
+			}
+			defer f.Close()
+			cfg, err := png.DecodeConfig(f)
+			if err != nil {
+				t.Fatalf("error decoding uploaded image: %v", err)
+			}
+			if cfg.Width != tt.wantWidth || cfg.Height != tt.wantHeight {
+				t.Errorf("got %dx%d image, want %dx%d", cfg.Width, cfg.Height, tt.wantWidth, tt.wantHeight)
+			}
+			// The image should be served under /content/.
+			expectResponse(t, ts.request("GET", "/content/"+imageName, nil, false), http.StatusOK, "")
+		})
+	}
+}
+
+func TestCensor(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"name", censorBack("John Smith", 4, 10, ' '), "John *****"},
+		{"single name", censorBack("Johnathan", 4, 10, ' '), "John*****"},
+		{"short name", censorBack("Jo", 4, 10, ' '), "Jo"},
+		{"matric number", censorFront("U1234567A", 4, 10, ' '), "*****567A"},
+		{"max asterisk", censorFront("mock_cs_123456789012345", 8, 10, ' '), "**********89012345"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+var (
+	testShirt = Product{
+		Name:      "Shirt",
+		BasePrice: 1500,
+		Variants: []ProductVariant{
+			{
+				Type: "Size",
+				Options: []ProductVariantOptions{
+					{Text: "S"},
+					{Text: "M", AdditionalPrice: 200},
+				},
+			},
+		},
+		DefaultImageURL: "shirt.png",
+		ImageURLs: []ProductImageURL{
+			{SelectedOptions: []*string{ptr("M")}, URL: "shirt-m.png"},
+		},
+		Enabled:    ptr(true),
+		SalePeriod: 1,
+		Stock:      []ProductStock{{Variant: "S", Available: 2}},
+	}
+	testSticker = Product{
+		Name:       "Sticker",
+		BasePrice:  300,
+		Variants:   []ProductVariant{},
+		ImageURLs:  []ProductImageURL{},
+		Enabled:    ptr(true),
+		SalePeriod: 1,
+	}
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// createProduct saves the product in the current sale period.
+func (ts *testServer) createProduct(product Product) Product {
+	ts.t.Helper()
+	var resp Product
+	ts.requestOK("POST", "/api/v0/sales/1/products", product, true, &resp)
+	return resp
+}
+
+// createCoupon saves an enabled percentage coupon in the current sale period.
+func (ts *testServer) createCoupon(code string, discountPercentage int, requirements ...string) Coupon {
+	ts.t.Helper()
+	return ts.createDiscountCoupon(code, fmt.Sprintf(`{"type":"percentage","amount":%d}`, discountPercentage), requirements...)
+}
+
+// createDiscountCoupon saves an enabled coupon with the discount in the current
+// sale period.
+func (ts *testServer) createDiscountCoupon(code string, discount string, requirements ...string) Coupon {
+	ts.t.Helper()
+	coupon := Coupon{
+		Requirements: []json.RawMessage{},
+		CouponCode:   code,
+		Discount:     json.RawMessage(discount),
+		Enabled:      ptr(true),
+		Public:       ptr(true),
+		StripeDesc:   ptr(code + " discount"),
+	}
+	for _, v := range requirements {
+		coupon.Requirements = append(coupon.Requirements, json.RawMessage(v))
+	}
+	var resp Coupon
+	ts.requestOK("POST", "/api/v0/sales/1/coupons", coupon, true, &resp)
+	return resp
+}
+
+// checkout places an order and returns its order ID and checkout session ID.
+func (ts *testServer) checkout(checkoutReq CheckoutRequest) (orderID string, sessionID string) {
+	ts.t.Helper()
+	var resp CheckoutResponse
+	ts.requestOK("POST", "/api/v0/checkout", checkoutReq, false, &resp)
+	_, sessionID, _ = strings.Cut(resp.CheckoutURL, "session_id=")
+	order, err := ts.Queries.OrderByPaymentReference(context.Background(), sql.NullString{
+		String: sessionID,
+		Valid:  true,
+	})
+	if err != nil {
+		ts.t.Fatalf("error looking up order of session %q: %v", sessionID, err)
+	}
+	return order.OrderID, sessionID
+}
+
+// paidOrder places an order and completes its payment.
+func (ts *testServer) paidOrder(checkoutReq CheckoutRequest) (orderID string, sessionID string) {
+	ts.t.Helper()
+	orderID, sessionID = ts.checkout(checkoutReq)
+	rec := ts.request("GET", "/api/v0/checkout/complete?session_id="+sessionID, nil, false)
+	if rec.Code != http.StatusTemporaryRedirect {
+		ts.t.Fatalf("error completing checkout: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	return orderID, sessionID
+}
+
+// lookupOrder returns the order as seen by admins.
+func (ts *testServer) lookupOrder(orderID string) Order {
+	ts.t.Helper()
+	var resp OrderResponse
+	ts.requestOK("GET", "/api/v0/orders/"+orderID+"?include_cancelled=1", nil, true, &resp)
+	if len(resp.Orders) != 1 {
+		ts.t.Fatalf("got %d orders for %q, want 1", len(resp.Orders), orderID)
+	}
+	return resp.Orders[0]
+}
+
+// stock returns the available and reserved stock of the variant.
+func (ts *testServer) stock(productID string, variant string) (available int64, reserved int64) {
+	ts.t.Helper()
+	id, _ := strconv.ParseInt(productID, 10, 64)
+	stock, err := ts.Queries.ProductStockByVariant(context.Background(), db.ProductStockByVariantParams{
+		ProductID: id,
+		Variant:   variant,
+	})
+	if err != nil {
+		ts.t.Fatalf("error fetching stock: %v", err)
+	}
+	return stock.Available, stock.Reserved
+}
+
+func testCheckoutRequest(items ...CartItem) CheckoutRequest {
+	return CheckoutRequest{
+		Name:         "Tan Ah Kow",
+		MatricNumber: "U2345678A",
+		Email:        "tanahkow@e.ntu.edu.sg",
+		Items:        items,
+	}
+}
+
+func shirtItem(productID string, size string, amount int) CartItem {
+	return CartItem{
+		ID:      productID,
+		Variant: []CartItemVariant{{Type: "Size", Option: size}},
+		Amount:  amount,
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chanbakjsd/CCDSQuickShop/backend/db"
+)
+
+const (
+	// apiTokenPrefix makes API tokens easy to recognise, e.g. by secret
+	// scanners.
+	apiTokenPrefix          = "ccds_"
+	apiTokenDefaultLifetime = 90 * 24 * time.Hour
+	apiTokenMaxLifetime     = 366 * 24 * time.Hour
+	// apiTokenTouchInterval is how often the last used time of a token is
+	// updated so that busy scripts do not write on every request.
+	apiTokenTouchInterval = time.Minute
+)
+
+type APITokensResponse struct {
+	Tokens []APIToken `json:"tokens"`
+}
+
+type APIToken struct {
+	ID           int64        `json:"id"`
+	Name         string       `json:"name"`
+	AdminEmail   string       `json:"admin_email"`
+	Permissions  []Permission `json:"permissions"`
+	CreateTime   time.Time    `json:"create_time"`
+	ExpireTime   time.Time    `json:"expire_time"`
+	LastUsedTime *time.Time   `json:"last_used_time,omitempty"`
+	RevokeTime   *time.Time   `json:"revoke_time,omitempty"`
+
+	// Token is only returned when the token is created.
+	Token string `json:"token,omitempty" audit:"-"`
+}
+
+type CreateAPITokenRequest struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+	// ExpireTime defaults to 90 days from now.
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// APITokens lists the API tokens of the logged in admin. Admins that can
+// manage other admins see the tokens of everyone.
+func (s *Server) APITokens(w http.ResponseWriter, req *http.Request) {
+	admin, ok := s.sessionAdmin(w, req)
+	if !ok {
+		return
+	}
+	adminEmail := admin.Email
+	if admin.Has(PermManageUsers) {
+		adminEmail = ""
+	}
+	dbTokens, err := s.Queries.ListApiTokens(req.Context(), adminEmail)
+	if err != nil {
+		slog.Error("error fetching API tokens", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	tokens := make([]APIToken, 0, len(dbTokens))
+	for _, v := range dbTokens {
+		tokens = append(tokens, dbTokenToAPIToken(v))
+	}
+	if err := json.NewEncoder(w).Encode(APITokensResponse{
+		Tokens: tokens,
+	}); err != nil {
+		slog.Error("error writing API tokens response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// CreateAPIToken mints an API token for the logged in admin. The token can
+// only be given permissions that the admin has.
+func (s *Server) CreateAPIToken(w http.ResponseWriter, req *http.Request) {
+	admin, ok := s.tokenManager(w, req)
+	if !ok {
+		return
+	}
+	var tokenReq CreateAPITokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&tokenReq); err != nil {
+		slog.Error("error parsing request", "err", err)
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(tokenReq.Name) == "" {
+		http.Error(w, "Token name is required", http.StatusBadRequest)
+		return
+	}
+	if len(tokenReq.Permissions) == 0 {
+		http.Error(w, "Token needs at least one permission", http.StatusBadRequest)
+		return
+	}
+	for _, perm := range tokenReq.Permissions {
+		if !admin.Has(perm) {
+			http.Error(w, fmt.Sprintf("Cannot grant permission %q", perm), http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now().UTC()
+	expireTime := tokenReq.ExpireTime.UTC()
+	if expireTime.IsZero() {
+		expireTime = now.Add(apiTokenDefaultLifetime)
+	}
+	if !expireTime.After(now) || expireTime.After(now.Add(apiTokenMaxLifetime)) {
+		http.Error(w, "Expiry must be in the future and within a year", http.StatusBadRequest)
+		return
+	}
+	token, err := newAPIToken()
+	if err != nil {
+		slog.Error("error generating API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	ctx := req.Context()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	id, err := queries.CreateApiToken(ctx, db.CreateApiTokenParams{
+		Name:        tokenReq.Name,
+		TokenHash:   hashAPIToken(token),
+		AdminEmail:  admin.Email,
+		Permissions: formatPermissions(tokenReq.Permissions),
+		CreateTime:  now,
+		ExpireTime:  expireTime,
+	})
+	if err != nil {
+		slog.Error("error creating API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	created, err := queries.ApiTokenByID(ctx, id)
+	if err != nil {
+		slog.Error("error fetching created API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	resp := dbTokenToAPIToken(created)
+	if err := recordAudit(ctx, queries, sessionUser(req), auditAPIToken, id, "create", nil, resp); err != nil {
+		slog.Error("error recording API token creation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	resp.Token = token
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error("error writing API token response", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// RevokeAPIToken revokes an API token of the logged in admin. Admins that can
+// manage other admins can revoke the tokens of everyone.
+func (s *Server) RevokeAPIToken(w http.ResponseWriter, req *http.Request) {
+	admin, ok := s.tokenManager(w, req)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid API token ID", http.StatusBadRequest)
+		return
+	}
+	ctx := req.Context()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		slog.Error("error creating transaction for API token revocation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	queries := s.Queries.WithTx(tx)
+	before, err := queries.ApiTokenByID(ctx, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Invalid API token ID", http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("error fetching API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if before.AdminEmail != admin.Email && !admin.Has(PermManageUsers) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+	revoked, err := queries.RevokeApiToken(ctx, db.RevokeApiTokenParams{
+		RevokeTime: sql.NullTime{
+			Time:  time.Now().UTC(),
+			Valid: true,
+		},
+		ID: id,
+	})
+	switch {
+	case err != nil:
+		slog.Error("error revoking API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	case revoked == 0:
+		http.Error(w, "API token is already revoked", http.StatusNotFound)
+		return
+	}
+	after, err := queries.ApiTokenByID(ctx, id)
+	if err != nil {
+		slog.Error("error fetching revoked API token", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := recordAudit(ctx, queries, sessionUser(req), auditAPIToken, id, "revoke", dbTokenToAPIToken(before), dbTokenToAPIToken(after)); err != nil {
+		slog.Error("error recording API token revocation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		slog.Error("error commiting API token revocation", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tokenManager returns the logged in admin if they may manage their API
+// tokens. API tokens cannot be used to mint or revoke tokens so that a leaked
+// token cannot be used to keep access after it is revoked.
+func (s *Server) tokenManager(w http.ResponseWriter, req *http.Request) (adminSession, bool) {
+	admin, ok := s.sessionAdmin(w, req)
+	if !ok {
+		return adminSession{}, false
+	}
+	if admin.Token != nil {
+		http.Error(w, "API tokens cannot manage API tokens", http.StatusForbidden)
+		return adminSession{}, false
+	}
+	return admin, true
+}
+
+// apiToken looks up an API token that is neither expired nor revoked and
+// records that it was used.
+func (s *Server) apiToken(ctx context.Context, token string) (db.ApiToken, error) {
+	now := time.Now().UTC()
+	apiToken, err := s.Queries.ApiTokenByHash(ctx, db.ApiTokenByHashParams{
+		TokenHash: hashAPIToken(token),
+		Now:       now,
+	})
+	if err != nil {
+		return db.ApiToken{}, err
+	}
+	err = s.Queries.TouchApiToken(ctx, db.TouchApiTokenParams{
+		Now:       sql.NullTime{Time: now, Valid: true},
+		ID:        apiToken.ID,
+		StaleTime: sql.NullTime{Time: now.Add(-apiTokenTouchInterval), Valid: true},
+	})
+	if err != nil {
+		// The request can still go ahead without the usage recorded.
+		slog.Warn("error recording API token usage", "token_id", apiToken.ID, "err", err)
+	}
+	return apiToken, nil
+}
+
+// bearerToken returns the token in the Authorization header, if any.
+func bearerToken(req *http.Request) (string, bool) {
+	return strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+}
+
+func newAPIToken() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// hashAPIToken hashes the token for storage. Tokens are random enough that a
+// slow password hash is not needed.
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func formatPermissions(perms []Permission) string {
+	s := make([]string, 0, len(perms))
+	for _, perm := range perms {
+		s = append(s, string(perm))
+	}
+	return strings.Join(s, ",")
+}
+
+func parsePermissions(s string) []Permission {
+	var perms []Permission
+	for _, perm := range strings.Split(s, ",") {
+		if perm != "" {
+			perms = append(perms, Permission(perm))
+		}
+	}
+	return perms
+}
+
+func dbTokenToAPIToken(token db.ApiToken) APIToken {
+	apiToken := APIToken{
+		ID:          token.ID,
+		Name:        token.Name,
+		AdminEmail:  token.AdminEmail,
+		Permissions: parsePermissions(token.Permissions),
+		CreateTime:  token.CreateTime,
+		ExpireTime:  token.ExpireTime,
+	}
+	if token.LastUsedTime.Valid {
+		apiToken.LastUsedTime = &token.LastUsedTime.Time
+	}
+	if token.RevokeTime.Valid {
+		apiToken.RevokeTime = &token.RevokeTime.Time
+	}
+	return apiToken
+}
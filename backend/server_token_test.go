@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createToken mints an API token as testAdminEmail and returns it.
+func (ts *testServer) createToken(perms ...Permission) APIToken {
+	ts.t.Helper()
+	var resp APIToken
+	ts.requestOK("POST", "/api/v0/tokens", CreateAPITokenRequest{
+		Name:        "Collection script",
+		Permissions: perms,
+	}, true, &resp)
+	return resp
+}
+
+func (ts *testServer) requestWithToken(method string, target string, body any, token string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	req := ts.newRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreateAPIToken(t *testing.T) {
+	tests := []struct {
+		name string
+		body any
+		code int
+		want string
+	}{
+		{"token", CreateAPITokenRequest{Name: "Script", Permissions: []Permission{PermViewOrders}}, http.StatusOK, `"token":"ccds_`},
+		{"not json", "not json", http.StatusBadRequest, "Invalid Body"},
+		{"missing name", CreateAPITokenRequest{Permissions: []Permission{PermViewOrders}}, http.StatusBadRequest, "Token name is required"},
+		{"missing permissions", CreateAPITokenRequest{Name: "Script"}, http.StatusBadRequest, "at least one permission"},
+		{"unknown permission", CreateAPITokenRequest{Name: "Script", Permissions: []Permission{"launch_rockets"}}, http.StatusBadRequest, `Cannot grant permission "launch_rockets"`},
+		{"expired", CreateAPITokenRequest{Name: "Script", Permissions: []Permission{PermViewOrders}, ExpireTime: time.Now().Add(-time.Hour)}, http.StatusBadRequest, "Expiry must be"},
+		{"expiry too far", CreateAPITokenRequest{Name: "Script", Permissions: []Permission{PermViewOrders}, ExpireTime: time.Now().AddDate(2, 0, 0)}, http.StatusBadRequest, "Expiry must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			expectResponse(t, ts.request("POST", "/api/v0/tokens", tt.body, true), tt.code, tt.want)
+		})
+	}
+}
+
+func TestAPITokenStoredHashed(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.createToken(PermViewOrders)
+	var tokenHash string
+	if err := ts.DB.QueryRow("SELECT token_hash FROM api_tokens").Scan(&tokenHash); err != nil {
+		t.Fatalf("error reading token: %v", err)
+	}
+	if tokenHash != hashAPIToken(token.Token) {
+		t.Errorf("got token hash %q, want the SHA-256 of the token", tokenHash)
+	}
+	entries := ts.auditLog("entity_type=api_token")
+	if len(entries) != 1 || entries[0].Action != "create" {
+		t.Fatalf("got audit log %+v, want the token creation", entries)
+	}
+	for _, secret := range []string{token.Token, tokenHash} {
+		if strings.Contains(string(entries[0].After), secret) {
+			t.Errorf("got audit log %s, want it to not contain the token", entries[0].After)
+		}
+	}
+}
+
+func TestAPITokenScope(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createProduct(testShirt)
+	orderID, _ := ts.paidOrder(testCheckoutRequest(shirtItem("1", "M", 1)))
+	token := ts.createToken(PermViewOrders, PermCollectOrders)
+
+	expectResponse(t, ts.requestWithToken("GET", "/api/v0/perm_check", nil, token.Token), http.StatusNoContent, "")
+	expectResponse(t, ts.requestWithToken("POST", "/api/v0/orders/"+orderID+"/collect", nil, token.Token), http.StatusNoContent, "")
+	// The owner can edit the store, but the token was not given the permission.
+	expectResponse(t, ts.requestWithToken("POST", "/api/v0/sales", SalePeriod{Name: "Sale"}, token.Token), http.StatusForbidden, "Insufficient permissions")
+	// Tokens cannot be used to mint more tokens.
+	expectResponse(t, ts.requestWithToken("POST", "/api/v0/tokens", CreateAPITokenRequest{Name: "Script", Permissions: []Permission{PermViewOrders}}, token.Token), http.StatusForbidden, "cannot manage API tokens")
+
+	// Changes made with the token are recorded as the admin that minted it.
+	entries := ts.auditLog("entity_type=order")
+	if len(entries) != 1 || entries[0].Actor != testAdminEmail {
+		t.Errorf("got audit log %+v, want the collection by %s", entries, testAdminEmail)
+	}
+
+	var tokens APITokensResponse
+	ts.requestOK("GET", "/api/v0/tokens", nil, true, &tokens)
+	if len(tokens.Tokens) != 1 || tokens.Tokens[0].LastUsedTime == nil || tokens.Tokens[0].Token != "" {
+		t.Errorf("got tokens %+v, want the used token without its secret", tokens.Tokens)
+	}
+}
+
+func TestAPITokenLimitedByRole(t *testing.T) {
+	ts := newTestServer(t)
+	collector := ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+	rec := ts.requestWithCookies("POST", "/api/v0/tokens", CreateAPITokenRequest{
+		Name:        "Script",
+		Permissions: []Permission{PermEditStore},
+	}, collector)
+	expectResponse(t, rec, http.StatusBadRequest, `Cannot grant permission "edit_store"`)
+
+	// Tokens lose permissions that their admin loses.
+	token := ts.createToken(PermViewOrders, PermEditStore)
+	ts.requestOK("POST", "/api/v0/users", User{Email: "helper@e.ntu.edu.sg", Role: RoleOwner}, true, nil)
+	ts.requestOK("POST", "/api/v0/users", User{Email: testAdminEmail, Role: RoleViewer}, true, nil)
+	expectResponse(t, ts.requestWithToken("POST", "/api/v0/sales", SalePeriod{Name: "Sale"}, token.Token), http.StatusForbidden, "Insufficient permissions")
+	expectResponse(t, ts.requestWithToken("GET", "/api/v0/perm_check", nil, token.Token), http.StatusNoContent, "")
+	ts.requestWithCookies("DELETE", "/api/v0/users", User{Email: testAdminEmail}, collector)
+	expectResponse(t, ts.requestWithToken("GET", "/api/v0/perm_check", nil, token.Token), http.StatusUnauthorized, "User not authenticated")
+}
+
+func TestInvalidAPIToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token func(ts *testServer) string
+	}{
+		{"unknown", func(ts *testServer) string {
+			return "ccds_unknown"
+		}},
+		{"revoked", func(ts *testServer) string {
+			token := ts.createToken(PermViewOrders)
+			ts.requestOK("DELETE", "/api/v0/tokens/"+strconv.FormatInt(token.ID, 10), nil, true, nil)
+			return token.Token
+		}},
+		{"expired", func(ts *testServer) string {
+			token := ts.createToken(PermViewOrders)
+			if _, err := ts.DB.Exec("UPDATE api_tokens SET expire_time = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+				ts.t.Fatalf("error expiring token: %v", err)
+			}
+			return token.Token
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			token := tt.token(ts)
+			expectResponse(t, ts.requestWithToken("GET", "/api/v0/perm_check", nil, token), http.StatusUnauthorized, "Invalid API token")
+		})
+	}
+}
+
+func TestRevokeAPIToken(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.createToken(PermViewOrders)
+	target := "/api/v0/tokens/" + strconv.FormatInt(token.ID, 10)
+
+	// Only the owner of the token or admins that manage users can revoke it.
+	collector := ts.createAdmin("helper@e.ntu.edu.sg", RoleCollector)
+	expectResponse(t, ts.requestWithCookies("DELETE", target, nil, collector), http.StatusForbidden, "Insufficient permissions")
+	var tokens APITokensResponse
+	rec := ts.requestWithCookies("GET", "/api/v0/tokens", nil, collector)
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || len(tokens.Tokens) != 0 {
+		t.Errorf("got tokens %+v (err: %v) for collector, want none", tokens.Tokens, err)
+	}
+
+	expectResponse(t, ts.request("DELETE", target, nil, true), http.StatusNoContent, "")
+	expectResponse(t, ts.request("DELETE", target, nil, true), http.StatusNotFound, "already revoked")
+	expectResponse(t, ts.request("DELETE", "/api/v0/tokens/999", nil, true), http.StatusNotFound, "Invalid API token ID")
+	expectResponse(t, ts.request("DELETE", "/api/v0/tokens/abc", nil, true), http.StatusBadRequest, "Invalid API token ID")
+
+	ts.requestOK("GET", "/api/v0/tokens", nil, true, &tokens)
+	if len(tokens.Tokens) != 1 || tokens.Tokens[0].RevokeTime == nil {
+		t.Errorf("got tokens %+v, want the revoked token", tokens.Tokens)
+	}
+}
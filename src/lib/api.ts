@@ -138,6 +138,21 @@ const adminSales = (period: string = 'current') =>
 
 		updateCoupon: async (coupon: AdminCoupon): Promise<AdminCoupon> =>
 			handleFetch(AdminCoupon, `/sales/${period}/coupons`, coupon),
+		couponBatches: async (): Promise<CouponBatch[]> => {
+			const resp = await handleFetch(
+				z.object({ batches: CouponBatch.array() }),
+				`/sales/${period}/coupon_batches`
+			)
+			return resp.batches
+		},
+		createCouponBatch: (batch: CreateCouponBatch): Promise<CouponBatch> =>
+			handleFetch(CouponBatch, `/sales/${period}/coupons/batch`, batch),
+		couponBatchExportURL: (id: number): string =>
+			`${API_URL}/sales/${period}/coupon_batches/${id}`,
+		revokeCouponBatch: (id: number): Promise<void> =>
+			handleFetch(z.undefined(), `/sales/${period}/coupons/batch/${id}`, undefined, {
+				method: 'DELETE'
+			}),
 		updateProduct: async (product: ShopItem): Promise<ShopItem> =>
 			handleFetch(ShopItem, `/sales/${period}/products`, product),
 
@@ -145,12 +160,21 @@ const adminSales = (period: string = 'current') =>
 			let url = `/sales/${period}/order_summary`
 			if (showCollected) url += '?show_collected=1'
 			return handleFetch(OrderSummary, url)
+		},
+		orderExportURL: (format: 'csv' | 'xlsx'): string =>
+			`${API_URL}/sales/${period}/orders/export?format=${format}`,
+
+		clone: async (newPeriod: CloneSalePeriod): Promise<SalePeriod> => {
+			const resp = await handleFetch(z.object({ period: SalePeriod }), `/sales/${period}/clone`, newPeriod)
+			return resp.period
 		}
 	}) as const
 
 const adminOrders = {
-	cancel: (orderID: string): Promise<void> =>
-		handleFetch(z.undefined(), `/orders/${encodeURI(orderID)}/cancel`, {}),
+	cancel: (orderID: string, reason: string = ''): Promise<void> =>
+		handleFetch(z.undefined(), `/orders/${encodeURI(orderID)}/cancel`, { reason }),
+	refund: (orderID: string, reason: string, items: RefundItem[]): Promise<void> =>
+		handleFetch(z.undefined(), `/orders/${encodeURI(orderID)}/refund`, { reason, items }),
 	collect: (orderID: string): Promise<void> =>
 		handleFetch(z.undefined(), `/orders/${encodeURI(orderID)}/collect`, {}),
 	search: async (keyword: string, includeCancelled?: boolean): Promise<Order[]> => {
@@ -170,30 +194,109 @@ const adminClosures = {
 		handleFetch(StoreClosure, '/closures', closure)
 }
 
+export const roles = ['owner', 'merch_manager', 'collector', 'viewer'] as const
 export type User = z.infer<typeof User>
-const User = z.string()
+const User = z.object({
+	email: z.string(),
+	role: z.enum(roles)
+})
+export type CurrentUser = z.infer<typeof CurrentUser>
+const CurrentUser = User.extend({
+	permissions: z.string().array()
+})
 const adminUsers = {
 	list: async (): Promise<User[]> => {
 		const resp = await handleFetch(z.object({ users: User.array() }), '/users')
 		return resp.users
 	},
-	add: (user: User): Promise<void> => handleFetch(z.undefined(), '/users', user),
-	remove: (user: User): Promise<void> =>
-		handleFetch(z.undefined(), '/users', user, { method: 'DELETE' })
+	me: (): Promise<CurrentUser> => handleFetch(CurrentUser, '/users/me'),
+	save: (user: User): Promise<void> => handleFetch(z.undefined(), '/users', user),
+	remove: (email: string): Promise<void> =>
+		handleFetch(z.undefined(), '/users', { email }, { method: 'DELETE' })
 } as const
 
+export type APIToken = z.infer<typeof APIToken>
+const APIToken = z.object({
+	id: z.number(),
+	name: z.string(),
+	admin_email: z.string(),
+	permissions: z.string().array(),
+	create_time: z.coerce.date(),
+	expire_time: z.coerce.date(),
+	last_used_time: z.coerce.date().optional(),
+	revoke_time: z.coerce.date().optional(),
+	token: z.string().optional()
+})
+export type CreateAPIToken = {
+	name: string
+	permissions: string[]
+	expire_time?: Date
+}
+const adminTokens = {
+	list: async (): Promise<APIToken[]> => {
+		const resp = await handleFetch(z.object({ tokens: APIToken.array() }), '/tokens')
+		return resp.tokens
+	},
+	create: (token: CreateAPIToken): Promise<APIToken> => handleFetch(APIToken, '/tokens', token),
+	revoke: (id: number): Promise<void> =>
+		handleFetch(z.undefined(), `/tokens/${id}`, undefined, { method: 'DELETE' })
+} as const
+
+export type AuditLogEntry = z.infer<typeof AuditLogEntry>
+const AuditLogEntry = z.object({
+	id: z.number(),
+	actor: z.string(),
+	time: z.coerce.date(),
+	entity_type: z.string(),
+	entity_id: z.string(),
+	action: z.string(),
+	before: z.record(z.unknown()).nullable(),
+	after: z.record(z.unknown()).nullable()
+})
+export type AuditLogFilter = {
+	entity_type?: string
+	entity_id?: string
+	actor?: string
+	from?: Date
+	until?: Date
+	before?: number
+}
+const auditLog = async (filter: AuditLogFilter = {}): Promise<AuditLogEntry[]> => {
+	const params = new URLSearchParams()
+	for (const [key, value] of Object.entries(filter)) {
+		if (value === undefined || value === '') continue
+		params.set(key, value instanceof Date ? value.toISOString() : `${value}`)
+	}
+	const resp = await handleFetch(
+		z.object({ entries: AuditLogEntry.array() }),
+		`/audit_log?${params}`
+	)
+	return resp.entries
+}
+
 const admin = {
+	auditLog,
 	closures: adminClosures,
 	orders: adminOrders,
 	sales: adminSales,
+	tokens: adminTokens,
 	users: adminUsers,
 	checkPerm: (): Promise<void> => handleFetch(z.undefined(), '/perm_check'),
-	listSales: async (): Promise<SalePeriod[]> => {
-		const resp = await handleFetch(z.object({ periods: SalePeriod.array() }), `/sales`)
+	listSales: async (archived: boolean = false): Promise<SalePeriod[]> => {
+		const resp = await handleFetch(
+			z.object({ periods: SalePeriod.array() }),
+			archived ? '/sales?archived=1' : '/sales'
+		)
 		return resp.periods
 	},
 	updateSales: (period: SalePeriod): Promise<SalePeriod> =>
 		handleFetch(SalePeriod, '/sales', period),
+	deleteSales: (id: string, force: boolean = false): Promise<void> =>
+		handleFetch(z.undefined(), `/sales/${id}${force ? '?force=1' : ''}`, undefined, {
+			method: 'DELETE'
+		}),
+	restoreSales: (id: string): Promise<void> =>
+		handleFetch(z.undefined(), `/sales/${id}/restore`, {}),
 	uploadImage: async (img: Blob, raw: boolean = false): Promise<string> => {
 		const data = new FormData()
 		data.append('file', img)
@@ -206,6 +309,12 @@ const admin = {
 	}
 } as const
 
+const OrderRefund = z.object({
+	amount: z.number(),
+	reason: z.string(),
+	refundTime: z.coerce.date(),
+	adminEmail: z.string().optional()
+})
 const Order = z.object({
 	id: z.string(),
 	name: z.string(),
@@ -217,7 +326,9 @@ const Order = z.object({
 	cancelled: z.boolean(),
 	coupon: Coupon.nullable(),
 	items: OrderItem.array(),
-	salePeriod: z.string()
+	salePeriod: z.string(),
+	refundStatus: z.enum(['none', 'partial', 'full']),
+	refunds: OrderRefund.array()
 })
 const StoreClosure = z.object({
 	id: z.string(),
@@ -226,6 +337,16 @@ const StoreClosure = z.object({
 	message: z.string(),
 	show_order_check: z.boolean()
 })
+const CouponBatch = z.object({
+	id: z.number(),
+	name: z.string(),
+	stripe_id: z.string(),
+	create_time: z.coerce.date(),
+	revoke_time: z.coerce.date().optional(),
+	coupon_count: z.number(),
+	redemptions: z.number(),
+	codes: z.string().array().optional()
+})
 const OrderSummary = z.object({
 	unfulfilled: z
 		.object({
@@ -241,13 +362,33 @@ const OrderSummary = z.object({
 const SalePeriod = z.object({
 	id: z.string(),
 	name: z.string(),
-	start_time: z.coerce.date()
+	start_time: z.coerce.date(),
+	end_time: z.coerce.date().optional(),
+	closed_message: z.string(),
+	delete_time: z.coerce.date().optional()
 })
 
 export type Order = z.infer<typeof Order>
+export type OrderRefund = z.infer<typeof OrderRefund>
+export type RefundItem = { id: number; amount: number }
 export type StoreClosure = z.infer<typeof StoreClosure>
 export type OrderSummary = z.infer<typeof OrderSummary>
 export type SalePeriod = z.infer<typeof SalePeriod>
+export type CouponBatch = z.infer<typeof CouponBatch>
+export type CreateCouponBatch = {
+	name: string
+	count: number
+	prefix: string
+	requirements: unknown[]
+	discount: unknown
+	stripe_desc: string
+}
+export type CloneSalePeriod = {
+	name?: string
+	start_time: Date
+	skip_disabled: boolean
+	new_stripe_coupons: boolean
+}
 
 export default {
 	admin,
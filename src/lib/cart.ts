@@ -20,7 +20,9 @@ export type OrderItem = z.infer<typeof OrderItem>
 export const OrderItem = CartItem.omit({
 	variant: true
 }).extend({
-	variant: z.string()
+	variant: z.string(),
+	refundedAmount: z.number(),
+	itemID: z.number().optional()
 })
 
 export type Item = CartItem | OrderItem
@@ -34,13 +36,59 @@ export const Requirement = z.union([
 		type: z.literal("email"),
 		value: z.string(),
 	}),
+	z.object({
+		type: z.literal("valid_time"),
+		from: z.string().optional(), // RFC 3339 timestamps.
+		until: z.string().optional(),
+	}),
+	z.object({
+		type: z.literal("email_domain"),
+		values: z.string().array(), // Domains without the @.
+	}),
+	z.object({
+		type: z.literal("email_regex"),
+		value: z.string(), // Matched against the lowercased email.
+	}),
+	z.object({
+		type: z.literal("matric_prefix"),
+		values: z.string().array(),
+	}),
+	z.object({
+		type: z.literal("product"),
+		value: z.string(), // The product ID.
+		options: z.string().array().optional(), // Variant options that must be chosen.
+	}),
+	z.object({
+		type: z.literal("min_subtotal"),
+		amount: z.number(), // The minimum subtotal in cents.
+	}),
 ])
 
-// Discount is used for previewing discounts in the cart.
-export const Discount = z.object({
-	type: z.literal("percentage"),
-	amount: z.number(), // 40% discount (i.e. pay 60%) is represented as 40.
-})
+// Discount is used for previewing discounts in the cart. It must be calculated
+// the same way as the backend.
+export const Discount = z.union([
+	z.object({
+		type: z.literal("percentage"),
+		amount: z.number(), // 40% discount (i.e. pay 60%) is represented as 40.
+		products: z.string().array().optional(), // Every product if unset.
+	}),
+	z.object({
+		type: z.literal("fixed"),
+		amount: z.number(), // The amount off in cents.
+		products: z.string().array().optional(),
+	}),
+	z.object({
+		type: z.literal("free_item"),
+		amount: z.number(), // The number of units that are free.
+		products: z.string().array(),
+	}),
+	z.object({
+		type: z.literal("buy_x_get_y"),
+		buy: z.number(),
+		free: z.number(),
+		products: z.string().array(),
+	}),
+])
 
 export const Coupon = z.object({
 	requirements: Requirement.array(),
@@ -54,6 +102,10 @@ export const AdminCoupon = Coupon.extend({
 	enabled: z.boolean(),
 	public: z.boolean(),
 	stripe_desc: z.string().nullish(),
+	redemption_limit: z.number().nullish(), // Unlimited if unset.
+	customer_redemption_limit: z.number().nullish(), // Per matric number or email.
+	redemptions: z.number().optional(), // Paid orders using the coupon.
+	pending_redemptions: z.number().optional(), // Unpaid orders using the coupon.
 });
 
 export type CartItem = z.infer<typeof CartItem>
@@ -65,19 +117,72 @@ export type AdminCoupon = z.infer<typeof AdminCoupon>
 export const calculateCartTotal = (cart: Item[]) =>
 	cart.reduce<number>((total, item) => total + item.unitPrice * item.amount, 0);
 
+// freeUnits returns the total price of the cheapest units, which are the ones
+// made free.
+const freeUnits = (items: Item[], count: number): number => {
+	const prices = items
+		.flatMap((item) => Array<number>(item.amount).fill(item.unitPrice))
+		.sort((a, b) => a - b);
+	return prices.slice(0, count).reduce((total, price) => total + price, 0);
+};
+
 export const applyCoupon = (cart: Item[], coupon: Coupon): number => {
 	const cartTotal = calculateCartTotal(cart);
-	switch (coupon.discount.type) {
+	const discount = coupon.discount;
+	const products = discount.products ?? [];
+	const eligible = cart.filter((item) => products.length === 0 || products.includes(item.id));
+	const eligibleTotal = calculateCartTotal(eligible);
+	const eligibleCount = eligible.reduce<number>((total, item) => total + item.amount, 0);
+	switch (discount.type) {
 		case 'percentage':
-			return Math.floor((cartTotal * (100 - coupon.discount.amount)) / 100);
+			return cartTotal - Math.floor((eligibleTotal * discount.amount + 50) / 100);
+		case 'fixed':
+			return cartTotal - Math.min(discount.amount, eligibleTotal);
+		case 'free_item':
+			return cartTotal - freeUnits(eligible, discount.amount);
+		case 'buy_x_get_y': {
+			const count = Math.floor(eligibleCount / (discount.buy + discount.free)) * discount.free;
+			return cartTotal - freeUnits(eligible, count);
+		}
+	}
+};
+
+// hasOptions reports whether the item was chosen in all of the variant options.
+const hasOptions = (item: Item, options: string[]): boolean => {
+	if (typeof item.variant === 'string') {
+		const chosen = item.variant.split(', ');
+		return options.every((option) => chosen.includes(option));
 	}
+	const chosen = item.variant.map((x) => x.option);
+	return options.every((option) => chosen.includes(option));
 };
 
-export const checkRequirement = (cart: Item[], email: string, requirement: Requirement): boolean => {
+export const checkRequirement = (cart: Item[], email: string, matricNumber: string, requirement: Requirement): boolean => {
+	const fullEmail = (email + EMAIL_SUFFIX).toLowerCase();
 	switch (requirement.type) {
 		case 'purchase_count':
 			return cart.reduce<number>((total, item) => total + item.amount, 0) >= requirement.amount;
 		case 'email':
 			return (email + EMAIL_SUFFIX).toLowerCase() === requirement.value.toLowerCase()
+		case 'valid_time': {
+			const now = Date.now();
+			return (!requirement.from || Date.parse(requirement.from) <= now) &&
+				(!requirement.until || now < Date.parse(requirement.until));
+		}
+		case 'email_domain':
+			return requirement.values.some((domain) => fullEmail.endsWith('@' + domain));
+		case 'email_regex':
+			try {
+				return new RegExp(requirement.value).test(fullEmail);
+			} catch {
+				// The backend checks it when checking out.
+				return true;
+			}
+		case 'matric_prefix':
+			return requirement.values.some((prefix) => matricNumber.toUpperCase().startsWith(prefix));
+		case 'product':
+			return cart.some((item) => item.id === requirement.value && hasOptions(item, requirement.options ?? []));
+		case 'min_subtotal':
+			return calculateCartTotal(cart) >= requirement.amount;
 	}
 };
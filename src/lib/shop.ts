@@ -17,6 +17,12 @@ const ShopItemImage = z.object({
 	url: z.string()
 })
 
+const ShopItemStock = z.object({
+	variant: z.string(), // Selected options joined with ', '.
+	available: z.number(),
+	reserved: z.number().optional()
+})
+
 export const ShopItem = z.object({
 	id: z.string(),
 	name: z.string(),
@@ -25,7 +31,8 @@ export const ShopItem = z.object({
 	imageURLs: ShopItemImage.array(),
 	defaultImageURL: z.string(),
 	enabled: z.boolean().optional(),
-	salePeriod: z.number()
+	salePeriod: z.number(),
+	stock: ShopItemStock.array()
 })
 
 export type ShopItem = z.infer<typeof ShopItem>
@@ -38,7 +45,8 @@ export const emptyShopItem = (name: string, salePeriod: string): ShopItem => ({
 	defaultImageURL: '',
 	imageURLs: [],
 	enabled: false,
-	salePeriod: +salePeriod
+	salePeriod: +salePeriod,
+	stock: []
 })
 
 export const toArrayVariant = (item: ShopItem, variants: Record<string, string | undefined>) => {
@@ -71,6 +79,15 @@ export const resolveImageURL = (item: ShopItem, variants: Record<string, string
 	return result
 }
 
+// Returns the remaining stock of the selected variant, or undefined if the stock
+// is not tracked or not all options have been selected.
+export const remainingStock = (item: ShopItem, variants: Record<string, string | undefined>) => {
+	const arrVariants = toArrayVariant(item, variants)
+	if (arrVariants.some((x) => !x)) return undefined
+	const variant = arrVariants.join(', ')
+	return item.stock.find((x) => x.variant === variant)?.available
+}
+
 export const tentativePrice = (item: ShopItem, variants: Record<string, string | undefined>) => {
 	const arrVariants = toArrayVariant(item, variants)
 	// Add all the existing addon prices to the base price.